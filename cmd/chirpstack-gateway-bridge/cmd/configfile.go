@@ -12,13 +12,70 @@ import (
 // when updating this template, don't forget to update config.md!
 const configTemplate = `[general]
 # debug=5, info=4, warning=3, error=2, fatal=1, panic=0
+#
+# The log level and the MQTT topic templates are reloaded on SIGHUP, other
+# changes require a restart.
 log_level={{ .General.LogLevel }}
 
+# Log format.
+#
+# Valid options are:
+#   * text: human-readable text format
+#   * json: JSON format, one object per line (e.g. for log shippers)
+log_format="{{ .General.LogFormat }}"
+
+# Log output.
+#
+# Valid options are:
+#   * stderr: write log messages to stderr
+#   * syslog: write log messages to syslog (not supported on Windows)
+#   * file:   write log messages to the log file configured below
+log_output="{{ .General.LogOutput }}"
+
 # Log to syslog.
 #
-# When set to true, log messages are being written to syslog.
+# When set to true, log messages are being written to syslog, in addition to
+# the log output configured above.
 log_to_syslog={{ .General.LogToSyslog }}
 
+# Syslog facility (e.g. user, daemon or local0 - local7).
+syslog_facility="{{ .General.SyslogFacility }}"
+
+# Syslog tag.
+syslog_tag="{{ .General.SyslogTag }}"
+
+# Log file.
+#
+# The path of the log file, used when the log output is set to file.
+log_file="{{ .General.LogFile }}"
+
+# Log file max. size (MB).
+#
+# When the log file exceeds this size, it is rotated (renamed to <log_file>.1,
+# existing backups are renamed to <log_file>.N+1). Set to 0 to disable
+# rotation.
+log_file_max_size={{ .General.LogFileMaxSize }}
+
+# Log file max. backups.
+#
+# The max. number of rotated log files to keep.
+log_file_max_backups={{ .General.LogFileMaxBackups }}
+
+# Startup banner.
+#
+# When set to true, a single log line summarizing the effective endpoints
+# (MQTT brokers and TLS status, backend bind and topic templates) is logged
+# once after the first successful connection to the MQTT broker.
+startup_banner={{ .General.StartupBanner }}
+
+# pprof bind.
+#
+# The ip:port to bind the pprof HTTP server to (e.g. 127.0.0.1:6060), serving
+# the net/http/pprof endpoints under /debug/pprof/. This server is separate
+# from the metrics server. Leave blank to disable. Do not expose this endpoint
+# to untrusted networks.
+pprof_bind="{{ .General.PProfBind }}"
+
 
 # Filters.
 #
@@ -55,6 +112,287 @@ join_euis=[{{ range $index, $elm := .Filters.JoinEUIs }}
   ["{{ index $elm 0 }}", "{{ index $elm 1 }}"],{{ end }}
 ]
 
+# Gateway allow list.
+#
+# When set, only the events of the configured gateway IDs are forwarded. The
+# events of other gateways (e.g. of unknown packet-forwarders sending to the
+# exposed UDP port) are dropped. When set, the deny list is ignored.
+#
+# Example:
+# gateway_allow=[
+#   "0102030405060708",
+# ]
+gateway_allow=[{{ range $index, $elm := .Filters.GatewayFilterAllow }}
+  "{{ $elm }}",{{ end }}
+]
+
+# Gateway deny list.
+#
+# The events of the configured gateway IDs are dropped. This is only used
+# when the allow list is empty.
+gateway_deny=[{{ range $index, $elm := .Filters.GatewayFilterDeny }}
+  "{{ $elm }}",{{ end }}
+]
+
+
+# Uplink and downlink validation.
+#
+# Some packet-forwarders occasionally forward incomplete uplinks (e.g. without
+# frequency or data-rate). These uplinks are validated before they are
+# published by the integration. Downlinks are validated before they are sent
+# to the gateway.
+[validation]
+
+# Action for uplinks with missing required fields.
+#
+# Valid options are:
+#   * disabled: uplinks are not validated
+#   * drop: incomplete uplinks are dropped
+#   * fix: missing fields are set when they can be derived or defaulted
+#          (e.g. the code-rate), other incomplete uplinks are dropped
+uplink_action="{{ .Validation.UplinkAction }}"
+
+# Max. uplink age.
+#
+# Uplinks of which the receive timestamp is older than the given duration
+# are dropped instead of published (e.g. uplinks that were spooled by the
+# gateway during an outage). Uplinks without receive timestamp are always
+# published. Set this to 0 to disable this check.
+max_uplink_age="{{ .Validation.MaxUplinkAge }}"
+
+# Action for downlinks with a duplicate or regressed token.
+#
+# The token of each downlink is compared with the last token received for the
+# same gateway, to detect duplicates (e.g. a re-delivered downlink) and
+# regressions (e.g. after a network server restart). A token is regressed when
+# it is before the last token, taking the wrap-around of the 16bit token into
+# account. Note that this assumes that the network server increments the
+# token. Downlinks without token are not validated.
+#
+# Valid options are:
+#   * disabled: downlink tokens are not validated
+#   * log: a warning is logged for duplicate and regressed tokens
+#   * drop: downlinks with a duplicate or regressed token are logged and
+#           dropped
+downlink_token_action="{{ .Validation.DownlinkTokenAction }}"
+
+
+# Uplink rate limiting.
+#
+# Uplinks exceeding the rate limits are dropped before they are published,
+# e.g. to protect the MQTT broker when a single gateway floods uplinks
+# (misbehaving device or jamming). The rates are in uplinks per second, the
+# burst is the number of uplinks that can be received at once. When the burst
+# is not set, it defaults to the rate (rounded up). The dropped uplinks are
+# counted by the ratelimit_uplink_dropped_count metric.
+[rate_limit]
+# Uplink rate per gateway (0 = disabled).
+gateway_uplink_rate={{ .RateLimit.GatewayUplinkRate }}
+
+# Uplink burst per gateway.
+gateway_uplink_burst={{ .RateLimit.GatewayUplinkBurst }}
+
+# Uplink rate for all gateways (0 = disabled).
+#
+# This is a secondary guard, e.g. in case many gateways are flooding uplinks.
+uplink_rate={{ .RateLimit.UplinkRate }}
+
+# Uplink burst for all gateways.
+uplink_burst={{ .RateLimit.UplinkBurst }}
+
+
+# Downlink override (for testing only).
+#
+# When set, the TX parameters of all downlinks are overridden before these are
+# sent to the gateway, e.g. to force all downlinks on a test channel during
+# lab or certification testing. Each modified downlink is logged (including
+# the original values) and counted by the downlinkoverride_count metric. Set
+# an option to 0 to disable its override.
+[downlink_override]
+# TX frequency (Hz).
+frequency={{ .DownlinkOverride.Frequency }}
+
+# Max. TX power (dBm).
+#
+# Downlinks with a higher TX power are clamped to this TX power.
+max_power={{ .DownlinkOverride.MaxPower }}
+
+# LoRa spreading-factor.
+spreading_factor={{ .DownlinkOverride.SpreadingFactor }}
+
+# LoRa bandwidth (kHz).
+bandwidth={{ .DownlinkOverride.Bandwidth }}
+
+
+# Uplink coalescing.
+#
+# When enabled, identical uplinks (PHYPayload) received by the same gateway
+# within the coalescing window (e.g. on multiple antennas) are published as a
+# single uplink frame-set (gw.UplinkFrameSet), containing the RX meta-data
+# (e.g. RSSI and SNR) of each reception. Frame-sets are published as up_set
+# event instead of the up event, using the QoS and retained flag of the up
+# event. Uplinks are published after the window has expired.
+[uplink_coalescing]
+
+# Coalescing window. Set this to 0 to disable.
+window="{{ .UplinkCoalescing.Window }}"
+
+
+# Error ack aggregation.
+#
+# A burst of downlinks failing for the same reason (e.g. TOO_LATE because of a
+# clock skew) results in a burst of identical error acks. When enabled, the
+# first error ack of a gateway is published as-is, and the identical error
+# acks within the window are published as a single summary ack, of which the
+# error contains the number of aggregated acks. A different error or a
+# successful ack publishes the summary immediately. Note that the network
+# server only receives the acks of the first and last downlink of an
+# aggregation.
+[ack_aggregation]
+
+# Aggregation window. Set this to 0 to disable.
+window="{{ .AckAggregation.Window }}"
+
+
+# Gateway control.
+#
+# The forwarding of individual gateways can be disabled at runtime (e.g.
+# during maintenance) using the HTTP control endpoint. Uplinks, stats, raw
+# packet-forwarder events and downlinks of disabled gateways are dropped until
+# they are re-enabled (counted by the gatewaycontrol_event_dropped_count
+# metric):
+#   * GET    /gateways/disabled              returns the disabled gateway IDs
+#   * PUT    /gateways/<gateway_id>/disabled disables the given gateway
+#   * DELETE /gateways/<gateway_id>/disabled re-enables the given gateway
+[gateway_control]
+
+# The ip:port to bind the HTTP control endpoint to. Leave blank to disable.
+#
+# The endpoint does not implement authentication. It must not be exposed,
+# bind it to localhost (e.g. 127.0.0.1:8090) or a trusted network only.
+bind="{{ .GatewayControl.Bind }}"
+
+# State file (optional).
+#
+# When set, the disabled gateways are persisted to this file and restored
+# on startup.
+state_file="{{ .GatewayControl.StateFile }}"
+
+
+# Downlink injection.
+#
+# When enabled, downlink frames can be injected using a HTTP endpoint, as if
+# they were received from the integration (e.g. to transmit test downlinks
+# without a network server). The endpoint accepts the JSON encoded downlink
+# frame:
+#
+#   * POST /downlink
+#
+# Requests must be authenticated using the "Authorization: Bearer <token>"
+# header.
+[downlink_injection]
+
+# The ip:port to bind the HTTP injection endpoint to. Leave blank to disable.
+bind="{{ .DownlinkInjection.Bind }}"
+
+# Bearer token (required when enabled).
+token="{{ .DownlinkInjection.Token }}"
+
+
+# Gateway capabilities.
+#
+# The downlinks for a gateway with a capability profile are validated against
+# this profile. Downlink items (e.g. the RX1 and RX2 options) requesting an
+# unsupported feature are removed from the downlink. These items are reported
+# in the tx acknowledgement with the status describing the mismatch (e.g.
+# TX_FREQ for an unsupported frequency, TX_POWER for an unsupported tx power).
+# When none of the items is supported, the downlink is rejected. Downlinks for
+# gateways without profile are not validated.
+[capabilities]
+
+  # Example:
+  # [[capabilities.gateways]]
+  #
+  #   # Gateway ID.
+  #   gateway_id="0101010101010101"
+  #
+  #   # Supported modulations (LORA, FSK). Leave empty to allow all.
+  #   modulations=["LORA"]
+  #
+  #   # Supported LoRa spreading-factors. Leave empty to allow all.
+  #   spreading_factors=[7, 8, 9, 10, 11, 12]
+  #
+  #   # Supported downlink timings (IMMEDIATELY, DELAY, GPS_EPOCH). GPS_EPOCH
+  #   # requires a GPS. Leave empty to allow all.
+  #   timings=["IMMEDIATELY", "DELAY"]
+  #
+  #   # Supported frequency range (Hz). Set to 0 to disable the check.
+  #   min_frequency=863000000
+  #   max_frequency=870000000
+  #
+  #   # Max. tx power (dBm). Set to 0 to disable the check.
+  #   max_power=14
+  #
+  #   # Transform downlink timing.
+  #   #
+  #   # When set, downlinks using an unsupported timing are converted to a
+  #   # supported timing, using the last uplink of the gateway (with GPS time)
+  #   # as reference. E.g. GPS_EPOCH is converted to DELAY for gateways without
+  #   # GPS. Downlinks that can not be converted are rejected.
+  #   transform_timing=true
+{{ range $i, $gateway := .Capabilities.Gateways }}
+  [[capabilities.gateways]]
+  gateway_id="{{ $gateway.GatewayID }}"
+  modulations=[{{ range $index, $elm := $gateway.Modulations }}
+    "{{ $elm }}",{{ end }}
+  ]
+  spreading_factors=[{{ range $index, $elm := $gateway.SpreadingFactors }}
+    {{ $elm }},{{ end }}
+  ]
+  timings=[{{ range $index, $elm := $gateway.Timings }}
+    "{{ $elm }}",{{ end }}
+  ]
+  min_frequency={{ $gateway.MinFrequency }}
+  max_frequency={{ $gateway.MaxFrequency }}
+  max_power={{ $gateway.MaxPower }}
+  transform_timing={{ $gateway.TransformTiming }}
+{{ end }}
+
+
+# Virtual gateways.
+#
+# A virtual gateway collapses multiple physical gateways (e.g. a multi-antenna
+# site) into a single logical gateway. Uplinks received by the physical gateways
+# are published using the virtual Gateway ID (de-duplicated) and downlinks for
+# the virtual Gateway ID are sent to all connected physical gateways.
+[virtual_gateways]
+
+# De-duplication window.
+#
+# The same uplink received by multiple physical gateways of the same virtual
+# gateway within this window is only published once. Set this to 0 to disable
+# de-duplication.
+deduplication_window="{{ .VirtualGateways.DeduplicationWindow }}"
+
+  # Example:
+  # [[virtual_gateways.gateways]]
+  #
+  #   # Virtual Gateway ID.
+  #   gateway_id="0101010101010101"
+  #
+  #   # Physical Gateway IDs.
+  #   physical_gateway_ids=[
+  #     "0202020202020202",
+  #     "0303030303030303",
+  #   ]
+{{ range $i, $gateway := .VirtualGateways.Gateways }}
+  [[virtual_gateways.gateways]]
+  gateway_id="{{ $gateway.GatewayID }}"
+  physical_gateway_ids=[{{ range $index, $elm := $gateway.PhysicalGatewayIDs }}
+    "{{ $elm }}",{{ end }}
+  ]
+{{ end }}
+
 
 # Gateway backend configuration.
 [backend]
@@ -67,6 +405,21 @@ join_euis=[{{ range $index, $elm := .Filters.JoinEUIs }}
 #   * basic_station
 type="{{ .Backend.Type }}"
 
+# Max. number of in-flight downlinks per gateway.
+#
+# In-flight downlinks are downlinks sent to the gateway, for which no tx
+# acknowledgement has been received yet. When this number has been reached,
+# new downlinks are rejected with a QUEUE_FULL tx acknowledgement.
+# Set this to 0 to disable this limit.
+max_in_flight_downlinks={{ .Backend.MaxInFlightDownlinks }}
+
+# In-flight downlink timeout.
+#
+# Downlinks that have not been acknowledged within this duration are no
+# longer considered in-flight (e.g. in case the gateway does not send tx
+# acknowledgements).
+in_flight_downlink_timeout="{{ .Backend.InFlightDownlinkTimeout }}"
+
 
   # Semtech UDP packet-forwarder backend.
   [backend.semtech_udp]
@@ -91,6 +444,190 @@ type="{{ .Backend.Type }}"
   # the time would otherwise be unset.
   fake_rx_time={{ .Backend.SemtechUDP.FakeRxTime }}
 
+  # Source address meta-data.
+  #
+  # When enabled, the source UDP address (ip:port) of the packet-forwarder
+  # is added to the rxInfo of the published uplinks as metaData.source_addr.
+  # Note: this is only supported by the json marshaler.
+  source_addr_meta_data={{ .Backend.SemtechUDP.SourceAddrMetaData }}
+
+  # Extension fields.
+  #
+  # Some packet-forwarders add non-standard fields to the rxpk and stat
+  # objects (e.g. network time or board temperature). The listed fields are
+  # copied as-is to the meta-data of the published uplinks (rxInfo.metaData)
+  # and stats (metaData). Fields that are not listed are ignored.
+  # Note: for uplinks, this is only supported by the json marshaler.
+  #
+  # Example:
+  # extension_fields=[
+  #   "temp",
+  #   "ntime",
+  # ]
+  extension_fields=[{{ range $index, $elm := .Backend.SemtechUDP.ExtensionFields }}
+    "{{ $elm }}",{{ end }}
+  ]
+
+  # UDP dropped datagrams interval (Linux only).
+  #
+  # When set, the number of datagrams dropped by the kernel for the UDP
+  # listener (e.g. because of a receive buffer overflow) is read from
+  # /proc/net/udp at this interval and exposed as the
+  # backend_semtechudp_udp_dropped metric. A warning is logged when this
+  # number increases. Set this to 0 to disable.
+  udp_drops_interval="{{ .Backend.SemtechUDP.UDPDropsInterval }}"
+
+  # UDP read buffer size (bytes).
+  #
+  # When set, the receive buffer of the UDP listener is set to this size
+  # (e.g. 4194304), so that bursts of packets from many gateways are not
+  # dropped by the kernel. Note that on Linux the size is capped by the
+  # net.core.rmem_max sysctl setting. Set this to 0 to use the OS default.
+  # Use the udp_drops_interval option to monitor the dropped datagrams.
+  udp_read_buffer={{ .Backend.SemtechUDP.UDPReadBuffer }}
+
+  # Number of workers.
+  #
+  # When set, the received UDP packets are handled by this number of worker
+  # goroutines. When all workers are busy, reading from the UDP listener
+  # blocks until a worker is available (the packets are buffered by the
+  # kernel). Set this to 0 to handle each packet in its own goroutine.
+  workers={{ .Backend.SemtechUDP.Workers }}
+
+  # Stats interval.
+  #
+  # When set, gateway stats are published for the gateways that did not send
+  # their own stats (packet-forwarder stat interval) within this interval,
+  # e.g. when the packet-forwarder stat interval is long or disabled. These
+  # stats contain the uplinks and downlinks counted by the bridge since the
+  # last published stats. Stats sent by the packet-forwarder always include
+  # these counters, they are never published twice. Set this to 0 to disable.
+  stats_interval="{{ .Backend.SemtechUDP.StatsInterval }}"
+
+  # Gateway timeout.
+  #
+  # A gateway is considered offline when no PULL_DATA and PUSH_DATA have been
+  # received within this duration, in which case the gateway is unsubscribed
+  # and its offline connection state is published. The gateway is online
+  # again after its next PULL_DATA or PUSH_DATA. This must be greater than 0.
+  gateway_timeout="{{ .Backend.SemtechUDP.GatewayTimeout }}"
+
+  # Downlink tx acknowledgement timeout.
+  #
+  # The duration to wait for the TX_ACK of a downlink. When no TX_ACK is
+  # received within this duration (e.g. the PULL_RESP or TX_ACK was lost
+  # over a lossy backhaul), a failed (INTERNAL_ERROR) tx acknowledgement is
+  # reported. Set to 0s to disable. As protocol version 1 packet-forwarders
+  # do not send TX_ACK packets, this is not applied to these gateways.
+  tx_ack_timeout="{{ .Backend.SemtechUDP.TXAckTimeout }}"
+
+  # Downlink tx acknowledgement retransmit.
+  #
+  # When set, a downlink of which the TX_ACK was not received within the
+  # tx_ack_timeout is retransmitted once, before a failed tx acknowledgement
+  # is reported.
+  tx_ack_retransmit={{ .Backend.SemtechUDP.TXAckRetransmit }}
+
+    # Unit normalization.
+    #
+    # Some packet-forwarders deviate from the protocol and report the rxpk
+    # frequency in Hz instead of MHz, or the GPS time (tmms) in seconds or
+    # nanoseconds instead of milliseconds. These values are converted to the
+    # canonical units before publishing (uplinks and the per-frequency stats).
+    [backend.semtech_udp.normalization]
+    # Frequency unit.
+    #
+    # Valid options are:
+    #   * mhz:  MHz (as defined by the protocol)
+    #   * hz:   Hz
+    #   * auto: values >= 1000000 are considered Hz, other values MHz
+    frequency_unit="{{ .Backend.SemtechUDP.Normalization.FrequencyUnit }}"
+
+    # GPS time unit.
+    #
+    # Valid options are:
+    #   * ms:   milliseconds (as defined by the protocol)
+    #   * s:    seconds
+    #   * ns:   nanoseconds
+    #   * auto: detect the unit based on the magnitude of the value
+    gps_time_unit="{{ .Backend.SemtechUDP.Normalization.GPSTimeUnit }}"
+
+    # Backpressure signaling.
+    #
+    # When the number of received UDP packets that are still being handled
+    # (e.g. because the MQTT broker is slow) exceeds the threshold, the
+    # PUSH_ACK responses are delayed to signal the packet-forwarder to back
+    # off. The delay increases linearly with the number of pending packets,
+    # up to max_ack_delay. Keep max_ack_delay below the PUSH_ACK timeout of
+    # the packet-forwarder (100ms by default). Set the threshold to 0 to
+    # disable.
+    [backend.semtech_udp.backpressure]
+    threshold={{ .Backend.SemtechUDP.Backpressure.Threshold }}
+    max_ack_delay="{{ .Backend.SemtechUDP.Backpressure.MaxACKDelay }}"
+
+    # PULL_DATA monitoring.
+    #
+    # Downlinks are sent to the address from which the gateway sends its
+    # PULL_DATA keep-alives. NAT devices dropping idle UDP mappings silently
+    # break the downlink path, which can be detected by a growing PULL_DATA
+    # interval or a changing source port (NAT rebinding).
+    [backend.semtech_udp.pull_data_monitor]
+    # Max. PULL_DATA interval.
+    #
+    # A warning is logged when the interval between two PULL_DATA packets of
+    # a gateway exceeds this value. This should be set above the keepalive
+    # interval of the packet-forwarder. Set to 0 to disable.
+    max_interval="{{ .Backend.SemtechUDP.PullDataMonitor.MaxInterval }}"
+
+    # Log source address changes.
+    #
+    # When set, a warning is logged when the source address (or port) of the
+    # PULL_DATA packets of a gateway changes.
+    log_port_changes={{ .Backend.SemtechUDP.PullDataMonitor.LogPortChanges }}
+
+    # Per gateway configuration (optional).
+    #
+    # The board, RF chain and antenna on which a frame was received are
+    # included in the uplink meta-data. When the number of antennas of a
+    # gateway is configured, frames reporting an antenna index outside this
+    # range are dropped as invalid.
+    #
+    # Example:
+    # [[backend.semtech_udp.gateways]]
+    #
+    #   # Gateway ID.
+    #   gateway_id="0102030405060708"
+    #
+    #   # Number of antennas.
+    #   antennas=2
+{{ range $i, $gateway := .Backend.SemtechUDP.Gateways }}
+    [[backend.semtech_udp.gateways]]
+    gateway_id="{{ $gateway.GatewayID }}"
+    antennas={{ $gateway.Antennas }}
+{{ end }}
+
+    # Downlink tx acknowledgement retry policy (optional).
+    #
+    # By default, a downlink that is rejected by the gateway is retried using
+    # the next transmission window (e.g. RX2) until no windows are left.
+    # When one or more policies are configured, only the configured statuses
+    # are retried (up to the configured number of retries), other statuses
+    # are forwarded immediately.
+    #
+    # Example:
+    # [[backend.semtech_udp.tx_ack_retries]]
+    #
+    #   # TX acknowledgement status (e.g. COLLISION_PACKET, COLLISION_BEACON, TOO_LATE).
+    #   status="COLLISION_PACKET"
+    #
+    #   # Max. number of retries.
+    #   retries=1
+{{ range $i, $retry := .Backend.SemtechUDP.TXAckRetries }}
+    [[backend.semtech_udp.tx_ack_retries]]
+    status="{{ $retry.Status }}"
+    retries={{ $retry.Retries }}
+{{ end }}
+
 
   # ChirpStack Concentratord backend.
   [backend.concentratord]
@@ -209,16 +746,90 @@ type="{{ .Backend.Type }}"
 
 # Integration configuration.
 [integration]
+# Integration type.
+#
+# Valid options are:
+#   * mqtt
+#   * gcp_pub_sub
+type="{{ .Integration.Type }}"
+
+# Dry-run mode.
+#
+# When enabled, the bridge does not connect to the configured integration
+# (e.g. the MQTT broker). Instead, the uplinks, stats and other events are
+# logged (at info level) in a readable (JSON) form. This can be used to verify
+# that a gateway is forwarding correctly before the integration has been
+# provisioned. Note that no downlinks are received in this mode.
+dry_run={{ .Integration.DryRun }}
+
 # Payload marshaler.
 #
 # This defines how the MQTT payloads are encoded. Valid options are:
 # * protobuf:  Protobuf encoding
 # * json:      JSON encoding (easier for debugging, but less compact than 'protobuf')
+# * cbor:      CBOR encoding (using the same keys and values as 'json', except
+#              that bytes fields are encoded as byte strings, MQTT only)
 marshaler="{{ .Integration.Marshaler }}"
 
+# Ack payload marshaler.
+#
+# This defines how the MQTT payloads of the downlink tx acknowledgements (ack
+# events) are encoded, e.g. for consumers expecting a different format than
+# the other events. Valid options are the same as for the marshaler option.
+# Leave this empty to use the marshaler as configured above.
+ack_marshaler="{{ .Integration.AckMarshaler }}"
+
+# JSON gateway ID key.
+#
+# When set and the json marshaler is used, the gateway ID key (gatewayID) of
+# all published messages (e.g. uplinks, stats and acks) is renamed to the
+# given key (e.g. gatewayEUI). Leave this empty to keep the default key.
+json_gateway_id_key="{{ .Integration.JSONGatewayIDKey }}"
+
+# JSON payload encoding.
+#
+# This defines the encoding of the PHYPayload (phyPayload) of the published
+# uplinks and of the received downlinks of the MQTT integration when the json
+# marshaler is used, e.g. for consumers that only handle hex encoded payloads.
+# Valid options are:
+#   * base64
+#   * hex
+json_payload_encoding="{{ .Integration.JSONPayloadEncoding }}"
+
+# Gateway configuration acknowledgements.
+#
+# When enabled, a config_ack event is published after a gateway configuration
+# command has been handled by the backend. The event contains the gatewayID,
+# the version of the configuration and the error in case the configuration
+# could not be applied (empty on success).
+config_ack={{ .Integration.ConfigAck }}
+
   # MQTT integration configuration.
+  #
+  # Besides the .GatewayID variable, the following gateway ID variables are
+  # available in all topic templates:
+  #   * .GatewayIDString: Gateway ID as lower-case hex string
+  #   * .GatewayIDUpper:  Gateway ID as upper-case hex string
+  #   * .GatewayIDBytes:  Gateway ID as bytes (e.g. {{ "{{" }} index .GatewayIDBytes 0 {{ "}}" }})
+  #   * .MAC:             alias of .GatewayID (backwards compatibility)
+  #
+  # Environment variables can be referenced in all topic templates using the
+  # env function, e.g. to use the same configuration file across deployments:
+  #   "{{ "{{" }} env "REGION" {{ "}}" }}/gateway/{{ "{{" }} .GatewayID {{ "}}" }}/event/{{ "{{" }} .EventType {{ "}}" }}"
+  # The environment variable is read each time the topic is rendered (not
+  # when the configuration is loaded).
   [integration.mqtt]
   # Event topic template.
+  #
+  # Besides the .GatewayID and .EventType variables, the following variables
+  # are available for uplink events (e.g. to route uplinks by data-rate):
+  #   * .Frequency:       frequency in Hz
+  #   * .Modulation:      modulation (LORA, FSK or LR_FHSS)
+  #   * .DataRate:        data-rate (e.g. SF7BW125 or 50000)
+  #   * .SpreadingFactor: LoRa spreading-factor
+  #   * .Bandwidth:       LoRa bandwidth in kHz
+  #
+  # Example: "gateway/{{ "{{" }} .GatewayID {{ "}}" }}/event/{{ "{{" }} .EventType {{ "}}" }}/{{ "{{" }} .DataRate {{ "}}" }}"
   event_topic_template="{{ .Integration.MQTT.EventTopicTemplate }}"
 
   # State topic template.
@@ -229,9 +840,32 @@ marshaler="{{ .Integration.Marshaler }}"
   # supported when using the generic authentication type.
   state_topic_template="{{ .Integration.MQTT.StateTopicTemplate }}"
 
+  # Gateway connection state topic template.
+  #
+  # When set, the online / offline connection state (conn) of each gateway is
+  # published to this topic instead of the state topic, e.g.
+  # "gateway/{{ "{{" }} .GatewayID {{ "}}" }}/presence". The state is online once the
+  # bridge receives the first PULL_DATA or PUSH_DATA of the gateway and
+  # offline when no packets were received from the gateway within the gateway
+  # timeout (see the gateway_timeout option of the semtech_udp backend). Like
+  # the other states, these are published as retained messages (see
+  # state_retained).
+  # This feature is only supported when using the generic authentication type.
+  gateway_conn_state_topic_template="{{ .Integration.MQTT.GatewayConnStateTopicTemplate }}"
+
   # Command topic template.
   command_topic_template="{{ .Integration.MQTT.CommandTopicTemplate }}"
 
+  # Downlink wildcard topic.
+  #
+  # When set, the bridge subscribes once to this (wildcard) topic instead of
+  # subscribing to the command topic of each gateway, e.g. "gateway/+/command/#".
+  # The gateway ID is parsed from the received topic, using the level holding
+  # the gateway ID in the command topic template. Commands for gateways that
+  # are not connected to this bridge are ignored. This reduces the number of
+  # subscriptions when many gateways are connected to a single bridge.
+  downlink_wildcard_topic="{{ .Integration.MQTT.DownlinkWildcardTopic }}"
+
   # State retained.
   #
   # By default this value is set to true and states are published as retained
@@ -239,22 +873,408 @@ marshaler="{{ .Integration.Marshaler }}"
   # by the MQTT broker.
   state_retained={{ .Integration.MQTT.StateRetained }}
 
+  # Topic template env strict.
+  #
+  # By default, referencing an environment variable that is not set (using
+  # the env function) renders as an empty string. When set to true, this
+  # results in an error instead, e.g. the bridge fails to start when the
+  # variable is not set.
+  topic_template_env_strict={{ .Integration.MQTT.TopicTemplateEnvStrict }}
+
+  # Gateway connection state topic template.
+  #
+  # When set, the bridge publishes a retained {"state":"online"} message to
+  # this topic after connecting to the MQTT broker and registers a retained
+  # {"state":"offline"} message as last will and testament, which is published
+  # by the broker when the bridge disconnects unexpectedly (e.g. crash or
+  # power loss). On a clean shutdown, the offline state is published by the
+  # bridge. The following variables are available:
+  #   * .ClientID:  the MQTT client ID
+  #   * .GatewayID: the Gateway ID, only available when it is provided by the
+  #                 authentication method (e.g. the client ID is a Gateway ID)
+  #
+  # As only a single last will and testament can be set, this replaces the
+  # last will of the state topic (when set).
+  #
+  # Example: "gateway/{{ "{{" }} .GatewayID {{ "}}" }}/connection"
+  connection_state_topic_template="{{ .Integration.MQTT.ConnectionStateTopicTemplate }}"
+
   # Keep alive will set the amount of time (in seconds) that the client should
   # wait before sending a PING request to the broker. This will allow the client
   # to know that a connection has not been lost with the server.
+  #
+  # The broker disconnects the client when nothing is received within 1.5
+  # times this interval (some brokers enforce a max. keep alive, in which case
+  # a longer interval might be rejected or reduced). When the connection
+  # passes a NAT gateway or firewall with an idle timeout, set this below
+  # that timeout to prevent the connection from being dropped silently.
+  # The value must be at least 1s, set to 0 to disable the keep alive.
   # Valid units are 'ms', 's', 'm', 'h'. Note that these values can be combined, e.g. '24h30m15s'.
   keep_alive="{{ .Integration.MQTT.KeepAlive }}"
 
+  # Auto reconnect.
+  #
+  # When enabled, the MQTT client reconnects automatically when the connection
+  # is lost (starting with a 1s interval), buffering the messages published
+  # while offline. When disabled, the connection is restored using the
+  # reconnect_interval (see below) and messages published while offline are
+  # dropped.
+  auto_reconnect={{ .Integration.MQTT.AutoReconnect }}
+
+  # Initial interval between (re)connection attempts.
+  #
+  # This interval is doubled after each failed attempt, up to the
+  # max_reconnect_interval. It is used for the initial connect and, when
+  # auto_reconnect is disabled, when the connection is lost.
+  # Valid units are 'ms', 's', 'm', 'h'. Note that these values can be combined, e.g. '24h30m15s'.
+  reconnect_interval="{{ .Integration.MQTT.ReconnectInterval }}"
+
   # Maximum interval that will be waited between reconnection attempts when connection is lost.
   # Valid units are 'ms', 's', 'm', 'h'. Note that these values can be combined, e.g. '24h30m15s'.
   max_reconnect_interval="{{ .Integration.MQTT.MaxReconnectInterval }}"
 
+  # Publish timeout.
+  #
+  # The max. duration to wait for the broker to acknowledge a publish (or for
+  # the publish to be sent in case of qos 0). On timeout, the publish is
+  # reported as failed, e.g. on a half-open connection to a broker which
+  # accepts the connection but never acknowledges. Set to 0 to wait forever.
+  # Valid units are 'ms', 's', 'm', 'h'. Note that these values can be combined, e.g. '24h30m15s'.
+  publish_timeout="{{ .Integration.MQTT.PublishTimeout }}"
+
+  # Subscribe timeout.
+  #
+  # The max. duration to wait for the broker to acknowledge a (un)subscribe.
+  # On timeout, the subscription is retried (see subscribe_retry). Set to 0
+  # to wait forever.
+  # Valid units are 'ms', 's', 'm', 'h'. Note that these values can be combined, e.g. '24h30m15s'.
+  subscribe_timeout="{{ .Integration.MQTT.SubscribeTimeout }}"
+
+  # Unsubscribe grace.
+  #
+  # The MQTT unsubscribe of a disconnected gateway is delayed by this grace and
+  # cancelled when the gateway re-connects within this grace. This avoids
+  # subscribe / unsubscribe storms in case of flapping gateway connections.
+  # Set this to 0 to unsubscribe immediately.
+  # Valid units are 'ms', 's', 'm', 'h'. Note that these values can be combined, e.g. '24h30m15s'.
+  unsubscribe_grace="{{ .Integration.MQTT.UnsubscribeGrace }}"
+
+  # Subscribe batch size.
+  #
+  # When set, the command topics of the gateways are subscribed in batches of
+  # this size (one SUBSCRIBE packet per batch), issued sequentially. This
+  # avoids exceeding the broker limits when re-subscribing thousands of
+  # gateways after a reconnect. The gateways of a failed batch are retried
+  # (see subscribe_retry), the other batches are not affected. Set this to 0
+  # to subscribe each gateway separately.
+  subscribe_batch_size={{ .Integration.MQTT.SubscribeBatchSize }}
+
+  # Downlink QoS.
+  #
+  # The quality of service level (0, 1 or 2) used for subscribing to the
+  # command topics (downlinks), including the re-subscriptions after a
+  # reconnect. Set to -1 to use the qos of the authentication section (see
+  # below). E.g. use 1 when a lost downlink results in a missed class-C
+  # transmission.
+  downlink_qos={{ .Integration.MQTT.DownlinkQOS }}
+
+  # Topic probe.
+  #
+  # When enabled, a zero-length message is published (QoS 1) to the event and
+  # state topic on startup, using probe as event and state type (e.g.
+  # gateway/0000000000000000/event/probe). Topics for which the publish fails
+  # are reported, so that ACL or topic issues (e.g. brokers requiring topics to
+  # exist) are detected before traffic flows. The gateway ID provided by the
+  # authentication is used, or 0000000000000000 if not available.
+  topic_probe={{ .Integration.MQTT.TopicProbe }}
+
+  # Combine uplink and stats.
+  #
+  # When enabled, the most recent stats of the gateway are added to each
+  # published uplink event under the stats key. The stats events are still
+  # published separately. This requires the json marshaler.
+  combine_uplink_stats={{ .Integration.MQTT.CombineUplinkStats }}
+
+  # LoRaWAN MAC-layer summary.
+  #
+  # When enabled, the PHYPayload of each published uplink event is decoded
+  # and a summary of the LoRaWAN header (mType, and for data frames also
+  # devAddr, fCnt and fPort) is added under the macSummary key. Uplinks with
+  # a PHYPayload that can't be decoded are published without summary. This
+  # requires the json marshaler.
+  mac_summary={{ .Integration.MQTT.MACSummary }}
+
+  # Event envelope.
+  #
+  # Valid options are:
+  #   * none:        events are published as-is
+  #   * cloudevents: events are wrapped in a CloudEvents (v1.0, structured
+  #                  mode) envelope, with the event as data. The type is set
+  #                  to io.chirpstack.gateway.<event type> and the source to
+  #                  /gateway/<gateway id>. Commands wrapped in a CloudEvents
+  #                  envelope are unwrapped. This requires the json marshaler.
+  envelope="{{ .Integration.MQTT.Envelope }}"
+
+  # Sort JSON keys.
+  #
+  # When enabled, the keys of all (nested) JSON objects of the published
+  # events and states are sorted, so that the same message always results in
+  # the same payload (e.g. for systems that checksum or diff payloads). This
+  # requires the json marshaler.
+  sort_json_keys={{ .Integration.MQTT.SortJSONKeys }}
+
+  # Payload compression.
+  #
+  # Valid options are:
+  #   * none: payloads are published uncompressed
+  #   * gzip: event payloads are gzip compressed
+  #
+  # Note: gzip compressed command payloads (e.g. downlinks) are always
+  # decompressed, regardless of this setting.
+  compression="{{ .Integration.MQTT.Compression }}"
+
+  # Compressed events.
+  #
+  # When compression is enabled, only the payloads of the listed event types
+  # are compressed. Valid event types are "up", "up_set", "stats", "ack",
+  # "exec", "raw" and "config_ack". Leave this empty to compress the payloads
+  # of all events.
+  #
+  # Example:
+  # compression_events=[
+  #   "up",
+  #   "stats",
+  # ]
+  compression_events=[{{ range $index, $elm := .Integration.MQTT.CompressionEvents }}
+    "{{ $elm }}",{{ end }}
+  ]
+
+  # Compression topic suffix.
+  #
+  # When set, this suffix is appended to the topic of compressed events, so
+  # that consumers can distinguish compressed from uncompressed payloads.
+  #
+  # Example: "/gzip"
+  compression_topic_suffix="{{ .Integration.MQTT.CompressionTopicSuffix }}"
+
+  # Uplink allow-list.
+  #
+  # When set, the published uplink events only contain the listed keys. Nested
+  # keys are separated by a dot (e.g. "txInfo.frequency"), for arrays (e.g. the
+  # rxInfo of a frame-set) the key applies to each element. The gateway ID and
+  # uplink ID of the rxInfo are always published. Leave empty to publish all
+  # keys. This requires the json marshaler.
+  #
+  # Example:
+  # uplink_allow_list=[
+  #   "phyPayload",
+  #   "txInfo.frequency",
+  #   "rxInfo.rssi",
+  #   "rxInfo.loRaSNR",
+  # ]
+  uplink_allow_list=[{{ range $index, $elm := .Integration.MQTT.UplinkAllowList }}
+    "{{ $elm }}",{{ end }}
+  ]
+
   # Terminate on connect error.
   #
   # When set to true, instead of re-trying to connect, the ChirpStack Gateway Bridge
   # process will be terminated on a connection error.
   terminate_on_connect_error={{ .Integration.MQTT.TerminateOnConnectError }}
 
+  # Max. connect attempts.
+  #
+  # The max. number of attempts to connect to the MQTT broker on startup,
+  # after which the ChirpStack Gateway Bridge exits with an error. The interval
+  # between two attempts starts at 2s and is doubled after each attempt, up to
+  # max_reconnect_interval. Set this to 0 to retry forever.
+  max_connect_attempts={{ .Integration.MQTT.MaxConnectAttempts }}
+
+  # Gateway state persistence.
+  #
+  # When a file is configured, the set of gateways for which the command topic
+  # is subscribed is persisted to this file. On startup, these gateways are
+  # re-subscribed immediately, before they re-register with the backend.
+  # Restored gateways that did not re-register within the restore timeout are
+  # unsubscribed again.
+  [integration.mqtt.gateway_state]
+  # Gateway state file. Leave blank to disable.
+  file="{{ .Integration.MQTT.GatewayState.File }}"
+
+  # Restore timeout.
+  #
+  # Valid units are 'ms', 's', 'm', 'h'. Set this to 0 to never unsubscribe
+  # restored gateways.
+  restore_timeout="{{ .Integration.MQTT.GatewayState.RestoreTimeout }}"
+
+  # Subscribe retry.
+  #
+  # When subscribing to the command topic of a gateway fails (e.g. after a
+  # reconnect), the subscription of this gateway is retried with its own
+  # backoff, so that a failing gateway topic does not delay the others. The
+  # interval starts at the initial interval and is doubled after each failed
+  # attempt, up to the max. interval. Valid units are 'ms', 's', 'm', 'h'.
+  #
+  # A subscription that is denied by the broker (e.g. by its ACL) is not
+  # retried until the gateway re-registers or the connection is restored.
+  [integration.mqtt.subscribe_retry]
+  # Initial retry interval.
+  initial_interval="{{ .Integration.MQTT.SubscribeRetry.InitialInterval }}"
+
+  # Max. retry interval.
+  max_interval="{{ .Integration.MQTT.SubscribeRetry.MaxInterval }}"
+
+  # Max. number of attempts.
+  #
+  # After this number of failed attempts, the subscription is not retried
+  # until the gateway re-registers or the connection is restored. Set this
+  # to 0 to retry without limit.
+  max_attempts={{ .Integration.MQTT.SubscribeRetry.MaxAttempts }}
+
+  # Event QoS.
+  #
+  # The quality of service level (0, 1 or 2) used for publishing the uplink,
+  # stats and ack events. Set to -1 to use the qos of the authentication
+  # section (see below). E.g. use 1 for the acks, as their delivery matters
+  # more than the delivery of the stats.
+  [integration.mqtt.event_qos]
+  up={{ .Integration.MQTT.EventQOS.Up }}
+  stats={{ .Integration.MQTT.EventQOS.Stats }}
+  ack={{ .Integration.MQTT.EventQOS.Ack }}
+
+  # Event retained.
+  #
+  # When set, the uplink, stats or ack events are published with the retain
+  # flag set, in which case the broker stores the last event per topic and
+  # delivers it to new subscribers. E.g. enable this for the stats, so that
+  # subscribers don't have to wait for the next stats interval to receive
+  # the last-known stats of a gateway.
+  [integration.mqtt.event_retained]
+  up={{ .Integration.MQTT.EventRetained.Up }}
+  stats={{ .Integration.MQTT.EventRetained.Stats }}
+  ack={{ .Integration.MQTT.EventRetained.Ack }}
+
+  # Downlink buffer.
+  #
+  # The received downlink frames are buffered, so that a slow or stalled
+  # packet-forwarder backend does not block the MQTT client (which could
+  # result in keep-alive timeouts). When the buffer is full, a downlink frame
+  # is dropped (and logged) according to the overflow policy:
+  #   * drop_oldest: the oldest buffered downlink frame is dropped
+  #   * drop_newest: the received downlink frame is dropped
+  #
+  # Set the size to 0 to disable the buffer, in which case the downlink
+  # frames are handled by the MQTT client directly.
+  [integration.mqtt.downlink_buffer]
+  size={{ .Integration.MQTT.DownlinkBuffer.Size }}
+  overflow="{{ .Integration.MQTT.DownlinkBuffer.Overflow }}"
+
+  # Aggregate metrics.
+  #
+  # When a topic is configured, the aggregate counters of the MQTT integration
+  # since start (published uplinks, stats and acks, received downlinks,
+  # publish errors and reconnects) are published as JSON to this topic, using
+  # the given interval. This is independent of the Prometheus metrics (see
+  # the metrics section), e.g. for broker-only monitoring setups.
+  [integration.mqtt.aggregate_metrics]
+  # Topic (leave empty to disable).
+  topic="{{ .Integration.MQTT.AggregateMetrics.Topic }}"
+
+  # Publish interval.
+  interval="{{ .Integration.MQTT.AggregateMetrics.Interval }}"
+
+  # Stats groups.
+  #
+  # The stats of the gateways of a group are also published to the stats
+  # group topic (e.g. for a NOC view), of which the template can use the
+  # .Group and .GatewayID variables. A gateway can be part of multiple groups.
+  [integration.mqtt.stats_groups]
+  # Stats group topic template.
+  topic_template="{{ .Integration.MQTT.StatsGroups.TopicTemplate }}"
+
+  # Group only.
+  #
+  # When set, the stats of the grouped gateways are only published to the
+  # stats group topic, instead of also to the per gateway event topic.
+  group_only={{ .Integration.MQTT.StatsGroups.GroupOnly }}
+
+  # Example:
+  # [[integration.mqtt.stats_groups.groups]]
+  #
+  #   # Name of the group.
+  #   name="north"
+  #
+  #   # Gateway IDs of the group.
+  #   gateway_ids=[
+  #     "0102030405060708",
+  #   ]
+{{ range $i, $group := .Integration.MQTT.StatsGroups.Groups }}
+  [[integration.mqtt.stats_groups.groups]]
+  name="{{ $group.Name }}"
+  gateway_ids=[{{ range $index, $elm := $group.GatewayIDs }}
+    "{{ $elm }}",{{ end }}
+  ]
+{{ end }}
+
+  # Keepalive alerts.
+  #
+  # When a gateway of a keepalive group does not send any events within the
+  # threshold of the group, an alert is published as JSON to the keepalive
+  # topic. Once the gateway sends events again, a recovery is published to
+  # the same topic. The topic template can use the .GatewayID and .Group
+  # variables.
+  [integration.mqtt.keepalive_alerts]
+  # Keepalive topic template.
+  topic_template="{{ .Integration.MQTT.KeepaliveAlerts.TopicTemplate }}"
+
+  # Check interval.
+  #
+  # This defines the interval in which the thresholds are checked and thus
+  # the max. delay of an alert.
+  interval="{{ .Integration.MQTT.KeepaliveAlerts.Interval }}"
+
+  # Example:
+  # [[integration.mqtt.keepalive_alerts.groups]]
+  #
+  #   # Name of the group.
+  #   name="critical"
+  #
+  #   # Silence threshold.
+  #   threshold="5m"
+  #
+  #   # Gateway IDs of the group.
+  #   gateway_ids=[
+  #     "0102030405060708",
+  #   ]
+{{ range $i, $group := .Integration.MQTT.KeepaliveAlerts.Groups }}
+  [[integration.mqtt.keepalive_alerts.groups]]
+  name="{{ $group.Name }}"
+  threshold="{{ $group.Threshold }}"
+  gateway_ids=[{{ range $index, $elm := $group.GatewayIDs }}
+    "{{ $elm }}",{{ end }}
+  ]
+{{ end }}
+
+  # Maintenance windows.
+  #
+  # During a (scheduled) maintenance window of the MQTT broker, connection
+  # errors are logged as warning instead of error, to avoid triggering
+  # alerts. Outside the windows, connection errors are logged as error.
+  #
+  # Example:
+  # [[integration.mqtt.maintenance_windows]]
+  #
+  #   # Start of the window (RFC3339).
+  #   start="2020-10-01T02:00:00Z"
+  #
+  #   # End of the window (RFC3339).
+  #   end="2020-10-01T04:00:00Z"
+{{ range $i, $window := .Integration.MQTT.MaintenanceWindows }}
+  [[integration.mqtt.maintenance_windows]]
+  start="{{ $window.Start }}"
+  end="{{ $window.End }}"
+{{ end }}
+
 
   # MQTT authentication.
   [integration.mqtt.auth]
@@ -268,7 +1288,16 @@ marshaler="{{ .Integration.Marshaler }}"
     # MQTT servers.
     #
     # Configure one or multiple MQTT server to connect to. Each item must be in
-    # the following format: scheme://host:port where scheme is tcp, ssl or ws.
+    # the following format: scheme://host:port where scheme is tcp, ssl, ws or
+    # wss. For the WebSocket schemes, the path can be added, e.g.
+    # wss://host:port/mqtt. The TLS settings below also apply to wss.
+    #
+    # When multiple servers are configured (e.g. for failover), the servers
+    # are tried in the configured order on each (re)connect, the first server
+    # that accepts the connection is used. The bridge does not fall back to
+    # an earlier server while the connection is up, this only happens after
+    # the connection is lost. Note that the servers do not share the
+    # (persistent) session state, unless the brokers are clustered.
     servers=[{{ range $index, $elm := .Integration.MQTT.Auth.Generic.Servers }}
       "{{ $elm }}",{{ end }}
     ]
@@ -279,6 +1308,20 @@ marshaler="{{ .Integration.Marshaler }}"
     # Connect with the given password (optional)
     password="{{ .Integration.MQTT.Auth.Generic.Password }}"
 
+    # Password command (optional)
+    #
+    # When set, the output of this command is used as password. The command
+    # is executed on each (re)connect, e.g. to obtain a short-lived token. In
+    # case the command fails, the password above is used.
+    password_command="{{ .Integration.MQTT.Auth.Generic.PasswordCommand }}"
+
+    # Password file (optional)
+    #
+    # When set, the content of this file is used as password. The file is
+    # read on each (re)connect, so that a rotated token is picked up. This
+    # can't be used together with password_command.
+    password_file="{{ .Integration.MQTT.Auth.Generic.PasswordFile }}"
+
     # Quality of service level
     #
     # 0: at most once
@@ -301,6 +1344,12 @@ marshaler="{{ .Integration.Marshaler }}"
     # Set the client id to be used by this client when connecting to the MQTT
     # broker. A client id must be no longer than 23 characters. When left blank,
     # a random id will be generated. This requires clean_session=true.
+    #
+    # A stable client id is required for persistent sessions and makes the
+    # broker ACLs and connection logs meaningful. The client id is a template,
+    # in which the .Hostname variable can be used, e.g. "bridge-{{ "{{" }} .Hostname {{ "}}" }}".
+    # When the client id is a Gateway ID, the last will and testament of this
+    # gateway is set (see state_topic_template).
     client_id="{{ .Integration.MQTT.Auth.Generic.ClientID }}"
 
     # CA certificate file (optional)
@@ -310,12 +1359,80 @@ marshaler="{{ .Integration.Marshaler }}"
     # on the server (e.g. when self generated).
     ca_cert="{{ .Integration.MQTT.Auth.Generic.CACert }}"
 
+    # Use the system cert pool (optional)
+    #
+    # By default, the configured CA certificate replaces the system cert pool.
+    # When enabled, the CA certificate is added to the system cert pool, so that
+    # both the broker certificates signed by the CA certificate and by publicly
+    # trusted CAs are validated.
+    tls_use_system_cert_pool={{ .Integration.MQTT.Auth.Generic.TLSUseSystemCertPool }}
+
     # mqtt TLS certificate file (optional)
     tls_cert="{{ .Integration.MQTT.Auth.Generic.TLSCert }}"
 
     # mqtt TLS key file (optional)
     tls_key="{{ .Integration.MQTT.Auth.Generic.TLSKey }}"
 
+    # mqtt TLS key password (optional)
+    #
+    # Set this when the TLS key file is encrypted. Both encrypted PKCS#1
+    # ("BEGIN RSA PRIVATE KEY" / "BEGIN EC PRIVATE KEY" with encryption
+    # headers) and encrypted PKCS#8 ("BEGIN ENCRYPTED PRIVATE KEY") keys are
+    # supported.
+    tls_key_password="{{ .Integration.MQTT.Auth.Generic.TLSKeyPassword }}"
+
+    # TLS min. version (optional)
+    #
+    # Valid options are 1.0, 1.1, 1.2 and 1.3. Leave blank to use the Go
+    # default.
+    tls_min_version="{{ .Integration.MQTT.Auth.Generic.TLSMinVersion }}"
+
+    # TLS cipher suites (optional)
+    #
+    # The cipher suites (by name) that may be used for TLS 1.0 - 1.2. Note that
+    # the TLS 1.3 cipher suites are not configurable. Leave empty to use the
+    # Go defaults.
+    #
+    # Example:
+    # tls_cipher_suites=[
+    #   "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+    #   "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+    # ]
+    tls_cipher_suites=[{{ range $index, $elm := .Integration.MQTT.Auth.Generic.TLSCipherSuites }}
+      "{{ $elm }}",{{ end }}
+    ]
+
+      # WebSocket HTTP headers (optional).
+      #
+      # Additional HTTP headers to send with the WebSocket upgrade request
+      # when using the ws or wss scheme (e.g. for an authenticating proxy).
+      #
+      # Example:
+      # X-Auth-Token="secret"
+      [integration.mqtt.auth.generic.http_headers]
+{{ range $k, $v := .Integration.MQTT.Auth.Generic.HTTPHeaders }}      {{ $k }}="{{ $v }}"
+{{ end }}
+      # Fallback MQTT broker.
+      #
+      # When the MQTT broker rejects the credentials (bad username or password,
+      # not authorized) for the configured number of consecutive connection
+      # attempts, the ChirpStack Gateway Bridge switches to the fallback
+      # server and credentials. Leave server blank to disable this feature.
+      # Note that auto_reconnect is disabled when a fallback server is set,
+      # as the connection attempts must be counted by the reconnect loop.
+      [integration.mqtt.auth.generic.fallback]
+      # MQTT server (e.g. scheme://host:port where scheme is tcp, ssl or ws).
+      server="{{ .Integration.MQTT.Auth.Generic.Fallback.Server }}"
+
+      # Connect with the given username (optional).
+      username="{{ .Integration.MQTT.Auth.Generic.Fallback.Username }}"
+
+      # Connect with the given password (optional).
+      password="{{ .Integration.MQTT.Auth.Generic.Fallback.Password }}"
+
+      # Number of consecutive authentication failures before switching.
+      auth_failures={{ .Integration.MQTT.Auth.Generic.Fallback.AuthFailures }}
+
 
     # Google Cloud Platform Cloud IoT Core authentication.
     #
@@ -392,9 +1509,83 @@ marshaler="{{ .Integration.Marshaler }}"
     tls_key="{{ .Integration.MQTT.Auth.AzureIoTHub.TLSKey }}"
 
 
+  # gRPC integration configuration.
+  #
+  # When enabled, a gRPC server is started next to the MQTT integration.
+  # Clients (e.g. a local controller) can stream the uplink frames, gateway
+  # stats and downlink tx acknowledgements and send downlink frames using
+  # the gw.GatewayBridgeService service:
+  #   * StreamUplinkFrames(google.protobuf.Empty) returns (stream gw.UplinkFrame)
+  #   * StreamUplinkFrameSets(google.protobuf.Empty) returns (stream gw.UplinkFrameSet)
+  #   * StreamGatewayStats(google.protobuf.Empty) returns (stream gw.GatewayStats)
+  #   * StreamDownlinkTXAcks(google.protobuf.Empty) returns (stream gw.DownlinkTXAck)
+  #   * SendDownlinkFrame(gw.DownlinkFrame) returns (google.protobuf.Empty)
+  [integration.grpc]
+
+  # Enable the gRPC server.
+  enabled={{ .Integration.GRPC.Enabled }}
+
+  # ip:port to bind the gRPC server to.
+  bind="{{ .Integration.GRPC.Bind }}"
+
+
+  # Google Cloud Pub/Sub integration configuration.
+  #
+  # This integration is used when the integration type is set to gcp_pub_sub.
+  # All events and states are published to a single topic, as Pub/Sub topic
+  # names are static. Each message has the following attributes:
+  #   * gatewayID: Gateway ID as lower-case hex string
+  #   * event:     Event type (e.g. up, stats or ack), for events
+  #   * state:     State type (e.g. conn), for states
+  #
+  # Commands are pulled from the command subscription and must have the
+  # "command" attribute set to one of: down, config, exec or raw. Commands
+  # with a gatewayID attribute for a gateway not connected to this instance
+  # are not acknowledged, so that they are redelivered to the other
+  # subscribers. When multiple ChirpStack Gateway Bridge instances share the
+  # same command topic, a subscription filter on the gatewayID attribute per
+  # instance is recommended.
+  #
+  # The payloads are encoded using the configured marshaler. The service-
+  # account credentials are read from the file set by the
+  # GOOGLE_APPLICATION_CREDENTIALS environment variable. This variable is
+  # required, unless the endpoint is not a Google API endpoint (e.g. when
+  # using the Pub/Sub emulator), in which case requests are not authenticated.
+  [integration.gcp_pub_sub]
+
+  # Pub/Sub API endpoint.
+  endpoint="{{ .Integration.GCPPubSub.Endpoint }}"
+
+  # Google Cloud project ID.
+  project_id="{{ .Integration.GCPPubSub.ProjectID }}"
+
+  # Event topic name.
+  event_topic_name="{{ .Integration.GCPPubSub.EventTopicName }}"
+
+  # Command subscription name.
+  command_subscription_name="{{ .Integration.GCPPubSub.CommandSubscriptionName }}"
+
+  # Publish timeout.
+  publish_timeout="{{ .Integration.GCPPubSub.PublishTimeout }}"
+
+
 # Metrics configuration.
 [metrics]
 
+# Metrics backend.
+#
+# Valid options are:
+#   * prometheus: expose the metrics using the Prometheus endpoint
+#   * statsd:     push the metrics to a StatsD server
+backend="{{ .Metrics.Backend }}"
+
+# Last error metric.
+#
+# When enabled, the last error of the mqtt, udp and config subsystems is
+# exposed by the status_last_error_timestamp_seconds metric, with the
+# (truncated) error as label. Only the last error per subsystem is exposed.
+last_error_metric={{ .Metrics.LastErrorMetric }}
+
   # Metrics stored in Prometheus.
   #
   # These metrics expose information about the state of the ChirpStack Gateway Bridge
@@ -407,6 +1598,37 @@ marshaler="{{ .Integration.Marshaler }}"
   # metrics endpoint.
   bind="{{ .Metrics.Prometheus.Bind }}"
 
+  # Expose the /status endpoint.
+  #
+  # When enabled, the Prometheus metrics server also serves the /status
+  # endpoint, returning the last error of the mqtt, udp and config subsystems
+  # as JSON.
+  status_endpoint_enabled={{ .Metrics.Prometheus.StatusEndpointEnabled }}
+
+  # Expose the /diagnostics endpoint.
+  #
+  # When enabled, the Prometheus metrics server also serves the /diagnostics
+  # endpoint, returning a snapshot of the internal state as JSON (e.g. to
+  # attach to a support ticket). This contains the known gateways (connection
+  # state and last seen timestamp), the counters, the last errors and the
+  # configuration. Passwords and tokens are redacted.
+  diagnostics_endpoint_enabled={{ .Metrics.Prometheus.DiagnosticsEndpointEnabled }}
+
+  # Metrics pushed to StatsD.
+  #
+  # Counters are pushed as the delta since the previous push, gauges as
+  # their current value. Metric labels are appended to the metric name,
+  # e.g. prefix.integration_mqtt_event_count.event.up.
+  [metrics.statsd]
+  # StatsD server (ip:port, UDP).
+  server="{{ .Metrics.StatsD.Server }}"
+
+  # Prefix added to each metric name.
+  prefix="{{ .Metrics.StatsD.Prefix }}"
+
+  # Push interval.
+  interval="{{ .Metrics.StatsD.Interval }}"
+
 
 # Gateway meta-data.
 #
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+)
+
+// deprecatedConfigKeys contains the options which are only supported for
+// backwards compatibility and are therefore not documented.
+var deprecatedConfigKeys = map[string]struct{}{
+	"backend.basic_station.filters.net_ids":   {},
+	"backend.basic_station.filters.join_euis": {},
+	"integration.mqtt.auth.generic.server":    {},
+}
+
+var (
+	configSectionRegexp = regexp.MustCompile(`^\[{1,2}([a-z0-9_.]+)\]{1,2}$`)
+	configKeyRegexp     = regexp.MustCompile(`^([a-z0-9_]+)\s*=`)
+)
+
+// TestConfigTemplate makes sure that every option of the config struct is
+// documented in the config template, including the (commented) examples.
+func TestConfigTemplate(t *testing.T) {
+	assert := require.New(t)
+
+	var buf bytes.Buffer
+	assert.NoError(template.Must(template.New("config").Parse(configTemplate)).Execute(&buf, config.Config{}))
+
+	documented := make(map[string]struct{})
+	var section string
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(scanner.Text()), "#"))
+
+		if m := configSectionRegexp.FindStringSubmatch(line); m != nil {
+			// e.g. [commands.commands.reboot] documents commands.commands
+			section = m[1]
+			for parts := strings.Split(section, "."); len(parts) > 0; parts = parts[:len(parts)-1] {
+				documented[strings.Join(parts, ".")] = struct{}{}
+			}
+			continue
+		}
+
+		if m := configKeyRegexp.FindStringSubmatch(line); m != nil {
+			documented[section+"."+m[1]] = struct{}{}
+		}
+	}
+	assert.NoError(scanner.Err())
+
+	var missing []string
+	for _, key := range configKeys(reflect.TypeOf(config.Config{}), "") {
+		_, ok := documented[key]
+		_, deprecated := deprecatedConfigKeys[key]
+		if !ok && !deprecated {
+			missing = append(missing, key)
+		}
+	}
+	assert.Empty(missing, "options not documented in the config template")
+}
+
+// configKeys returns the keys of the options of the given config struct.
+func configKeys(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		name := strings.Split(f.Tag.Get("mapstructure"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		// anonymous structs are sections, named types (e.g. time.Duration or
+		// the []VirtualGateway elements) are options
+		if f.Type.Kind() == reflect.Struct && f.Type.Name() == "" {
+			keys = append(keys, configKeys(f.Type, name)...)
+			continue
+		}
+		keys = append(keys, name)
+	}
+	return keys
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -37,8 +38,22 @@ func init() {
 
 	// default values
 	viper.SetDefault("general.log_level", 4)
+	viper.SetDefault("general.log_format", "text")
+	viper.SetDefault("general.log_output", "stderr")
+	viper.SetDefault("general.log_file_max_size", 10)
+	viper.SetDefault("general.log_file_max_backups", 5)
+	viper.SetDefault("general.syslog_facility", "user")
+	viper.SetDefault("general.syslog_tag", "chirpstack-gateway-bridge")
+	viper.SetDefault("validation.uplink_action", "disabled")
+	viper.SetDefault("virtual_gateways.deduplication_window", 200*time.Millisecond)
+
 	viper.SetDefault("backend.type", "semtech_udp")
+	viper.SetDefault("backend.in_flight_downlink_timeout", 30*time.Second)
 	viper.SetDefault("backend.semtech_udp.udp_bind", "0.0.0.0:1700")
+	viper.SetDefault("backend.semtech_udp.gateway_timeout", time.Minute)
+	viper.SetDefault("backend.semtech_udp.backpressure.max_ack_delay", 50*time.Millisecond)
+	viper.SetDefault("backend.semtech_udp.normalization.frequency_unit", "mhz")
+	viper.SetDefault("backend.semtech_udp.normalization.gps_time_unit", "ms")
 
 	viper.SetDefault("backend.concentratord.crc_check", true)
 	viper.SetDefault("backend.concentratord.event_url", "ipc:///tmp/concentratord_event")
@@ -53,24 +68,54 @@ func init() {
 	viper.SetDefault("backend.basic_station.frequency_min", 863000000)
 	viper.SetDefault("backend.basic_station.frequency_max", 870000000)
 
+	viper.SetDefault("integration.type", "mqtt")
 	viper.SetDefault("integration.marshaler", "protobuf")
+	viper.SetDefault("integration.json_payload_encoding", "base64")
 	viper.SetDefault("integration.mqtt.auth.type", "generic")
+	viper.SetDefault("integration.grpc.bind", "127.0.0.1:8070")
+	viper.SetDefault("integration.gcp_pub_sub.endpoint", "https://pubsub.googleapis.com")
+	viper.SetDefault("integration.gcp_pub_sub.publish_timeout", time.Second*10)
 
 	viper.SetDefault("integration.mqtt.event_topic_template", "gateway/{{ .GatewayID }}/event/{{ .EventType }}")
 	viper.SetDefault("integration.mqtt.state_topic_template", "gateway/{{ .GatewayID }}/state/{{ .StateType }}")
 	viper.SetDefault("integration.mqtt.command_topic_template", "gateway/{{ .GatewayID }}/command/#")
 	viper.SetDefault("integration.mqtt.state_retained", true)
 	viper.SetDefault("integration.mqtt.keep_alive", 30*time.Second)
+	viper.SetDefault("integration.mqtt.envelope", "none")
+	viper.SetDefault("integration.mqtt.compression", "none")
+	viper.SetDefault("integration.mqtt.auto_reconnect", true)
+	viper.SetDefault("integration.mqtt.reconnect_interval", 2*time.Second)
 	viper.SetDefault("integration.mqtt.max_reconnect_interval", time.Minute)
+	viper.SetDefault("integration.mqtt.publish_timeout", 5*time.Second)
+	viper.SetDefault("integration.mqtt.subscribe_timeout", 5*time.Second)
+	viper.SetDefault("integration.mqtt.gateway_state.restore_timeout", 5*time.Minute)
+	viper.SetDefault("integration.mqtt.subscribe_retry.initial_interval", time.Second)
+	viper.SetDefault("integration.mqtt.subscribe_retry.max_interval", time.Minute)
+	viper.SetDefault("integration.mqtt.downlink_qos", -1)
+	viper.SetDefault("integration.mqtt.downlink_buffer.size", 100)
+	viper.SetDefault("integration.mqtt.downlink_buffer.overflow", "drop_oldest")
+	viper.SetDefault("integration.mqtt.event_qos.up", -1)
+	viper.SetDefault("integration.mqtt.event_qos.stats", -1)
+	viper.SetDefault("integration.mqtt.event_qos.ack", -1)
+	viper.SetDefault("integration.mqtt.aggregate_metrics.interval", time.Minute)
+	viper.SetDefault("integration.mqtt.stats_groups.topic_template", "group/{{ .Group }}/event/stats")
+	viper.SetDefault("integration.mqtt.keepalive_alerts.topic_template", "gateway/{{ .GatewayID }}/alert/keepalive")
+	viper.SetDefault("integration.mqtt.keepalive_alerts.interval", 10*time.Second)
 
 	viper.SetDefault("integration.mqtt.auth.generic.servers", []string{"tcp://127.0.0.1:1883"})
 	viper.SetDefault("integration.mqtt.auth.generic.clean_session", true)
+	viper.SetDefault("integration.mqtt.auth.generic.fallback.auth_failures", 3)
 
 	viper.SetDefault("integration.mqtt.auth.gcp_cloud_iot_core.server", "ssl://mqtt.googleapis.com:8883")
 	viper.SetDefault("integration.mqtt.auth.gcp_cloud_iot_core.jwt_expiration", time.Hour*24)
 
 	viper.SetDefault("integration.mqtt.auth.azure_iot_hub.sas_token_expiration", 24*time.Hour)
 
+	viper.SetDefault("metrics.backend", "prometheus")
+	viper.SetDefault("metrics.statsd.server", "127.0.0.1:8125")
+	viper.SetDefault("metrics.statsd.prefix", "chirpstack_gateway_bridge")
+	viper.SetDefault("metrics.statsd.interval", 10*time.Second)
+
 	viper.SetDefault("meta_data.dynamic.split_delimiter", "=")
 	viper.SetDefault("meta_data.dynamic.execution_interval", time.Minute)
 	viper.SetDefault("meta_data.dynamic.max_execution_duration", time.Second)
@@ -88,14 +133,26 @@ func Execute(v string) {
 }
 
 func initConfig() {
+	conf, err := loadConfig()
+	if err != nil {
+		log.WithError(err).WithField("config", cfgFile).Fatal("load configuration error")
+	}
+	config.C = conf
+}
+
+// loadConfig reads the configuration file and environment variables and
+// returns the resulting configuration.
+func loadConfig() (config.Config, error) {
+	var conf config.Config
+
 	if cfgFile != "" {
 		b, err := ioutil.ReadFile(cfgFile)
 		if err != nil {
-			log.WithError(err).WithField("config", cfgFile).Fatal("error loading config file")
+			return conf, errors.Wrap(err, "read config file error")
 		}
 		viper.SetConfigType("toml")
 		if err := viper.ReadConfig(bytes.NewBuffer(b)); err != nil {
-			log.WithError(err).WithField("config", cfgFile).Fatal("error loading config file")
+			return conf, errors.Wrap(err, "read config file error")
 		}
 	} else {
 		viper.SetConfigName("chirpstack-gateway-bridge")
@@ -106,7 +163,7 @@ func initConfig() {
 			switch err.(type) {
 			case viper.ConfigFileNotFoundError:
 			default:
-				log.WithError(err).Fatal("read configuration file error")
+				return conf, errors.Wrap(err, "read configuration file error")
 			}
 		}
 	}
@@ -123,22 +180,28 @@ func initConfig() {
 		}
 	}
 
-	viperBindEnvs(config.C)
+	viperBindEnvs(conf)
 
-	if err := viper.Unmarshal(&config.C); err != nil {
-		log.WithError(err).Fatal("unmarshal config error")
+	if err := viper.Unmarshal(&conf); err != nil {
+		return conf, errors.Wrap(err, "unmarshal config error")
 	}
 
 	// backwards compatibility when BasicStation filters have been configured.
-	if config.C.Backend.Type == "basic_station" && (len(config.C.Backend.BasicStation.Filters.NetIDs) != 0 || len(config.C.Backend.BasicStation.Filters.JoinEUIs) != 0) {
-		config.C.Filters.NetIDs = config.C.Backend.BasicStation.Filters.NetIDs
-		config.C.Filters.JoinEUIs = config.C.Backend.BasicStation.Filters.JoinEUIs
+	if conf.Backend.Type == "basic_station" && (len(conf.Backend.BasicStation.Filters.NetIDs) != 0 || len(conf.Backend.BasicStation.Filters.JoinEUIs) != 0) {
+		conf.Filters.NetIDs = conf.Backend.BasicStation.Filters.NetIDs
+		conf.Filters.JoinEUIs = conf.Backend.BasicStation.Filters.JoinEUIs
 	}
 
 	// migrate server to servers
-	if config.C.Integration.MQTT.Auth.Generic.Server != "" {
-		config.C.Integration.MQTT.Auth.Generic.Servers = []string{config.C.Integration.MQTT.Auth.Generic.Server}
+	if conf.Integration.MQTT.Auth.Generic.Server != "" {
+		conf.Integration.MQTT.Auth.Generic.Servers = []string{conf.Integration.MQTT.Auth.Generic.Server}
+	}
+
+	if err := conf.NormalizeEUIs(); err != nil {
+		return conf, errors.Wrap(err, "invalid configuration")
 	}
+
+	return conf, nil
 }
 
 func viperBindEnvs(iface interface{}, parts ...string) {
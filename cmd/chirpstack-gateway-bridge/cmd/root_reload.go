@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/integration"
+)
+
+// reloadableKeys contains the config keys that are applied on a
+// configuration reload (SIGHUP). Changes to other keys require a restart.
+var reloadableKeys = map[string]struct{}{
+	"general.log_level":                                  {},
+	"integration.mqtt.event_topic_template":              {},
+	"integration.mqtt.state_topic_template":              {},
+	"integration.mqtt.command_topic_template":            {},
+	"integration.mqtt.gateway_conn_state_topic_template": {},
+}
+
+// reloadConfig re-reads the configuration and applies the reloadable
+// options, without re-connecting to the MQTT broker. Changes to the other
+// options are logged as requiring a restart.
+func reloadConfig() error {
+	conf, err := loadConfig()
+	if err != nil {
+		return errors.Wrap(err, "load configuration error")
+	}
+
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	var restart []string
+	for _, key := range config.C.ChangedKeys(conf) {
+		if _, ok := reloadableKeys[key]; !ok {
+			restart = append(restart, key)
+		}
+	}
+
+	if err := integration.Reload(conf); err != nil {
+		return errors.Wrap(err, "reload integration error")
+	}
+
+	config.C.General.LogLevel = conf.General.LogLevel
+	config.C.Integration.MQTT.EventTopicTemplate = conf.Integration.MQTT.EventTopicTemplate
+	config.C.Integration.MQTT.StateTopicTemplate = conf.Integration.MQTT.StateTopicTemplate
+	config.C.Integration.MQTT.CommandTopicTemplate = conf.Integration.MQTT.CommandTopicTemplate
+	config.C.Integration.MQTT.GatewayConnStateTopicTemplate = conf.Integration.MQTT.GatewayConnStateTopicTemplate
+
+	if err := setLogLevel(); err != nil {
+		return errors.Wrap(err, "set log level error")
+	}
+
+	if len(restart) != 0 {
+		log.WithField("keys", strings.Join(restart, ", ")).Warning("configuration changes require a restart to be applied")
+	}
+
+	log.Info("configuration reloaded")
+
+	return nil
+}
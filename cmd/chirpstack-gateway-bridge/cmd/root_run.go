@@ -1,35 +1,67 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/ackaggregation"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/backend"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/capabilities"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/coalesce"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/commands"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/diagnostics"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/downlinkinjection"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/downlinkoverride"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/filters"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/forwarder"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/gatewaycontrol"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/inflight"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/integration"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/logfile"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/metadata"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/metrics"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/profiling"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/ratelimit"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/status"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/validation"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/virtualgateway"
 )
 
 func run(cmd *cobra.Command, args []string) error {
 
 	tasks := []func() error{
 		setLogLevel,
+		setLogFormat,
+		setLogOutput,
 		setSyslog,
 		printStartMessage,
+		validateConfig,
 		setupFilters,
+		setupValidation,
+		setupRateLimit,
+		setupDownlinkOverride,
+		setupInFlight,
+		setupCapabilities,
+		setupVirtualGateways,
+		setupGatewayControl,
+		setupDiagnostics,
+		setupDownlinkInjection,
+		setupCoalesce,
+		setupAckAggregation,
 		setupBackend,
 		setupIntegration,
 		setupForwarder,
+		setupStatus,
 		setupMetrics,
+		setupProfiling,
 		setupMetaData,
 		setupCommands,
 		startIntegration,
@@ -42,9 +74,18 @@ func run(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	sigChan := make(chan os.Signal)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	log.WithField("signal", <-sigChan).Info("signal received")
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		log.WithField("signal", sig).Info("signal received")
+		if sig != syscall.SIGHUP {
+			break
+		}
+
+		if err := reloadConfig(); err != nil {
+			log.WithError(err).Error("reload configuration error")
+		}
+	}
 	log.Warning("shutting down server")
 
 	integration.GetIntegration().Stop()
@@ -57,6 +98,43 @@ func setLogLevel() error {
 	return nil
 }
 
+func setLogFormat() error {
+	switch config.C.General.LogFormat {
+	case "text":
+		log.SetFormatter(&log.TextFormatter{
+			TimestampFormat: time.RFC3339Nano,
+		})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{
+			TimestampFormat: time.RFC3339Nano,
+		})
+	default:
+		return fmt.Errorf("unknown log format: %s", config.C.General.LogFormat)
+	}
+	return nil
+}
+
+func setLogOutput() error {
+	switch config.C.General.LogOutput {
+	case "stderr":
+	case "syslog":
+		// the output is set by setSyslog
+	case "file":
+		if config.C.General.LogFile == "" {
+			return errors.New("log_file must be set when log_output is file")
+		}
+
+		w, err := logfile.New(config.C.General.LogFile, int64(config.C.General.LogFileMaxSize)*1024*1024, config.C.General.LogFileMaxBackups)
+		if err != nil {
+			return errors.Wrap(err, "open log file error")
+		}
+		log.SetOutput(w)
+	default:
+		return fmt.Errorf("unknown log output: %s", config.C.General.LogOutput)
+	}
+	return nil
+}
+
 func printStartMessage() error {
 	log.WithFields(log.Fields{
 		"version": version,
@@ -65,6 +143,10 @@ func printStartMessage() error {
 	return nil
 }
 
+func validateConfig() error {
+	return config.C.Validate()
+}
+
 func setupBackend() error {
 	if err := backend.Setup(config.C); err != nil {
 		return errors.Wrap(err, "setup backend error")
@@ -93,6 +175,55 @@ func setupMetrics() error {
 	return nil
 }
 
+func setupProfiling() error {
+	if err := profiling.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup profiling error")
+	}
+	return nil
+}
+
+func setupCoalesce() error {
+	if err := coalesce.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup uplink coalescing error")
+	}
+	return nil
+}
+
+func setupAckAggregation() error {
+	if err := ackaggregation.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup error ack aggregation error")
+	}
+	return nil
+}
+
+func setupGatewayControl() error {
+	if err := gatewaycontrol.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup gateway control error")
+	}
+	return nil
+}
+
+func setupDiagnostics() error {
+	if err := diagnostics.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup diagnostics error")
+	}
+	return nil
+}
+
+func setupDownlinkInjection() error {
+	if err := downlinkinjection.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup downlink injection error")
+	}
+	return nil
+}
+
+func setupStatus() error {
+	if err := status.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup status error")
+	}
+	return nil
+}
+
 func setupMetaData() error {
 	if err := metadata.Setup(config.C); err != nil {
 		return errors.Wrap(err, "setup meta-data error")
@@ -107,6 +238,48 @@ func setupFilters() error {
 	return nil
 }
 
+func setupInFlight() error {
+	if err := inflight.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup in-flight error")
+	}
+	return nil
+}
+
+func setupCapabilities() error {
+	if err := capabilities.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup capabilities error")
+	}
+	return nil
+}
+
+func setupValidation() error {
+	if err := validation.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup validation error")
+	}
+	return nil
+}
+
+func setupRateLimit() error {
+	if err := ratelimit.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup ratelimit error")
+	}
+	return nil
+}
+
+func setupDownlinkOverride() error {
+	if err := downlinkoverride.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup downlinkoverride error")
+	}
+	return nil
+}
+
+func setupVirtualGateways() error {
+	if err := virtualgateway.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup virtual gateways error")
+	}
+	return nil
+}
+
 func setupCommands() error {
 	if err := commands.Setup(config.C); err != nil {
 		return errors.Wrap(err, "setup commands error")
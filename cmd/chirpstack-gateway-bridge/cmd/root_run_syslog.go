@@ -3,6 +3,8 @@
 package cmd
 
 import (
+	"fmt"
+	"io/ioutil"
 	"log/syslog"
 
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
@@ -11,34 +13,67 @@ import (
 	lsyslog "github.com/sirupsen/logrus/hooks/syslog"
 )
 
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
 func setSyslog() error {
-	if !config.C.General.LogToSyslog {
+	if !config.C.General.LogToSyslog && config.C.General.LogOutput != "syslog" {
 		return nil
 	}
 
+	facility, ok := syslogFacilities[config.C.General.SyslogFacility]
+	if !ok {
+		return fmt.Errorf("unknown syslog facility: %s", config.C.General.SyslogFacility)
+	}
+
 	var prio syslog.Priority
 
 	switch log.StandardLogger().Level {
 	case log.DebugLevel:
-		prio = syslog.LOG_USER | syslog.LOG_DEBUG
+		prio = facility | syslog.LOG_DEBUG
 	case log.InfoLevel:
-		prio = syslog.LOG_USER | syslog.LOG_INFO
+		prio = facility | syslog.LOG_INFO
 	case log.WarnLevel:
-		prio = syslog.LOG_USER | syslog.LOG_WARNING
+		prio = facility | syslog.LOG_WARNING
 	case log.ErrorLevel:
-		prio = syslog.LOG_USER | syslog.LOG_ERR
+		prio = facility | syslog.LOG_ERR
 	case log.FatalLevel:
-		prio = syslog.LOG_USER | syslog.LOG_CRIT
+		prio = facility | syslog.LOG_CRIT
 	case log.PanicLevel:
-		prio = syslog.LOG_USER | syslog.LOG_CRIT
+		prio = facility | syslog.LOG_CRIT
 	}
 
-	hook, err := lsyslog.NewSyslogHook("", "", prio, "chirpstack-gateway-bridge")
+	hook, err := lsyslog.NewSyslogHook("", "", prio, config.C.General.SyslogTag)
 	if err != nil {
 		return errors.Wrap(err, "get syslog hook error")
 	}
 
 	log.AddHook(hook)
 
+	// only log to syslog
+	if config.C.General.LogOutput == "syslog" {
+		log.SetOutput(ioutil.Discard)
+	}
+
 	return nil
 }
@@ -8,7 +8,7 @@ import (
 )
 
 func setSyslog() error {
-	if config.C.General.LogToSyslog {
+	if config.C.General.LogToSyslog || config.C.General.LogOutput == "syslog" {
 		log.Fatal("syslog logging is not supported on Windows")
 	}
 
@@ -0,0 +1,144 @@
+// Package ackaggregation implements the aggregation of identical error tx
+// acknowledgements of a gateway (e.g. a burst of TOO_LATE acks caused by a
+// clock skew) into a single summary acknowledgement.
+package ackaggregation
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+// aggregate holds the error acks of a gateway, aggregated within the
+// current window. The first error ack is published as-is, the last one is
+// kept for the summary.
+type aggregate struct {
+	error string
+	count int
+	last  gw.DownlinkTXAck
+}
+
+var (
+	mux     sync.Mutex
+	window  time.Duration
+	pending map[lorawan.EUI64]*aggregate
+)
+
+// Setup configures the ackaggregation package.
+func Setup(conf config.Config) error {
+	mux.Lock()
+	defer mux.Unlock()
+
+	window = conf.AckAggregation.Window
+	pending = make(map[lorawan.EUI64]*aggregate)
+
+	if window != 0 {
+		log.WithFields(log.Fields{
+			"window": window,
+		}).Info("ackaggregation: error ack aggregation configured")
+	}
+
+	return nil
+}
+
+// Enabled returns true when the error ack aggregation is enabled.
+func Enabled() bool {
+	mux.Lock()
+	defer mux.Unlock()
+
+	return window != 0
+}
+
+// DownlinkTXAck aggregates the given tx acknowledgement, the given function is
+// called for each acknowledgement that must be published. An error ack
+// starts the aggregation window for the gateway and is published as-is.
+// Identical error acks within the window are aggregated and published as a
+// single summary ack (with the number of aggregated acks added to the error)
+// when the window expires. A different error or a successful ack flushes the
+// summary immediately, after which the given ack is handled as usual.
+func DownlinkTXAck(pl gw.DownlinkTXAck, f func(gw.DownlinkTXAck)) {
+	var gatewayID lorawan.EUI64
+	copy(gatewayID[:], pl.GetGatewayId())
+
+	var publish []gw.DownlinkTXAck
+
+	mux.Lock()
+	agg, ok := pending[gatewayID]
+	if ok && pl.Error != "" && pl.Error == agg.error {
+		agg.count++
+		agg.last = pl
+		mux.Unlock()
+		return
+	}
+
+	if ok {
+		if summary, ok := agg.summary(); ok {
+			publish = append(publish, summary)
+		}
+		delete(pending, gatewayID)
+	}
+
+	if pl.Error != "" {
+		agg = &aggregate{error: pl.Error}
+		pending[gatewayID] = agg
+		time.AfterFunc(window, func() {
+			flush(gatewayID, agg, f)
+		})
+	}
+	mux.Unlock()
+
+	for _, ack := range append(publish, pl) {
+		f(ack)
+	}
+}
+
+// flush publishes the summary of the given aggregate when its window has
+// expired. When error acks were aggregated, a new window is started, else
+// the aggregation for the gateway ends.
+func flush(gatewayID lorawan.EUI64, agg *aggregate, f func(gw.DownlinkTXAck)) {
+	mux.Lock()
+	if pending[gatewayID] != agg {
+		mux.Unlock()
+		return
+	}
+
+	summary, ok := agg.summary()
+	if !ok {
+		delete(pending, gatewayID)
+		mux.Unlock()
+		return
+	}
+
+	agg.count = 0
+	time.AfterFunc(window, func() {
+		flush(gatewayID, agg, f)
+	})
+	mux.Unlock()
+
+	log.WithFields(log.Fields{
+		"gateway_id": gatewayID,
+		"error":      summary.Error,
+	}).Info("ackaggregation: publishing aggregated error ack")
+
+	f(summary)
+}
+
+// summary returns the summary ack of the aggregated acks. It returns false
+// when no acks were aggregated.
+func (a *aggregate) summary() (gw.DownlinkTXAck, bool) {
+	if a.count == 0 {
+		return gw.DownlinkTXAck{}, false
+	}
+
+	aggregatedCounter().Add(float64(a.count))
+
+	summary := a.last
+	summary.Error = fmt.Sprintf("%s (aggregated: %d)", a.error, a.count)
+	return summary, true
+}
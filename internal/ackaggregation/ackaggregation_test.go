@@ -0,0 +1,129 @@
+package ackaggregation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+)
+
+func TestDownlinkTXAck(t *testing.T) {
+	var conf config.Config
+	conf.AckAggregation.Window = 100 * time.Millisecond
+	require.NoError(t, Setup(conf))
+
+	ack := func(gatewayID byte, token uint32, err string) gw.DownlinkTXAck {
+		return gw.DownlinkTXAck{
+			GatewayId: []byte{1, 2, 3, 4, 5, 6, 7, gatewayID},
+			Token:     token,
+			Error:     err,
+		}
+	}
+
+	ackChan := make(chan gw.DownlinkTXAck, 10)
+	f := func(pl gw.DownlinkTXAck) {
+		ackChan <- pl
+	}
+
+	// expectAck asserts the next published ack.
+	expectAck := func(assert *require.Assertions, token uint32, err string) {
+		select {
+		case pl := <-ackChan:
+			assert.Equal(token, pl.Token)
+			assert.Equal(err, pl.Error)
+		case <-time.After(time.Second):
+			assert.Fail("expected ack", "token: %d", token)
+		}
+	}
+
+	// expectNoAck asserts that no ack is published within the given duration.
+	expectNoAck := func(assert *require.Assertions, d time.Duration) {
+		select {
+		case pl := <-ackChan:
+			assert.Fail("unexpected ack", "token: %d, error: %s", pl.Token, pl.Error)
+		case <-time.After(d):
+		}
+	}
+
+	t.Run("Successful acks are not aggregated", func(t *testing.T) {
+		assert := require.New(t)
+		assert.True(Enabled())
+
+		DownlinkTXAck(ack(1, 1, ""), f)
+		DownlinkTXAck(ack(1, 2, ""), f)
+		expectAck(assert, 1, "")
+		expectAck(assert, 2, "")
+	})
+
+	t.Run("Identical errors are aggregated", func(t *testing.T) {
+		assert := require.New(t)
+
+		for i := uint32(1); i <= 4; i++ {
+			DownlinkTXAck(ack(2, i, "TOO_LATE"), f)
+		}
+
+		// the first error is published as-is
+		expectAck(assert, 1, "TOO_LATE")
+		expectNoAck(assert, 50*time.Millisecond)
+
+		// the summary is published when the window expires
+		expectAck(assert, 4, "TOO_LATE (aggregated: 3)")
+
+		t.Run("Aggregation ends after an empty window", func(t *testing.T) {
+			assert := require.New(t)
+
+			expectNoAck(assert, 250*time.Millisecond)
+
+			DownlinkTXAck(ack(2, 5, "TOO_LATE"), f)
+			expectAck(assert, 5, "TOO_LATE")
+		})
+	})
+
+	t.Run("Different error flushes immediately", func(t *testing.T) {
+		assert := require.New(t)
+
+		DownlinkTXAck(ack(3, 1, "TOO_LATE"), f)
+		DownlinkTXAck(ack(3, 2, "TOO_LATE"), f)
+		DownlinkTXAck(ack(3, 3, "TOO_LATE"), f)
+		DownlinkTXAck(ack(3, 4, "COLLISION_PACKET"), f)
+
+		expectAck(assert, 1, "TOO_LATE")
+		expectAck(assert, 3, "TOO_LATE (aggregated: 2)")
+		expectAck(assert, 4, "COLLISION_PACKET")
+	})
+
+	t.Run("Successful ack flushes immediately", func(t *testing.T) {
+		assert := require.New(t)
+
+		DownlinkTXAck(ack(4, 1, "TOO_LATE"), f)
+		DownlinkTXAck(ack(4, 2, "TOO_LATE"), f)
+		DownlinkTXAck(ack(4, 3, ""), f)
+		DownlinkTXAck(ack(4, 4, ""), f)
+
+		expectAck(assert, 1, "TOO_LATE")
+		expectAck(assert, 2, "TOO_LATE (aggregated: 1)")
+		expectAck(assert, 3, "")
+		expectAck(assert, 4, "")
+		expectNoAck(assert, 150*time.Millisecond)
+	})
+
+	t.Run("Gateways are aggregated independently", func(t *testing.T) {
+		assert := require.New(t)
+
+		DownlinkTXAck(ack(5, 1, "TOO_LATE"), f)
+		DownlinkTXAck(ack(6, 2, "TOO_LATE"), f)
+
+		expectAck(assert, 1, "TOO_LATE")
+		expectAck(assert, 2, "TOO_LATE")
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		assert := require.New(t)
+
+		require.NoError(t, Setup(config.Config{}))
+		assert.False(Enabled())
+	})
+}
@@ -0,0 +1,17 @@
+package ackaggregation
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	aac = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ackaggregation_aggregated_count",
+		Help: "The number of error tx acknowledgements aggregated into a summary acknowledgement.",
+	})
+)
+
+func aggregatedCounter() prometheus.Counter {
+	return aac
+}
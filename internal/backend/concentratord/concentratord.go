@@ -359,6 +359,7 @@ func (b *Backend) handleUplinkFrame(bb []byte) error {
 			"uplink_id":  uplinkID,
 			"crc_status": pl.GetRxInfo().GetCrcStatus(),
 		}).Debug("backend/concentratord: ignoring uplink event, CRC is not valid")
+		crcDroppedCounter(pl.GetRxInfo().GetCrcStatus().String()).Inc()
 		return nil
 	}
 
@@ -15,6 +15,11 @@ var (
 		Name: "backend_concentratord_command_count",
 		Help: "The number of received commands (per type)",
 	}, []string{"command"})
+
+	crc = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "backend_concentratord_uplink_crc_dropped_count",
+		Help: "The number of uplink frames dropped because of an invalid CRC (per crc_status)",
+	}, []string{"crc_status"})
 )
 
 func eventCounter(typ string) prometheus.Counter {
@@ -24,3 +29,7 @@ func eventCounter(typ string) prometheus.Counter {
 func commandCounter(typ string) prometheus.Counter {
 	return cc.With(prometheus.Labels{"command": typ})
 }
+
+func crcDroppedCounter(status string) prometheus.Counter {
+	return crc.With(prometheus.Labels{"crc_status": status})
+}
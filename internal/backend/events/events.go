@@ -1,6 +1,10 @@
 package events
 
-import "github.com/brocaar/lorawan"
+import (
+	"github.com/golang/protobuf/proto"
+
+	"github.com/brocaar/lorawan"
+)
 
 // Subscribe event
 type Subscribe struct {
@@ -10,3 +14,27 @@ type Subscribe struct {
 	// Subscribe (true) or unsubscribe (false) the gateway.
 	Subscribe bool
 }
+
+// GatewayConfigAck event, published after a gateway configuration has been
+// applied by the backend. It implements proto.Message so that it can be
+// encoded by the configured marshaler.
+type GatewayConfigAck struct {
+	// Gateway ID.
+	GatewayId []byte `protobuf:"bytes,1,opt,name=gateway_id,json=gatewayID,proto3" json:"gateway_id,omitempty"`
+
+	// Version of the applied configuration.
+	Version string `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+
+	// Error in case the configuration could not be applied (empty on
+	// success).
+	Error string `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+}
+
+// Reset implements proto.Message.
+func (m *GatewayConfigAck) Reset() { *m = GatewayConfigAck{} }
+
+// String implements proto.Message.
+func (m *GatewayConfigAck) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage implements proto.Message.
+func (*GatewayConfigAck) ProtoMessage() {}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/patrickmn/go-cache"
@@ -18,9 +19,15 @@ import (
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/backend/semtechudp/packets"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/filters"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/status"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/uplinkmeta"
 	"github.com/brocaar/lorawan"
 )
 
+// packetQueueSize defines the number of received packets that are queued
+// for the workers, before reading from the UDP listener blocks.
+const packetQueueSize = 1024
+
 // udpPacket represents a raw UDP packet.
 type udpPacket struct {
 	addr *net.UDPAddr
@@ -44,16 +51,103 @@ type Backend struct {
 
 	udpSendChan chan udpPacket
 
+	// Received packets to be handled by the workers. When nil, each packet
+	// is handled in its own goroutine.
+	packetChan chan udpPacket
+	workers    int
+
 	wg           sync.WaitGroup
 	conn         *net.UDPConn
 	closed       bool
 	gateways     gateways
 	fakeRxTime   bool
 	skipCRCCheck bool
+
+	// Tag the received uplinks with the source UDP address of the
+	// packet-forwarder.
+	sourceAddrMetaData bool
+
+	// Non-standard rxpk and stat fields, which are copied to the meta-data
+	// of the published uplinks and stats.
+	extensionFields []string
+
+	// Number of antennas per gateway, used to validate the antenna of the
+	// received uplink frames.
+	antennas map[lorawan.EUI64]uint32
+
+	// Max. number of retries per tx acknowledgement status. When nil, each
+	// error is retried using the next downlink frame item.
+	txAckRetries map[gw.TxAckStatus]int
+
+	// Duration to wait for the TX_ACK of a downlink frame item (0 =
+	// disabled). When expired, the item is retransmitted once (when
+	// txAckRetransmit is set), else a failed tx acknowledgement is reported.
+	txAckTimeout    time.Duration
+	txAckRetransmit bool
+	txAckTimersMux  sync.Mutex
+	txAckTimers     map[uint16]*time.Timer
+
+	// Normalizer for the units of the received rxpk objects.
+	normalizer normalizer
+
+	// UDP dropped datagrams source and last value.
+	udpDropsFunc     func() (uint64, error)
+	udpDrops         uint64
+	udpDropsInterval time.Duration
+
+	// Interval after which the collected stats are published, when the
+	// packet-forwarder did not send its own stats.
+	statsInterval time.Duration
+
+	// Number of received packets that are being handled and the
+	// backpressure settings used to delay the PUSH_ACK responses.
+	pending                 int64
+	backpressureThreshold   int64
+	backpressureMaxACKDelay time.Duration
+
+	// PULL_DATA monitoring settings.
+	pullDataMaxInterval time.Duration
+	logPortChanges      bool
 }
 
 // NewBackend creates a new backend.
 func NewBackend(conf config.Config) (*Backend, error) {
+	antennas := make(map[lorawan.EUI64]uint32)
+	for _, gwConf := range conf.Backend.SemtechUDP.Gateways {
+		var gatewayID lorawan.EUI64
+		if err := gatewayID.UnmarshalText([]byte(gwConf.GatewayID)); err != nil {
+			return nil, errors.Wrap(err, "unmarshal gateway id error")
+		}
+
+		if gwConf.Antennas != 0 {
+			antennas[gatewayID] = gwConf.Antennas
+		}
+	}
+
+	var txAckRetries map[gw.TxAckStatus]int
+	if len(conf.Backend.SemtechUDP.TXAckRetries) != 0 {
+		txAckRetries = make(map[gw.TxAckStatus]int)
+	}
+	for _, retry := range conf.Backend.SemtechUDP.TXAckRetries {
+		status, ok := gw.TxAckStatus_value[retry.Status]
+		if !ok {
+			return nil, fmt.Errorf("unknown tx ack status: %s", retry.Status)
+		}
+		txAckRetries[gw.TxAckStatus(status)] = retry.Retries
+	}
+
+	normalizer, err := newNormalizer(
+		conf.Backend.SemtechUDP.Normalization.FrequencyUnit,
+		conf.Backend.SemtechUDP.Normalization.GPSTimeUnit,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "new normalizer error")
+	}
+
+	if conf.Backend.SemtechUDP.Workers < 0 {
+		return nil, fmt.Errorf("invalid number of workers: %d", conf.Backend.SemtechUDP.Workers)
+	}
+
 	addr, err := net.ResolveUDPAddr("udp", conf.Backend.SemtechUDP.UDPBind)
 	if err != nil {
 		return nil, errors.Wrap(err, "resolve udp addr error")
@@ -65,15 +159,54 @@ func NewBackend(conf config.Config) (*Backend, error) {
 		return nil, errors.Wrap(err, "listen udp error")
 	}
 
+	if conf.Backend.SemtechUDP.UDPReadBuffer != 0 {
+		if err := conn.SetReadBuffer(conf.Backend.SemtechUDP.UDPReadBuffer); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "set udp read buffer error")
+		}
+	}
+
 	b := &Backend{
 		conn:        conn,
 		udpSendChan: make(chan udpPacket),
 		gateways: gateways{
 			gateways: make(map[lorawan.EUI64]gateway),
+			timeout:  conf.Backend.SemtechUDP.GatewayTimeout,
 		},
 		fakeRxTime:   conf.Backend.SemtechUDP.FakeRxTime,
 		skipCRCCheck: conf.Backend.SemtechUDP.SkipCRCCheck,
 		cache:        cache.New(15*time.Second, 15*time.Second),
+		antennas:     antennas,
+		txAckRetries: txAckRetries,
+		normalizer:   normalizer,
+
+		txAckTimeout:    conf.Backend.SemtechUDP.TXAckTimeout,
+		txAckRetransmit: conf.Backend.SemtechUDP.TXAckRetransmit,
+		txAckTimers:     make(map[uint16]*time.Timer),
+
+		sourceAddrMetaData: conf.Backend.SemtechUDP.SourceAddrMetaData,
+		extensionFields:    conf.Backend.SemtechUDP.ExtensionFields,
+
+		udpDropsInterval: conf.Backend.SemtechUDP.UDPDropsInterval,
+		statsInterval:    conf.Backend.SemtechUDP.StatsInterval,
+
+		backpressureThreshold:   int64(conf.Backend.SemtechUDP.Backpressure.Threshold),
+		backpressureMaxACKDelay: conf.Backend.SemtechUDP.Backpressure.MaxACKDelay,
+
+		pullDataMaxInterval: conf.Backend.SemtechUDP.PullDataMonitor.MaxInterval,
+		logPortChanges:      conf.Backend.SemtechUDP.PullDataMonitor.LogPortChanges,
+	}
+	b.udpDropsFunc = b.getUDPDrops
+
+	if conf.Backend.SemtechUDP.Workers != 0 {
+		b.workers = conf.Backend.SemtechUDP.Workers
+		b.packetChan = make(chan udpPacket, packetQueueSize)
+	}
+
+	// a short gateway timeout requires a shorter cleanup interval
+	cleanupInterval := time.Minute
+	if timeout := b.gateways.getTimeout(); timeout < cleanupInterval {
+		cleanupInterval = timeout
 	}
 
 	go func() {
@@ -82,7 +215,7 @@ func NewBackend(conf config.Config) (*Backend, error) {
 			if err := b.gateways.cleanup(); err != nil {
 				log.WithError(err).Error("backend/semtechudp: gateway registry cleanup failed")
 			}
-			time.Sleep(time.Minute)
+			time.Sleep(cleanupInterval)
 		}
 	}()
 
@@ -92,15 +225,27 @@ func NewBackend(conf config.Config) (*Backend, error) {
 // Start stats the backend.
 func (b *Backend) Start() error {
 	// Add the waitgroups before the goroutines or a race occurs with closing
-	b.wg.Add(2)
+	b.wg.Add(2 + b.workers)
 	go func() {
 		err := b.readPackets()
 		if !b.isClosed() {
 			log.WithError(err).Error("backend/semtechudp: read udp packets error")
 		}
+		if b.packetChan != nil {
+			close(b.packetChan)
+		}
 		b.wg.Done()
 	}()
 
+	for i := 0; i < b.workers; i++ {
+		go func() {
+			for up := range b.packetChan {
+				b.handleUDPPacket(up)
+			}
+			b.wg.Done()
+		}()
+	}
+
 	go func() {
 		err := b.sendPackets()
 		if !b.isClosed() {
@@ -109,6 +254,14 @@ func (b *Backend) Start() error {
 		b.wg.Done()
 	}()
 
+	if b.udpDropsInterval != 0 {
+		go b.udpDropsLoop(b.udpDropsInterval)
+	}
+
+	if b.statsInterval != 0 {
+		go b.statsLoop(b.statsInterval)
+	}
+
 	return nil
 }
 
@@ -166,6 +319,8 @@ func (b *Backend) SendDownlinkFrame(frame gw.DownlinkFrame) error {
 		frame.Token = uint32(binary.BigEndian.Uint16(tokenB))
 	}
 
+	b.cache.Set(fmt.Sprintf("%d:retries", frame.Token), 0, cache.DefaultExpiration)
+
 	acks := make([]*gw.DownlinkTXAckItem, len(frame.Items))
 	for i := range acks {
 		acks[i] = &gw.DownlinkTXAckItem{
@@ -193,6 +348,9 @@ func (b *Backend) sendDownlinkFrame(frame gw.DownlinkFrame, i int, txAckItems []
 	if err != nil {
 		return errors.Wrap(err, "get gateway error")
 	}
+	if gw.addr == nil {
+		return errors.Wrap(errGatewayNoPullData, "get gateway error")
+	}
 
 	pullResp, err := packets.GetPullRespPacket(gw.protocolVersion, uint16(frame.Token), frame, i)
 	if err != nil {
@@ -208,6 +366,12 @@ func (b *Backend) sendDownlinkFrame(frame gw.DownlinkFrame, i int, txAckItems []
 		data: bytes,
 		addr: gw.addr,
 	}
+
+	// protocol version 1 packet-forwarders do not send TX_ACK packets
+	if gw.protocolVersion != packets.ProtocolVersion1 {
+		b.startTXAckTimer(uint16(frame.Token), i)
+	}
+
 	return nil
 }
 
@@ -236,6 +400,7 @@ func (b *Backend) readPackets() error {
 				return nil
 			}
 
+			status.SetLastError(status.UDP, err)
 			log.WithError(err).Error("gateway: read from udp error")
 			continue
 		}
@@ -243,15 +408,25 @@ func (b *Backend) readPackets() error {
 		copy(data, buf[:i])
 		up := udpPacket{data: data, addr: addr}
 
-		// handle packet async
-		go func(up udpPacket) {
-			if err := b.handlePacket(up); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"data_base64": base64.StdEncoding.EncodeToString(up.data),
-					"addr":        up.addr,
-				}).Error("backend/semtechudp: could not handle packet")
-			}
-		}(up)
+		// handle packet async, by the workers or in a new goroutine
+		atomic.AddInt64(&b.pending, 1)
+		if b.packetChan != nil {
+			b.packetChan <- up
+		} else {
+			go b.handleUDPPacket(up)
+		}
+	}
+}
+
+func (b *Backend) handleUDPPacket(up udpPacket) {
+	defer atomic.AddInt64(&b.pending, -1)
+
+	if err := b.handlePacket(up); err != nil {
+		status.SetLastError(status.UDP, err)
+		log.WithError(err).WithFields(log.Fields{
+			"data_base64": base64.StdEncoding.EncodeToString(up.data),
+			"addr":        up.addr,
+		}).Error("backend/semtechudp: could not handle packet")
 	}
 }
 
@@ -274,6 +449,7 @@ func (b *Backend) sendPackets() error {
 
 		_, err = b.conn.WriteToUDP(p.data, p.addr)
 		if err != nil {
+			status.SetLastError(status.UDP, err)
 			log.WithFields(log.Fields{
 				"addr":             p.addr,
 				"type":             pt,
@@ -286,6 +462,21 @@ func (b *Backend) sendPackets() error {
 	return nil
 }
 
+// sendPacketAfter sends the given packet after the given delay. The packet is
+// not sent when the backend has been closed in the meantime.
+func (b *Backend) sendPacketAfter(p udpPacket, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		b.RLock()
+		defer b.RUnlock()
+
+		if b.closed {
+			return
+		}
+
+		b.udpSendChan <- p
+	})
+}
+
 func (b *Backend) handlePacket(up udpPacket) error {
 	b.RLock()
 	defer b.RUnlock()
@@ -332,9 +523,14 @@ func (b *Backend) handlePullData(up udpPacket) error {
 		return errors.Wrap(err, "marshal pull ack packet error")
 	}
 
+	now := time.Now().UTC()
+	if prev, err := b.gateways.get(p.GatewayMAC); err == nil {
+		b.monitorPullData(p.GatewayMAC, prev, up.addr, now)
+	}
+
 	err = b.gateways.set(p.GatewayMAC, gateway{
 		addr:            up.addr,
-		lastSeen:        time.Now().UTC(),
+		lastSeen:        now,
 		protocolVersion: p.ProtocolVersion,
 	})
 	if err != nil {
@@ -348,12 +544,54 @@ func (b *Backend) handlePullData(up udpPacket) error {
 	return nil
 }
 
+// monitorPullData compares the PULL_DATA received from the given address
+// with the previous PULL_DATA of the gateway. A growing interval or a changed
+// source address indicates that the NAT mapping of the gateway was dropped,
+// which breaks the downlink path until the next PULL_DATA.
+func (b *Backend) monitorPullData(gatewayID lorawan.EUI64, prev gateway, addr *net.UDPAddr, now time.Time) {
+	// the gateway was registered by its PUSH_DATA
+	if prev.lastSeen.IsZero() {
+		return
+	}
+
+	interval := now.Sub(prev.lastSeen)
+	pullDataIntervalHistogram().Observe(interval.Seconds())
+
+	if b.pullDataMaxInterval > 0 && interval > b.pullDataMaxInterval {
+		pullDataIntervalExceededCounter().Inc()
+
+		log.WithFields(log.Fields{
+			"gateway_id":   gatewayID,
+			"interval":     interval,
+			"max_interval": b.pullDataMaxInterval,
+		}).Warning("backend/semtechudp: PULL_DATA interval exceeds max. interval, check the NAT / network path of the gateway")
+	}
+
+	if prev.addr == nil || (prev.addr.IP.Equal(addr.IP) && prev.addr.Port == addr.Port) {
+		return
+	}
+
+	addrChangeCounter().Inc()
+
+	if b.logPortChanges {
+		log.WithFields(log.Fields{
+			"gateway_id":    gatewayID,
+			"addr":          addr,
+			"previous_addr": prev.addr,
+		}).Warning("backend/semtechudp: PULL_DATA source address changed, possible NAT rebinding")
+	}
+}
+
 func (b *Backend) handleTXACK(up udpPacket) error {
 	var p packets.TXACKPacket
 	if err := p.UnmarshalBinary(up.data); err != nil {
 		return err
 	}
 
+	if !b.stopTXAckTimer(p.RandomToken) {
+		return fmt.Errorf("tx ack for token %d received after the tx ack timeout", p.RandomToken)
+	}
+
 	// get downlink frame from cache
 	var frame gw.DownlinkFrame
 	v, ok := b.cache.Get(fmt.Sprintf("%d:frame", p.RandomToken))
@@ -395,19 +633,28 @@ func (b *Backend) handleTXACK(up udpPacket) error {
 		return errors.New("cache items are out of sync")
 	}
 
+	status := gw.TxAckStatus_OK
+	if p.Payload != nil {
+		var known bool
+		status, known = p.Payload.TXPKACK.GetTxAckStatus()
+		if !known {
+			log.WithFields(log.Fields{
+				"gateway_id": p.GatewayMAC,
+				"token":      p.RandomToken,
+				"error":      p.Payload.TXPKACK.Error,
+			}).Warning("backend/semtechudp: unknown tx ack error, reporting internal error")
+		}
+	}
+
 	// did the received ack contain an error?
-	if p.Payload != nil && p.Payload.TXPKACK.Error != "" && p.Payload.TXPKACK.Error != "NONE" {
+	if status != gw.TxAckStatus_OK {
 		// set tx ack error
-		if v, ok := gw.TxAckStatus_value[p.Payload.TXPKACK.Error]; ok {
-			txAckItems[itemIndex] = &gw.DownlinkTXAckItem{
-				Status: gw.TxAckStatus(v),
-			}
-		} else {
-			return fmt.Errorf("unexpected error: %s", p.Payload.TXPKACK.Error)
+		txAckItems[itemIndex] = &gw.DownlinkTXAckItem{
+			Status: status,
 		}
 
 		// can we retry?
-		if itemIndex < len(frame.Items)-1 {
+		if itemIndex < len(frame.Items)-1 && b.retryTXAck(p.RandomToken, txAckItems[itemIndex].Status) {
 			// retry with next option
 			return b.sendDownlinkFrame(frame, itemIndex+1, txAckItems)
 		}
@@ -452,6 +699,19 @@ func (b *Backend) handlePushData(up udpPacket) error {
 		return err
 	}
 
+	// the PUSH_DATA registers the gateway or keeps it registered, e.g. when
+	// the PULL_DATA is lost on its way to the bridge
+	b.gateways.pushData(p.GatewayMAC, p.ProtocolVersion, time.Now().UTC())
+
+	for i := range p.Payload.RXPK {
+		b.normalizer.RXPK(&p.Payload.RXPK[i])
+	}
+
+	extensions, err := getExtensionFields(up.data[12:], b.extensionFields)
+	if err != nil {
+		return errors.Wrap(err, "get extension fields error")
+	}
+
 	// ack the packet
 	ack := packets.PushACKPacket{
 		ProtocolVersion: p.ProtocolVersion,
@@ -461,9 +721,19 @@ func (b *Backend) handlePushData(up udpPacket) error {
 	if err != nil {
 		return err
 	}
-	b.udpSendChan <- udpPacket{
-		addr: up.addr,
-		data: bytes,
+	// the delayed PUSH_ACK is sent by a timer, so that the delay does not
+	// block the handling of the other received packets
+	if delay := b.pushACKDelay(); delay > 0 {
+		pushACKDelayCounter().Inc()
+		b.sendPacketAfter(udpPacket{
+			addr: up.addr,
+			data: bytes,
+		}, delay)
+	} else {
+		b.udpSendChan <- udpPacket{
+			addr: up.addr,
+			data: bytes,
+		}
 	}
 
 	// gateway stats
@@ -484,19 +754,95 @@ func (b *Backend) handlePushData(up udpPacket) error {
 			stats.Ip = up.addr.IP.String()
 		}
 
+		if len(extensions.stat) != 0 {
+			if stats.MetaData == nil {
+				stats.MetaData = make(map[string]string)
+			}
+			for k, v := range extensions.stat {
+				stats.MetaData[k] = v
+			}
+		}
+
 		b.handleStats(p.GatewayMAC, *stats)
 	}
 
 	// uplink frames
-	uplinkFrames, err := p.GetUplinkFrames(b.skipCRCCheck, b.fakeRxTime)
+	// all frames are returned so that the stats reflect the actual CRC status,
+	// frames without a valid CRC are dropped by handleUplinkFrames.
+	skipCRCCheck := true
+	uplinkFrames, err := p.GetUplinkFrames(skipCRCCheck, b.fakeRxTime)
 	if err != nil {
 		return errors.Wrap(err, "get uplink frames error")
 	}
+	if b.sourceAddrMetaData {
+		for i := range uplinkFrames {
+			uplinkmeta.Set(uplinkFrames[i].GetRxInfo().GetUplinkId(), uplinkmeta.SourceAddr, up.addr.String())
+		}
+	}
+	if len(b.extensionFields) != 0 {
+		frameRXPK := p.GetUplinkFrameRXPKIndices(skipCRCCheck)
+
+		for i := range uplinkFrames {
+			if i >= len(frameRXPK) || frameRXPK[i] >= len(extensions.rxpk) {
+				break
+			}
+			for k, v := range extensions.rxpk[frameRXPK[i]] {
+				uplinkmeta.Set(uplinkFrames[i].GetRxInfo().GetUplinkId(), k, v)
+			}
+		}
+	}
 	b.handleUplinkFrames(uplinkFrames)
 
 	return nil
 }
 
+// pushACKDelay returns the duration by which the PUSH_ACK must be delayed
+// to signal backpressure to the packet-forwarder. The delay increases
+// linearly with the number of pending packets above the threshold and is
+// bounded by the configured max. delay.
+func (b *Backend) pushACKDelay() time.Duration {
+	if b.backpressureThreshold <= 0 {
+		return 0
+	}
+
+	over := atomic.LoadInt64(&b.pending) - b.backpressureThreshold
+	if over <= 0 {
+		return 0
+	}
+
+	delay := time.Duration(int64(b.backpressureMaxACKDelay) * over / b.backpressureThreshold)
+	if delay > b.backpressureMaxACKDelay {
+		delay = b.backpressureMaxACKDelay
+	}
+	return delay
+}
+
+// retryTXAck returns true when the downlink must be retried for the given
+// tx acknowledgement status, according to the configured retry policy.
+func (b *Backend) retryTXAck(token uint16, status gw.TxAckStatus) bool {
+	if b.txAckRetries == nil {
+		return true
+	}
+
+	maxRetries, ok := b.txAckRetries[status]
+	if !ok {
+		return false
+	}
+
+	key := fmt.Sprintf("%d:retries", token)
+	var retries int
+	if v, ok := b.cache.Get(key); ok {
+		retries, _ = v.(int)
+	}
+
+	if retries >= maxRetries {
+		return false
+	}
+
+	b.cache.Set(key, retries+1, cache.DefaultExpiration)
+	return true
+}
+
 func (b *Backend) handleStats(gatewayID lorawan.EUI64, stats gw.GatewayStats) {
 	if conn, err := b.gateways.get(gatewayID); err == nil {
 		s := conn.stats.ExportStats()
@@ -520,10 +866,32 @@ func (b *Backend) handleUplinkFrames(uplinkFrames []gw.UplinkFrame) error {
 		var gatewayID lorawan.EUI64
 		copy(gatewayID[:], uplinkFrames[i].GetRxInfo().GatewayId)
 
+		// frames received on an antenna that does not exist are dropped before
+		// these are counted
+		if antennas, ok := b.antennas[gatewayID]; ok && uplinkFrames[i].GetRxInfo().GetAntenna() >= antennas {
+			log.WithFields(log.Fields{
+				"gateway_id": gatewayID,
+				"board":      uplinkFrames[i].GetRxInfo().GetBoard(),
+				"rf_chain":   uplinkFrames[i].GetRxInfo().GetRfChain(),
+				"antenna":    uplinkFrames[i].GetRxInfo().GetAntenna(),
+				"antennas":   antennas,
+			}).Warning("backend/semtechudp: frame dropped because of invalid antenna")
+			continue
+		}
+
 		if conn, err := b.gateways.get(gatewayID); err == nil {
 			conn.stats.CountUplink(&uplinkFrames[i])
 		}
 
+		if !b.skipCRCCheck && uplinkFrames[i].GetRxInfo().GetCrcStatus() != gw.CRCStatus_CRC_OK {
+			log.WithFields(log.Fields{
+				"gateway_id": gatewayID,
+				"crc_status": uplinkFrames[i].GetRxInfo().GetCrcStatus(),
+			}).Debug("backend/semtechudp: frame dropped because of invalid CRC")
+			crcDroppedCounter(uplinkFrames[i].GetRxInfo().GetCrcStatus().String()).Inc()
+			continue
+		}
+
 		if filters.MatchFilters(uplinkFrames[i].PhyPayload) {
 			if b.uplinkFrameFunc != nil {
 				b.uplinkFrameFunc(uplinkFrames[i])
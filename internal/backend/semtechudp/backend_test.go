@@ -2,17 +2,22 @@ package semtechudp
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/duration"
 	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 
@@ -20,6 +25,8 @@ import (
 	"github.com/brocaar/chirpstack-api/go/v3/gw"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/backend/semtechudp/packets"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/status"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/uplinkmeta"
 	"github.com/brocaar/lorawan"
 )
 
@@ -45,6 +52,12 @@ func (ts *BackendTestSuite) SetupTest() {
 
 	var conf config.Config
 	conf.Backend.SemtechUDP.UDPBind = "127.0.0.1:0"
+	conf.Backend.SemtechUDP.Gateways = []config.SemtechUDPGateway{
+		{
+			GatewayID: "0807060504030201",
+			Antennas:  2,
+		},
+	}
 
 	ts.backend, err = NewBackend(conf)
 	assert.NoError(err)
@@ -96,6 +109,100 @@ func (ts *BackendTestSuite) TestPullData() {
 	})
 }
 
+func (ts *BackendTestSuite) TestPullDataMonitor() {
+	assert := require.New(ts.T())
+
+	log.SetLevel(log.WarnLevel)
+	hook := logtest.NewGlobal()
+	defer func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetLevel(log.ErrorLevel)
+	}()
+
+	ts.backend.pullDataMaxInterval = 30 * time.Second
+	ts.backend.logPortChanges = true
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	sendPullData := func(conn *net.UDPConn) {
+		p := packets.PullDataPacket{
+			ProtocolVersion: packets.ProtocolVersion2,
+			RandomToken:     12345,
+			GatewayMAC:      gatewayID,
+		}
+		b, err := p.MarshalBinary()
+		assert.NoError(err)
+
+		_, err = conn.WriteToUDP(b, ts.backendUDPAddr)
+		assert.NoError(err)
+
+		// the PULL_ACK is sent after the PULL_DATA has been handled
+		buf := make([]byte, 65507)
+		_, _, err = conn.ReadFromUDP(buf)
+		assert.NoError(err)
+	}
+
+	// register the gateway
+	sendPullData(ts.gwUDPConn)
+
+	ts.T().Run("Within interval, same address", func(t *testing.T) {
+		assert := require.New(t)
+		hook.Reset()
+
+		sendPullData(ts.gwUDPConn)
+		assert.Len(hook.AllEntries(), 0)
+	})
+
+	ts.T().Run("Interval exceeded", func(t *testing.T) {
+		assert := require.New(t)
+		hook.Reset()
+
+		g, err := ts.backend.gateways.get(gatewayID)
+		assert.NoError(err)
+		g.lastSeen = time.Now().Add(-45 * time.Second)
+		assert.NoError(ts.backend.gateways.set(gatewayID, g))
+
+		sendPullData(ts.gwUDPConn)
+
+		entries := hook.AllEntries()
+		assert.Len(entries, 1)
+		assert.Equal(log.WarnLevel, entries[0].Level)
+		assert.Equal("backend/semtechudp: PULL_DATA interval exceeds max. interval, check the NAT / network path of the gateway", entries[0].Message)
+		assert.Equal(gatewayID, entries[0].Data["gateway_id"])
+		assert.True(entries[0].Data["interval"].(time.Duration) >= 45*time.Second)
+	})
+
+	ts.T().Run("Source port changed", func(t *testing.T) {
+		assert := require.New(t)
+		hook.Reset()
+
+		gwAddr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+		assert.NoError(err)
+		conn, err := net.ListenUDP("udp", gwAddr)
+		assert.NoError(err)
+		defer conn.Close()
+		assert.NoError(conn.SetDeadline(time.Now().Add(time.Second)))
+
+		sendPullData(conn)
+
+		entries := hook.AllEntries()
+		assert.Len(entries, 1)
+		assert.Equal(log.WarnLevel, entries[0].Level)
+		assert.Equal("backend/semtechudp: PULL_DATA source address changed, possible NAT rebinding", entries[0].Message)
+		assert.Equal(ts.gwUDPConn.LocalAddr().String(), entries[0].Data["previous_addr"].(*net.UDPAddr).String())
+		assert.Equal(conn.LocalAddr().String(), entries[0].Data["addr"].(*net.UDPAddr).String())
+
+		t.Run("Logging disabled", func(t *testing.T) {
+			assert := require.New(t)
+			hook.Reset()
+			ts.backend.logPortChanges = false
+
+			sendPullData(ts.gwUDPConn)
+			assert.Len(hook.AllEntries(), 0)
+		})
+	})
+}
+
 func (ts *BackendTestSuite) TestTXAck() {
 	testTable := []struct {
 		Name          string
@@ -648,6 +755,325 @@ func (ts *BackendTestSuite) TestTXAckRetryOkIgnored() {
 	}, txAck)
 }
 
+func (ts *BackendTestSuite) TestTXAckRetryPolicy() {
+	assert := require.New(ts.T())
+	buf := make([]byte, 65507)
+
+	ts.backend.txAckRetries = map[gw.TxAckStatus]int{
+		gw.TxAckStatus_COLLISION_PACKET: 1,
+	}
+
+	// register gateway
+	p := packets.PullDataPacket{
+		ProtocolVersion: packets.ProtocolVersion2,
+		RandomToken:     12345,
+		GatewayMAC:      lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+	}
+	b, err := p.MarshalBinary()
+	assert.NoError(err)
+	_, err = ts.gwUDPConn.WriteToUDP(b, ts.backendUDPAddr)
+	assert.NoError(err)
+	_, _, err = ts.gwUDPConn.ReadFromUDP(buf)
+	assert.NoError(err)
+
+	item := func(phy []byte) *gw.DownlinkFrameItem {
+		return &gw.DownlinkFrameItem{
+			PhyPayload: phy,
+			TxInfo: &gw.DownlinkTXInfo{
+				Frequency:  868100000,
+				Power:      14,
+				Modulation: common.Modulation_LORA,
+				ModulationInfo: &gw.DownlinkTXInfo_LoraModulationInfo{
+					LoraModulationInfo: &gw.LoRaModulationInfo{
+						Bandwidth:       125,
+						SpreadingFactor: 7,
+						CodeRate:        "4/5",
+					},
+				},
+				Timing: gw.DownlinkTiming_IMMEDIATELY,
+				TimingInfo: &gw.DownlinkTXInfo_ImmediatelyTimingInfo{
+					ImmediatelyTimingInfo: &gw.ImmediatelyTimingInfo{},
+				},
+			},
+		}
+	}
+
+	sendNack := func(assert *require.Assertions, token uint16, status string) {
+		ack := packets.TXACKPacket{
+			ProtocolVersion: packets.ProtocolVersion2,
+			RandomToken:     token,
+			GatewayMAC:      [8]byte{1, 2, 3, 4, 5, 6, 7, 8},
+			Payload: &packets.TXACKPayload{
+				TXPKACK: packets.TXPKACK{
+					Error: status,
+				},
+			},
+		}
+		b, err := ack.MarshalBinary()
+		assert.NoError(err)
+		_, err = ts.gwUDPConn.WriteToUDP(b, ts.backendUDPAddr)
+		assert.NoError(err)
+	}
+
+	tests := []struct {
+		Name          string
+		Token         uint16
+		Status        gw.TxAckStatus
+		ExpectedRetry bool
+	}{
+		{
+			Name:          "retryable nack",
+			Token:         1001,
+			Status:        gw.TxAckStatus_COLLISION_PACKET,
+			ExpectedRetry: true,
+		},
+		{
+			Name:          "non-retryable nack",
+			Token:         1002,
+			Status:        gw.TxAckStatus_TX_FREQ,
+			ExpectedRetry: false,
+		},
+	}
+
+	for _, tst := range tests {
+		ts.T().Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			ackChan := make(chan gw.DownlinkTXAck, 1)
+			ts.backend.SetDownlinkTxAckFunc(func(pl gw.DownlinkTXAck) {
+				ackChan <- pl
+			})
+
+			assert.NoError(ts.backend.SendDownlinkFrame(gw.DownlinkFrame{
+				Token:     uint32(tst.Token),
+				GatewayId: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+				Items: []*gw.DownlinkFrameItem{
+					item([]byte{1, 2, 3}),
+					item([]byte{4, 5, 6}),
+					item([]byte{7, 8, 9}),
+				},
+			}))
+
+			// first attempt
+			i, _, err := ts.gwUDPConn.ReadFromUDP(buf)
+			assert.NoError(err)
+			var pullResp packets.PullRespPacket
+			assert.NoError(pullResp.UnmarshalBinary(buf[:i]))
+			assert.Equal([]byte{1, 2, 3}, pullResp.Payload.TXPK.Data)
+
+			sendNack(assert, tst.Token, tst.Status.String())
+
+			if tst.ExpectedRetry {
+				// re-attempt using the next item
+				i, _, err := ts.gwUDPConn.ReadFromUDP(buf)
+				assert.NoError(err)
+				assert.NoError(pullResp.UnmarshalBinary(buf[:i]))
+				assert.Equal([]byte{4, 5, 6}, pullResp.Payload.TXPK.Data)
+
+				// the retries are exhausted, the error must be forwarded
+				sendNack(assert, tst.Token, tst.Status.String())
+				assert.Equal([]*gw.DownlinkTXAckItem{
+					{Status: tst.Status},
+					{Status: tst.Status},
+					{Status: gw.TxAckStatus_IGNORED},
+				}, (<-ackChan).Items)
+			} else {
+				assert.Equal([]*gw.DownlinkTXAckItem{
+					{Status: tst.Status},
+					{Status: gw.TxAckStatus_IGNORED},
+					{Status: gw.TxAckStatus_IGNORED},
+				}, (<-ackChan).Items)
+			}
+		})
+	}
+}
+
+func (ts *BackendTestSuite) TestTXAckTimeout() {
+	assert := require.New(ts.T())
+	buf := make([]byte, 65507)
+
+	ts.backend.txAckTimeout = 50 * time.Millisecond
+
+	// register gateway
+	p := packets.PullDataPacket{
+		ProtocolVersion: packets.ProtocolVersion2,
+		RandomToken:     12345,
+		GatewayMAC:      lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+	}
+	b, err := p.MarshalBinary()
+	assert.NoError(err)
+	_, err = ts.gwUDPConn.WriteToUDP(b, ts.backendUDPAddr)
+	assert.NoError(err)
+	_, _, err = ts.gwUDPConn.ReadFromUDP(buf)
+	assert.NoError(err)
+
+	frame := func(token uint16) gw.DownlinkFrame {
+		return gw.DownlinkFrame{
+			Token:     uint32(token),
+			GatewayId: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			Items: []*gw.DownlinkFrameItem{
+				{
+					PhyPayload: []byte{1, 2, 3},
+					TxInfo: &gw.DownlinkTXInfo{
+						Frequency:  868100000,
+						Power:      14,
+						Modulation: common.Modulation_LORA,
+						ModulationInfo: &gw.DownlinkTXInfo_LoraModulationInfo{
+							LoraModulationInfo: &gw.LoRaModulationInfo{
+								Bandwidth:       125,
+								SpreadingFactor: 7,
+								CodeRate:        "4/5",
+							},
+						},
+						Timing: gw.DownlinkTiming_IMMEDIATELY,
+						TimingInfo: &gw.DownlinkTXInfo_ImmediatelyTimingInfo{
+							ImmediatelyTimingInfo: &gw.ImmediatelyTimingInfo{},
+						},
+					},
+				},
+				{
+					PhyPayload: []byte{4, 5, 6},
+					TxInfo: &gw.DownlinkTXInfo{
+						Frequency:  869525000,
+						Power:      14,
+						Modulation: common.Modulation_LORA,
+						ModulationInfo: &gw.DownlinkTXInfo_LoraModulationInfo{
+							LoraModulationInfo: &gw.LoRaModulationInfo{
+								Bandwidth:       125,
+								SpreadingFactor: 12,
+								CodeRate:        "4/5",
+							},
+						},
+						Timing: gw.DownlinkTiming_IMMEDIATELY,
+						TimingInfo: &gw.DownlinkTXInfo_ImmediatelyTimingInfo{
+							ImmediatelyTimingInfo: &gw.ImmediatelyTimingInfo{},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	readPullResp := func(assert *require.Assertions) packets.PullRespPacket {
+		i, _, err := ts.gwUDPConn.ReadFromUDP(buf)
+		assert.NoError(err)
+		var pullResp packets.PullRespPacket
+		assert.NoError(pullResp.UnmarshalBinary(buf[:i]))
+		return pullResp
+	}
+
+	ackChan := make(chan gw.DownlinkTXAck, 1)
+	ts.backend.SetDownlinkTxAckFunc(func(pl gw.DownlinkTXAck) {
+		ackChan <- pl
+	})
+
+	ts.T().Run("No retransmit", func(t *testing.T) {
+		assert := require.New(t)
+		ts.backend.txAckRetransmit = false
+
+		assert.NoError(ts.backend.SendDownlinkFrame(frame(2001)))
+		assert.Equal([]byte{1, 2, 3}, readPullResp(assert).Payload.TXPK.Data)
+
+		// the downlink has failed after the timeout
+		assert.Equal([]*gw.DownlinkTXAckItem{
+			{Status: gw.TxAckStatus_INTERNAL_ERROR},
+			{Status: gw.TxAckStatus_IGNORED},
+		}, (<-ackChan).Items)
+
+		// a late TX_ACK is not reported
+		ack := packets.TXACKPacket{
+			ProtocolVersion: packets.ProtocolVersion2,
+			RandomToken:     2001,
+			GatewayMAC:      [8]byte{1, 2, 3, 4, 5, 6, 7, 8},
+		}
+		b, err := ack.MarshalBinary()
+		assert.NoError(err)
+		assert.EqualError(ts.backend.handleTXACK(udpPacket{data: b}), "tx ack for token 2001 received after the tx ack timeout")
+	})
+
+	ts.T().Run("Retransmit", func(t *testing.T) {
+		assert := require.New(t)
+		ts.backend.txAckRetransmit = true
+
+		assert.NoError(ts.backend.SendDownlinkFrame(frame(2002)))
+		first := readPullResp(assert)
+
+		// the same item is retransmitted once
+		second := readPullResp(assert)
+		assert.Equal(first, second)
+
+		assert.Equal([]*gw.DownlinkTXAckItem{
+			{Status: gw.TxAckStatus_INTERNAL_ERROR},
+			{Status: gw.TxAckStatus_IGNORED},
+		}, (<-ackChan).Items)
+	})
+
+	ts.T().Run("TX_ACK within timeout", func(t *testing.T) {
+		assert := require.New(t)
+		ts.backend.txAckRetransmit = true
+
+		assert.NoError(ts.backend.SendDownlinkFrame(frame(2003)))
+		readPullResp(assert)
+
+		ack := packets.TXACKPacket{
+			ProtocolVersion: packets.ProtocolVersion2,
+			RandomToken:     2003,
+			GatewayMAC:      [8]byte{1, 2, 3, 4, 5, 6, 7, 8},
+		}
+		b, err := ack.MarshalBinary()
+		assert.NoError(err)
+		_, err = ts.gwUDPConn.WriteToUDP(b, ts.backendUDPAddr)
+		assert.NoError(err)
+
+		assert.Equal([]*gw.DownlinkTXAckItem{
+			{Status: gw.TxAckStatus_OK},
+			{Status: gw.TxAckStatus_IGNORED},
+		}, (<-ackChan).Items)
+
+		// no retransmit or failed ack after the timeout
+		time.Sleep(100 * time.Millisecond)
+		select {
+		case pl := <-ackChan:
+			t.Fatalf("unexpected tx ack: %+v", pl)
+		default:
+		}
+	})
+
+	ts.T().Run("Protocol version 1", func(t *testing.T) {
+		assert := require.New(t)
+		ts.backend.txAckRetransmit = true
+
+		// register the gateway as protocol version 1 gateway
+		p := packets.PullDataPacket{
+			ProtocolVersion: packets.ProtocolVersion1,
+			RandomToken:     12346,
+			GatewayMAC:      lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		}
+		b, err := p.MarshalBinary()
+		assert.NoError(err)
+		_, err = ts.gwUDPConn.WriteToUDP(b, ts.backendUDPAddr)
+		assert.NoError(err)
+		_, _, err = ts.gwUDPConn.ReadFromUDP(buf)
+		assert.NoError(err)
+
+		assert.NoError(ts.backend.SendDownlinkFrame(frame(2004)))
+		readPullResp(assert)
+
+		// no retransmit or failed ack, as no TX_ACK is expected
+		time.Sleep(100 * time.Millisecond)
+		select {
+		case pl := <-ackChan:
+			t.Fatalf("unexpected tx ack: %+v", pl)
+		default:
+		}
+
+		assert.NoError(ts.gwUDPConn.SetReadDeadline(time.Now().Add(50 * time.Millisecond)))
+		_, _, err = ts.gwUDPConn.ReadFromUDP(buf)
+		assert.Error(err)
+		assert.NoError(ts.gwUDPConn.SetDeadline(time.Now().Add(time.Second)))
+	})
+}
+
 func (ts *BackendTestSuite) TestPushData() {
 	latitude := float64(1.234)
 	longitude := float64(2.123)
@@ -695,10 +1121,19 @@ func (ts *BackendTestSuite) TestPushData() {
 					Altitude:  123,
 					Source:    common.LocationSource_GPS,
 				},
-				RxPacketsReceived:   1,
-				RxPacketsReceivedOk: 2,
-				TxPacketsReceived:   4,
-				TxPacketsEmitted:    5,
+				RxPacketsReceived: 1,
+				TxPacketsReceived: 4,
+				// the PUSH_DATA registered the gateway, the other counters
+				// are exported by its stats collector
+				RxPacketsPerFrequency:  map[uint32]uint32{},
+				TxPacketsPerFrequency:  map[uint32]uint32{},
+				RxPacketsPerModulation: []*gw.PerModulationCount{},
+				TxPacketsPerModulation: []*gw.PerModulationCount{},
+				TxPacketsPerStatus:     map[string]uint32{},
+				MetaData: map[string]string{
+					"rxfw": "3",
+					"ackr": "33.3",
+				},
 			},
 		},
 		{
@@ -720,12 +1155,21 @@ func (ts *BackendTestSuite) TestPushData() {
 				},
 			},
 			Stats: &gw.GatewayStats{
-				GatewayId:           []byte{1, 2, 3, 4, 5, 6, 7, 8},
-				Time:                nowPB,
-				RxPacketsReceived:   1,
-				RxPacketsReceivedOk: 2,
-				TxPacketsReceived:   4,
-				TxPacketsEmitted:    5,
+				GatewayId:         []byte{1, 2, 3, 4, 5, 6, 7, 8},
+				Time:              nowPB,
+				RxPacketsReceived: 1,
+				TxPacketsReceived: 4,
+				// the PUSH_DATA registered the gateway, the other counters
+				// are exported by its stats collector
+				RxPacketsPerFrequency:  map[uint32]uint32{},
+				TxPacketsPerFrequency:  map[uint32]uint32{},
+				RxPacketsPerModulation: []*gw.PerModulationCount{},
+				TxPacketsPerModulation: []*gw.PerModulationCount{},
+				TxPacketsPerStatus:     map[string]uint32{},
+				MetaData: map[string]string{
+					"rxfw": "3",
+					"ackr": "33.3",
+				},
 			},
 		},
 		{
@@ -836,12 +1280,461 @@ func (ts *BackendTestSuite) TestPushData() {
 				assert.Len(receivedUF.RxInfo.UplinkId, 16)
 				receivedUF.RxInfo.UplinkId = nil
 
-				assert.Equal(uf, receivedUF)
+				// counting the uplink sets the size cache of the modulation info
+				assert.Equal(proto.MarshalTextString(&uf), proto.MarshalTextString(&receivedUF))
 			}
 		})
 	}
 }
 
+func (ts *BackendTestSuite) TestPushDataAntennas() {
+	assert := require.New(ts.T())
+
+	uplinkChan := make(chan gw.UplinkFrame, 3)
+	ts.backend.SetUplinkFrameFunc(func(pl gw.UplinkFrame) {
+		uplinkChan <- pl
+	})
+
+	p := packets.PushDataPacket{
+		ProtocolVersion: packets.ProtocolVersion2,
+		RandomToken:     1234,
+		GatewayMAC:      [8]byte{8, 7, 6, 5, 4, 3, 2, 1},
+		Payload: packets.PushDataPayload{
+			RXPK: []packets.RXPK{
+				{
+					Tmst: 708016819,
+					Freq: 868.5,
+					Brd:  2,
+					RFCh: 1,
+					Stat: 1,
+					Modu: "LORA",
+					DatR: packets.DatR{LoRa: "SF7BW125"},
+					CodR: "4/5",
+					Size: 16,
+					Data: []byte{64, 1, 1, 1, 1, 128, 0, 0, 1, 85, 247, 99, 71, 166, 43, 75},
+					RSig: []packets.RSig{
+						{Ant: 0, Chan: 3, RSSIC: -51, LSNR: 7},
+						{Ant: 1, Chan: 3, RSSIC: -52, LSNR: 6},
+						{Ant: 2, Chan: 3, RSSIC: -53, LSNR: 5},
+					},
+				},
+			},
+		},
+	}
+
+	b, err := p.MarshalBinary()
+	assert.NoError(err)
+	_, err = ts.gwUDPConn.WriteToUDP(b, ts.backendUDPAddr)
+	assert.NoError(err)
+
+	// expect ack
+	buf := make([]byte, 65507)
+	_, _, err = ts.gwUDPConn.ReadFromUDP(buf)
+	assert.NoError(err)
+
+	// the frame received on antenna 2 is dropped as only 2 antennas are
+	// configured for this gateway
+	for _, ant := range []uint32{0, 1} {
+		uf := <-uplinkChan
+		assert.Equal(uint32(2), uf.RxInfo.Board)
+		assert.Equal(uint32(1), uf.RxInfo.RfChain)
+		assert.Equal(ant, uf.RxInfo.Antenna)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(uplinkChan, 0)
+
+	// the dropped frame is not counted
+	conn, err := ts.backend.gateways.get(lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1})
+	assert.NoError(err)
+	stats := conn.stats.ExportStats()
+	assert.Equal(uint32(2), stats.RxPacketsReceived)
+	assert.Equal(uint32(2), stats.RxPacketsReceivedOk)
+}
+
+func (ts *BackendTestSuite) TestPushDataCRCStats() {
+	for _, skipCRCCheck := range []bool{false, true} {
+		ts.T().Run(fmt.Sprintf("skip crc check %t", skipCRCCheck), func(t *testing.T) {
+			assert := require.New(t)
+			ts.backend.skipCRCCheck = skipCRCCheck
+			crcDropped := testutil.ToFloat64(crcDroppedCounter(gw.CRCStatus_BAD_CRC.String()))
+
+			statsChan := make(chan gw.GatewayStats, 1)
+			uplinkChan := make(chan gw.UplinkFrame, 2)
+
+			ts.backend.SetGatewayStatsFunc(func(pl gw.GatewayStats) {
+				statsChan <- pl
+			})
+			ts.backend.SetUplinkFrameFunc(func(pl gw.UplinkFrame) {
+				uplinkChan <- pl
+			})
+
+			buf := make([]byte, 65507)
+
+			// register gateway
+			pull := packets.PullDataPacket{
+				ProtocolVersion: packets.ProtocolVersion2,
+				RandomToken:     1234,
+				GatewayMAC:      [8]byte{1, 2, 3, 4, 5, 6, 7, 8},
+			}
+			b, err := pull.MarshalBinary()
+			assert.NoError(err)
+			_, err = ts.gwUDPConn.WriteToUDP(b, ts.backendUDPAddr)
+			assert.NoError(err)
+			_, _, err = ts.gwUDPConn.ReadFromUDP(buf)
+			assert.NoError(err)
+
+			// uplinks with valid and invalid CRC
+			push := packets.PushDataPacket{
+				ProtocolVersion: packets.ProtocolVersion2,
+				RandomToken:     1235,
+				GatewayMAC:      [8]byte{1, 2, 3, 4, 5, 6, 7, 8},
+				Payload: packets.PushDataPayload{
+					RXPK: []packets.RXPK{
+						{
+							Tmst: 708016819,
+							Freq: 868.5,
+							Stat: 1,
+							Modu: "LORA",
+							DatR: packets.DatR{LoRa: "SF7BW125"},
+							CodR: "4/5",
+							Size: 3,
+							Data: []byte{1, 2, 3},
+						},
+						{
+							Tmst: 708016820,
+							Freq: 868.5,
+							Stat: -1,
+							Modu: "LORA",
+							DatR: packets.DatR{LoRa: "SF7BW125"},
+							CodR: "4/5",
+							Size: 3,
+							Data: []byte{3, 2, 1},
+						},
+					},
+				},
+			}
+			b, err = push.MarshalBinary()
+			assert.NoError(err)
+			_, err = ts.gwUDPConn.WriteToUDP(b, ts.backendUDPAddr)
+			assert.NoError(err)
+			_, _, err = ts.gwUDPConn.ReadFromUDP(buf)
+			assert.NoError(err)
+
+			uf := <-uplinkChan
+			assert.Equal(gw.CRCStatus_CRC_OK, uf.RxInfo.CrcStatus)
+			if skipCRCCheck {
+				uf = <-uplinkChan
+				assert.Equal(gw.CRCStatus_BAD_CRC, uf.RxInfo.CrcStatus)
+			}
+
+			// stats
+			push = packets.PushDataPacket{
+				ProtocolVersion: packets.ProtocolVersion2,
+				RandomToken:     1236,
+				GatewayMAC:      [8]byte{1, 2, 3, 4, 5, 6, 7, 8},
+				Payload: packets.PushDataPayload{
+					Stat: &packets.Stat{
+						Time: packets.ExpandedTime(time.Now().UTC()),
+						RXNb: 2,
+						RXOK: 2,
+					},
+				},
+			}
+			b, err = push.MarshalBinary()
+			assert.NoError(err)
+			_, err = ts.gwUDPConn.WriteToUDP(b, ts.backendUDPAddr)
+			assert.NoError(err)
+			_, _, err = ts.gwUDPConn.ReadFromUDP(buf)
+			assert.NoError(err)
+
+			stats := <-statsChan
+			assert.Equal(uint32(2), stats.RxPacketsReceived)
+			assert.Equal(uint32(1), stats.RxPacketsReceivedOk)
+			assert.Equal(map[uint32]uint32{868500000: 1}, stats.RxPacketsPerFrequency)
+			assert.Len(uplinkChan, 0)
+
+			if skipCRCCheck {
+				assert.Equal(crcDropped, testutil.ToFloat64(crcDroppedCounter(gw.CRCStatus_BAD_CRC.String())))
+			} else {
+				assert.Equal(crcDropped+1, testutil.ToFloat64(crcDroppedCounter(gw.CRCStatus_BAD_CRC.String())))
+			}
+		})
+	}
+}
+
+func (ts *BackendTestSuite) TestPushDataBackpressure() {
+	ts.backend.backpressureThreshold = 10
+	ts.backend.backpressureMaxACKDelay = 200 * time.Millisecond
+	defer func() {
+		ts.backend.backpressureThreshold = 0
+		atomic.StoreInt64(&ts.backend.pending, 0)
+	}()
+
+	ts.T().Run("Delay", func(t *testing.T) {
+		tests := []struct {
+			Pending  int64
+			Expected time.Duration
+		}{
+			{0, 0},
+			{10, 0},
+			{15, 100 * time.Millisecond},
+			{20, 200 * time.Millisecond},
+			{1000, 200 * time.Millisecond},
+		}
+
+		for _, tst := range tests {
+			assert := require.New(t)
+			atomic.StoreInt64(&ts.backend.pending, tst.Pending)
+			assert.Equal(tst.Expected, ts.backend.pushACKDelay())
+		}
+	})
+
+	ts.T().Run("ACK latency", func(t *testing.T) {
+		assert := require.New(t)
+
+		p := packets.PushDataPacket{
+			ProtocolVersion: packets.ProtocolVersion2,
+			RandomToken:     1234,
+			GatewayMAC:      [8]byte{1, 2, 3, 4, 5, 6, 7, 8},
+		}
+		b, err := p.MarshalBinary()
+		assert.NoError(err)
+
+		latency := func(pending int64) time.Duration {
+			atomic.StoreInt64(&ts.backend.pending, pending)
+			start := time.Now()
+
+			_, err = ts.gwUDPConn.WriteToUDP(b, ts.backendUDPAddr)
+			assert.NoError(err)
+
+			buf := make([]byte, 65507)
+			_, _, err = ts.gwUDPConn.ReadFromUDP(buf)
+			assert.NoError(err)
+
+			return time.Since(start)
+		}
+
+		assert.Less(int64(latency(0)), int64(50*time.Millisecond))
+
+		// the packet being handled is counted as pending too
+		l := latency(14)
+		assert.GreaterOrEqual(int64(l), int64(100*time.Millisecond))
+		assert.Less(int64(l), int64(200*time.Millisecond))
+
+		l = latency(1000)
+		assert.GreaterOrEqual(int64(l), int64(200*time.Millisecond))
+		assert.Less(int64(l), int64(300*time.Millisecond))
+	})
+}
+
+func (ts *BackendTestSuite) TestLastError() {
+	assert := require.New(ts.T())
+
+	// invalid packet type
+	_, err := ts.gwUDPConn.WriteToUDP([]byte{2, 1, 2, 255}, ts.backendUDPAddr)
+	assert.NoError(err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	lastErr, ok := status.GetLastErrors()[status.UDP]
+	assert.True(ok)
+	assert.Contains(lastErr.Error, "unknown packet type")
+}
+
+func (ts *BackendTestSuite) TestPushDataNormalization() {
+	assert := require.New(ts.T())
+
+	n, err := newNormalizer(FrequencyUnitAuto, GPSTimeUnitAuto)
+	assert.NoError(err)
+	ts.backend.normalizer = n
+	defer func() {
+		ts.backend.normalizer, _ = newNormalizer("", "")
+	}()
+
+	uplinkChan := make(chan gw.UplinkFrame, 2)
+	ts.backend.SetUplinkFrameFunc(func(pl gw.UplinkFrame) {
+		uplinkChan <- pl
+	})
+
+	tmmsMS := int64(1300000000000)
+	tmmsS := int64(1300000000)
+
+	// vendor a reports the protocol units, vendor b Hz and seconds
+	for _, rxpk := range []packets.RXPK{
+		{Freq: 868.1, Tmms: &tmmsMS},
+		{Freq: 868100000, Tmms: &tmmsS},
+	} {
+		rxpk.Stat = 1
+		rxpk.Modu = "LORA"
+		rxpk.DatR = packets.DatR{LoRa: "SF7BW125"}
+		rxpk.CodR = "4/5"
+		rxpk.Data = []byte{1, 2, 3}
+
+		p := packets.PushDataPacket{
+			ProtocolVersion: packets.ProtocolVersion2,
+			RandomToken:     1234,
+			GatewayMAC:      [8]byte{1, 2, 3, 4, 5, 6, 7, 8},
+			Payload: packets.PushDataPayload{
+				RXPK: []packets.RXPK{rxpk},
+			},
+		}
+		b, err := p.MarshalBinary()
+		assert.NoError(err)
+		_, err = ts.gwUDPConn.WriteToUDP(b, ts.backendUDPAddr)
+		assert.NoError(err)
+
+		buf := make([]byte, 65507)
+		_, _, err = ts.gwUDPConn.ReadFromUDP(buf)
+		assert.NoError(err)
+
+		uf := <-uplinkChan
+		assert.Equal(uint32(868100000), uf.TxInfo.Frequency)
+		assert.Equal(ptypes.DurationProto(time.Duration(tmmsMS)*time.Millisecond), uf.RxInfo.TimeSinceGpsEpoch)
+	}
+}
+
+func (ts *BackendTestSuite) TestPushDataSourceAddrMetaData() {
+	for _, enabled := range []bool{false, true} {
+		ts.T().Run(fmt.Sprintf("enabled %t", enabled), func(t *testing.T) {
+			assert := require.New(t)
+			ts.backend.sourceAddrMetaData = enabled
+
+			uplinkChan := make(chan gw.UplinkFrame, 1)
+			ts.backend.SetUplinkFrameFunc(func(pl gw.UplinkFrame) {
+				uplinkChan <- pl
+			})
+
+			p := packets.PushDataPacket{
+				ProtocolVersion: packets.ProtocolVersion2,
+				RandomToken:     1234,
+				GatewayMAC:      [8]byte{1, 2, 3, 4, 5, 6, 7, 8},
+				Payload: packets.PushDataPayload{
+					RXPK: []packets.RXPK{
+						{
+							Tmst: 708016819,
+							Freq: 868.5,
+							Stat: 1,
+							Modu: "LORA",
+							DatR: packets.DatR{LoRa: "SF7BW125"},
+							CodR: "4/5",
+							Size: 3,
+							Data: []byte{1, 2, 3},
+						},
+					},
+				},
+			}
+			b, err := p.MarshalBinary()
+			assert.NoError(err)
+			_, err = ts.gwUDPConn.WriteToUDP(b, ts.backendUDPAddr)
+			assert.NoError(err)
+
+			buf := make([]byte, 65507)
+			_, _, err = ts.gwUDPConn.ReadFromUDP(buf)
+			assert.NoError(err)
+
+			uf := <-uplinkChan
+			metaData := uplinkmeta.Get(uf.RxInfo.UplinkId)
+			if enabled {
+				assert.Equal(map[string]string{
+					uplinkmeta.SourceAddr: ts.gwUDPConn.LocalAddr().String(),
+				}, metaData)
+			} else {
+				assert.Nil(metaData)
+			}
+		})
+	}
+}
+
+func (ts *BackendTestSuite) TestPushDataExtensionFields() {
+	assert := require.New(ts.T())
+	ts.backend.extensionFields = []string{"temp", "board"}
+	defer func() {
+		ts.backend.extensionFields = nil
+	}()
+
+	statsChan := make(chan gw.GatewayStats, 1)
+	uplinkChan := make(chan gw.UplinkFrame, 2)
+	ts.backend.SetGatewayStatsFunc(func(pl gw.GatewayStats) {
+		statsChan <- pl
+	})
+	ts.backend.SetUplinkFrameFunc(func(pl gw.UplinkFrame) {
+		uplinkChan <- pl
+	})
+
+	b := append([]byte{packets.ProtocolVersion2, 0x01, 0x02, byte(packets.PushData), 1, 2, 3, 4, 5, 6, 7, 8}, []byte(`{
+		"rxpk": [
+			{"tmst": 708016819, "freq": 868.5, "stat": 1, "modu": "LORA", "datr": "SF7BW125", "codr": "4/5", "rssi": -51, "lsnr": 7, "size": 3, "data": "AQID", "temp": 41.5, "board": "rev-b", "ntime": 123}
+		],
+		"stat": {"time": "2017-11-10 12:00:00 GMT", "rxnb": 1, "rxok": 1, "rxfw": 1, "ackr": 100, "dwnb": 0, "txnb": 0, "temp": 38, "ntime": 123}
+	}`)...)
+
+	_, err := ts.gwUDPConn.WriteToUDP(b, ts.backendUDPAddr)
+	assert.NoError(err)
+
+	buf := make([]byte, 65507)
+	_, _, err = ts.gwUDPConn.ReadFromUDP(buf)
+	assert.NoError(err)
+
+	ts.T().Run("Stats", func(t *testing.T) {
+		assert := require.New(t)
+
+		stats := <-statsChan
+		assert.Equal("38", stats.MetaData["temp"])
+		assert.NotContains(stats.MetaData, "ntime")
+	})
+
+	ts.T().Run("Uplink", func(t *testing.T) {
+		assert := require.New(t)
+
+		uf := <-uplinkChan
+		assert.Equal(map[string]string{
+			"temp":  "41.5",
+			"board": "rev-b",
+		}, uplinkmeta.Get(uf.RxInfo.UplinkId))
+	})
+}
+
+func (ts *BackendTestSuite) TestPushDataExtensionFieldsCRCError() {
+	assert := require.New(ts.T())
+	ts.backend.extensionFields = []string{"board"}
+	defer func() {
+		ts.backend.extensionFields = nil
+	}()
+
+	uplinkChan := make(chan gw.UplinkFrame, 2)
+	ts.backend.SetGatewayStatsFunc(func(pl gw.GatewayStats) {})
+	ts.backend.SetUplinkFrameFunc(func(pl gw.UplinkFrame) {
+		uplinkChan <- pl
+	})
+
+	b := append([]byte{packets.ProtocolVersion2, 0x01, 0x02, byte(packets.PushData), 1, 2, 3, 4, 5, 6, 7, 8}, []byte(`{
+		"rxpk": [
+			{"tmst": 708016819, "freq": 868.5, "stat": -1, "modu": "LORA", "datr": "SF7BW125", "codr": "4/5", "rssi": -51, "lsnr": 7, "size": 3, "data": "AQID", "board": "crc-fail"},
+			{"tmst": 708016820, "freq": 868.5, "stat": 1, "modu": "LORA", "datr": "SF7BW125", "codr": "4/5", "rssi": -51, "lsnr": 7, "size": 3, "data": "BAUG", "board": "crc-ok"}
+		]
+	}`)...)
+
+	_, err := ts.gwUDPConn.WriteToUDP(b, ts.backendUDPAddr)
+	assert.NoError(err)
+
+	buf := make([]byte, 65507)
+	_, _, err = ts.gwUDPConn.ReadFromUDP(buf)
+	assert.NoError(err)
+
+	uf := <-uplinkChan
+	assert.Equal([]byte{4, 5, 6}, uf.PhyPayload)
+	assert.Equal(map[string]string{
+		"board": "crc-ok",
+	}, uplinkmeta.Get(uf.RxInfo.UplinkId))
+
+	select {
+	case uf := <-uplinkChan:
+		assert.Fail("unexpected uplink", "%v", uf)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
 func (ts *BackendTestSuite) TestSendDownlinkFrame() {
 	assert := require.New(ts.T())
 	id, err := uuid.NewV4()
@@ -1025,3 +1918,134 @@ func (ts *BackendTestSuite) TestSendDownlinkFrame() {
 func TestBackend(t *testing.T) {
 	suite.Run(t, new(BackendTestSuite))
 }
+
+func TestBackendWorkers(t *testing.T) {
+	assert := require.New(t)
+
+	var conf config.Config
+	conf.Backend.SemtechUDP.UDPBind = "127.0.0.1:0"
+	conf.Backend.SemtechUDP.UDPReadBuffer = 1 << 20
+	conf.Backend.SemtechUDP.Workers = 2
+
+	backend, err := NewBackend(conf)
+	assert.NoError(err)
+	assert.NoError(backend.Start())
+	assert.Equal(2, backend.workers)
+
+	backendAddr, err := net.ResolveUDPAddr("udp", backend.conn.LocalAddr().String())
+	assert.NoError(err)
+
+	gwConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(err)
+	defer gwConn.Close()
+	assert.NoError(gwConn.SetDeadline(time.Now().Add(time.Second)))
+
+	for i := 0; i < 10; i++ {
+		p := packets.PullDataPacket{
+			ProtocolVersion: packets.ProtocolVersion2,
+			RandomToken:     uint16(i),
+			GatewayMAC:      [8]byte{1, 2, 3, 4, 5, 6, 7, 8},
+		}
+		b, err := p.MarshalBinary()
+		assert.NoError(err)
+		_, err = gwConn.WriteToUDP(b, backendAddr)
+		assert.NoError(err)
+	}
+
+	tokens := make(map[uint16]struct{})
+	buf := make([]byte, 65507)
+	for i := 0; i < 10; i++ {
+		n, _, err := gwConn.ReadFromUDP(buf)
+		assert.NoError(err)
+
+		var ack packets.PullACKPacket
+		assert.NoError(ack.UnmarshalBinary(buf[:n]))
+		tokens[ack.RandomToken] = struct{}{}
+	}
+	assert.Len(tokens, 10)
+
+	assert.NoError(backend.Stop())
+}
+
+func TestBackendBackpressureWorkers(t *testing.T) {
+	assert := require.New(t)
+
+	var conf config.Config
+	conf.Backend.SemtechUDP.UDPBind = "127.0.0.1:0"
+	conf.Backend.SemtechUDP.Workers = 1
+	conf.Backend.SemtechUDP.Backpressure.Threshold = 1
+	conf.Backend.SemtechUDP.Backpressure.MaxACKDelay = 500 * time.Millisecond
+
+	backend, err := NewBackend(conf)
+	assert.NoError(err)
+	assert.NoError(backend.Start())
+	defer backend.Stop()
+
+	backendAddr, err := net.ResolveUDPAddr("udp", backend.conn.LocalAddr().String())
+	assert.NoError(err)
+
+	gw1Conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(err)
+	defer gw1Conn.Close()
+	assert.NoError(gw1Conn.SetDeadline(time.Now().Add(time.Second)))
+
+	gw2Conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(err)
+	defer gw2Conn.Close()
+	assert.NoError(gw2Conn.SetDeadline(time.Now().Add(time.Second)))
+
+	// the max. delay applies to the PUSH_ACK of the first gateway
+	atomic.StoreInt64(&backend.pending, 1000)
+	start := time.Now()
+
+	pushData := packets.PushDataPacket{
+		ProtocolVersion: packets.ProtocolVersion2,
+		RandomToken:     1234,
+		GatewayMAC:      [8]byte{1, 2, 3, 4, 5, 6, 7, 8},
+	}
+	b, err := pushData.MarshalBinary()
+	assert.NoError(err)
+	_, err = gw1Conn.WriteToUDP(b, backendAddr)
+	assert.NoError(err)
+
+	// wait until the PUSH_DATA is being handled by the (single) worker
+	time.Sleep(50 * time.Millisecond)
+
+	pullData := packets.PullDataPacket{
+		ProtocolVersion: packets.ProtocolVersion2,
+		RandomToken:     4321,
+		GatewayMAC:      [8]byte{8, 7, 6, 5, 4, 3, 2, 1},
+	}
+	b, err = pullData.MarshalBinary()
+	assert.NoError(err)
+	_, err = gw2Conn.WriteToUDP(b, backendAddr)
+	assert.NoError(err)
+
+	buf := make([]byte, 65507)
+
+	// the packet of the other gateway is handled during the delay
+	n, _, err := gw2Conn.ReadFromUDP(buf)
+	assert.NoError(err)
+	var pullACK packets.PullACKPacket
+	assert.NoError(pullACK.UnmarshalBinary(buf[:n]))
+	assert.Equal(uint16(4321), pullACK.RandomToken)
+	assert.Less(int64(time.Since(start)), int64(250*time.Millisecond))
+
+	n, _, err = gw1Conn.ReadFromUDP(buf)
+	assert.NoError(err)
+	var pushACK packets.PushACKPacket
+	assert.NoError(pushACK.UnmarshalBinary(buf[:n]))
+	assert.Equal(uint16(1234), pushACK.RandomToken)
+	assert.GreaterOrEqual(int64(time.Since(start)), int64(500*time.Millisecond))
+}
+
+func TestBackendNegativeWorkers(t *testing.T) {
+	assert := require.New(t)
+
+	var conf config.Config
+	conf.Backend.SemtechUDP.UDPBind = "127.0.0.1:0"
+	conf.Backend.SemtechUDP.Workers = -1
+
+	_, err := NewBackend(conf)
+	assert.EqualError(err, "invalid number of workers: -1")
+}
@@ -0,0 +1,60 @@
+package semtechudp
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// extensionFields holds the values of the configured extension fields of
+// the rxpk objects (in the order of the rxpk array) and of the stat object
+// of a PUSH_DATA payload.
+type extensionFields struct {
+	rxpk []map[string]string
+	stat map[string]string
+}
+
+// getExtensionFields returns the values of the given (non-standard) fields of
+// the rxpk and stat objects of the given PUSH_DATA JSON payload. String values
+// are returned as-is, other values as their JSON encoding. Fields that are
+// not present are omitted.
+func getExtensionFields(payload []byte, fields []string) (extensionFields, error) {
+	var out extensionFields
+	if len(fields) == 0 {
+		return out, nil
+	}
+
+	var pl struct {
+		RXPK []map[string]json.RawMessage `json:"rxpk"`
+		Stat map[string]json.RawMessage   `json:"stat"`
+	}
+
+	if err := json.Unmarshal(payload, &pl); err != nil {
+		return out, errors.Wrap(err, "decode json error")
+	}
+
+	for _, rxpk := range pl.RXPK {
+		out.rxpk = append(out.rxpk, getExtensionValues(rxpk, fields))
+	}
+	out.stat = getExtensionValues(pl.Stat, fields)
+
+	return out, nil
+}
+
+func getExtensionValues(obj map[string]json.RawMessage, fields []string) map[string]string {
+	values := make(map[string]string)
+	for _, field := range fields {
+		raw, ok := obj[field]
+		if !ok {
+			continue
+		}
+
+		var str string
+		if err := json.Unmarshal(raw, &str); err == nil {
+			values[field] = str
+		} else {
+			values[field] = string(raw)
+		}
+	}
+	return values
+}
@@ -0,0 +1,44 @@
+package semtechudp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetExtensionFields(t *testing.T) {
+	payload := []byte(`{
+		"rxpk": [
+			{"tmst": 708016819, "temp": 41.5, "board": "rev-b", "other": 1},
+			{"tmst": 708016820, "ntime": {"sec": 1, "nsec": 2}}
+		],
+		"stat": {"rxnb": 1, "temp": 38, "other": true}
+	}`)
+
+	t.Run("Listed fields", func(t *testing.T) {
+		assert := require.New(t)
+
+		ext, err := getExtensionFields(payload, []string{"temp", "board", "ntime"})
+		assert.NoError(err)
+		assert.Equal([]map[string]string{
+			{"temp": "41.5", "board": "rev-b"},
+			{"ntime": `{"sec": 1, "nsec": 2}`},
+		}, ext.rxpk)
+		assert.Equal(map[string]string{"temp": "38"}, ext.stat)
+	})
+
+	t.Run("No fields", func(t *testing.T) {
+		assert := require.New(t)
+
+		ext, err := getExtensionFields(payload, nil)
+		assert.NoError(err)
+		assert.Equal(extensionFields{}, ext)
+	})
+
+	t.Run("Invalid json", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := getExtensionFields([]byte("{"), []string{"temp"})
+		assert.Error(err)
+	})
+}
@@ -25,6 +25,37 @@ var (
 		Name: "backend_semtechudp_gateway_diconnect_count",
 		Help: "The number of gateways that disconnected from the backend.",
 	})
+
+	udg = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "backend_semtechudp_udp_dropped",
+		Help: "The number of UDP datagrams dropped by the kernel for the backend socket (e.g. because of a receive buffer overflow).",
+	})
+
+	pad = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "backend_semtechudp_push_ack_delayed_count",
+		Help: "The number of PUSH_ACK responses delayed because of backpressure.",
+	})
+
+	pdi = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "backend_semtechudp_pull_data_interval_seconds",
+		Help:    "The interval between two PULL_DATA packets of a gateway.",
+		Buckets: []float64{5, 10, 15, 20, 30, 45, 60},
+	})
+
+	pde = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "backend_semtechudp_pull_data_interval_exceeded_count",
+		Help: "The number of PULL_DATA packets received after the configured max. interval.",
+	})
+
+	gac = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "backend_semtechudp_gateway_addr_change_count",
+		Help: "The number of times the PULL_DATA source address of a gateway changed (e.g. NAT rebinding).",
+	})
+
+	crc = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "backend_semtechudp_uplink_crc_dropped_count",
+		Help: "The number of uplink frames dropped because of an invalid CRC (per crc_status).",
+	}, []string{"crc_status"})
 )
 
 func udpWriteCounter(pt string) prometheus.Counter {
@@ -42,3 +73,27 @@ func connectCounter() prometheus.Counter {
 func disconnectCounter() prometheus.Counter {
 	return gwd
 }
+
+func udpDropsGauge() prometheus.Gauge {
+	return udg
+}
+
+func pushACKDelayCounter() prometheus.Counter {
+	return pad
+}
+
+func pullDataIntervalHistogram() prometheus.Histogram {
+	return pdi
+}
+
+func pullDataIntervalExceededCounter() prometheus.Counter {
+	return pde
+}
+
+func addrChangeCounter() prometheus.Counter {
+	return gac
+}
+
+func crcDroppedCounter(status string) prometheus.Counter {
+	return crc.With(prometheus.Labels{"crc_status": status})
+}
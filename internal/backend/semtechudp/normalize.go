@@ -0,0 +1,97 @@
+package semtechudp
+
+import (
+	"fmt"
+
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/backend/semtechudp/packets"
+)
+
+// Frequency units of the rxpk freq field.
+const (
+	FrequencyUnitMHz  = "mhz"
+	FrequencyUnitHz   = "hz"
+	FrequencyUnitAuto = "auto"
+)
+
+// Time units of the rxpk tmms field.
+const (
+	GPSTimeUnitMS   = "ms"
+	GPSTimeUnitS    = "s"
+	GPSTimeUnitNS   = "ns"
+	GPSTimeUnitAuto = "auto"
+)
+
+const (
+	// autoFrequencyHzMin defines the min. frequency value that is considered
+	// to be in Hz when the frequency unit is set to auto. LoRa frequencies
+	// in MHz are always below this value.
+	autoFrequencyHzMin = 1000000
+
+	// autoGPSTimeSMax defines the max. GPS time value that is considered to
+	// be in seconds and autoGPSTimeNSMin the min. value that is considered
+	// to be in nanoseconds when the GPS time unit is set to auto.
+	autoGPSTimeSMax  = 1e11
+	autoGPSTimeNSMin = 1e15
+)
+
+// normalizer converts the units of the received rxpk objects to the units as
+// defined by the packet-forwarder protocol (frequency in MHz, GPS time in
+// milliseconds), for packet-forwarders that deviate from this protocol.
+type normalizer struct {
+	frequencyUnit string
+	gpsTimeUnit   string
+}
+
+func newNormalizer(frequencyUnit, gpsTimeUnit string) (normalizer, error) {
+	switch frequencyUnit {
+	case "":
+		frequencyUnit = FrequencyUnitMHz
+	case FrequencyUnitMHz, FrequencyUnitHz, FrequencyUnitAuto:
+	default:
+		return normalizer{}, fmt.Errorf("unknown frequency unit: %s", frequencyUnit)
+	}
+
+	switch gpsTimeUnit {
+	case "":
+		gpsTimeUnit = GPSTimeUnitMS
+	case GPSTimeUnitMS, GPSTimeUnitS, GPSTimeUnitNS, GPSTimeUnitAuto:
+	default:
+		return normalizer{}, fmt.Errorf("unknown gps time unit: %s", gpsTimeUnit)
+	}
+
+	return normalizer{
+		frequencyUnit: frequencyUnit,
+		gpsTimeUnit:   gpsTimeUnit,
+	}, nil
+}
+
+// RXPK normalizes the units of the given rxpk.
+func (n normalizer) RXPK(rxpk *packets.RXPK) {
+	switch n.frequencyUnit {
+	case FrequencyUnitHz:
+		rxpk.Freq = rxpk.Freq / 1000000
+	case FrequencyUnitAuto:
+		if rxpk.Freq >= autoFrequencyHzMin {
+			rxpk.Freq = rxpk.Freq / 1000000
+		}
+	}
+
+	if rxpk.Tmms == nil {
+		return
+	}
+
+	tmms := *rxpk.Tmms
+	switch n.gpsTimeUnit {
+	case GPSTimeUnitS:
+		tmms = tmms * 1000
+	case GPSTimeUnitNS:
+		tmms = tmms / 1000000
+	case GPSTimeUnitAuto:
+		if tmms < autoGPSTimeSMax {
+			tmms = tmms * 1000
+		} else if tmms >= autoGPSTimeNSMin {
+			tmms = tmms / 1000000
+		}
+	}
+	rxpk.Tmms = &tmms
+}
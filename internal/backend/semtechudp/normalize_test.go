@@ -0,0 +1,96 @@
+package semtechudp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/backend/semtechudp/packets"
+)
+
+func TestNormalizer(t *testing.T) {
+	int64Ptr := func(i int64) *int64 {
+		return &i
+	}
+
+	tests := []struct {
+		Name          string
+		FrequencyUnit string
+		GPSTimeUnit   string
+		In            packets.RXPK
+		Expected      packets.RXPK
+		Error         string
+	}{
+		{
+			Name:     "defaults",
+			In:       packets.RXPK{Freq: 868.1, Tmms: int64Ptr(1300000000000)},
+			Expected: packets.RXPK{Freq: 868.1, Tmms: int64Ptr(1300000000000)},
+		},
+		{
+			Name:          "hz and seconds",
+			FrequencyUnit: FrequencyUnitHz,
+			GPSTimeUnit:   GPSTimeUnitS,
+			In:            packets.RXPK{Freq: 868100000, Tmms: int64Ptr(1300000000)},
+			Expected:      packets.RXPK{Freq: 868.1, Tmms: int64Ptr(1300000000000)},
+		},
+		{
+			Name:        "nanoseconds",
+			GPSTimeUnit: GPSTimeUnitNS,
+			In:          packets.RXPK{Freq: 868.1, Tmms: int64Ptr(1300000000000000000)},
+			Expected:    packets.RXPK{Freq: 868.1, Tmms: int64Ptr(1300000000000)},
+		},
+		{
+			Name:          "auto, vendor a (protocol units)",
+			FrequencyUnit: FrequencyUnitAuto,
+			GPSTimeUnit:   GPSTimeUnitAuto,
+			In:            packets.RXPK{Freq: 868.1, Tmms: int64Ptr(1300000000000)},
+			Expected:      packets.RXPK{Freq: 868.1, Tmms: int64Ptr(1300000000000)},
+		},
+		{
+			Name:          "auto, vendor b (hz and seconds)",
+			FrequencyUnit: FrequencyUnitAuto,
+			GPSTimeUnit:   GPSTimeUnitAuto,
+			In:            packets.RXPK{Freq: 868100000, Tmms: int64Ptr(1300000000)},
+			Expected:      packets.RXPK{Freq: 868.1, Tmms: int64Ptr(1300000000000)},
+		},
+		{
+			Name:          "auto, vendor c (nanoseconds)",
+			FrequencyUnit: FrequencyUnitAuto,
+			GPSTimeUnit:   GPSTimeUnitAuto,
+			In:            packets.RXPK{Freq: 868.1, Tmms: int64Ptr(1300000000000000000)},
+			Expected:      packets.RXPK{Freq: 868.1, Tmms: int64Ptr(1300000000000)},
+		},
+		{
+			Name:     "without gps time",
+			In:       packets.RXPK{Freq: 868.1},
+			Expected: packets.RXPK{Freq: 868.1},
+		},
+		{
+			Name:          "invalid frequency unit",
+			FrequencyUnit: "khz",
+			Error:         "unknown frequency unit: khz",
+		},
+		{
+			Name:        "invalid gps time unit",
+			GPSTimeUnit: "us",
+			Error:       "unknown gps time unit: us",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			n, err := newNormalizer(tst.FrequencyUnit, tst.GPSTimeUnit)
+			if tst.Error != "" {
+				assert.EqualError(err, tst.Error)
+				return
+			}
+			assert.NoError(err)
+
+			n.RXPK(&tst.In)
+			assert.InDelta(tst.Expected.Freq, tst.In.Freq, 0.000001)
+			assert.Equal(tst.Expected.Tmms, tst.In.Tmms)
+		})
+	}
+}
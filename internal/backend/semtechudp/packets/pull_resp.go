@@ -148,8 +148,10 @@ func GetPullRespPacket(protoVersion uint8, randomToken uint16, frame gw.Downlink
 		if len(txInfo.GetContext()) < 4 {
 			return packet, fmt.Errorf("context must contain at least 4 bytes, got: %d", len(txInfo.GetContext()))
 		}
-		timestamp := binary.BigEndian.Uint32(txInfo.GetContext()[0:4])
-		timestamp += uint32(delay / time.Microsecond)
+		timestamp, err := addTmstDelay(binary.BigEndian.Uint32(txInfo.GetContext()[0:4]), delay)
+		if err != nil {
+			return packet, errors.Wrap(err, "add delay to context timestamp error")
+		}
 		packet.Payload.TXPK.Tmst = &timestamp
 
 	case gw.DownlinkTiming_GPS_EPOCH:
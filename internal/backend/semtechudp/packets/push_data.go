@@ -14,6 +14,7 @@ import (
 	"github.com/brocaar/chirpstack-api/go/v3/common"
 	"github.com/brocaar/chirpstack-api/go/v3/gw"
 	"github.com/brocaar/lorawan"
+	"github.com/brocaar/lorawan/gps"
 )
 
 // loRaDataRateRegex contains a regexp for parsing the LoRa data-rate string.
@@ -60,6 +61,7 @@ func (p PushDataPacket) GetGatewayStats() (*gw.GatewayStats, error) {
 		RxPacketsReceivedOk: p.Payload.Stat.RXOK,
 		TxPacketsEmitted:    p.Payload.Stat.TXNb,
 		TxPacketsReceived:   p.Payload.Stat.DWNb,
+		MetaData:            getStatMetaData(*p.Payload.Stat),
 	}
 
 	// time
@@ -89,13 +91,33 @@ func (p PushDataPacket) GetGatewayStats() (*gw.GatewayStats, error) {
 	return &stats, nil
 }
 
+// getStatMetaData returns the stat fields that do not map to a gw.GatewayStats
+// field, so that these are forwarded as meta-data.
+func getStatMetaData(stat Stat) map[string]string {
+	metaData := map[string]string{
+		"rxfw": strconv.FormatUint(uint64(stat.RXFW), 10),
+		"ackr": strconv.FormatFloat(stat.ACKR, 'f', -1, 64),
+	}
+
+	for k, v := range map[string]string{
+		"pfrm": stat.PFrm,
+		"mail": stat.Mail,
+		"desc": stat.Desc,
+	} {
+		if v != "" {
+			metaData[k] = v
+		}
+	}
+
+	return metaData
+}
+
 // GetUplinkFrames returns a slice of gw.UplinkFrame.
 func (p PushDataPacket) GetUplinkFrames(skipCRCCheck bool, FakeRxInfoTime bool) ([]gw.UplinkFrame, error) {
 	var frames []gw.UplinkFrame
 
 	for i := range p.Payload.RXPK {
-		// validate CRC
-		if p.Payload.RXPK[i].Stat != 1 && !skipCRCCheck {
+		if !includeRXPK(p.Payload.RXPK[i], skipCRCCheck) {
 			continue
 		}
 
@@ -136,6 +158,36 @@ func (p PushDataPacket) GetUplinkFrames(skipCRCCheck bool, FakeRxInfoTime bool)
 	return frames, nil
 }
 
+// GetUplinkFrameRXPKIndices returns for each uplink frame returned by
+// GetUplinkFrames, given the same skipCRCCheck value, the index of the rxpk
+// object from which the uplink frame originates.
+func (p PushDataPacket) GetUplinkFrameRXPKIndices(skipCRCCheck bool) []int {
+	var out []int
+
+	for i := range p.Payload.RXPK {
+		if !includeRXPK(p.Payload.RXPK[i], skipCRCCheck) {
+			continue
+		}
+
+		// an rxpk with rsig array results in an uplink frame per rsig object
+		n := len(p.Payload.RXPK[i].RSig)
+		if n == 0 {
+			n = 1
+		}
+		for j := 0; j < n; j++ {
+			out = append(out, i)
+		}
+	}
+
+	return out
+}
+
+// includeRXPK returns true when the rxpk must be converted into uplink
+// frame(s) (validate CRC).
+func includeRXPK(rxpk RXPK, skipCRCCheck bool) bool {
+	return rxpk.Stat == 1 || skipCRCCheck
+}
+
 func setUplinkFrameRSig(frame gw.UplinkFrame, rxPK RXPK, rSig RSig) gw.UplinkFrame {
 	frame.RxInfo.Antenna = uint32(rSig.Ant)
 	frame.RxInfo.Channel = uint32(rSig.Chan)
@@ -202,6 +254,31 @@ func getUplinkFrame(gatewayID []byte, rxpk RXPK, FakeRxInfoTime bool) (gw.Uplink
 		frame.RxInfo.TimeSinceGpsEpoch = ptypes.DurationProto(d)
 	}
 
+	// Plain fine timestamp, relative to the (GPS synchronized) second of the
+	// RX time. An encrypted fine timestamp (rsig etime) takes precedence.
+	if rxpk.FTime != nil && *rxpk.FTime < uint32(time.Second) {
+		var rxTime time.Time
+		if rxpk.Time != nil && !time.Time(*rxpk.Time).IsZero() {
+			rxTime = time.Time(*rxpk.Time)
+		} else if rxpk.Tmms != nil {
+			rxTime = time.Time(gps.NewTimeFromTimeSinceGPSEpoch(time.Duration(*rxpk.Tmms) * time.Millisecond))
+		}
+
+		if !rxTime.IsZero() {
+			ts, err := ptypes.TimestampProto(rxTime.Truncate(time.Second).Add(time.Duration(*rxpk.FTime)))
+			if err != nil {
+				return frame, errors.Wrap(err, "backend/semtechudp/packets: fine timestamp proto error")
+			}
+
+			frame.RxInfo.FineTimestampType = gw.FineTimestampType_PLAIN
+			frame.RxInfo.FineTimestamp = &gw.UplinkRXInfo_PlainFineTimestamp{
+				PlainFineTimestamp: &gw.PlainFineTimestamp{
+					Time: ts,
+				},
+			}
+		}
+	}
+
 	// LoRa data-rate
 	if rxpk.DatR.LoRa != "" {
 		frame.TxInfo.Modulation = common.Modulation_LORA
@@ -311,28 +388,32 @@ type Stat struct {
 	ACKR float64      `json:"ackr"` // Percentage of upstream datagrams that were acknowledged
 	DWNb uint32       `json:"dwnb"` // Number of downlink datagrams received (unsigned integer)
 	TXNb uint32       `json:"txnb"` // Number of packets emitted (unsigned integer)
+	PFrm string       `json:"pfrm"` // Gateway platform / packet-forwarder description (Optional)
+	Mail string       `json:"mail"` // Contact e-mail of the gateway owner (Optional)
+	Desc string       `json:"desc"` // Description of the gateway (Optional)
 }
 
 // RXPK contain a RF packet and associated metadata.
 type RXPK struct {
-	Time *CompactTime `json:"time"` // UTC time of pkt RX, us precision, ISO 8601 'compact' format (e.g. 2013-03-31T16:21:17.528002Z)
-	Tmms *int64       `json:"tmms"` // GPS time of pkt RX, number of milliseconds since 06.Jan.1980
-	Tmst uint32       `json:"tmst"` // Internal timestamp of "RX finished" event (32b unsigned)
-	AESK uint8        `json:"aesk"` //AES key index used for encrypting fine timestamps
-	Chan uint8        `json:"chan"` // Concentrator "IF" channel used for RX (unsigned integer)
-	RFCh uint8        `json:"rfch"` // Concentrator "RF chain" used for RX (unsigned integer)
-	Stat int8         `json:"stat"` // CRC status: 1 = OK, -1 = fail, 0 = no CRC
-	Freq float64      `json:"freq"` // RX central frequency in MHz (unsigned float, Hz precision)
-	Brd  uint32       `json:"brd"`  // Concentrator board used for RX (unsigned integer)
-	RSSI int16        `json:"rssi"` // RSSI in dBm (signed integer, 1 dB precision)
-	Size uint16       `json:"size"` // RF packet payload size in bytes (unsigned integer)
-	DatR DatR         `json:"datr"` // LoRa datarate identifier (eg. SF12BW500) || FSK datarate (unsigned, in bits per second)
-	Modu string       `json:"modu"` // Modulation identifier "LORA" or "FSK"
-	CodR string       `json:"codr"` // LoRa ECC coding rate identifier
-	LSNR float64      `json:"lsnr"` // Lora SNR ratio in dB (signed float, 0.1 dB precision)
-	HPW  uint8        `json:"hpw"`  // LR-FHSS hopping grid number of steps.
-	Data []byte       `json:"data"` // Base64 encoded RF packet payload, padded
-	RSig []RSig       `json:"rsig"` // Received signal information, per antenna (Optional)
+	Time  *CompactTime `json:"time"`  // UTC time of pkt RX, us precision, ISO 8601 'compact' format (e.g. 2013-03-31T16:21:17.528002Z)
+	Tmms  *int64       `json:"tmms"`  // GPS time of pkt RX, number of milliseconds since 06.Jan.1980
+	Tmst  uint32       `json:"tmst"`  // Internal timestamp of "RX finished" event (32b unsigned)
+	FTime *uint32      `json:"ftime"` // Fine timestamp, ns precision within the second of the RX time [0..999999999] (Optional)
+	AESK  uint8        `json:"aesk"`  //AES key index used for encrypting fine timestamps
+	Chan  uint8        `json:"chan"`  // Concentrator "IF" channel used for RX (unsigned integer)
+	RFCh  uint8        `json:"rfch"`  // Concentrator "RF chain" used for RX (unsigned integer)
+	Stat  int8         `json:"stat"`  // CRC status: 1 = OK, -1 = fail, 0 = no CRC
+	Freq  float64      `json:"freq"`  // RX central frequency in MHz (unsigned float, Hz precision)
+	Brd   uint32       `json:"brd"`   // Concentrator board used for RX (unsigned integer)
+	RSSI  int16        `json:"rssi"`  // RSSI in dBm (signed integer, 1 dB precision)
+	Size  uint16       `json:"size"`  // RF packet payload size in bytes (unsigned integer)
+	DatR  DatR         `json:"datr"`  // LoRa datarate identifier (eg. SF12BW500) || FSK datarate (unsigned, in bits per second)
+	Modu  string       `json:"modu"`  // Modulation identifier "LORA" or "FSK"
+	CodR  string       `json:"codr"`  // LoRa ECC coding rate identifier
+	LSNR  float64      `json:"lsnr"`  // Lora SNR ratio in dB (signed float, 0.1 dB precision)
+	HPW   uint8        `json:"hpw"`   // LR-FHSS hopping grid number of steps.
+	Data  []byte       `json:"data"`  // Base64 encoded RF packet payload, padded
+	RSig  []RSig       `json:"rsig"`  // Received signal information, per antenna (Optional)
 }
 
 // RSig contains the received signal information per antenna.
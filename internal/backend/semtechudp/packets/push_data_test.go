@@ -1,16 +1,19 @@
 package packets
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/brocaar/chirpstack-api/go/v3/common"
 	"github.com/brocaar/chirpstack-api/go/v3/gw"
 	"github.com/brocaar/lorawan"
+	"github.com/brocaar/lorawan/gps"
 )
 
 func TestPushDataTest(t *testing.T) {
@@ -100,6 +103,10 @@ func TestGetGatewayStats(t *testing.T) {
 				RxPacketsReceivedOk: 2,
 				TxPacketsReceived:   5,
 				TxPacketsEmitted:    6,
+				MetaData: map[string]string{
+					"rxfw": "3",
+					"ackr": "4",
+				},
 			},
 		},
 		{
@@ -125,6 +132,10 @@ func TestGetGatewayStats(t *testing.T) {
 				RxPacketsReceivedOk: 2,
 				TxPacketsReceived:   5,
 				TxPacketsEmitted:    6,
+				MetaData: map[string]string{
+					"rxfw": "3",
+					"ackr": "4",
+				},
 			},
 		},
 	}
@@ -142,6 +153,44 @@ func TestGetGatewayStats(t *testing.T) {
 	}
 }
 
+func TestGetGatewayStatsJSON(t *testing.T) {
+	assert := require.New(t)
+
+	// stat as sent by the Semtech packet-forwarder (with the optional fields
+	// of the TTN / mp packet-forwarder)
+	b := []byte{ProtocolVersion2, 0x04, 0xd2, 0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+	b = append(b, []byte(`{"stat":{"time":"2021-04-14 12:34:56 GMT","lati":52.37012,"long":4.89092,"alti":4,"rxnb":12,"rxok":10,"rxfw":9,"ackr":100.0,"dwnb":3,"txnb":2,"pfrm":"IMST + Rpi","mail":"ops@example.com","desc":"Rooftop gateway"}}`)...)
+
+	var p PushDataPacket
+	assert.NoError(p.UnmarshalBinary(b))
+
+	stats, err := p.GetGatewayStats()
+	assert.NoError(err)
+	stats.StatsId = nil
+
+	assert.Equal(&gw.GatewayStats{
+		GatewayId: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		Time:      &timestamp.Timestamp{Seconds: 1618403696},
+		Location: &common.Location{
+			Latitude:  52.37012,
+			Longitude: 4.89092,
+			Altitude:  4,
+			Source:    common.LocationSource_GPS,
+		},
+		RxPacketsReceived:   12,
+		RxPacketsReceivedOk: 10,
+		TxPacketsReceived:   3,
+		TxPacketsEmitted:    2,
+		MetaData: map[string]string{
+			"rxfw": "9",
+			"ackr": "100",
+			"pfrm": "IMST + Rpi",
+			"mail": "ops@example.com",
+			"desc": "Rooftop gateway",
+		},
+	}, stats)
+}
+
 func TestGetUplinkFrame(t *testing.T) {
 	assert := assert.New(t)
 
@@ -483,3 +532,102 @@ func TestGetUplinkFrame(t *testing.T) {
 		})
 	}
 }
+
+func TestGetUplinkFrameFineTimestamp(t *testing.T) {
+	rxTime := time.Date(2020, 6, 1, 12, 30, 15, 500000000, time.UTC)
+	ctRxTime := CompactTime(rxTime)
+	tmms := int64(gps.Time(rxTime).TimeSinceGPSEpoch() / time.Millisecond)
+	ftime := uint32(123456789)
+	invalidFTime := uint32(time.Second)
+
+	fineTime, err := ptypes.TimestampProto(time.Date(2020, 6, 1, 12, 30, 15, 123456789, time.UTC))
+	require.NoError(t, err)
+
+	tests := []struct {
+		Name     string
+		RXPK     RXPK
+		Expected *gw.PlainFineTimestamp
+	}{
+		{
+			Name:     "fine timestamp and rx time",
+			RXPK:     RXPK{Time: &ctRxTime, FTime: &ftime},
+			Expected: &gw.PlainFineTimestamp{Time: fineTime},
+		},
+		{
+			Name:     "fine timestamp and gps time",
+			RXPK:     RXPK{Tmms: &tmms, FTime: &ftime},
+			Expected: &gw.PlainFineTimestamp{Time: fineTime},
+		},
+		{
+			Name: "fine timestamp without rx time",
+			RXPK: RXPK{FTime: &ftime},
+		},
+		{
+			Name: "invalid fine timestamp",
+			RXPK: RXPK{Time: &ctRxTime, FTime: &invalidFTime},
+		},
+		{
+			Name: "no fine timestamp",
+			RXPK: RXPK{Time: &ctRxTime, Tmms: &tmms},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			tst.RXPK.DatR = DatR{LoRa: "SF12BW125"}
+			frame, err := getUplinkFrame([]byte{1, 2, 3, 4, 5, 6, 7, 8}, tst.RXPK, false)
+			assert.NoError(err)
+
+			if tst.Expected == nil {
+				assert.Equal(gw.FineTimestampType_NONE, frame.RxInfo.FineTimestampType)
+				assert.Nil(frame.RxInfo.FineTimestamp)
+				return
+			}
+
+			assert.Equal(gw.FineTimestampType_PLAIN, frame.RxInfo.FineTimestampType)
+			assert.Equal(tst.Expected, frame.RxInfo.GetPlainFineTimestamp())
+		})
+	}
+
+	t.Run("encrypted fine timestamp takes precedence", func(t *testing.T) {
+		assert := require.New(t)
+
+		frame, err := getUplinkFrame([]byte{1, 2, 3, 4, 5, 6, 7, 8}, RXPK{Time: &ctRxTime, FTime: &ftime, DatR: DatR{LoRa: "SF12BW125"}}, false)
+		assert.NoError(err)
+		frame = setUplinkFrameRSig(frame, RXPK{AESK: 1}, RSig{ETime: []byte{1, 2, 3}})
+
+		assert.Equal(gw.FineTimestampType_ENCRYPTED, frame.RxInfo.FineTimestampType)
+		assert.Equal([]byte{1, 2, 3}, frame.RxInfo.GetEncryptedFineTimestamp().EncryptedNs)
+	})
+}
+
+func TestGetUplinkFrameRXPKIndices(t *testing.T) {
+	p := PushDataPacket{
+		GatewayMAC: lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		Payload: PushDataPayload{
+			RXPK: []RXPK{
+				{Stat: -1, Modu: "LORA", DatR: DatR{LoRa: "SF7BW125"}, CodR: "4/5", Data: []byte{1}},
+				{Stat: 1, Modu: "LORA", DatR: DatR{LoRa: "SF7BW125"}, CodR: "4/5", Data: []byte{2}, RSig: []RSig{{Ant: 0}, {Ant: 1}}},
+				{Stat: 1, Modu: "LORA", DatR: DatR{LoRa: "SF7BW125"}, CodR: "4/5", Data: []byte{3}},
+			},
+		},
+	}
+
+	for _, skipCRCCheck := range []bool{true, false} {
+		t.Run(fmt.Sprintf("skip crc check %t", skipCRCCheck), func(t *testing.T) {
+			assert := require.New(t)
+
+			frames, err := p.GetUplinkFrames(skipCRCCheck, false)
+			assert.NoError(err)
+
+			indices := p.GetUplinkFrameRXPKIndices(skipCRCCheck)
+			assert.Len(indices, len(frames))
+
+			for i := range frames {
+				assert.Equal(p.Payload.RXPK[indices[i]].Data, frames[i].PhyPayload)
+			}
+		})
+	}
+}
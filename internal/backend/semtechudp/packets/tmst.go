@@ -0,0 +1,25 @@
+package packets
+
+import (
+	"fmt"
+	"time"
+)
+
+// MaxTmstDelay defines the max. delay relative to a concentrator counter
+// (tmst) value, e.g. of the DELAY downlink timing. The counter is a 32bit
+// microsecond counter, wrapping every ~71.6 minutes. The packet-forwarder
+// compares counter values using 32bit modular arithmetic, a counter value
+// which is half the counter range or more ahead is interpreted as being in
+// the past.
+const MaxTmstDelay = (1<<31 - 1) * time.Microsecond
+
+// addTmstDelay returns the concentrator counter value of the given counter
+// value plus the given delay, taking the counter rollover into account.
+func addTmstDelay(tmst uint32, delay time.Duration) (uint32, error) {
+	if delay < 0 || delay > MaxTmstDelay {
+		return 0, fmt.Errorf("delay must be between 0 and %s, got: %s", MaxTmstDelay, delay)
+	}
+
+	// uint32 arithmetic wraps around at 2^32, like the concentrator counter
+	return tmst + uint32(delay/time.Microsecond), nil
+}
@@ -0,0 +1,121 @@
+package packets
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/brocaar/chirpstack-api/go/v3/common"
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddTmstDelay(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Tmst     uint32
+		Delay    time.Duration
+		Expected uint32
+		Error    bool
+	}{
+		{
+			Name:     "no rollover",
+			Tmst:     1000000,
+			Delay:    time.Second,
+			Expected: 2000000,
+		},
+		{
+			Name:     "rollover between uplink and downlink",
+			Tmst:     0xffffffff - 499999,
+			Delay:    time.Second,
+			Expected: 500000,
+		},
+		{
+			Name:     "uplink at last counter value",
+			Tmst:     0xffffffff,
+			Delay:    5 * time.Second,
+			Expected: 4999999,
+		},
+		{
+			Name:     "max delay",
+			Tmst:     0xffffffff,
+			Delay:    MaxTmstDelay,
+			Expected: 1<<31 - 2,
+		},
+		{
+			Name:  "delay exceeds half the counter range",
+			Tmst:  1000000,
+			Delay: MaxTmstDelay + time.Microsecond,
+			Error: true,
+		},
+		{
+			Name:  "negative delay",
+			Tmst:  1000000,
+			Delay: -time.Second,
+			Error: true,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			tmst, err := addTmstDelay(tst.Tmst, tst.Delay)
+			if tst.Error {
+				assert.Error(err)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tst.Expected, tmst)
+		})
+	}
+}
+
+func TestGetPullRespPacketTmstRollover(t *testing.T) {
+	assert := require.New(t)
+
+	context := make([]byte, 4)
+	binary.BigEndian.PutUint32(context, 0xffffffff-499999)
+
+	txInfo := gw.DownlinkTXInfo{
+		Frequency:  868100000,
+		Modulation: common.Modulation_LORA,
+		ModulationInfo: &gw.DownlinkTXInfo_LoraModulationInfo{
+			LoraModulationInfo: &gw.LoRaModulationInfo{
+				SpreadingFactor: 12,
+				Bandwidth:       125,
+				CodeRate:        "4/5",
+			},
+		},
+		Timing: gw.DownlinkTiming_DELAY,
+		TimingInfo: &gw.DownlinkTXInfo_DelayTimingInfo{
+			DelayTimingInfo: &gw.DelayTimingInfo{
+				Delay: ptypes.DurationProto(time.Second),
+			},
+		},
+		Context: context,
+	}
+	pl := gw.DownlinkFrame{
+		Items: []*gw.DownlinkFrameItem{
+			{
+				PhyPayload: []byte{1, 2, 3, 4},
+				TxInfo:     &txInfo,
+			},
+		},
+	}
+
+	packet, err := GetPullRespPacket(ProtocolVersion2, 1234, pl, 0)
+	assert.NoError(err)
+	assert.Equal(uint32(500000), *packet.Payload.TXPK.Tmst)
+
+	// the downlink would be interpreted as being in the past
+	txInfo.TimingInfo = &gw.DownlinkTXInfo_DelayTimingInfo{
+		DelayTimingInfo: &gw.DelayTimingInfo{
+			Delay: ptypes.DurationProto(time.Hour),
+		},
+	}
+	_, err = GetPullRespPacket(ProtocolVersion2, 1234, pl, 0)
+	assert.Error(err)
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"strings"
 
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
 	"github.com/brocaar/lorawan"
 )
 
@@ -71,3 +73,21 @@ type TXACKPayload struct {
 type TXPKACK struct {
 	Error string `json:"error"`
 }
+
+// GetTxAckStatus returns the TX acknowledgement status for the error of the
+// TXPKACK. The error is matched case-insensitive, an empty error or NONE
+// returns OK. Unknown errors return INTERNAL_ERROR, in which case known is
+// false.
+func (p TXPKACK) GetTxAckStatus() (status gw.TxAckStatus, known bool) {
+	e := strings.ToUpper(strings.TrimSpace(p.Error))
+	if e == "" || e == "NONE" {
+		return gw.TxAckStatus_OK, true
+	}
+
+	// IGNORED is set by the bridge, not by the packet-forwarder
+	if v, ok := gw.TxAckStatus_value[e]; ok && gw.TxAckStatus(v) != gw.TxAckStatus_IGNORED {
+		return gw.TxAckStatus(v), true
+	}
+
+	return gw.TxAckStatus_INTERNAL_ERROR, false
+}
@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
 )
 
 func TestTXACK(t *testing.T) {
@@ -63,3 +65,34 @@ func TestTXACK(t *testing.T) {
 		assert.Equal(test.TXACKPacket, p)
 	}
 }
+
+func TestTXPKACKGetTxAckStatus(t *testing.T) {
+	tests := []struct {
+		Error          string
+		ExpectedStatus gw.TxAckStatus
+		ExpectedKnown  bool
+	}{
+		{"", gw.TxAckStatus_OK, true},
+		{"NONE", gw.TxAckStatus_OK, true},
+		{"TOO_LATE", gw.TxAckStatus_TOO_LATE, true},
+		{"TOO_EARLY", gw.TxAckStatus_TOO_EARLY, true},
+		{"COLLISION_PACKET", gw.TxAckStatus_COLLISION_PACKET, true},
+		{"COLLISION_BEACON", gw.TxAckStatus_COLLISION_BEACON, true},
+		{"TX_FREQ", gw.TxAckStatus_TX_FREQ, true},
+		{"TX_POWER", gw.TxAckStatus_TX_POWER, true},
+		{"GPS_UNLOCKED", gw.TxAckStatus_GPS_UNLOCKED, true},
+		{" gps_unlocked", gw.TxAckStatus_GPS_UNLOCKED, true},
+		{"IGNORED", gw.TxAckStatus_INTERNAL_ERROR, false},
+		{"SOMETHING_ELSE", gw.TxAckStatus_INTERNAL_ERROR, false},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Error, func(t *testing.T) {
+			assert := assert.New(t)
+
+			status, known := TXPKACK{Error: tst.Error}.GetTxAckStatus()
+			assert.Equal(tst.ExpectedStatus, status)
+			assert.Equal(tst.ExpectedKnown, known)
+		})
+	}
+}
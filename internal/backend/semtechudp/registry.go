@@ -14,24 +14,36 @@ import (
 // errors
 var (
 	errGatewayDoesNotExist = errors.New("gateway does not exist")
+	errGatewayNoPullData   = errors.New("gateway has not sent PULL_DATA")
 )
 
-// gatewayCleanupDuration contains the duration after which the gateway is
-// cleaned up from the registry after no activity
-var gatewayCleanupDuration = -1 * time.Minute
+// defaultGatewayTimeout contains the duration after which the gateway is
+// cleaned up from the registry after no activity, when no gateway timeout
+// is configured.
+const defaultGatewayTimeout = time.Minute
 
 // gateway contains a connection and meta-data for a gateway connection.
 type gateway struct {
 	stats           *stats.Collector
 	addr            *net.UDPAddr
 	lastSeen        time.Time
+	lastPushData    time.Time
 	protocolVersion uint8
 }
 
+// lastActivity returns the time of the last PULL_DATA or PUSH_DATA.
+func (g gateway) lastActivity() time.Time {
+	if g.lastPushData.After(g.lastSeen) {
+		return g.lastPushData
+	}
+	return g.lastSeen
+}
+
 // gateways contains the gateways registry.
 type gateways struct {
 	sync.RWMutex
 	gateways map[lorawan.EUI64]gateway
+	timeout  time.Duration
 
 	subscribeEventFunc func(events.Subscribe)
 }
@@ -63,6 +75,7 @@ func (c *gateways) set(gatewayID lorawan.EUI64, gw gateway) error {
 		connectCounter().Inc()
 	} else {
 		gw.stats = gww.stats
+		gw.lastPushData = gww.lastPushData
 	}
 
 	if c.subscribeEventFunc != nil {
@@ -76,13 +89,50 @@ func (c *gateways) set(gatewayID lorawan.EUI64, gw gateway) error {
 	return nil
 }
 
-// cleanup removes inactive gateways from the registry.
+// pushData updates the last PUSH_DATA time of the given gateway. Unknown
+// gateways are registered, so that the gateway is online on its first
+// uplink. Note that downlinks can only be sent once the gateway has sent its
+// PULL_DATA (see set).
+func (c *gateways) pushData(gatewayID lorawan.EUI64, protocolVersion uint8, t time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	gw, ok := c.gateways[gatewayID]
+	if !ok {
+		gw = gateway{
+			stats:           stats.NewCollector(),
+			protocolVersion: protocolVersion,
+		}
+		connectCounter().Inc()
+
+		if c.subscribeEventFunc != nil {
+			c.subscribeEventFunc(events.Subscribe{
+				Subscribe: true,
+				GatewayID: gatewayID,
+			})
+		}
+	}
+	gw.lastPushData = t
+	c.gateways[gatewayID] = gw
+}
+
+// getTimeout returns the duration after which an inactive gateway is
+// removed from the registry.
+func (c *gateways) getTimeout() time.Duration {
+	if c.timeout == 0 {
+		return defaultGatewayTimeout
+	}
+	return c.timeout
+}
+
+// cleanup removes inactive gateways from the registry. A gateway is inactive
+// when no PULL_DATA and PUSH_DATA have been received within the timeout.
 func (c *gateways) cleanup() error {
 	c.Lock()
 	defer c.Unlock()
 
 	for gatewayID := range c.gateways {
-		if c.gateways[gatewayID].lastSeen.Before(time.Now().Add(gatewayCleanupDuration)) {
+		if time.Since(c.gateways[gatewayID].lastActivity()) > c.getTimeout() {
 			disconnectCounter().Inc()
 
 			if c.subscribeEventFunc != nil {
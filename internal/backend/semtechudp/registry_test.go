@@ -0,0 +1,71 @@
+package semtechudp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/backend/events"
+	"github.com/brocaar/lorawan"
+)
+
+func TestGatewaysCleanup(t *testing.T) {
+	assert := require.New(t)
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	var subscribeEvents []events.Subscribe
+	reg := gateways{
+		gateways: make(map[lorawan.EUI64]gateway),
+		timeout:  time.Second,
+		subscribeEventFunc: func(pl events.Subscribe) {
+			subscribeEvents = append(subscribeEvents, pl)
+		},
+	}
+
+	assert.NoError(reg.set(gatewayID, gateway{lastSeen: time.Now().Add(-2 * time.Second)}))
+
+	t.Run("PUSH_DATA keeps the gateway registered", func(t *testing.T) {
+		assert := require.New(t)
+
+		reg.pushData(gatewayID, 2, time.Now())
+		assert.NoError(reg.cleanup())
+
+		_, err := reg.get(gatewayID)
+		assert.NoError(err)
+	})
+
+	t.Run("PULL_DATA keeps the last PUSH_DATA", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(reg.set(gatewayID, gateway{lastSeen: time.Now().Add(-2 * time.Second)}))
+		assert.NoError(reg.cleanup())
+
+		_, err := reg.get(gatewayID)
+		assert.NoError(err)
+	})
+
+	t.Run("Inactive gateway is removed", func(t *testing.T) {
+		assert := require.New(t)
+
+		reg.pushData(gatewayID, 2, time.Now().Add(-2*time.Second))
+		assert.NoError(reg.cleanup())
+
+		_, err := reg.get(gatewayID)
+		assert.Equal(errGatewayDoesNotExist, err)
+		assert.Equal(events.Subscribe{Subscribe: false, GatewayID: gatewayID}, subscribeEvents[len(subscribeEvents)-1])
+	})
+
+	t.Run("PUSH_DATA of unknown gateway registers the gateway", func(t *testing.T) {
+		assert := require.New(t)
+
+		reg.pushData(gatewayID, 2, time.Now())
+		gw, err := reg.get(gatewayID)
+		assert.NoError(err)
+		assert.Nil(gw.addr)
+		assert.NotNil(gw.stats)
+		assert.Equal(uint8(2), gw.protocolVersion)
+		assert.Equal(events.Subscribe{Subscribe: true, GatewayID: gatewayID}, subscribeEvents[len(subscribeEvents)-1])
+	})
+}
@@ -0,0 +1,67 @@
+package semtechudp
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/lorawan"
+)
+
+// statsCheckInterval defines the interval at which the gateways are checked
+// for stats that must be published.
+var statsCheckInterval = time.Second
+
+// statsLoop periodically publishes the collected stats of the gateways that
+// did not send their own stats within the given interval.
+func (b *Backend) statsLoop(interval time.Duration) {
+	for !b.isClosed() {
+		b.publishIntervalStats(interval)
+		time.Sleep(statsCheckInterval)
+	}
+}
+
+// publishIntervalStats publishes the collected stats of the gateways of
+// which the stats were last published more than the given interval ago.
+// The stats sent by the packet-forwarder also export the collected stats,
+// this way the same uplinks and downlinks are never counted twice.
+func (b *Backend) publishIntervalStats(interval time.Duration) {
+	var statsList []gw.GatewayStats
+
+	b.gateways.RLock()
+	for gatewayID, gateway := range b.gateways.gateways {
+		if time.Since(gateway.stats.LastExport()) < interval {
+			continue
+		}
+
+		id, err := uuid.NewV4()
+		if err != nil {
+			log.WithError(err).Error("backend/semtechudp: new uuid error")
+			continue
+		}
+
+		gatewayID := gatewayID
+		stats := gateway.stats.ExportStats()
+		stats.GatewayId = gatewayID[:]
+		stats.Time = ptypes.TimestampNow()
+		stats.StatsId = id[:]
+		statsList = append(statsList, stats)
+	}
+	b.gateways.RUnlock()
+
+	for _, stats := range statsList {
+		var gatewayID lorawan.EUI64
+		copy(gatewayID[:], stats.GatewayId)
+
+		log.WithFields(log.Fields{
+			"gateway_id": gatewayID,
+		}).Debug("backend/semtechudp: publishing interval stats")
+
+		if b.gatewayStatsFunc != nil {
+			b.gatewayStatsFunc(stats)
+		}
+	}
+}
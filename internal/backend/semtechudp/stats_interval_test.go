@@ -0,0 +1,63 @@
+package semtechudp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/backend/stats"
+	"github.com/brocaar/lorawan"
+)
+
+func TestPublishIntervalStats(t *testing.T) {
+	assert := require.New(t)
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	collector := stats.NewCollector()
+
+	var published []gw.GatewayStats
+	b := Backend{
+		gateways: gateways{
+			gateways: map[lorawan.EUI64]gateway{
+				gatewayID: {stats: collector},
+			},
+		},
+		gatewayStatsFunc: func(pl gw.GatewayStats) {
+			published = append(published, pl)
+		},
+	}
+
+	collector.CountUplink(&gw.UplinkFrame{
+		TxInfo: &gw.UplinkTXInfo{Frequency: 868100000},
+		RxInfo: &gw.UplinkRXInfo{CrcStatus: gw.CRCStatus_CRC_OK},
+	})
+
+	t.Run("Stats published within interval", func(t *testing.T) {
+		b.publishIntervalStats(time.Hour)
+		assert.Len(published, 0)
+	})
+
+	t.Run("Interval expired", func(t *testing.T) {
+		time.Sleep(10 * time.Millisecond)
+		b.publishIntervalStats(5 * time.Millisecond)
+		assert.Len(published, 1)
+		assert.Equal(gatewayID[:], published[0].GatewayId)
+		assert.Equal(uint32(1), published[0].RxPacketsReceived)
+		assert.Equal(uint32(1), published[0].RxPacketsReceivedOk)
+		assert.Equal(map[uint32]uint32{868100000: 1}, published[0].RxPacketsPerFrequency)
+		assert.NotNil(published[0].Time)
+		assert.Len(published[0].StatsId, 16)
+	})
+
+	t.Run("Counters are reset after publishing", func(t *testing.T) {
+		b.publishIntervalStats(time.Hour)
+		assert.Len(published, 1)
+
+		time.Sleep(10 * time.Millisecond)
+		b.publishIntervalStats(5 * time.Millisecond)
+		assert.Len(published, 2)
+		assert.Equal(uint32(0), published[1].RxPacketsReceived)
+	})
+}
@@ -0,0 +1,132 @@
+package semtechudp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/lorawan"
+)
+
+// startTXAckTimer starts the timer for the TX_ACK of the given downlink frame
+// item. This is a no-op when the tx ack timeout is disabled.
+func (b *Backend) startTXAckTimer(token uint16, index int) {
+	if b.txAckTimeout == 0 {
+		return
+	}
+
+	b.txAckTimersMux.Lock()
+	defer b.txAckTimersMux.Unlock()
+
+	if t, ok := b.txAckTimers[token]; ok {
+		t.Stop()
+	}
+
+	var t *time.Timer
+	t = time.AfterFunc(b.txAckTimeout, func() {
+		b.txAckTimersMux.Lock()
+		if b.txAckTimers[token] != t {
+			// the TX_ACK was received or the timer has been replaced
+			b.txAckTimersMux.Unlock()
+			return
+		}
+		delete(b.txAckTimers, token)
+		b.txAckTimersMux.Unlock()
+
+		b.handleTXAckTimeout(token, index)
+	})
+	b.txAckTimers[token] = t
+}
+
+// stopTXAckTimer stops the timer for the TX_ACK of the given token. It
+// returns false when the tx ack timeout is enabled, but no timer is pending,
+// meaning that the TX_ACK was received after the timeout expired.
+func (b *Backend) stopTXAckTimer(token uint16) bool {
+	if b.txAckTimeout == 0 {
+		return true
+	}
+
+	b.txAckTimersMux.Lock()
+	defer b.txAckTimersMux.Unlock()
+
+	t, ok := b.txAckTimers[token]
+	if !ok {
+		return false
+	}
+
+	t.Stop()
+	delete(b.txAckTimers, token)
+	return true
+}
+
+// handleTXAckTimeout handles the expiration of the TX_ACK timeout of the
+// given downlink frame item. The downlink frame item is retransmitted once
+// when configured, else a failed tx acknowledgement is reported.
+func (b *Backend) handleTXAckTimeout(token uint16, index int) {
+	if b.isClosed() {
+		return
+	}
+
+	v, ok := b.cache.Get(fmt.Sprintf("%d:frame", token))
+	if !ok {
+		return
+	}
+	frame, ok := v.(gw.DownlinkFrame)
+	if !ok {
+		return
+	}
+
+	v, ok = b.cache.Get(fmt.Sprintf("%d:ack", token))
+	if !ok {
+		return
+	}
+	txAckItems, ok := v.([]*gw.DownlinkTXAckItem)
+	if !ok || index > len(txAckItems)-1 {
+		return
+	}
+
+	var gatewayID lorawan.EUI64
+	copy(gatewayID[:], frame.GetGatewayId())
+
+	retransmitKey := fmt.Sprintf("%d:%d:retransmit", token, index)
+	if _, retransmitted := b.cache.Get(retransmitKey); b.txAckRetransmit && !retransmitted {
+		log.WithFields(log.Fields{
+			"gateway_id": gatewayID,
+			"token":      token,
+			"timeout":    b.txAckTimeout,
+		}).Warning("backend/semtechudp: no tx ack received, retransmitting downlink")
+
+		b.cache.Set(retransmitKey, true, cache.DefaultExpiration)
+		err := b.sendDownlinkFrame(frame, index, txAckItems)
+		if err == nil {
+			return
+		}
+		log.WithError(err).WithField("gateway_id", gatewayID).Error("backend/semtechudp: retransmit downlink error")
+	} else {
+		log.WithFields(log.Fields{
+			"gateway_id": gatewayID,
+			"token":      token,
+			"timeout":    b.txAckTimeout,
+		}).Warning("backend/semtechudp: no tx ack received, reporting failed downlink")
+	}
+
+	// a late TX_ACK must not result in a second tx acknowledgement
+	b.cache.Delete(fmt.Sprintf("%d:frame", token))
+	b.cache.Delete(retransmitKey)
+
+	txAckItems[index] = &gw.DownlinkTXAckItem{
+		Status: gw.TxAckStatus_INTERNAL_ERROR,
+	}
+
+	if b.downlinkTxAckFunc != nil {
+		b.downlinkTxAckFunc(gw.DownlinkTXAck{
+			GatewayId:  frame.GatewayId,
+			Token:      frame.Token,
+			DownlinkId: frame.DownlinkId,
+			Items:      txAckItems,
+		})
+	}
+}
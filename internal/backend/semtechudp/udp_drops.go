@@ -0,0 +1,114 @@
+package semtechudp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// procNetUDPFiles contains the files exposing the UDP socket statistics
+// on Linux.
+var procNetUDPFiles = []string{"/proc/net/udp", "/proc/net/udp6"}
+
+// udpDropsLoop periodically updates the UDP dropped datagrams gauge, using
+// the given interval.
+func (b *Backend) udpDropsLoop(interval time.Duration) {
+	for !b.isClosed() {
+		if err := b.updateUDPDrops(); err != nil {
+			log.WithError(err).Error("backend/semtechudp: update udp drops error")
+			return
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// updateUDPDrops reads the number of dropped datagrams of the UDP socket
+// and updates the gauge. A warning is logged when the number has increased.
+func (b *Backend) updateUDPDrops() error {
+	drops, err := b.udpDropsFunc()
+	if err != nil {
+		return errors.Wrap(err, "get udp drops error")
+	}
+
+	if drops > b.udpDrops {
+		log.WithFields(log.Fields{
+			"dropped":       drops - b.udpDrops,
+			"total_dropped": drops,
+		}).Warning("backend/semtechudp: udp datagrams dropped by kernel, consider increasing the socket receive buffer")
+	}
+
+	b.udpDrops = drops
+	udpDropsGauge().Set(float64(drops))
+
+	return nil
+}
+
+// getUDPDrops returns the number of dropped datagrams of the UDP socket,
+// as reported by /proc/net/udp(6).
+func (b *Backend) getUDPDrops() (uint64, error) {
+	port := b.conn.LocalAddr().(*net.UDPAddr).Port
+
+	for _, name := range procNetUDPFiles {
+		f, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+
+		drops, found, err := parseProcNetUDPDrops(f, port)
+		f.Close()
+		if err != nil {
+			return 0, errors.Wrapf(err, "parse %s error", name)
+		}
+
+		if found {
+			return drops, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no udp socket statistics found for port %d", port)
+}
+
+// parseProcNetUDPDrops returns the drops column of the socket bound to the
+// given local port.
+func parseProcNetUDPDrops(r io.Reader, port int) (uint64, bool, error) {
+	scanner := bufio.NewScanner(r)
+
+	// skip the header
+	scanner.Scan()
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 13 {
+			continue
+		}
+
+		// local_address is formatted as IP:PORT (hex)
+		addr := strings.Split(fields[1], ":")
+		if len(addr) != 2 {
+			continue
+		}
+
+		p, err := strconv.ParseUint(addr[1], 16, 16)
+		if err != nil || int(p) != port {
+			continue
+		}
+
+		drops, err := strconv.ParseUint(fields[len(fields)-1], 10, 64)
+		if err != nil {
+			return 0, false, errors.Wrap(err, "parse drops error")
+		}
+
+		return drops, true, nil
+	}
+
+	return 0, false, scanner.Err()
+}
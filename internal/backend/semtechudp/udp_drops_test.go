@@ -0,0 +1,78 @@
+package semtechudp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProcNetUDPDrops(t *testing.T) {
+	procNetUDP := `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  123: 00000000:0044 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 15345 2 0000000000000000 0
+  456: 0100007F:06A4 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 15346 2 0000000000000000 42
+`
+
+	tests := []struct {
+		Name          string
+		Port          int
+		ExpectedDrops uint64
+		ExpectedFound bool
+	}{
+		{
+			Name:          "port 1700",
+			Port:          1700,
+			ExpectedDrops: 42,
+			ExpectedFound: true,
+		},
+		{
+			Name:          "port 68",
+			Port:          68,
+			ExpectedDrops: 0,
+			ExpectedFound: true,
+		},
+		{
+			Name:          "unknown port",
+			Port:          1701,
+			ExpectedFound: false,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			drops, found, err := parseProcNetUDPDrops(strings.NewReader(procNetUDP), tst.Port)
+			assert.NoError(err)
+			assert.Equal(tst.ExpectedFound, found)
+			assert.Equal(tst.ExpectedDrops, drops)
+		})
+	}
+}
+
+func TestUpdateUDPDrops(t *testing.T) {
+	assert := require.New(t)
+
+	var drops uint64
+	var dropsErr error
+	b := Backend{
+		udpDropsFunc: func() (uint64, error) {
+			return drops, dropsErr
+		},
+	}
+
+	drops = 10
+	assert.NoError(b.updateUDPDrops())
+	assert.Equal(float64(10), testutil.ToFloat64(udpDropsGauge()))
+
+	drops = 15
+	assert.NoError(b.updateUDPDrops())
+	assert.Equal(float64(15), testutil.ToFloat64(udpDropsGauge()))
+	assert.Equal(uint64(15), b.udpDrops)
+
+	dropsErr = errors.New("boom")
+	assert.Error(b.updateUDPDrops())
+	assert.Equal(float64(15), testutil.ToFloat64(udpDropsGauge()))
+}
@@ -3,6 +3,7 @@ package stats
 import (
 	"encoding/hex"
 	"sync"
+	"time"
 
 	"github.com/brocaar/chirpstack-api/go/v3/gw"
 	"github.com/golang/protobuf/proto"
@@ -11,8 +12,9 @@ import (
 type Collector struct {
 	sync.Mutex
 
-	rxCount uint32
-	txCount uint32
+	rxCount   uint32
+	rxOkCount uint32
+	txCount   uint32
 
 	rxPerFreqCount map[uint32]uint32
 	txPerFreqCount map[uint32]uint32
@@ -21,6 +23,8 @@ type Collector struct {
 	txPerModulationCount map[string]uint32
 
 	txStatusCount map[string]uint32
+
+	exported time.Time
 }
 
 func NewCollector() *Collector {
@@ -59,6 +63,14 @@ func (c *Collector) CountUplink(uf *gw.UplinkFrame) {
 	modStr := hex.EncodeToString(b)
 
 	c.rxCount = c.rxCount + 1
+
+	// frames with a bad CRC are only counted as received, they might still be
+	// forwarded when the CRC check is disabled.
+	if uf.GetRxInfo().GetCrcStatus() == gw.CRCStatus_BAD_CRC {
+		return
+	}
+
+	c.rxOkCount = c.rxOkCount + 1
 	c.rxPerFreqCount[uf.GetTxInfo().Frequency] = c.rxPerFreqCount[uf.GetTxInfo().Frequency] + 1
 	c.rxPerModulationCount[modStr] = c.rxPerModulationCount[modStr] + 1
 }
@@ -109,7 +121,7 @@ func (c *Collector) ExportStats() gw.GatewayStats {
 
 	stats := gw.GatewayStats{
 		RxPacketsReceived:      c.rxCount,
-		RxPacketsReceivedOk:    c.rxCount,
+		RxPacketsReceivedOk:    c.rxOkCount,
 		TxPacketsReceived:      c.txCount,
 		TxPacketsEmitted:       c.txCount,
 		RxPacketsPerFrequency:  make(map[uint32]uint32),
@@ -157,9 +169,19 @@ func (c *Collector) ExportStats() gw.GatewayStats {
 	return stats
 }
 
+// LastExport returns the time of the last export (or the time the collector
+// was created, when the stats were never exported).
+func (c *Collector) LastExport() time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.exported
+}
+
 func (c *Collector) reset() {
+	c.exported = time.Now()
 	c.rxCount = 0
-	c.rxCount = 0
+	c.rxOkCount = 0
 	c.txCount = 0
 	c.rxPerFreqCount = make(map[uint32]uint32)
 	c.txPerFreqCount = make(map[uint32]uint32)
@@ -283,3 +283,24 @@ func TestStats(t *testing.T) {
 		})
 	})
 }
+
+func TestStatsCRCStatus(t *testing.T) {
+	assert := require.New(t)
+
+	c := NewCollector()
+	for _, crcStatus := range []gw.CRCStatus{gw.CRCStatus_CRC_OK, gw.CRCStatus_BAD_CRC, gw.CRCStatus_CRC_OK} {
+		c.CountUplink(&gw.UplinkFrame{
+			TxInfo: &gw.UplinkTXInfo{
+				Frequency: 868100000,
+			},
+			RxInfo: &gw.UplinkRXInfo{
+				CrcStatus: crcStatus,
+			},
+		})
+	}
+	stats := c.ExportStats()
+
+	assert.Equal(uint32(3), stats.RxPacketsReceived)
+	assert.Equal(uint32(2), stats.RxPacketsReceivedOk)
+	assert.Equal(map[uint32]uint32{868100000: 2}, stats.RxPacketsPerFrequency)
+}
@@ -0,0 +1,107 @@
+package capabilities
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/lorawan"
+)
+
+// downlinkItemsTimeout defines the duration after which the original items
+// of a downlink of which unsupported items have been removed, are no longer
+// kept.
+const downlinkItemsTimeout = time.Minute
+
+// downlinkItems holds the original items of a downlink frame of which the
+// unsupported items have been removed.
+type downlinkItems struct {
+	// original item index for each item that has been sent
+	indices []int
+	// tx acknowledgement items for the original items
+	items   []*gw.DownlinkTXAckItem
+	expires time.Time
+}
+
+// removedItems holds the downlinks of which items have been removed, by
+// gateway ID and downlink key.
+var removedItems = make(map[string]downlinkItems)
+
+// storeItems stores the original items of the given downlink frame, so that
+// its tx acknowledgement can be restored. It returns false when the downlink
+// frame can not be identified (no downlink ID and token), in which case the
+// tx acknowledgement can not be restored.
+func storeItems(pl *gw.DownlinkFrame, indices []int, items []*gw.DownlinkTXAckItem) bool {
+	key, ok := getKey(pl.GetGatewayId(), pl.GetDownlinkId(), pl.GetToken())
+	if !ok {
+		return false
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	now := time.Now()
+	for k, v := range removedItems {
+		if now.After(v.expires) {
+			delete(removedItems, k)
+		}
+	}
+
+	removedItems[key] = downlinkItems{
+		indices: indices,
+		items:   items,
+		expires: now.Add(downlinkItemsTimeout),
+	}
+
+	return true
+}
+
+// DownlinkTXAck restores the items of the given tx acknowledgement to the
+// original items of the downlink frame, in case unsupported items were
+// removed by DownlinkFrame. The removed items are set to the status with
+// which they were rejected.
+func DownlinkTXAck(pl *gw.DownlinkTXAck) {
+	key, ok := getKey(pl.GetGatewayId(), pl.GetDownlinkId(), pl.GetToken())
+	if !ok {
+		return
+	}
+
+	mux.Lock()
+	di, ok := removedItems[key]
+	delete(removedItems, key)
+	mux.Unlock()
+
+	if !ok {
+		return
+	}
+
+	items := make([]*gw.DownlinkTXAckItem, len(di.items))
+	for i := range di.items {
+		items[i] = &gw.DownlinkTXAckItem{
+			Status: di.items[i].Status,
+		}
+	}
+	for i, item := range pl.Items {
+		if i < len(di.indices) {
+			items[di.indices[i]] = item
+		}
+	}
+	pl.Items = items
+}
+
+// getKey returns the key identifying the downlink of the given gateway. The
+// downlink ID is used when set, as the token is replaced by the backend when
+// it is 0. It returns false when the downlink can not be identified.
+func getKey(gatewayID, downlinkID []byte, token uint32) (string, bool) {
+	var id lorawan.EUI64
+	copy(id[:], gatewayID)
+
+	if len(downlinkID) != 0 {
+		return fmt.Sprintf("%s:%s", id, hex.EncodeToString(downlinkID)), true
+	}
+	if token != 0 {
+		return fmt.Sprintf("%s:token:%d", id, token), true
+	}
+	return "", false
+}
@@ -0,0 +1,222 @@
+// Package capabilities implements the validation of downlinks against the
+// capability profile (e.g. supported modulations, frequencies and tx power)
+// of the gateway.
+package capabilities
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-api/go/v3/common"
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+// profile holds the capabilities of a gateway. Empty sets and zero values
+// are not validated.
+type profile struct {
+	modulations      map[common.Modulation]struct{}
+	spreadingFactors map[uint32]struct{}
+	timings          map[gw.DownlinkTiming]struct{}
+	minFrequency     uint32
+	maxFrequency     uint32
+	maxPower         int32
+	transformTiming  bool
+}
+
+var (
+	mux      sync.RWMutex
+	profiles map[lorawan.EUI64]profile
+)
+
+// Setup configures the capabilities package.
+func Setup(conf config.Config) error {
+	p := make(map[lorawan.EUI64]profile)
+
+	for _, c := range conf.Capabilities.Gateways {
+		var gatewayID lorawan.EUI64
+		if err := gatewayID.UnmarshalText([]byte(c.GatewayID)); err != nil {
+			return errors.Wrap(err, "unmarshal gateway id error")
+		}
+
+		pr := profile{
+			modulations:      make(map[common.Modulation]struct{}),
+			spreadingFactors: make(map[uint32]struct{}),
+			timings:          make(map[gw.DownlinkTiming]struct{}),
+			minFrequency:     c.MinFrequency,
+			maxFrequency:     c.MaxFrequency,
+			maxPower:         c.MaxPower,
+			transformTiming:  c.TransformTiming,
+		}
+
+		for _, m := range c.Modulations {
+			v, ok := common.Modulation_value[m]
+			if !ok {
+				return fmt.Errorf("unknown modulation: %s", m)
+			}
+			pr.modulations[common.Modulation(v)] = struct{}{}
+		}
+
+		for _, sf := range c.SpreadingFactors {
+			pr.spreadingFactors[sf] = struct{}{}
+		}
+
+		for _, t := range c.Timings {
+			v, ok := gw.DownlinkTiming_value[t]
+			if !ok {
+				return fmt.Errorf("unknown downlink timing: %s", t)
+			}
+			pr.timings[gw.DownlinkTiming(v)] = struct{}{}
+		}
+
+		p[gatewayID] = pr
+
+		log.WithFields(log.Fields{
+			"gateway_id": gatewayID,
+		}).Info("capabilities: gateway capability profile configured")
+	}
+
+	mux.Lock()
+	profiles = p
+	references = make(map[lorawan.EUI64]timingReference)
+	removedItems = make(map[string]downlinkItems)
+	mux.Unlock()
+
+	return nil
+}
+
+// DownlinkFrame validates the items of the given downlink frame against the
+// capability profile of the gateway. Items requesting an unsupported feature
+// are removed from the downlink frame, the tx acknowledgement is restored to
+// the original items by DownlinkTXAck. When none of the items is supported,
+// it returns false and the tx acknowledgement with which the downlink frame
+// must be rejected. When the profile has timing transform enabled, the timing
+// of the items is converted (see UplinkFrame).
+func DownlinkFrame(pl *gw.DownlinkFrame) (gw.DownlinkTXAck, bool) {
+	var gatewayID lorawan.EUI64
+	copy(gatewayID[:], pl.GetGatewayId())
+
+	mux.RLock()
+	pr, ok := profiles[gatewayID]
+	mux.RUnlock()
+
+	if !ok {
+		return gw.DownlinkTXAck{}, true
+	}
+
+	var errs []string
+	var supported []int
+	items := make([]*gw.DownlinkTXAckItem, len(pl.Items))
+	for i, item := range pl.Items {
+		items[i] = &gw.DownlinkTXAckItem{
+			Status: gw.TxAckStatus_IGNORED,
+		}
+
+		if pr.transformTiming {
+			txInfo, err := pr.convertTiming(gatewayID, item.GetTxInfo())
+			if err != nil {
+				downlinkRejectedCounter(timingStatus(item.GetTxInfo()).String()).Inc()
+				items[i].Status = timingStatus(item.GetTxInfo())
+				errs = append(errs, fmt.Sprintf("item %d: %s", i, err))
+				continue
+			}
+
+			// the items can be shared with other downlink frames (e.g.
+			// virtual gateways)
+			if txInfo != item.GetTxInfo() {
+				pl.Items = append([]*gw.DownlinkFrameItem(nil), pl.Items...)
+				pl.Items[i] = &gw.DownlinkFrameItem{
+					PhyPayload: item.PhyPayload,
+					TxInfo:     txInfo,
+				}
+				item = pl.Items[i]
+			}
+		}
+
+		status, reason := pr.validate(item.GetTxInfo())
+		if reason == "" {
+			supported = append(supported, i)
+			continue
+		}
+
+		downlinkRejectedCounter(status.String()).Inc()
+		items[i].Status = status
+		errs = append(errs, fmt.Sprintf("item %d: %s", i, reason))
+	}
+
+	if len(errs) == 0 {
+		return gw.DownlinkTXAck{}, true
+	}
+
+	errStr := fmt.Sprintf("unsupported by gateway capabilities: %s", strings.Join(errs, ", "))
+
+	if len(supported) != 0 && storeItems(pl, supported, items) {
+		log.WithFields(log.Fields{
+			"gateway_id": gatewayID,
+			"error":      errStr,
+		}).Warning("capabilities: unsupported downlink items removed")
+
+		kept := make([]*gw.DownlinkFrameItem, 0, len(supported))
+		for _, i := range supported {
+			kept = append(kept, pl.Items[i])
+		}
+		pl.Items = kept
+
+		return gw.DownlinkTXAck{}, true
+	}
+
+	return gw.DownlinkTXAck{
+		GatewayId:  pl.GatewayId,
+		Token:      pl.Token,
+		DownlinkId: pl.DownlinkId,
+		Error:      errStr,
+		Items:      items,
+	}, false
+}
+
+// validate validates the given tx-info against the profile. It returns the
+// tx acknowledgement status and the reason in case of a mismatch, or an
+// empty reason when the tx-info is supported.
+func (p profile) validate(txInfo *gw.DownlinkTXInfo) (gw.TxAckStatus, string) {
+	if len(p.modulations) != 0 {
+		if _, ok := p.modulations[txInfo.GetModulation()]; !ok {
+			return gw.TxAckStatus_INTERNAL_ERROR, fmt.Sprintf("modulation %s is not supported", txInfo.GetModulation())
+		}
+	}
+
+	if modInfo := txInfo.GetLoraModulationInfo(); modInfo != nil && len(p.spreadingFactors) != 0 {
+		if _, ok := p.spreadingFactors[modInfo.GetSpreadingFactor()]; !ok {
+			return gw.TxAckStatus_INTERNAL_ERROR, fmt.Sprintf("spreading-factor %d is not supported", modInfo.GetSpreadingFactor())
+		}
+	}
+
+	if len(p.timings) != 0 {
+		if _, ok := p.timings[txInfo.GetTiming()]; !ok {
+			return timingStatus(txInfo), fmt.Sprintf("timing %s is not supported", txInfo.GetTiming())
+		}
+	}
+
+	if (p.minFrequency != 0 && txInfo.GetFrequency() < p.minFrequency) || (p.maxFrequency != 0 && txInfo.GetFrequency() > p.maxFrequency) {
+		return gw.TxAckStatus_TX_FREQ, fmt.Sprintf("frequency %d is not within the supported range %d - %d", txInfo.GetFrequency(), p.minFrequency, p.maxFrequency)
+	}
+
+	if p.maxPower != 0 && txInfo.GetPower() > p.maxPower {
+		return gw.TxAckStatus_TX_POWER, fmt.Sprintf("tx power %d exceeds the max. tx power %d", txInfo.GetPower(), p.maxPower)
+	}
+
+	return gw.TxAckStatus_OK, ""
+}
+
+// timingStatus returns the tx acknowledgement status for an unsupported
+// timing of the given tx-info.
+func timingStatus(txInfo *gw.DownlinkTXInfo) gw.TxAckStatus {
+	if txInfo.GetTiming() == gw.DownlinkTiming_GPS_EPOCH {
+		return gw.TxAckStatus_GPS_UNLOCKED
+	}
+	return gw.TxAckStatus_INTERNAL_ERROR
+}
@@ -0,0 +1,458 @@
+package capabilities
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-api/go/v3/common"
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/backend/semtechudp/packets"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+)
+
+func TestDownlinkFrame(t *testing.T) {
+	var conf config.Config
+	conf.Capabilities.Gateways = []config.GatewayCapabilities{
+		{
+			GatewayID:        "0102030405060708",
+			Modulations:      []string{"LORA"},
+			SpreadingFactors: []uint32{7, 8, 9, 10, 11, 12},
+			Timings:          []string{"IMMEDIATELY", "DELAY"},
+			MinFrequency:     863000000,
+			MaxFrequency:     870000000,
+			MaxPower:         14,
+		},
+	}
+	require.NoError(t, Setup(conf))
+
+	txInfo := func(f func(*gw.DownlinkTXInfo)) *gw.DownlinkTXInfo {
+		txInfo := gw.DownlinkTXInfo{
+			Frequency:  868100000,
+			Power:      14,
+			Modulation: common.Modulation_LORA,
+			ModulationInfo: &gw.DownlinkTXInfo_LoraModulationInfo{
+				LoraModulationInfo: &gw.LoRaModulationInfo{
+					SpreadingFactor: 12,
+					Bandwidth:       125,
+				},
+			},
+			Timing: gw.DownlinkTiming_DELAY,
+		}
+		if f != nil {
+			f(&txInfo)
+		}
+		return &txInfo
+	}
+
+	tests := []struct {
+		Name           string
+		GatewayID      []byte
+		TXInfo         *gw.DownlinkTXInfo
+		ExpectedOK     bool
+		ExpectedStatus gw.TxAckStatus
+		ExpectedError  string
+	}{
+		{
+			Name:       "supported",
+			GatewayID:  []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			TXInfo:     txInfo(nil),
+			ExpectedOK: true,
+		},
+		{
+			Name:      "gateway without profile",
+			GatewayID: []byte{8, 7, 6, 5, 4, 3, 2, 1},
+			TXInfo: txInfo(func(txInfo *gw.DownlinkTXInfo) {
+				txInfo.Frequency = 915000000
+			}),
+			ExpectedOK: true,
+		},
+		{
+			Name:      "unsupported frequency",
+			GatewayID: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			TXInfo: txInfo(func(txInfo *gw.DownlinkTXInfo) {
+				txInfo.Frequency = 915000000
+			}),
+			ExpectedStatus: gw.TxAckStatus_TX_FREQ,
+			ExpectedError:  "unsupported by gateway capabilities: item 0: frequency 915000000 is not within the supported range 863000000 - 870000000",
+		},
+		{
+			Name:      "unsupported tx power",
+			GatewayID: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			TXInfo: txInfo(func(txInfo *gw.DownlinkTXInfo) {
+				txInfo.Power = 27
+			}),
+			ExpectedStatus: gw.TxAckStatus_TX_POWER,
+			ExpectedError:  "unsupported by gateway capabilities: item 0: tx power 27 exceeds the max. tx power 14",
+		},
+		{
+			Name:      "unsupported modulation",
+			GatewayID: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			TXInfo: txInfo(func(txInfo *gw.DownlinkTXInfo) {
+				txInfo.Modulation = common.Modulation_FSK
+				txInfo.ModulationInfo = &gw.DownlinkTXInfo_FskModulationInfo{
+					FskModulationInfo: &gw.FSKModulationInfo{
+						Datarate: 50000,
+					},
+				}
+			}),
+			ExpectedStatus: gw.TxAckStatus_INTERNAL_ERROR,
+			ExpectedError:  "unsupported by gateway capabilities: item 0: modulation FSK is not supported",
+		},
+		{
+			Name:      "unsupported spreading-factor",
+			GatewayID: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			TXInfo: txInfo(func(txInfo *gw.DownlinkTXInfo) {
+				txInfo.GetLoraModulationInfo().SpreadingFactor = 5
+			}),
+			ExpectedStatus: gw.TxAckStatus_INTERNAL_ERROR,
+			ExpectedError:  "unsupported by gateway capabilities: item 0: spreading-factor 5 is not supported",
+		},
+		{
+			Name:      "unsupported gps timing",
+			GatewayID: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			TXInfo: txInfo(func(txInfo *gw.DownlinkTXInfo) {
+				txInfo.Timing = gw.DownlinkTiming_GPS_EPOCH
+			}),
+			ExpectedStatus: gw.TxAckStatus_GPS_UNLOCKED,
+			ExpectedError:  "unsupported by gateway capabilities: item 0: timing GPS_EPOCH is not supported",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			pl := gw.DownlinkFrame{
+				GatewayId:  tst.GatewayID,
+				DownlinkId: []byte{1, 2, 3, 4},
+				Token:      123,
+				Items: []*gw.DownlinkFrameItem{
+					{
+						PhyPayload: []byte{1, 2, 3},
+						TxInfo:     tst.TXInfo,
+					},
+				},
+			}
+
+			ack, ok := DownlinkFrame(&pl)
+			assert.Equal(tst.ExpectedOK, ok)
+			if tst.ExpectedOK {
+				return
+			}
+
+			assert.Equal(pl.GatewayId, ack.GatewayId)
+			assert.Equal(pl.DownlinkId, ack.DownlinkId)
+			assert.Equal(pl.Token, ack.Token)
+			assert.Equal(tst.ExpectedError, ack.Error)
+			assert.Len(ack.Items, 1)
+			assert.Equal(tst.ExpectedStatus, ack.Items[0].Status)
+		})
+	}
+
+	t.Run("Unsupported second item", func(t *testing.T) {
+		assert := require.New(t)
+
+		pl := gw.DownlinkFrame{
+			GatewayId:  []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			DownlinkId: []byte{1, 2, 3, 4},
+			Items: []*gw.DownlinkFrameItem{
+				{TxInfo: txInfo(nil)},
+				{TxInfo: txInfo(func(txInfo *gw.DownlinkTXInfo) {
+					txInfo.Frequency = 923300000
+				})},
+			},
+		}
+		first := pl.Items[0]
+
+		_, ok := DownlinkFrame(&pl)
+		assert.True(ok)
+		assert.Equal([]*gw.DownlinkFrameItem{first}, pl.Items)
+
+		ack := gw.DownlinkTXAck{
+			GatewayId:  pl.GatewayId,
+			Token:      1234,
+			DownlinkId: pl.DownlinkId,
+			Items: []*gw.DownlinkTXAckItem{
+				{Status: gw.TxAckStatus_OK},
+			},
+		}
+		DownlinkTXAck(&ack)
+		assert.Equal([]*gw.DownlinkTXAckItem{
+			{Status: gw.TxAckStatus_OK},
+			{Status: gw.TxAckStatus_TX_FREQ},
+		}, ack.Items)
+	})
+
+	t.Run("Unsupported first item", func(t *testing.T) {
+		assert := require.New(t)
+
+		pl := gw.DownlinkFrame{
+			GatewayId:  []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			DownlinkId: []byte{2, 2, 3, 4},
+			Items: []*gw.DownlinkFrameItem{
+				{TxInfo: txInfo(func(txInfo *gw.DownlinkTXInfo) {
+					txInfo.Power = 27
+				})},
+				{TxInfo: txInfo(nil)},
+			},
+		}
+		second := pl.Items[1]
+
+		_, ok := DownlinkFrame(&pl)
+		assert.True(ok)
+		assert.Equal([]*gw.DownlinkFrameItem{second}, pl.Items)
+
+		ack := gw.DownlinkTXAck{
+			GatewayId:  pl.GatewayId,
+			DownlinkId: pl.DownlinkId,
+			Items: []*gw.DownlinkTXAckItem{
+				{Status: gw.TxAckStatus_TOO_LATE},
+			},
+		}
+		DownlinkTXAck(&ack)
+		assert.Equal([]*gw.DownlinkTXAckItem{
+			{Status: gw.TxAckStatus_TX_POWER},
+			{Status: gw.TxAckStatus_TOO_LATE},
+		}, ack.Items)
+
+		// the ack is restored once
+		ack.Items = ack.Items[1:]
+		DownlinkTXAck(&ack)
+		assert.Len(ack.Items, 1)
+	})
+
+	t.Run("All items unsupported", func(t *testing.T) {
+		assert := require.New(t)
+
+		pl := gw.DownlinkFrame{
+			GatewayId:  []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			DownlinkId: []byte{3, 2, 3, 4},
+			Items: []*gw.DownlinkFrameItem{
+				{TxInfo: txInfo(func(txInfo *gw.DownlinkTXInfo) {
+					txInfo.Power = 27
+				})},
+				{TxInfo: txInfo(func(txInfo *gw.DownlinkTXInfo) {
+					txInfo.Frequency = 923300000
+				})},
+			},
+		}
+
+		ack, ok := DownlinkFrame(&pl)
+		assert.False(ok)
+		assert.Len(pl.Items, 2)
+		assert.Equal(gw.TxAckStatus_TX_POWER, ack.Items[0].Status)
+		assert.Equal(gw.TxAckStatus_TX_FREQ, ack.Items[1].Status)
+		assert.Equal("unsupported by gateway capabilities: item 0: tx power 27 exceeds the max. tx power 14, item 1: frequency 923300000 is not within the supported range 863000000 - 870000000", ack.Error)
+	})
+
+	t.Run("Unidentifiable downlink", func(t *testing.T) {
+		assert := require.New(t)
+
+		pl := gw.DownlinkFrame{
+			GatewayId: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			Items: []*gw.DownlinkFrameItem{
+				{TxInfo: txInfo(nil)},
+				{TxInfo: txInfo(func(txInfo *gw.DownlinkTXInfo) {
+					txInfo.Frequency = 923300000
+				})},
+			},
+		}
+
+		// the ack can not be restored, the downlink is rejected
+		ack, ok := DownlinkFrame(&pl)
+		assert.False(ok)
+		assert.Equal(gw.TxAckStatus_IGNORED, ack.Items[0].Status)
+		assert.Equal(gw.TxAckStatus_TX_FREQ, ack.Items[1].Status)
+		assert.Contains(ack.Error, "item 1: frequency 923300000")
+	})
+
+	t.Run("Invalid config", func(t *testing.T) {
+		assert := require.New(t)
+
+		var conf config.Config
+		conf.Capabilities.Gateways = []config.GatewayCapabilities{
+			{GatewayID: "0102030405060708", Modulations: []string{"FOO"}},
+		}
+		assert.Error(Setup(conf))
+	})
+}
+
+func TestTimingTransform(t *testing.T) {
+	var conf config.Config
+	conf.Capabilities.Gateways = []config.GatewayCapabilities{
+		{
+			GatewayID:       "0102030405060708",
+			Timings:         []string{"IMMEDIATELY", "DELAY"},
+			TransformTiming: true,
+		},
+		{
+			GatewayID:       "0807060504030201",
+			Timings:         []string{"GPS_EPOCH"},
+			TransformTiming: true,
+		},
+		{
+			GatewayID: "0101010101010101",
+			Timings:   []string{"IMMEDIATELY", "DELAY"},
+		},
+	}
+	require.NoError(t, Setup(conf))
+
+	uplink := func(gatewayID []byte) gw.UplinkFrame {
+		return gw.UplinkFrame{
+			RxInfo: &gw.UplinkRXInfo{
+				GatewayId:         gatewayID,
+				Context:           []byte{1, 2, 3, 4},
+				TimeSinceGpsEpoch: ptypes.DurationProto(100 * time.Second),
+			},
+		}
+	}
+
+	gpsEpochFrame := func(gatewayID []byte) gw.DownlinkFrame {
+		return gw.DownlinkFrame{
+			GatewayId: gatewayID,
+			Items: []*gw.DownlinkFrameItem{
+				{
+					PhyPayload: []byte{1, 2, 3},
+					TxInfo: &gw.DownlinkTXInfo{
+						Frequency: 868100000,
+						Timing:    gw.DownlinkTiming_GPS_EPOCH,
+						TimingInfo: &gw.DownlinkTXInfo_GpsEpochTimingInfo{
+							GpsEpochTimingInfo: &gw.GPSEpochTimingInfo{
+								TimeSinceGpsEpoch: ptypes.DurationProto(101500 * time.Millisecond),
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("GPS epoch without uplink reference", func(t *testing.T) {
+		assert := require.New(t)
+
+		pl := gpsEpochFrame([]byte{1, 2, 3, 4, 5, 6, 7, 8})
+		ack, ok := DownlinkFrame(&pl)
+		assert.False(ok)
+		assert.Equal(gw.TxAckStatus_GPS_UNLOCKED, ack.Items[0].Status)
+		assert.Equal("unsupported by gateway capabilities: item 0: timing GPS_EPOCH can not be converted, no uplink reference", ack.Error)
+	})
+
+	t.Run("GPS epoch to delay", func(t *testing.T) {
+		assert := require.New(t)
+
+		UplinkFrame(uplink([]byte{1, 2, 3, 4, 5, 6, 7, 8}))
+
+		pl := gpsEpochFrame([]byte{1, 2, 3, 4, 5, 6, 7, 8})
+		original := pl.Items[0]
+
+		_, ok := DownlinkFrame(&pl)
+		assert.True(ok)
+
+		txInfo := pl.Items[0].GetTxInfo()
+		assert.Equal(gw.DownlinkTiming_DELAY, txInfo.Timing)
+		assert.Equal([]byte{1, 2, 3, 4}, txInfo.Context)
+		delay, err := ptypes.Duration(txInfo.GetDelayTimingInfo().GetDelay())
+		assert.NoError(err)
+		assert.Equal(1500*time.Millisecond, delay)
+		assert.Equal([]byte{1, 2, 3}, pl.Items[0].PhyPayload)
+
+		// the original item is not modified
+		assert.Equal(gw.DownlinkTiming_GPS_EPOCH, original.GetTxInfo().Timing)
+	})
+
+	t.Run("GPS epoch before uplink reference", func(t *testing.T) {
+		assert := require.New(t)
+
+		pl := gpsEpochFrame([]byte{1, 2, 3, 4, 5, 6, 7, 8})
+		pl.Items[0].TxInfo.GetGpsEpochTimingInfo().TimeSinceGpsEpoch = ptypes.DurationProto(99 * time.Second)
+
+		ack, ok := DownlinkFrame(&pl)
+		assert.False(ok)
+		assert.Equal(gw.TxAckStatus_GPS_UNLOCKED, ack.Items[0].Status)
+		assert.Contains(ack.Error, "gps time is out of range of the uplink reference")
+	})
+
+	t.Run("GPS epoch exceeds max. delay", func(t *testing.T) {
+		assert := require.New(t)
+
+		pl := gpsEpochFrame([]byte{1, 2, 3, 4, 5, 6, 7, 8})
+		pl.Items[0].TxInfo.GetGpsEpochTimingInfo().TimeSinceGpsEpoch = ptypes.DurationProto(100*time.Second + packets.MaxTmstDelay + time.Microsecond)
+
+		ack, ok := DownlinkFrame(&pl)
+		assert.False(ok)
+		assert.Contains(ack.Error, "gps time is out of range of the uplink reference")
+
+		pl = gpsEpochFrame([]byte{1, 2, 3, 4, 5, 6, 7, 8})
+		pl.Items[0].TxInfo.GetGpsEpochTimingInfo().TimeSinceGpsEpoch = ptypes.DurationProto(100*time.Second + packets.MaxTmstDelay)
+
+		_, ok = DownlinkFrame(&pl)
+		assert.True(ok)
+	})
+
+	t.Run("Delay to GPS epoch", func(t *testing.T) {
+		assert := require.New(t)
+
+		UplinkFrame(uplink([]byte{8, 7, 6, 5, 4, 3, 2, 1}))
+
+		pl := gw.DownlinkFrame{
+			GatewayId: []byte{8, 7, 6, 5, 4, 3, 2, 1},
+			Items: []*gw.DownlinkFrameItem{
+				{
+					TxInfo: &gw.DownlinkTXInfo{
+						Timing: gw.DownlinkTiming_DELAY,
+						TimingInfo: &gw.DownlinkTXInfo_DelayTimingInfo{
+							DelayTimingInfo: &gw.DelayTimingInfo{
+								Delay: ptypes.DurationProto(time.Second),
+							},
+						},
+						Context: []byte{1, 2, 3, 4},
+					},
+				},
+			},
+		}
+
+		_, ok := DownlinkFrame(&pl)
+		assert.True(ok)
+
+		txInfo := pl.Items[0].GetTxInfo()
+		assert.Equal(gw.DownlinkTiming_GPS_EPOCH, txInfo.Timing)
+		gpsTime, err := ptypes.Duration(txInfo.GetGpsEpochTimingInfo().GetTimeSinceGpsEpoch())
+		assert.NoError(err)
+		assert.Equal(101*time.Second, gpsTime)
+	})
+
+	t.Run("Immediately to GPS epoch", func(t *testing.T) {
+		assert := require.New(t)
+
+		pl := gw.DownlinkFrame{
+			GatewayId: []byte{8, 7, 6, 5, 4, 3, 2, 1},
+			Items: []*gw.DownlinkFrameItem{
+				{
+					TxInfo: &gw.DownlinkTXInfo{
+						Timing: gw.DownlinkTiming_IMMEDIATELY,
+					},
+				},
+			},
+		}
+
+		ack, ok := DownlinkFrame(&pl)
+		assert.False(ok)
+		assert.Equal(gw.TxAckStatus_INTERNAL_ERROR, ack.Items[0].Status)
+		assert.Equal("unsupported by gateway capabilities: item 0: timing IMMEDIATELY can not be converted", ack.Error)
+	})
+
+	t.Run("Gateway without timing transform", func(t *testing.T) {
+		assert := require.New(t)
+
+		UplinkFrame(uplink([]byte{1, 1, 1, 1, 1, 1, 1, 1}))
+
+		pl := gpsEpochFrame([]byte{1, 1, 1, 1, 1, 1, 1, 1})
+		ack, ok := DownlinkFrame(&pl)
+		assert.False(ok)
+		assert.Equal(gw.TxAckStatus_GPS_UNLOCKED, ack.Items[0].Status)
+		assert.Equal("unsupported by gateway capabilities: item 0: timing GPS_EPOCH is not supported", ack.Error)
+	})
+}
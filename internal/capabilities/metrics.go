@@ -0,0 +1,17 @@
+package capabilities
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	drc = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "capabilities_downlink_rejected_count",
+		Help: "The number of downlink items rejected because of a gateway capability mismatch (per tx ack status).",
+	}, []string{"status"})
+)
+
+func downlinkRejectedCounter(status string) prometheus.Counter {
+	return drc.With(prometheus.Labels{"status": status})
+}
@@ -0,0 +1,125 @@
+package capabilities
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/backend/semtechudp/packets"
+	"github.com/brocaar/lorawan"
+)
+
+// timingReference holds the uplink timing reference of a gateway, used to
+// convert between the DELAY and GPS_EPOCH downlink timing.
+type timingReference struct {
+	context           []byte
+	timeSinceGPSEpoch time.Duration
+}
+
+var references = make(map[lorawan.EUI64]timingReference)
+
+// UplinkFrame stores the given uplink as timing reference when the gateway
+// has a capability profile with timing transform. Only uplinks with context
+// and GPS time are used as reference.
+func UplinkFrame(pl gw.UplinkFrame) {
+	rxInfo := pl.GetRxInfo()
+	if len(rxInfo.GetContext()) == 0 || rxInfo.GetTimeSinceGpsEpoch() == nil {
+		return
+	}
+
+	gpsTime, err := ptypes.Duration(rxInfo.GetTimeSinceGpsEpoch())
+	if err != nil {
+		return
+	}
+
+	var gatewayID lorawan.EUI64
+	copy(gatewayID[:], rxInfo.GetGatewayId())
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	if pr, ok := profiles[gatewayID]; !ok || !pr.transformTiming {
+		return
+	}
+
+	references[gatewayID] = timingReference{
+		context:           rxInfo.GetContext(),
+		timeSinceGPSEpoch: gpsTime,
+	}
+}
+
+// convertTiming converts the timing of the given tx-info into a timing
+// supported by the profile. It returns a converted copy, or the given tx-info
+// when no conversion is needed. An error is returned when the timing can not
+// be converted.
+func (p profile) convertTiming(gatewayID lorawan.EUI64, txInfo *gw.DownlinkTXInfo) (*gw.DownlinkTXInfo, error) {
+	if len(p.timings) == 0 {
+		return txInfo, nil
+	}
+	if _, ok := p.timings[txInfo.GetTiming()]; ok {
+		return txInfo, nil
+	}
+
+	mux.RLock()
+	ref, ok := references[gatewayID]
+	mux.RUnlock()
+
+	out := proto.Clone(txInfo).(*gw.DownlinkTXInfo)
+
+	switch txInfo.GetTiming() {
+	case gw.DownlinkTiming_GPS_EPOCH:
+		if _, ok := p.timings[gw.DownlinkTiming_DELAY]; !ok {
+			break
+		}
+		if !ok {
+			return nil, fmt.Errorf("timing %s can not be converted, no uplink reference", txInfo.GetTiming())
+		}
+
+		gpsTime, err := ptypes.Duration(txInfo.GetGpsEpochTimingInfo().GetTimeSinceGpsEpoch())
+		if err != nil {
+			return nil, fmt.Errorf("timing %s can not be converted, invalid gps time", txInfo.GetTiming())
+		}
+
+		// the delay is relative to the concentrator counter of the reference
+		delay := gpsTime - ref.timeSinceGPSEpoch
+		if delay <= 0 || delay > packets.MaxTmstDelay {
+			return nil, fmt.Errorf("timing %s can not be converted, gps time is out of range of the uplink reference", txInfo.GetTiming())
+		}
+
+		out.Timing = gw.DownlinkTiming_DELAY
+		out.TimingInfo = &gw.DownlinkTXInfo_DelayTimingInfo{
+			DelayTimingInfo: &gw.DelayTimingInfo{
+				Delay: ptypes.DurationProto(delay),
+			},
+		}
+		out.Context = ref.context
+		return out, nil
+
+	case gw.DownlinkTiming_DELAY:
+		if _, ok := p.timings[gw.DownlinkTiming_GPS_EPOCH]; !ok {
+			break
+		}
+		if !ok || !bytes.Equal(ref.context, txInfo.GetContext()) {
+			return nil, fmt.Errorf("timing %s can not be converted, context does not match the uplink reference", txInfo.GetTiming())
+		}
+
+		delay, err := ptypes.Duration(txInfo.GetDelayTimingInfo().GetDelay())
+		if err != nil {
+			return nil, fmt.Errorf("timing %s can not be converted, invalid delay", txInfo.GetTiming())
+		}
+
+		out.Timing = gw.DownlinkTiming_GPS_EPOCH
+		out.TimingInfo = &gw.DownlinkTXInfo_GpsEpochTimingInfo{
+			GpsEpochTimingInfo: &gw.GPSEpochTimingInfo{
+				TimeSinceGpsEpoch: ptypes.DurationProto(ref.timeSinceGPSEpoch + delay),
+			},
+		}
+		return out, nil
+	}
+
+	return nil, fmt.Errorf("timing %s can not be converted", txInfo.GetTiming())
+}
@@ -0,0 +1,82 @@
+// Package coalesce implements the coalescing of uplink frames that were
+// received by the same gateway (e.g. on multiple antennas) into a single
+// uplink frame-set with multiple RX meta-data entries.
+package coalesce
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+var (
+	mux     sync.Mutex
+	window  time.Duration
+	pending map[string]*gw.UplinkFrameSet
+)
+
+// Setup configures the coalesce package.
+func Setup(conf config.Config) error {
+	mux.Lock()
+	defer mux.Unlock()
+
+	window = conf.UplinkCoalescing.Window
+	pending = make(map[string]*gw.UplinkFrameSet)
+
+	if window != 0 {
+		log.WithFields(log.Fields{
+			"window": window,
+		}).Info("coalesce: uplink coalescing configured")
+	}
+
+	return nil
+}
+
+// Enabled returns true when uplink coalescing is enabled.
+func Enabled() bool {
+	mux.Lock()
+	defer mux.Unlock()
+
+	return window != 0
+}
+
+// UplinkFrame adds the given uplink frame to the frame-set of the same
+// gateway and PHYPayload. The first frame starts the coalescing window, after
+// which the given function is called once with the complete frame-set.
+func UplinkFrame(pl gw.UplinkFrame, f func(gw.UplinkFrameSet)) {
+	var gatewayID lorawan.EUI64
+	copy(gatewayID[:], pl.GetRxInfo().GetGatewayId())
+	key := fmt.Sprintf("%s:%s", gatewayID, hex.EncodeToString(pl.PhyPayload))
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	if set, ok := pending[key]; ok {
+		set.RxInfo = append(set.RxInfo, pl.RxInfo)
+		return
+	}
+
+	pending[key] = &gw.UplinkFrameSet{
+		PhyPayload: pl.PhyPayload,
+		TxInfo:     pl.TxInfo,
+		RxInfo:     []*gw.UplinkRXInfo{pl.RxInfo},
+	}
+
+	time.AfterFunc(window, func() {
+		mux.Lock()
+		set := pending[key]
+		delete(pending, key)
+		mux.Unlock()
+
+		if set != nil {
+			f(*set)
+		}
+	})
+}
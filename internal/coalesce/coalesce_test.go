@@ -0,0 +1,87 @@
+package coalesce
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+)
+
+func TestUplinkFrame(t *testing.T) {
+	var conf config.Config
+	conf.UplinkCoalescing.Window = 50 * time.Millisecond
+	require.NoError(t, Setup(conf))
+
+	uplink := func(gatewayID []byte, phy []byte, antenna uint32, rssi int32) gw.UplinkFrame {
+		return gw.UplinkFrame{
+			PhyPayload: phy,
+			TxInfo: &gw.UplinkTXInfo{
+				Frequency: 868100000,
+			},
+			RxInfo: &gw.UplinkRXInfo{
+				GatewayId: gatewayID,
+				Antenna:   antenna,
+				Rssi:      rssi,
+			},
+		}
+	}
+
+	t.Run("Two antennas", func(t *testing.T) {
+		assert := require.New(t)
+		assert.True(Enabled())
+
+		setChan := make(chan gw.UplinkFrameSet, 2)
+		f := func(set gw.UplinkFrameSet) {
+			setChan <- set
+		}
+
+		UplinkFrame(uplink([]byte{1, 2, 3, 4, 5, 6, 7, 8}, []byte{1, 2, 3}, 0, -50), f)
+		UplinkFrame(uplink([]byte{1, 2, 3, 4, 5, 6, 7, 8}, []byte{1, 2, 3}, 1, -60), f)
+
+		set := <-setChan
+		assert.True(proto.Equal(&gw.UplinkFrameSet{
+			PhyPayload: []byte{1, 2, 3},
+			TxInfo: &gw.UplinkTXInfo{
+				Frequency: 868100000,
+			},
+			RxInfo: []*gw.UplinkRXInfo{
+				{GatewayId: []byte{1, 2, 3, 4, 5, 6, 7, 8}, Antenna: 0, Rssi: -50},
+				{GatewayId: []byte{1, 2, 3, 4, 5, 6, 7, 8}, Antenna: 1, Rssi: -60},
+			},
+		}, &set))
+
+		time.Sleep(100 * time.Millisecond)
+		assert.Len(setChan, 0)
+	})
+
+	t.Run("Different payload and gateway", func(t *testing.T) {
+		assert := require.New(t)
+
+		setChan := make(chan gw.UplinkFrameSet, 3)
+		f := func(set gw.UplinkFrameSet) {
+			setChan <- set
+		}
+
+		UplinkFrame(uplink([]byte{1, 2, 3, 4, 5, 6, 7, 8}, []byte{1, 2, 3}, 0, -50), f)
+		UplinkFrame(uplink([]byte{1, 2, 3, 4, 5, 6, 7, 8}, []byte{3, 2, 1}, 0, -50), f)
+		UplinkFrame(uplink([]byte{8, 7, 6, 5, 4, 3, 2, 1}, []byte{1, 2, 3}, 0, -50), f)
+
+		time.Sleep(100 * time.Millisecond)
+		assert.Len(setChan, 3)
+		for i := 0; i < 3; i++ {
+			set := <-setChan
+			assert.Len(set.RxInfo, 1)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(Setup(config.Config{}))
+		assert.False(Enabled())
+	})
+}
@@ -1,28 +1,125 @@
 package config
 
 import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/brocaar/lorawan"
 )
 
 // Config defines the configuration structure.
 type Config struct {
 	General struct {
-		LogLevel    int  `mapstructure:"log_level"`
-		LogToSyslog bool `mapstructure:"log_to_syslog"`
+		LogLevel      int    `mapstructure:"log_level"`
+		LogFormat     string `mapstructure:"log_format"`
+		LogToSyslog   bool   `mapstructure:"log_to_syslog"`
+		StartupBanner bool   `mapstructure:"startup_banner"`
+		PProfBind     string `mapstructure:"pprof_bind"`
+
+		LogOutput         string `mapstructure:"log_output"`
+		LogFile           string `mapstructure:"log_file"`
+		LogFileMaxSize    int    `mapstructure:"log_file_max_size"`
+		LogFileMaxBackups int    `mapstructure:"log_file_max_backups"`
+		SyslogFacility    string `mapstructure:"syslog_facility"`
+		SyslogTag         string `mapstructure:"syslog_tag"`
 	} `mapstructure:"general"`
 
 	Filters struct {
 		NetIDs   []string    `mapstructure:"net_ids"`
 		JoinEUIs [][2]string `mapstructure:"join_euis"`
+
+		GatewayFilterAllow []string `mapstructure:"gateway_allow"`
+		GatewayFilterDeny  []string `mapstructure:"gateway_deny"`
 	} `mapstructure:"filters"`
 
+	Validation struct {
+		UplinkAction string        `mapstructure:"uplink_action"`
+		MaxUplinkAge time.Duration `mapstructure:"max_uplink_age"`
+
+		DownlinkTokenAction string `mapstructure:"downlink_token_action"`
+	} `mapstructure:"validation"`
+
+	RateLimit struct {
+		GatewayUplinkRate  float64 `mapstructure:"gateway_uplink_rate"`
+		GatewayUplinkBurst int     `mapstructure:"gateway_uplink_burst"`
+		UplinkRate         float64 `mapstructure:"uplink_rate"`
+		UplinkBurst        int     `mapstructure:"uplink_burst"`
+	} `mapstructure:"rate_limit"`
+
+	DownlinkOverride struct {
+		Frequency       uint32 `mapstructure:"frequency"`
+		MaxPower        int    `mapstructure:"max_power"`
+		SpreadingFactor uint32 `mapstructure:"spreading_factor"`
+		Bandwidth       uint32 `mapstructure:"bandwidth"`
+	} `mapstructure:"downlink_override"`
+
+	UplinkCoalescing struct {
+		Window time.Duration `mapstructure:"window"`
+	} `mapstructure:"uplink_coalescing"`
+
+	AckAggregation struct {
+		Window time.Duration `mapstructure:"window"`
+	} `mapstructure:"ack_aggregation"`
+
+	GatewayControl struct {
+		Bind      string `mapstructure:"bind"`
+		StateFile string `mapstructure:"state_file"`
+	} `mapstructure:"gateway_control"`
+
+	DownlinkInjection struct {
+		Bind  string `mapstructure:"bind"`
+		Token string `mapstructure:"token"`
+	} `mapstructure:"downlink_injection"`
+
+	Capabilities struct {
+		Gateways []GatewayCapabilities `mapstructure:"gateways"`
+	} `mapstructure:"capabilities"`
+
+	VirtualGateways struct {
+		DeduplicationWindow time.Duration    `mapstructure:"deduplication_window"`
+		Gateways            []VirtualGateway `mapstructure:"gateways"`
+	} `mapstructure:"virtual_gateways"`
+
 	Backend struct {
-		Type string `mapstructure:"type"`
+		Type                    string        `mapstructure:"type"`
+		MaxInFlightDownlinks    int           `mapstructure:"max_in_flight_downlinks"`
+		InFlightDownlinkTimeout time.Duration `mapstructure:"in_flight_downlink_timeout"`
 
 		SemtechUDP struct {
-			UDPBind      string `mapstructure:"udp_bind"`
-			SkipCRCCheck bool   `mapstructure:"skip_crc_check"`
-			FakeRxTime   bool   `mapstructure:"fake_rx_time"`
+			UDPBind            string                 `mapstructure:"udp_bind"`
+			SkipCRCCheck       bool                   `mapstructure:"skip_crc_check"`
+			FakeRxTime         bool                   `mapstructure:"fake_rx_time"`
+			SourceAddrMetaData bool                   `mapstructure:"source_addr_meta_data"`
+			ExtensionFields    []string               `mapstructure:"extension_fields"`
+			Gateways           []SemtechUDPGateway    `mapstructure:"gateways"`
+			TXAckRetries       []SemtechUDPTXAckRetry `mapstructure:"tx_ack_retries"`
+			TXAckTimeout       time.Duration          `mapstructure:"tx_ack_timeout"`
+			TXAckRetransmit    bool                   `mapstructure:"tx_ack_retransmit"`
+			UDPDropsInterval   time.Duration          `mapstructure:"udp_drops_interval"`
+			StatsInterval      time.Duration          `mapstructure:"stats_interval"`
+			UDPReadBuffer      int                    `mapstructure:"udp_read_buffer"`
+			Workers            int                    `mapstructure:"workers"`
+			GatewayTimeout     time.Duration          `mapstructure:"gateway_timeout"`
+
+			Normalization struct {
+				FrequencyUnit string `mapstructure:"frequency_unit"`
+				GPSTimeUnit   string `mapstructure:"gps_time_unit"`
+			} `mapstructure:"normalization"`
+
+			Backpressure struct {
+				Threshold   int           `mapstructure:"threshold"`
+				MaxACKDelay time.Duration `mapstructure:"max_ack_delay"`
+			} `mapstructure:"backpressure"`
+
+			PullDataMonitor struct {
+				MaxInterval    time.Duration `mapstructure:"max_interval"`
+				LogPortChanges bool          `mapstructure:"log_port_changes"`
+			} `mapstructure:"pull_data_monitor"`
 		} `mapstructure:"semtech_udp"`
 
 		BasicStation struct {
@@ -53,16 +150,92 @@ type Config struct {
 	} `mapstructure:"backend"`
 
 	Integration struct {
-		Marshaler string `mapstructure:"marshaler"`
+		Type                string `mapstructure:"type"`
+		Marshaler           string `mapstructure:"marshaler"`
+		AckMarshaler        string `mapstructure:"ack_marshaler"`
+		JSONGatewayIDKey    string `mapstructure:"json_gateway_id_key"`
+		JSONPayloadEncoding string `mapstructure:"json_payload_encoding"`
+		ConfigAck           bool   `mapstructure:"config_ack"`
+		DryRun              bool   `mapstructure:"dry_run"`
 
 		MQTT struct {
-			EventTopicTemplate      string        `mapstructure:"event_topic_template"`
-			CommandTopicTemplate    string        `mapstructure:"command_topic_template"`
-			StateTopicTemplate      string        `mapstructure:"state_topic_template"`
-			StateRetained           bool          `mapstructure:"state_retained"`
-			KeepAlive               time.Duration `mapstructure:"keep_alive"`
-			MaxReconnectInterval    time.Duration `mapstructure:"max_reconnect_interval"`
-			TerminateOnConnectError bool          `mapstructure:"terminate_on_connect_error"`
+			EventTopicTemplate            string        `mapstructure:"event_topic_template"`
+			CommandTopicTemplate          string        `mapstructure:"command_topic_template"`
+			DownlinkWildcardTopic         string        `mapstructure:"downlink_wildcard_topic"`
+			StateTopicTemplate            string        `mapstructure:"state_topic_template"`
+			GatewayConnStateTopicTemplate string        `mapstructure:"gateway_conn_state_topic_template"`
+			TopicTemplateEnvStrict        bool          `mapstructure:"topic_template_env_strict"`
+			StateRetained                 bool          `mapstructure:"state_retained"`
+			KeepAlive                     time.Duration `mapstructure:"keep_alive"`
+			AutoReconnect                 bool          `mapstructure:"auto_reconnect"`
+			ReconnectInterval             time.Duration `mapstructure:"reconnect_interval"`
+			MaxReconnectInterval          time.Duration `mapstructure:"max_reconnect_interval"`
+			PublishTimeout                time.Duration `mapstructure:"publish_timeout"`
+			SubscribeTimeout              time.Duration `mapstructure:"subscribe_timeout"`
+			UnsubscribeGrace              time.Duration `mapstructure:"unsubscribe_grace"`
+			SubscribeBatchSize            int           `mapstructure:"subscribe_batch_size"`
+			TopicProbe                    bool          `mapstructure:"topic_probe"`
+			CombineUplinkStats            bool          `mapstructure:"combine_uplink_stats"`
+			MACSummary                    bool          `mapstructure:"mac_summary"`
+			Envelope                      string        `mapstructure:"envelope"`
+			SortJSONKeys                  bool          `mapstructure:"sort_json_keys"`
+			Compression                   string        `mapstructure:"compression"`
+			CompressionEvents             []string      `mapstructure:"compression_events"`
+			CompressionTopicSuffix        string        `mapstructure:"compression_topic_suffix"`
+			UplinkAllowList               []string      `mapstructure:"uplink_allow_list"`
+			TerminateOnConnectError       bool          `mapstructure:"terminate_on_connect_error"`
+			MaxConnectAttempts            int           `mapstructure:"max_connect_attempts"`
+
+			GatewayState struct {
+				File           string        `mapstructure:"file"`
+				RestoreTimeout time.Duration `mapstructure:"restore_timeout"`
+			} `mapstructure:"gateway_state"`
+
+			ConnectionStateTopicTemplate string `mapstructure:"connection_state_topic_template"`
+
+			DownlinkQOS int `mapstructure:"downlink_qos"`
+
+			DownlinkBuffer struct {
+				Size     int    `mapstructure:"size"`
+				Overflow string `mapstructure:"overflow"`
+			} `mapstructure:"downlink_buffer"`
+
+			EventQOS struct {
+				Up    int `mapstructure:"up"`
+				Stats int `mapstructure:"stats"`
+				Ack   int `mapstructure:"ack"`
+			} `mapstructure:"event_qos"`
+
+			EventRetained struct {
+				Up    bool `mapstructure:"up"`
+				Stats bool `mapstructure:"stats"`
+				Ack   bool `mapstructure:"ack"`
+			} `mapstructure:"event_retained"`
+
+			MaintenanceWindows []MQTTMaintenanceWindow `mapstructure:"maintenance_windows"`
+
+			StatsGroups struct {
+				TopicTemplate string           `mapstructure:"topic_template"`
+				GroupOnly     bool             `mapstructure:"group_only"`
+				Groups        []MQTTStatsGroup `mapstructure:"groups"`
+			} `mapstructure:"stats_groups"`
+
+			AggregateMetrics struct {
+				Topic    string        `mapstructure:"topic"`
+				Interval time.Duration `mapstructure:"interval"`
+			} `mapstructure:"aggregate_metrics"`
+
+			KeepaliveAlerts struct {
+				TopicTemplate string               `mapstructure:"topic_template"`
+				Interval      time.Duration        `mapstructure:"interval"`
+				Groups        []MQTTKeepaliveGroup `mapstructure:"groups"`
+			} `mapstructure:"keepalive_alerts"`
+
+			SubscribeRetry struct {
+				InitialInterval time.Duration `mapstructure:"initial_interval"`
+				MaxInterval     time.Duration `mapstructure:"max_interval"`
+				MaxAttempts     int           `mapstructure:"max_attempts"`
+			} `mapstructure:"subscribe_retry"`
 
 			Auth struct {
 				Type string `mapstructure:"type"`
@@ -78,6 +251,23 @@ type Config struct {
 					QOS          uint8    `mapstructure:"qos"`
 					CleanSession bool     `mapstructure:"clean_session"`
 					ClientID     string   `mapstructure:"client_id"`
+
+					TLSKeyPassword       string   `mapstructure:"tls_key_password"`
+					TLSMinVersion        string   `mapstructure:"tls_min_version"`
+					TLSCipherSuites      []string `mapstructure:"tls_cipher_suites"`
+					TLSUseSystemCertPool bool     `mapstructure:"tls_use_system_cert_pool"`
+
+					HTTPHeaders map[string]string `mapstructure:"http_headers"`
+
+					PasswordCommand string `mapstructure:"password_command"`
+					PasswordFile    string `mapstructure:"password_file"`
+
+					Fallback struct {
+						Server       string `mapstructure:"server"`
+						Username     string `mapstructure:"username"`
+						Password     string `mapstructure:"password"`
+						AuthFailures int    `mapstructure:"auth_failures"`
+					} `mapstructure:"fallback"`
 				} `mapstructure:"generic"`
 
 				GCPCloudIoTCore struct {
@@ -101,13 +291,37 @@ type Config struct {
 				} `mapstructure:"azure_iot_hub"`
 			} `mapstructure:"auth"`
 		} `mapstructure:"mqtt"`
+
+		GRPC struct {
+			Enabled bool   `mapstructure:"enabled"`
+			Bind    string `mapstructure:"bind"`
+		} `mapstructure:"grpc"`
+
+		GCPPubSub struct {
+			Endpoint                string        `mapstructure:"endpoint"`
+			ProjectID               string        `mapstructure:"project_id"`
+			EventTopicName          string        `mapstructure:"event_topic_name"`
+			CommandSubscriptionName string        `mapstructure:"command_subscription_name"`
+			PublishTimeout          time.Duration `mapstructure:"publish_timeout"`
+		} `mapstructure:"gcp_pub_sub"`
 	} `mapstructure:"integration"`
 
 	Metrics struct {
+		Backend         string `mapstructure:"backend"`
+		LastErrorMetric bool   `mapstructure:"last_error_metric"`
+
 		Prometheus struct {
-			EndpointEnabled bool   `mapstructure:"endpoint_enabled"`
-			Bind            string `mapstructure:"bind"`
+			EndpointEnabled            bool   `mapstructure:"endpoint_enabled"`
+			Bind                       string `mapstructure:"bind"`
+			StatusEndpointEnabled      bool   `mapstructure:"status_endpoint_enabled"`
+			DiagnosticsEndpointEnabled bool   `mapstructure:"diagnostics_endpoint_enabled"`
 		} `mapstructure:"prometheus"`
+
+		StatsD struct {
+			Server   string        `mapstructure:"server"`
+			Prefix   string        `mapstructure:"prefix"`
+			Interval time.Duration `mapstructure:"interval"`
+		} `mapstructure:"statsd"`
 	} `mapstructure:"metrics"`
 
 	MetaData struct {
@@ -128,6 +342,164 @@ type Config struct {
 	} `mapstructure:"commands"`
 }
 
+// NormalizeEUIs validates the EUI64 values of the configuration (gateway IDs
+// and join EUI filters) and rewrites them to their canonical (lower-case hex)
+// form. The returned error names the offending config key and value.
+func (c *Config) NormalizeEUIs() error {
+	var euis []euiValue
+	add := func(value *string, format string, a ...interface{}) {
+		euis = append(euis, euiValue{key: fmt.Sprintf(format, a...), value: value})
+	}
+
+	for i := range c.Filters.JoinEUIs {
+		add(&c.Filters.JoinEUIs[i][0], "filters.join_euis[%d][0]", i)
+		add(&c.Filters.JoinEUIs[i][1], "filters.join_euis[%d][1]", i)
+	}
+	for i := range c.Filters.GatewayFilterAllow {
+		add(&c.Filters.GatewayFilterAllow[i], "filters.gateway_allow[%d]", i)
+	}
+	for i := range c.Filters.GatewayFilterDeny {
+		add(&c.Filters.GatewayFilterDeny[i], "filters.gateway_deny[%d]", i)
+	}
+	for i := range c.Backend.BasicStation.Filters.JoinEUIs {
+		add(&c.Backend.BasicStation.Filters.JoinEUIs[i][0], "backend.basic_station.filters.join_euis[%d][0]", i)
+		add(&c.Backend.BasicStation.Filters.JoinEUIs[i][1], "backend.basic_station.filters.join_euis[%d][1]", i)
+	}
+	for i := range c.Capabilities.Gateways {
+		add(&c.Capabilities.Gateways[i].GatewayID, "capabilities.gateways[%d].gateway_id", i)
+	}
+	for i := range c.VirtualGateways.Gateways {
+		add(&c.VirtualGateways.Gateways[i].GatewayID, "virtual_gateways.gateways[%d].gateway_id", i)
+		for j := range c.VirtualGateways.Gateways[i].PhysicalGatewayIDs {
+			add(&c.VirtualGateways.Gateways[i].PhysicalGatewayIDs[j], "virtual_gateways.gateways[%d].physical_gateway_ids[%d]", i, j)
+		}
+	}
+	for i := range c.Backend.SemtechUDP.Gateways {
+		add(&c.Backend.SemtechUDP.Gateways[i].GatewayID, "backend.semtech_udp.gateways[%d].gateway_id", i)
+	}
+	for i := range c.Integration.MQTT.StatsGroups.Groups {
+		for j := range c.Integration.MQTT.StatsGroups.Groups[i].GatewayIDs {
+			add(&c.Integration.MQTT.StatsGroups.Groups[i].GatewayIDs[j], "integration.mqtt.stats_groups.groups[%d].gateway_ids[%d]", i, j)
+		}
+	}
+
+	for i := range c.Integration.MQTT.KeepaliveAlerts.Groups {
+		for j := range c.Integration.MQTT.KeepaliveAlerts.Groups[i].GatewayIDs {
+			add(&c.Integration.MQTT.KeepaliveAlerts.Groups[i].GatewayIDs[j], "integration.mqtt.keepalive_alerts.groups[%d].gateway_ids[%d]", i, j)
+		}
+	}
+
+	for _, eui := range euis {
+		var v lorawan.EUI64
+		if err := v.UnmarshalText([]byte(*eui.value)); err != nil {
+			return errors.Wrapf(err, "invalid EUI64 %q for %s", *eui.value, eui.key)
+		}
+		*eui.value = v.String()
+	}
+
+	return nil
+}
+
+// ChangedKeys returns the (sorted) config keys of the options of which the
+// value differs between c and other. Structs are compared per field, other
+// values (e.g. slices and maps) are compared as a whole.
+func (c Config) ChangedKeys(other Config) []string {
+	var keys []string
+	changedKeys(reflect.ValueOf(c), reflect.ValueOf(other), "", &keys)
+	sort.Strings(keys)
+	return keys
+}
+
+func changedKeys(a, b reflect.Value, prefix string, keys *[]string) {
+	if a.Kind() != reflect.Struct {
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			*keys = append(*keys, prefix)
+		}
+		return
+	}
+
+	for i := 0; i < a.NumField(); i++ {
+		f := a.Type().Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("mapstructure"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		changedKeys(a.Field(i), b.Field(i), name, keys)
+	}
+}
+
+// euiValue references an EUI64 config value by its config key.
+type euiValue struct {
+	key   string
+	value *string
+}
+
+// VirtualGateway holds the configuration for a virtual gateway.
+type VirtualGateway struct {
+	GatewayID          string   `mapstructure:"gateway_id"`
+	PhysicalGatewayIDs []string `mapstructure:"physical_gateway_ids"`
+}
+
+// GatewayCapabilities holds the capability profile of a gateway, against
+// which the downlinks for this gateway are validated.
+type GatewayCapabilities struct {
+	GatewayID        string   `mapstructure:"gateway_id"`
+	Modulations      []string `mapstructure:"modulations"`
+	SpreadingFactors []uint32 `mapstructure:"spreading_factors"`
+	Timings          []string `mapstructure:"timings"`
+	MinFrequency     uint32   `mapstructure:"min_frequency"`
+	MaxFrequency     uint32   `mapstructure:"max_frequency"`
+	MaxPower         int32    `mapstructure:"max_power"`
+	TransformTiming  bool     `mapstructure:"transform_timing"`
+}
+
+// SemtechUDPGateway holds the per gateway configuration for the Semtech UDP
+// backend.
+type SemtechUDPGateway struct {
+	GatewayID string `mapstructure:"gateway_id"`
+	Antennas  uint32 `mapstructure:"antennas"`
+}
+
+// SemtechUDPTXAckRetry holds the retry policy for a tx acknowledgement
+// status of the Semtech UDP backend.
+type SemtechUDPTXAckRetry struct {
+	Status  string `mapstructure:"status"`
+	Retries int    `mapstructure:"retries"`
+}
+
+// MQTTMaintenanceWindow holds a (scheduled) maintenance window of the MQTT
+// broker. The start and end are formatted as RFC3339 timestamps.
+type MQTTMaintenanceWindow struct {
+	Start string `mapstructure:"start"`
+	End   string `mapstructure:"end"`
+}
+
+// MQTTStatsGroup holds a group of gateways of which the stats are also
+// published to the group stats topic.
+type MQTTStatsGroup struct {
+	Name       string   `mapstructure:"name"`
+	GatewayIDs []string `mapstructure:"gateway_ids"`
+}
+
+// MQTTKeepaliveGroup holds a group of gateways for which an alert is
+// published when no events are received within the given threshold.
+type MQTTKeepaliveGroup struct {
+	Name       string        `mapstructure:"name"`
+	Threshold  time.Duration `mapstructure:"threshold"`
+	GatewayIDs []string      `mapstructure:"gateway_ids"`
+}
+
 // BasicStationConcentrator holds the configuration for a BasicStation concentrator.
 type BasicStationConcentrator struct {
 	MultiSF BasicStationConcentratorMultiSF `mapstructure:"multi_sf"`
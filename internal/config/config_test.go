@@ -0,0 +1,97 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeEUIs(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		assert := require.New(t)
+
+		var c Config
+		c.Filters.JoinEUIs = [][2]string{{"0000000000000000", "00000000000000FF"}}
+		c.VirtualGateways.Gateways = []VirtualGateway{
+			{GatewayID: "AABBCCDDEEFF0011", PhysicalGatewayIDs: []string{"0102030405060708"}},
+		}
+		c.Backend.SemtechUDP.Gateways = []SemtechUDPGateway{{GatewayID: "0102030405060708"}}
+
+		assert.NoError(c.NormalizeEUIs())
+		assert.Equal("00000000000000ff", c.Filters.JoinEUIs[0][1])
+		assert.Equal("aabbccddeeff0011", c.VirtualGateways.Gateways[0].GatewayID)
+		assert.Equal("0102030405060708", c.VirtualGateways.Gateways[0].PhysicalGatewayIDs[0])
+	})
+
+	tests := []struct {
+		Name          string
+		Config        func(c *Config)
+		ExpectedError string
+	}{
+		{
+			Name: "too short",
+			Config: func(c *Config) {
+				c.Capabilities.Gateways = []GatewayCapabilities{{GatewayID: "01020304"}}
+			},
+			ExpectedError: `invalid EUI64 "01020304" for capabilities.gateways[0].gateway_id: lorawan: exactly 8 bytes are expected`,
+		},
+		{
+			Name: "too long",
+			Config: func(c *Config) {
+				c.Backend.SemtechUDP.Gateways = []SemtechUDPGateway{{GatewayID: "010203040506070809"}}
+			},
+			ExpectedError: `invalid EUI64 "010203040506070809" for backend.semtech_udp.gateways[0].gateway_id: lorawan: exactly 8 bytes are expected`,
+		},
+		{
+			Name: "invalid hex",
+			Config: func(c *Config) {
+				c.VirtualGateways.Gateways = []VirtualGateway{
+					{GatewayID: "0102030405060708", PhysicalGatewayIDs: []string{"0102030405060708", "01020304050607zz"}},
+				}
+			},
+			ExpectedError: `invalid EUI64 "01020304050607zz" for virtual_gateways.gateways[0].physical_gateway_ids[1]: encoding/hex: invalid byte: U+007A 'z'`,
+		},
+		{
+			Name: "empty",
+			Config: func(c *Config) {
+				c.Integration.MQTT.StatsGroups.Groups = []MQTTStatsGroup{{Name: "north", GatewayIDs: []string{""}}}
+			},
+			ExpectedError: `invalid EUI64 "" for integration.mqtt.stats_groups.groups[0].gateway_ids[0]: lorawan: exactly 8 bytes are expected`,
+		},
+		{
+			Name: "join eui range",
+			Config: func(c *Config) {
+				c.Filters.JoinEUIs = [][2]string{{"0000000000000000", "0x00000000000000ff"}}
+			},
+			ExpectedError: `invalid EUI64 "0x00000000000000ff" for filters.join_euis[0][1]: encoding/hex: invalid byte: U+0078 'x'`,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			var c Config
+			tst.Config(&c)
+			assert.EqualError(c.NormalizeEUIs(), tst.ExpectedError)
+		})
+	}
+}
+
+func TestChangedKeys(t *testing.T) {
+	assert := require.New(t)
+
+	var a, b Config
+	assert.Len(a.ChangedKeys(b), 0)
+
+	b.General.LogLevel = 5
+	b.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	b.Integration.MQTT.Auth.Generic.Servers = []string{"tcp://127.0.0.1:1883"}
+	b.Integration.MQTT.Auth.AzureIoTHub.DeviceKey = "secret"
+
+	assert.Equal([]string{
+		"general.log_level",
+		"integration.mqtt.auth.generic.servers",
+		"integration.mqtt.event_topic_template",
+	}, a.ChangedKeys(b))
+}
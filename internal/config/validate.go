@@ -0,0 +1,224 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ValidationError contains the problems found by Validate.
+type ValidationError []string
+
+// Error implements the error interface.
+func (e ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e, "; ")
+}
+
+// Validate validates the configuration, so that misconfiguration is reported
+// at startup instead of resulting in (obscure) errors once connecting. It
+// returns a ValidationError listing all the problems found, or nil when the
+// configuration is valid.
+func (c Config) Validate() error {
+	var v ValidationError
+
+	// the integration is not used in dry-run mode
+	integrationType := c.Integration.Type
+	if c.Integration.DryRun {
+		integrationType = ""
+	}
+
+	switch integrationType {
+	case "mqtt":
+		c.validateMQTT(&v)
+	case "gcp_pub_sub":
+		conf := c.Integration.GCPPubSub
+		v.required("integration.gcp_pub_sub.project_id", conf.ProjectID)
+		v.required("integration.gcp_pub_sub.event_topic_name", conf.EventTopicName)
+		v.required("integration.gcp_pub_sub.command_subscription_name", conf.CommandSubscriptionName)
+		v.positive("integration.gcp_pub_sub.publish_timeout", conf.PublishTimeout)
+	}
+
+	if c.Backend.Type == "semtech_udp" {
+		if c.Backend.SemtechUDP.Workers < 0 {
+			v.add("backend.semtech_udp.workers: must not be negative")
+		}
+		v.positive("backend.semtech_udp.gateway_timeout", c.Backend.SemtechUDP.GatewayTimeout)
+	}
+
+	if c.Backend.Type == "basic_station" {
+		conf := c.Backend.BasicStation
+		v.file("backend.basic_station.tls_cert", conf.TLSCert)
+		v.file("backend.basic_station.tls_key", conf.TLSKey)
+		v.file("backend.basic_station.ca_cert", conf.CACert)
+	}
+
+	if len(v) != 0 {
+		return v
+	}
+	return nil
+}
+
+func (c Config) validateMQTT(v *ValidationError) {
+	conf := c.Integration.MQTT
+
+	// the topic templates are set by the gcp_cloud_iot_core and
+	// azure_iot_hub authentication types
+	if conf.Auth.Type == "generic" {
+		v.required("integration.mqtt.event_topic_template", conf.EventTopicTemplate)
+		v.required("integration.mqtt.command_topic_template", conf.CommandTopicTemplate)
+		v.template("integration.mqtt.event_topic_template", conf.EventTopicTemplate)
+		v.template("integration.mqtt.command_topic_template", conf.CommandTopicTemplate)
+		v.template("integration.mqtt.state_topic_template", conf.StateTopicTemplate)
+		v.template("integration.mqtt.gateway_conn_state_topic_template", conf.GatewayConnStateTopicTemplate)
+	}
+	v.template("integration.mqtt.connection_state_topic_template", conf.ConnectionStateTopicTemplate)
+
+	v.qos("integration.mqtt.downlink_qos", conf.DownlinkQOS)
+	v.qos("integration.mqtt.event_qos.up", conf.EventQOS.Up)
+	v.qos("integration.mqtt.event_qos.stats", conf.EventQOS.Stats)
+	v.qos("integration.mqtt.event_qos.ack", conf.EventQOS.Ack)
+
+	v.notNegative("integration.mqtt.keep_alive", conf.KeepAlive)
+	v.notNegative("integration.mqtt.reconnect_interval", conf.ReconnectInterval)
+	v.notNegative("integration.mqtt.max_reconnect_interval", conf.MaxReconnectInterval)
+	v.notNegative("integration.mqtt.publish_timeout", conf.PublishTimeout)
+	v.notNegative("integration.mqtt.subscribe_timeout", conf.SubscribeTimeout)
+	if conf.AggregateMetrics.Topic != "" {
+		v.positive("integration.mqtt.aggregate_metrics.interval", conf.AggregateMetrics.Interval)
+	}
+	for i, event := range conf.CompressionEvents {
+		switch event {
+		case "up", "up_set", "stats", "ack", "exec", "raw", "config_ack":
+		default:
+			v.add(fmt.Sprintf("integration.mqtt.compression_events[%d]: unknown event type: %s", i, event))
+		}
+	}
+
+	switch conf.Auth.Type {
+	case "generic":
+		generic := conf.Auth.Generic
+		if len(generic.Servers) == 0 {
+			v.add("integration.mqtt.auth.generic.servers: at least one server must be configured")
+		}
+		for i, server := range generic.Servers {
+			v.required(fmt.Sprintf("integration.mqtt.auth.generic.servers[%d]", i), server)
+		}
+		if generic.QOS > 2 {
+			v.add(fmt.Sprintf("integration.mqtt.auth.generic.qos: invalid qos %d, must be 0, 1 or 2", generic.QOS))
+		}
+		v.file("integration.mqtt.auth.generic.ca_cert", generic.CACert)
+		v.file("integration.mqtt.auth.generic.tls_cert", generic.TLSCert)
+		v.file("integration.mqtt.auth.generic.tls_key", generic.TLSKey)
+		v.file("integration.mqtt.auth.generic.password_file", generic.PasswordFile)
+		if generic.Fallback.Server != "" {
+			v.server("integration.mqtt.auth.generic.fallback.server", generic.Fallback.Server)
+			if generic.Fallback.AuthFailures <= 0 {
+				v.add("integration.mqtt.auth.generic.fallback.auth_failures: must be greater than 0")
+			}
+		}
+	case "gcp_cloud_iot_core":
+		gcp := conf.Auth.GCPCloudIoTCore
+		v.required("integration.mqtt.auth.gcp_cloud_iot_core.server", gcp.Server)
+		v.required("integration.mqtt.auth.gcp_cloud_iot_core.device_id", gcp.DeviceID)
+		v.required("integration.mqtt.auth.gcp_cloud_iot_core.project_id", gcp.ProjectID)
+		v.required("integration.mqtt.auth.gcp_cloud_iot_core.cloud_region", gcp.CloudRegion)
+		v.required("integration.mqtt.auth.gcp_cloud_iot_core.registry_id", gcp.RegistryID)
+		v.required("integration.mqtt.auth.gcp_cloud_iot_core.jwt_key_file", gcp.JWTKeyFile)
+		v.file("integration.mqtt.auth.gcp_cloud_iot_core.jwt_key_file", gcp.JWTKeyFile)
+		v.positive("integration.mqtt.auth.gcp_cloud_iot_core.jwt_expiration", gcp.JWTExpiration)
+	case "azure_iot_hub":
+		azure := conf.Auth.AzureIoTHub
+		if azure.TLSCert == "" && azure.TLSKey == "" && azure.DeviceConnectionString == "" {
+			v.add("integration.mqtt.auth.azure_iot_hub: device_connection_string or tls_cert and tls_key must be configured")
+		}
+		v.file("integration.mqtt.auth.azure_iot_hub.tls_cert", azure.TLSCert)
+		v.file("integration.mqtt.auth.azure_iot_hub.tls_key", azure.TLSKey)
+	default:
+		v.add(fmt.Sprintf("integration.mqtt.auth.type: unknown auth type: %s", conf.Auth.Type))
+	}
+}
+
+// add adds the given problem.
+func (v *ValidationError) add(problem string) {
+	*v = append(*v, problem)
+}
+
+// required validates that the given value is not empty.
+func (v *ValidationError) required(key, value string) {
+	if value == "" {
+		v.add(key + ": must be set")
+	}
+}
+
+// template validates that the given (optional) value is a valid template.
+func (v *ValidationError) template(key, value string) {
+	if value == "" {
+		return
+	}
+	// the env function is provided by the mqtt integration
+	if _, err := template.New(key).Funcs(template.FuncMap{"env": os.Getenv}).Parse(value); err != nil {
+		v.add(fmt.Sprintf("%s: invalid template: %s", key, err))
+	}
+}
+
+// file validates that the given (optional) file exists and is readable.
+func (v *ValidationError) file(key, path string) {
+	if path == "" {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		v.add(fmt.Sprintf("%s: %s", key, err))
+		return
+	}
+	f.Close()
+}
+
+// server validates that the given value is a valid MQTT server URL. Like the
+// MQTT client, a server without scheme defaults to the tcp scheme.
+func (v *ValidationError) server(key, value string) {
+	if !strings.Contains(value, "://") {
+		value = "tcp://" + value
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		v.add(fmt.Sprintf("%s: %s", key, err))
+		return
+	}
+
+	switch u.Scheme {
+	case "tcp", "mqtt", "ssl", "tls", "mqtts", "ws", "wss":
+	default:
+		v.add(fmt.Sprintf("%s: unsupported scheme: %s", key, u.Scheme))
+		return
+	}
+
+	if u.Host == "" {
+		v.add(fmt.Sprintf("%s: host must be set", key))
+	}
+}
+
+// qos validates the given qos, -1 means the default qos is used.
+func (v *ValidationError) qos(key string, qos int) {
+	if qos < -1 || qos > 2 {
+		v.add(fmt.Sprintf("%s: invalid qos %d, must be 0, 1 or 2 (or -1 to use the default qos)", key, qos))
+	}
+}
+
+// notNegative validates that the given duration is 0 or greater.
+func (v *ValidationError) notNegative(key string, d time.Duration) {
+	if d < 0 {
+		v.add(fmt.Sprintf("%s: must not be negative", key))
+	}
+}
+
+// positive validates that the given duration is greater than 0.
+func (v *ValidationError) positive(key string, d time.Duration) {
+	if d <= 0 {
+		v.add(fmt.Sprintf("%s: must be greater than 0", key))
+	}
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	valid := func() Config {
+		var c Config
+		c.Backend.Type = "semtech_udp"
+		c.Backend.SemtechUDP.GatewayTimeout = time.Minute
+		c.Integration.Type = "mqtt"
+		c.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+		c.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+		c.Integration.MQTT.StateTopicTemplate = "gateway/{{ .GatewayID }}/state/{{ .StateType }}"
+		c.Integration.MQTT.KeepAlive = 30 * time.Second
+		c.Integration.MQTT.Auth.Type = "generic"
+		c.Integration.MQTT.Auth.Generic.Servers = []string{"tcp://127.0.0.1:1883"}
+		return c
+	}
+
+	tests := []struct {
+		Name          string
+		Config        func(c *Config)
+		ExpectedError string
+	}{
+		{
+			Name:   "valid",
+			Config: func(c *Config) {},
+		},
+		{
+			Name: "env template function",
+			Config: func(c *Config) {
+				c.Integration.MQTT.EventTopicTemplate = `{{ env "REGION" }}/gateway/{{ .GatewayID }}/event/{{ .EventType }}`
+			},
+		},
+		{
+			Name: "missing servers",
+			Config: func(c *Config) {
+				c.Integration.MQTT.Auth.Generic.Servers = nil
+			},
+			ExpectedError: "invalid configuration: integration.mqtt.auth.generic.servers: at least one server must be configured",
+		},
+		{
+			Name: "multiple problems",
+			Config: func(c *Config) {
+				c.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }/event"
+				c.Integration.MQTT.CommandTopicTemplate = ""
+				c.Integration.MQTT.EventQOS.Ack = 3
+				c.Integration.MQTT.KeepAlive = -time.Second
+				c.Integration.MQTT.Auth.Generic.CACert = "/does/not/exist.pem"
+			},
+			ExpectedError: `invalid configuration: integration.mqtt.command_topic_template: must be set; integration.mqtt.event_topic_template: invalid template: template: integration.mqtt.event_topic_template:1: unexpected "}" in operand; integration.mqtt.event_qos.ack: invalid qos 3, must be 0, 1 or 2 (or -1 to use the default qos); integration.mqtt.keep_alive: must not be negative; integration.mqtt.auth.generic.ca_cert: open /does/not/exist.pem: no such file or directory`,
+		},
+		{
+			Name: "fallback server",
+			Config: func(c *Config) {
+				c.Integration.MQTT.Auth.Generic.Fallback.Server = "tcp://127.0.0.1:1884"
+				c.Integration.MQTT.Auth.Generic.Fallback.AuthFailures = 3
+			},
+		},
+		{
+			Name: "invalid fallback server",
+			Config: func(c *Config) {
+				c.Integration.MQTT.Auth.Generic.Fallback.Server = "http://127.0.0.1:1884"
+			},
+			ExpectedError: "invalid configuration: integration.mqtt.auth.generic.fallback.server: unsupported scheme: http; integration.mqtt.auth.generic.fallback.auth_failures: must be greater than 0",
+		},
+		{
+			Name: "compression events",
+			Config: func(c *Config) {
+				c.Integration.MQTT.CompressionEvents = []string{"up", "uplink"}
+			},
+			ExpectedError: "invalid configuration: integration.mqtt.compression_events[1]: unknown event type: uplink",
+		},
+		{
+			Name: "unknown auth type",
+			Config: func(c *Config) {
+				c.Integration.MQTT.Auth.Type = "foo"
+			},
+			ExpectedError: "invalid configuration: integration.mqtt.auth.type: unknown auth type: foo",
+		},
+		{
+			Name: "gcp pub/sub",
+			Config: func(c *Config) {
+				c.Integration.Type = "gcp_pub_sub"
+				c.Integration.GCPPubSub.ProjectID = "test-project"
+				c.Integration.GCPPubSub.EventTopicName = "events"
+				c.Integration.GCPPubSub.PublishTimeout = time.Second
+			},
+			ExpectedError: "invalid configuration: integration.gcp_pub_sub.command_subscription_name: must be set",
+		},
+		{
+			Name: "dry run without broker",
+			Config: func(c *Config) {
+				c.Integration.DryRun = true
+				c.Integration.MQTT.Auth.Generic.Servers = nil
+			},
+		},
+		{
+			Name: "negative semtech udp workers",
+			Config: func(c *Config) {
+				c.Backend.SemtechUDP.Workers = -1
+			},
+			ExpectedError: "invalid configuration: backend.semtech_udp.workers: must not be negative",
+		},
+		{
+			Name: "semtech udp gateway timeout not set",
+			Config: func(c *Config) {
+				c.Backend.SemtechUDP.GatewayTimeout = 0
+			},
+			ExpectedError: "invalid configuration: backend.semtech_udp.gateway_timeout: must be greater than 0",
+		},
+		{
+			Name: "basic station tls files",
+			Config: func(c *Config) {
+				c.Backend.Type = "basic_station"
+				c.Backend.BasicStation.TLSCert = "/does/not/exist.pem"
+			},
+			ExpectedError: "invalid configuration: backend.basic_station.tls_cert: open /does/not/exist.pem: no such file or directory",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			c := valid()
+			tst.Config(&c)
+			err := c.Validate()
+			if tst.ExpectedError == "" {
+				assert.NoError(err)
+			} else {
+				assert.EqualError(err, tst.ExpectedError)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+// Package diagnostics implements a point-in-time snapshot of the internal
+// state of the bridge (e.g. for support tickets), containing the connected
+// gateways, the counters, the last errors and the (redacted) configuration.
+package diagnostics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/status"
+	"github.com/brocaar/lorawan"
+)
+
+// redacted replaces the value of secret config options.
+const redacted = "<redacted>"
+
+// secretKeys contains the config keys of which the values are redacted.
+var secretKeys = map[string]struct{}{
+	"password":                 {},
+	"token":                    {},
+	"tls_key_password":         {},
+	"device_connection_string": {},
+	"http_headers":             {},
+}
+
+// Snapshot contains the internal state of the bridge.
+type Snapshot struct {
+	Time       time.Time                   `json:"time"`
+	Gateways   []Gateway                   `json:"gateways"`
+	Counters   map[string]float64          `json:"counters"`
+	LastErrors map[string]status.LastError `json:"last_errors"`
+	Config     map[string]interface{}      `json:"config"`
+}
+
+// Gateway contains the state of a gateway.
+type Gateway struct {
+	GatewayID lorawan.EUI64 `json:"gateway_id"`
+	Connected bool          `json:"connected"`
+	LastSeen  *time.Time    `json:"last_seen"`
+}
+
+var (
+	mux      sync.RWMutex
+	conf     config.Config
+	gateways map[lorawan.EUI64]*Gateway
+)
+
+// Setup configures the diagnostics package.
+func Setup(c config.Config) error {
+	mux.Lock()
+	defer mux.Unlock()
+
+	conf = c
+	gateways = make(map[lorawan.EUI64]*Gateway)
+
+	return nil
+}
+
+// GatewaySubscription updates the connection state of the given gateway.
+func GatewaySubscription(subscribe bool, gatewayID lorawan.EUI64) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	getGateway(gatewayID).Connected = subscribe
+}
+
+// GatewaySeen updates the last seen timestamp of the given gateway.
+func GatewaySeen(gatewayID lorawan.EUI64) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	now := time.Now().UTC()
+	getGateway(gatewayID).LastSeen = &now
+}
+
+// GetSnapshot returns the snapshot of the internal state.
+func GetSnapshot() (Snapshot, error) {
+	counters, err := getCounters(prometheus.DefaultGatherer)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	mux.RLock()
+	defer mux.RUnlock()
+
+	snapshot := Snapshot{
+		Time:       time.Now().UTC(),
+		Gateways:   make([]Gateway, 0, len(gateways)),
+		Counters:   counters,
+		LastErrors: status.GetLastErrors(),
+		Config:     configMap(reflect.ValueOf(conf), "").(map[string]interface{}),
+	}
+
+	for _, gw := range gateways {
+		snapshot.Gateways = append(snapshot.Gateways, *gw)
+	}
+	sort.Slice(snapshot.Gateways, func(i, j int) bool {
+		return snapshot.Gateways[i].GatewayID.String() < snapshot.Gateways[j].GatewayID.String()
+	})
+
+	return snapshot, nil
+}
+
+// Handler returns the HTTP handler for the /diagnostics endpoint.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snapshot, err := GetSnapshot()
+		if err != nil {
+			log.WithError(err).Error("diagnostics: get snapshot error")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+			log.WithError(err).Error("diagnostics: encode snapshot error")
+		}
+	})
+}
+
+// getGateway returns the state of the given gateway.
+// Note: the caller must hold the lock.
+func getGateway(gatewayID lorawan.EUI64) *Gateway {
+	gw, ok := gateways[gatewayID]
+	if !ok {
+		gw = &Gateway{GatewayID: gatewayID}
+		gateways[gatewayID] = gw
+	}
+	return gw
+}
+
+// getCounters returns the values of the counters and gauges of the given
+// gatherer, by name (including the labels).
+func getCounters(g prometheus.Gatherer) (map[string]float64, error) {
+	mfs, err := g.Gather()
+	if err != nil {
+		return nil, fmt.Errorf("gather metrics error: %s", err)
+	}
+
+	out := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+			}
+
+			name := mf.GetName()
+			if len(labels) != 0 {
+				name = fmt.Sprintf("%s{%s}", name, strings.Join(labels, ","))
+			}
+
+			switch {
+			case m.GetCounter() != nil:
+				out[name] = m.GetCounter().GetValue()
+			case m.GetGauge() != nil:
+				out[name] = m.GetGauge().GetValue()
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// configMap converts the given config value into a map (for structs), using
+// the mapstructure keys. The values of the secret keys (and of the secret
+// maps) are redacted and the options that are not configurable
+// (mapstructure "-") are omitted.
+func configMap(v reflect.Value, key string) interface{} {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		out := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+
+			name := strings.Split(f.Tag.Get("mapstructure"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+
+			out[name] = configMap(v.Field(i), name)
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		out := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out[i] = configMap(v.Index(i), key)
+		}
+		return out
+	case reflect.Map:
+		if _, ok := secretKeys[key]; ok && v.Len() != 0 {
+			out := make(map[string]interface{})
+			for _, k := range v.MapKeys() {
+				out[fmt.Sprint(k.Interface())] = redacted
+			}
+			return out
+		}
+	case reflect.String:
+		if _, ok := secretKeys[key]; ok && v.String() != "" {
+			return redacted
+		}
+	}
+
+	return v.Interface()
+}
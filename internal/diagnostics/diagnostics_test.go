@@ -0,0 +1,121 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/status"
+	"github.com/brocaar/lorawan"
+)
+
+func TestSnapshot(t *testing.T) {
+	assert := require.New(t)
+
+	var conf config.Config
+	conf.Backend.Type = "semtech_udp"
+	conf.Integration.MQTT.KeepAlive = 30 * time.Second
+	conf.Integration.MQTT.Auth.Generic.Servers = []string{"tcp://127.0.0.1:1883"}
+	conf.Integration.MQTT.Auth.Generic.Username = "bridge"
+	conf.Integration.MQTT.Auth.Generic.Password = "mqtt-secret"
+	conf.Integration.MQTT.Auth.Generic.Fallback.Password = "fallback-secret"
+	conf.Integration.MQTT.Auth.Generic.HTTPHeaders = map[string]string{"x-auth-token": "header-secret"}
+	conf.Integration.MQTT.Auth.AzureIoTHub.DeviceConnectionString = "HostName=foo;SharedAccessKey=azure-secret"
+	conf.Integration.MQTT.Auth.AzureIoTHub.DeviceKey = "device-key-secret"
+	conf.DownlinkInjection.Token = "injection-secret"
+	assert.NoError(Setup(conf))
+
+	connectedID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	disconnectedID := lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}
+
+	GatewaySubscription(true, connectedID)
+	GatewaySeen(connectedID)
+	GatewaySubscription(true, disconnectedID)
+	GatewaySubscription(false, disconnectedID)
+
+	status.SetLastError(status.UDP, errors.New("read udp error"))
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/diagnostics", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+
+	body, err := ioutil.ReadAll(w.Body)
+	assert.NoError(err)
+
+	var snapshot Snapshot
+	assert.NoError(json.Unmarshal(body, &snapshot))
+
+	t.Run("Sections", func(t *testing.T) {
+		assert := require.New(t)
+
+		var sections map[string]json.RawMessage
+		assert.NoError(json.Unmarshal(body, &sections))
+		for _, s := range []string{"time", "gateways", "counters", "last_errors", "config"} {
+			assert.Contains(sections, s)
+		}
+		assert.WithinDuration(time.Now(), snapshot.Time, time.Second)
+	})
+
+	t.Run("Gateways", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.Len(snapshot.Gateways, 2)
+		assert.Equal(connectedID, snapshot.Gateways[0].GatewayID)
+		assert.True(snapshot.Gateways[0].Connected)
+		assert.NotNil(snapshot.Gateways[0].LastSeen)
+		assert.WithinDuration(time.Now(), *snapshot.Gateways[0].LastSeen, time.Second)
+
+		assert.Equal(disconnectedID, snapshot.Gateways[1].GatewayID)
+		assert.False(snapshot.Gateways[1].Connected)
+		assert.Nil(snapshot.Gateways[1].LastSeen)
+	})
+
+	t.Run("Counters", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Contains(snapshot.Counters, "go_goroutines")
+	})
+
+	t.Run("Last errors", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal("read udp error", snapshot.LastErrors[status.UDP].Error)
+	})
+
+	t.Run("Config", func(t *testing.T) {
+		assert := require.New(t)
+
+		backend := snapshot.Config["backend"].(map[string]interface{})
+		assert.Equal("semtech_udp", backend["type"])
+
+		mqtt := snapshot.Config["integration"].(map[string]interface{})["mqtt"].(map[string]interface{})
+		assert.Equal("30s", mqtt["keep_alive"])
+
+		generic := mqtt["auth"].(map[string]interface{})["generic"].(map[string]interface{})
+		assert.Equal([]interface{}{"tcp://127.0.0.1:1883"}, generic["servers"])
+		assert.Equal("bridge", generic["username"])
+		assert.Equal(redacted, generic["password"])
+		assert.Equal(redacted, generic["fallback"].(map[string]interface{})["password"])
+		assert.Equal(map[string]interface{}{"x-auth-token": redacted}, generic["http_headers"])
+
+		azure := mqtt["auth"].(map[string]interface{})["azure_iot_hub"].(map[string]interface{})
+		assert.Equal(redacted, azure["device_connection_string"])
+		assert.NotContains(azure, "devicekey")
+
+		assert.Equal(redacted, snapshot.Config["downlink_injection"].(map[string]interface{})["token"])
+	})
+
+	t.Run("Secrets are redacted", func(t *testing.T) {
+		assert := require.New(t)
+
+		for _, secret := range []string{"mqtt-secret", "fallback-secret", "azure-secret", "device-key-secret", "injection-secret", "header-secret"} {
+			assert.NotContains(string(body), secret)
+		}
+	})
+}
@@ -0,0 +1,161 @@
+// Package downlinkinjection implements an (authenticated) HTTP endpoint to
+// inject downlink frames, as if they were received from the integration
+// (e.g. for testing and manual operations).
+package downlinkinjection
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+var (
+	mux               sync.RWMutex
+	token             string
+	downlinkFrameFunc func(gw.DownlinkFrame)
+)
+
+// Setup configures the downlink injection package. When a bind is
+// configured, the HTTP injection endpoint is started.
+func Setup(conf config.Config) error {
+	if conf.DownlinkInjection.Bind == "" {
+		return nil
+	}
+
+	if conf.DownlinkInjection.Token == "" {
+		return errors.New("downlink injection token must be set")
+	}
+
+	mux.Lock()
+	token = conf.DownlinkInjection.Token
+	mux.Unlock()
+
+	log.WithFields(log.Fields{
+		"bind": conf.DownlinkInjection.Bind,
+	}).Info("downlinkinjection: starting downlink injection server")
+
+	server := http.Server{
+		Handler: Handler(),
+		Addr:    conf.DownlinkInjection.Bind,
+	}
+
+	go func() {
+		err := server.ListenAndServe()
+		log.WithError(err).Error("downlinkinjection: downlink injection server error")
+	}()
+
+	return nil
+}
+
+// SetDownlinkFrameFunc sets the function to which the injected downlink
+// frames are passed.
+func SetDownlinkFrameFunc(f func(gw.DownlinkFrame)) {
+	mux.Lock()
+	defer mux.Unlock()
+	downlinkFrameFunc = f
+}
+
+// Handler returns the HTTP handler of the downlink injection endpoint:
+//
+//	POST /downlink injects the (JSON encoded) downlink frame
+//
+// Requests must be authenticated using the "Authorization: Bearer <token>"
+// header. When the downlink frame does not have a downlink ID, a random ID
+// is assigned. The downlink ID is returned in the response.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Trim(r.URL.Path, "/") != "downlink" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		mux.RLock()
+		t := token
+		f := downlinkFrameFunc
+		mux.RUnlock()
+
+		if !authenticated(r, t) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if f == nil {
+			http.Error(w, "downlink handler is not set", http.StatusServiceUnavailable)
+			return
+		}
+
+		var pl gw.DownlinkFrame
+		unmarshaler := jsonpb.Unmarshaler{
+			AllowUnknownFields: true,
+		}
+		if err := unmarshaler.Unmarshal(r.Body, &pl); err != nil {
+			http.Error(w, "invalid downlink frame: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if len(pl.GetGatewayId()) != len(lorawan.EUI64{}) {
+			http.Error(w, "invalid gateway id", http.StatusBadRequest)
+			return
+		}
+
+		if len(pl.GetItems()) == 0 {
+			http.Error(w, "downlink frame has no items", http.StatusBadRequest)
+			return
+		}
+
+		if len(pl.GetDownlinkId()) == 0 {
+			id, err := uuid.NewV4()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			pl.DownlinkId = id[:]
+		}
+
+		var gatewayID lorawan.EUI64
+		var downID uuid.UUID
+		copy(gatewayID[:], pl.GetGatewayId())
+		copy(downID[:], pl.GetDownlinkId())
+
+		log.WithFields(log.Fields{
+			"gateway_id":  gatewayID,
+			"downlink_id": downID,
+			"remote_addr": r.RemoteAddr,
+		}).Info("downlinkinjection: downlink frame injected")
+
+		injectedCounter().Inc()
+		f(pl)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(map[string]string{"downlinkID": downID.String()}); err != nil {
+			log.WithError(err).Error("downlinkinjection: encode response error")
+		}
+	})
+}
+
+// authenticated returns true when the request contains the given bearer
+// token.
+func authenticated(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+}
@@ -0,0 +1,113 @@
+package downlinkinjection
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+)
+
+func TestDownlinkInjection(t *testing.T) {
+	assert := require.New(t)
+
+	var conf config.Config
+	conf.DownlinkInjection.Bind = "127.0.0.1:0"
+	assert.EqualError(Setup(conf), "downlink injection token must be set")
+
+	mux.Lock()
+	token = "secret"
+	mux.Unlock()
+
+	frames := make(chan gw.DownlinkFrame, 1)
+	SetDownlinkFrameFunc(func(pl gw.DownlinkFrame) {
+		frames <- pl
+	})
+	defer SetDownlinkFrameFunc(nil)
+
+	request := func(method, path, auth, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	downlink := `{
+		"gatewayID": "AQIDBAUGBwg=",
+		"token": 1234,
+		"items": [{
+			"phyPayload": "AQID",
+			"txInfo": {
+				"frequency": 868100000,
+				"power": 14,
+				"modulation": "LORA",
+				"loraModulationInfo": {"bandwidth": 125, "spreadingFactor": 7, "codeRate": "4/5"},
+				"timing": "IMMEDIATELY"
+			}
+		}]
+	}`
+
+	t.Run("Inject", func(t *testing.T) {
+		assert := require.New(t)
+
+		w := request(http.MethodPost, "/downlink", "Bearer secret", downlink)
+		assert.Equal(http.StatusAccepted, w.Code)
+
+		var resp map[string]string
+		assert.NoError(json.NewDecoder(w.Body).Decode(&resp))
+
+		pl := <-frames
+		assert.Equal([]byte{1, 2, 3, 4, 5, 6, 7, 8}, pl.GatewayId)
+		assert.Equal(uint32(1234), pl.Token)
+		assert.Len(pl.Items, 1)
+		assert.Equal([]byte{1, 2, 3}, pl.Items[0].PhyPayload)
+		assert.Equal(uint32(868100000), pl.Items[0].GetTxInfo().Frequency)
+		assert.Equal(gw.DownlinkTiming_IMMEDIATELY, pl.Items[0].GetTxInfo().Timing)
+
+		var downID uuid.UUID
+		copy(downID[:], pl.DownlinkId)
+		assert.NotEqual(uuid.Nil, downID)
+		assert.Equal(downID.String(), resp["downlinkID"])
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		tests := []struct {
+			Name string
+			Auth string
+		}{
+			{"no authorization header", ""},
+			{"invalid token", "Bearer foo"},
+			{"invalid scheme", "Basic secret"},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.Name, func(t *testing.T) {
+				assert := require.New(t)
+
+				w := request(http.MethodPost, "/downlink", tst.Auth, downlink)
+				assert.Equal(http.StatusUnauthorized, w.Code)
+				assert.Len(frames, 0)
+			})
+		}
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.Equal(http.StatusBadRequest, request(http.MethodPost, "/downlink", "Bearer secret", "foo").Code)
+		assert.Equal(http.StatusBadRequest, request(http.MethodPost, "/downlink", "Bearer secret", `{"gatewayID": "AQID", "items": [{}]}`).Code)
+		assert.Equal(http.StatusBadRequest, request(http.MethodPost, "/downlink", "Bearer secret", `{"gatewayID": "AQIDBAUGBwg="}`).Code)
+		assert.Equal(http.StatusMethodNotAllowed, request(http.MethodGet, "/downlink", "Bearer secret", "").Code)
+		assert.Equal(http.StatusNotFound, request(http.MethodPost, "/foo", "Bearer secret", downlink).Code)
+		assert.Len(frames, 0)
+	})
+}
@@ -0,0 +1,17 @@
+package downlinkinjection
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	dic = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "downlinkinjection_injected_count",
+		Help: "The number of downlink frames injected using the downlink injection endpoint.",
+	})
+)
+
+func injectedCounter() prometheus.Counter {
+	return dic
+}
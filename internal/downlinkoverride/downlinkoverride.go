@@ -0,0 +1,109 @@
+// Package downlinkoverride implements the override of the TX parameters
+// (frequency, power and LoRa data-rate) of the downlinks before these are
+// sent to the gateway. This is intended for lab and certification testing,
+// e.g. to force all downlinks on a test channel.
+package downlinkoverride
+
+import (
+	"sync"
+
+	"github.com/gofrs/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+var (
+	mux sync.RWMutex
+
+	// overrides, 0 = disabled
+	frequency       uint32
+	maxPower        int32
+	spreadingFactor uint32
+	bandwidth       uint32
+)
+
+// Setup configures the downlinkoverride package.
+func Setup(conf config.Config) error {
+	mux.Lock()
+	defer mux.Unlock()
+
+	frequency = conf.DownlinkOverride.Frequency
+	maxPower = int32(conf.DownlinkOverride.MaxPower)
+	spreadingFactor = conf.DownlinkOverride.SpreadingFactor
+	bandwidth = conf.DownlinkOverride.Bandwidth
+
+	if frequency != 0 || maxPower != 0 || spreadingFactor != 0 || bandwidth != 0 {
+		log.WithFields(log.Fields{
+			"frequency":        frequency,
+			"max_power":        maxPower,
+			"spreading_factor": spreadingFactor,
+			"bandwidth":        bandwidth,
+		}).Warning("downlinkoverride: downlink tx parameters are overridden, use for testing only")
+	}
+
+	return nil
+}
+
+// DownlinkFrame applies the configured overrides to the TX info of each
+// item of the given downlink frame. Each modified item is logged.
+func DownlinkFrame(df *gw.DownlinkFrame) {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	if frequency == 0 && maxPower == 0 && spreadingFactor == 0 && bandwidth == 0 {
+		return
+	}
+
+	var gatewayID lorawan.EUI64
+	var downID uuid.UUID
+	copy(gatewayID[:], df.GetGatewayId())
+	copy(downID[:], df.GetDownlinkId())
+
+	for i, item := range df.GetItems() {
+		txInfo := item.GetTxInfo()
+		if txInfo == nil {
+			continue
+		}
+
+		fields := make(log.Fields)
+
+		if frequency != 0 && txInfo.Frequency != frequency {
+			fields["frequency"] = txInfo.Frequency
+			txInfo.Frequency = frequency
+		}
+
+		if maxPower != 0 && txInfo.Power > maxPower {
+			fields["power"] = txInfo.Power
+			txInfo.Power = maxPower
+		}
+
+		if modInfo := txInfo.GetLoraModulationInfo(); modInfo != nil {
+			if spreadingFactor != 0 && modInfo.SpreadingFactor != spreadingFactor {
+				fields["spreading_factor"] = modInfo.SpreadingFactor
+				modInfo.SpreadingFactor = spreadingFactor
+			}
+
+			if bandwidth != 0 && modInfo.Bandwidth != bandwidth {
+				fields["bandwidth"] = modInfo.Bandwidth
+				modInfo.Bandwidth = bandwidth
+			}
+		}
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		for field := range fields {
+			overrideCounter(field).Inc()
+		}
+
+		log.WithFields(fields).WithFields(log.Fields{
+			"gateway_id":  gatewayID,
+			"downlink_id": downID,
+			"item":        i,
+		}).Info("downlinkoverride: downlink tx parameters overridden (original values logged)")
+	}
+}
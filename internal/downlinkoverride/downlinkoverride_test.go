@@ -0,0 +1,84 @@
+package downlinkoverride
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+)
+
+func TestDownlinkFrame(t *testing.T) {
+	downlink := func() gw.DownlinkFrame {
+		return gw.DownlinkFrame{
+			GatewayId: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			Items: []*gw.DownlinkFrameItem{
+				{
+					PhyPayload: []byte{1, 2, 3, 4},
+					TxInfo: &gw.DownlinkTXInfo{
+						Frequency: 868100000,
+						Power:     27,
+						ModulationInfo: &gw.DownlinkTXInfo_LoraModulationInfo{
+							LoraModulationInfo: &gw.LoRaModulationInfo{
+								Bandwidth:       125,
+								SpreadingFactor: 7,
+							},
+						},
+					},
+				},
+				{
+					PhyPayload: []byte{1, 2, 3, 4},
+					TxInfo: &gw.DownlinkTXInfo{
+						Frequency: 869525000,
+						Power:     10,
+						ModulationInfo: &gw.DownlinkTXInfo_FskModulationInfo{
+							FskModulationInfo: &gw.FSKModulationInfo{
+								Datarate: 50000,
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(Setup(config.Config{}))
+
+		df := downlink()
+		DownlinkFrame(&df)
+		expected := downlink()
+		assert.True(proto.Equal(&expected, &df))
+	})
+
+	t.Run("Overrides", func(t *testing.T) {
+		assert := require.New(t)
+
+		var conf config.Config
+		conf.DownlinkOverride.Frequency = 867100000
+		conf.DownlinkOverride.MaxPower = 14
+		conf.DownlinkOverride.SpreadingFactor = 12
+		conf.DownlinkOverride.Bandwidth = 250
+		assert.NoError(Setup(conf))
+		defer Setup(config.Config{})
+
+		df := downlink()
+		DownlinkFrame(&df)
+
+		lora := df.Items[0].TxInfo
+		assert.EqualValues(867100000, lora.Frequency)
+		assert.EqualValues(14, lora.Power)
+		assert.EqualValues(12, lora.GetLoraModulationInfo().SpreadingFactor)
+		assert.EqualValues(250, lora.GetLoraModulationInfo().Bandwidth)
+
+		// the power is only clamped and the data-rate only applies to LoRa
+		fsk := df.Items[1].TxInfo
+		assert.EqualValues(867100000, fsk.Frequency)
+		assert.EqualValues(10, fsk.Power)
+		assert.EqualValues(50000, fsk.GetFskModulationInfo().Datarate)
+	})
+}
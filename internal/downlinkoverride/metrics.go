@@ -0,0 +1,17 @@
+package downlinkoverride
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	oc = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "downlinkoverride_count",
+		Help: "The number of downlink items of which a tx parameter has been overridden (per parameter).",
+	}, []string{"parameter"})
+)
+
+func overrideCounter(parameter string) prometheus.Counter {
+	return oc.With(prometheus.Labels{"parameter": parameter})
+}
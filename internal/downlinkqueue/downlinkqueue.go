@@ -0,0 +1,75 @@
+// Package downlinkqueue implements a per-gateway queue, making sure that the
+// immediate downlinks (e.g. Class-C) are sent to a gateway in the order in
+// which they were received. Downlinks to different gateways are sent in
+// parallel.
+package downlinkqueue
+
+import (
+	"sync"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/lorawan"
+)
+
+var (
+	mux sync.Mutex
+
+	// gateway ID to the downlinks waiting to be sent, a gateway is only
+	// present while its downlinks are being sent
+	pending = make(map[lorawan.EUI64][]gw.DownlinkFrame)
+)
+
+// Enqueue passes the given downlink frame to the given function. Immediate
+// downlinks are passed one at a time per gateway, in the order in which they
+// were enqueued. Scheduled downlinks (delay or GPS epoch timing) are passed
+// directly, as the gateway transmits these at the scheduled time,
+// regardless the order in which they are received.
+func Enqueue(pl gw.DownlinkFrame, f func(gw.DownlinkFrame)) {
+	if !immediate(pl) {
+		go f(pl)
+		return
+	}
+
+	var gatewayID lorawan.EUI64
+	copy(gatewayID[:], pl.GetGatewayId())
+
+	mux.Lock()
+	if queue, ok := pending[gatewayID]; ok {
+		pending[gatewayID] = append(queue, pl)
+		mux.Unlock()
+		return
+	}
+	pending[gatewayID] = nil
+	mux.Unlock()
+
+	go run(gatewayID, pl, f)
+}
+
+// run passes the given downlink and the queued downlinks of the gateway to
+// the given function, until the queue is empty.
+func run(gatewayID lorawan.EUI64, pl gw.DownlinkFrame, f func(gw.DownlinkFrame)) {
+	for {
+		f(pl)
+
+		mux.Lock()
+		queue := pending[gatewayID]
+		if len(queue) == 0 {
+			delete(pending, gatewayID)
+			mux.Unlock()
+			return
+		}
+		pl = queue[0]
+		pending[gatewayID] = queue[1:]
+		mux.Unlock()
+	}
+}
+
+// immediate returns true when the given downlink must be sent immediately.
+// The timing of the first item is used, the other items are only used when
+// the first item can't be sent.
+func immediate(pl gw.DownlinkFrame) bool {
+	if len(pl.GetItems()) == 0 {
+		return pl.GetTxInfo().GetTiming() == gw.DownlinkTiming_IMMEDIATELY
+	}
+	return pl.GetItems()[0].GetTxInfo().GetTiming() == gw.DownlinkTiming_IMMEDIATELY
+}
@@ -0,0 +1,85 @@
+package downlinkqueue
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+)
+
+func downlink(gatewayID byte, token uint32, timing gw.DownlinkTiming) gw.DownlinkFrame {
+	return gw.DownlinkFrame{
+		GatewayId: []byte{gatewayID, 2, 3, 4, 5, 6, 7, 8},
+		Token:     token,
+		Items: []*gw.DownlinkFrameItem{
+			{TxInfo: &gw.DownlinkTXInfo{Timing: timing}},
+		},
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	t.Run("Immediate downlinks are sent in order", func(t *testing.T) {
+		assert := require.New(t)
+
+		var tokensMux sync.Mutex
+		var wg sync.WaitGroup
+		tokens := make(map[byte][]uint32)
+
+		f := func(pl gw.DownlinkFrame) {
+			time.Sleep(time.Millisecond)
+
+			tokensMux.Lock()
+			tokens[pl.GatewayId[0]] = append(tokens[pl.GatewayId[0]], pl.Token)
+			tokensMux.Unlock()
+
+			wg.Done()
+		}
+
+		var expected []uint32
+		for i := uint32(0); i < 20; i++ {
+			wg.Add(2)
+			Enqueue(downlink(1, i, gw.DownlinkTiming_IMMEDIATELY), f)
+			Enqueue(downlink(2, i, gw.DownlinkTiming_IMMEDIATELY), f)
+			expected = append(expected, i)
+		}
+		wg.Wait()
+
+		assert.Equal(expected, tokens[1])
+		assert.Equal(expected, tokens[2])
+
+		// the queues are removed once empty
+		assert.Eventually(func() bool {
+			mux.Lock()
+			defer mux.Unlock()
+			return len(pending) == 0
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("Scheduled downlinks are not queued", func(t *testing.T) {
+		assert := require.New(t)
+
+		block := make(chan struct{})
+		defer close(block)
+
+		sent := make(chan uint32, 2)
+		f := func(pl gw.DownlinkFrame) {
+			if pl.Token == 1 {
+				<-block
+			}
+			sent <- pl.Token
+		}
+
+		Enqueue(downlink(3, 1, gw.DownlinkTiming_IMMEDIATELY), f)
+		Enqueue(downlink(3, 2, gw.DownlinkTiming_DELAY), f)
+
+		select {
+		case token := <-sent:
+			assert.Equal(uint32(2), token)
+		case <-time.After(time.Second):
+			t.Fatal("expected scheduled downlink to be sent")
+		}
+	})
+}
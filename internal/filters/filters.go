@@ -2,6 +2,7 @@ package filters
 
 import (
 	"encoding/binary"
+	"sync"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -13,6 +14,16 @@ import (
 var netIDs []lorawan.NetID
 var joinEUIs [][2]lorawan.EUI64
 
+// gatewayAllow and gatewayDeny contain the allowed and denied gateway IDs.
+// When the allow list is set, the deny list is ignored.
+var gatewayAllow map[lorawan.EUI64]struct{}
+var gatewayDeny map[lorawan.EUI64]struct{}
+
+// gatewayDroppedLogged contains the gateway IDs of which a dropped event has
+// been logged, so that this is logged only once per gateway.
+var gatewayDroppedMux sync.Mutex
+var gatewayDroppedLogged = make(map[lorawan.EUI64]struct{})
+
 // Setup configures the filters package.
 func Setup(conf config.Config) error {
 	for _, netIDStr := range conf.Filters.NetIDs {
@@ -47,9 +58,81 @@ func Setup(conf config.Config) error {
 		}).Info("filters: JoinEUI range configured")
 	}
 
+	gatewayAllow = nil
+	gatewayDeny = nil
+
+	for _, idStr := range conf.Filters.GatewayFilterAllow {
+		var gatewayID lorawan.EUI64
+		if err := gatewayID.UnmarshalText([]byte(idStr)); err != nil {
+			return errors.Wrap(err, "unmarshal allowed gateway ID error")
+		}
+
+		if gatewayAllow == nil {
+			gatewayAllow = make(map[lorawan.EUI64]struct{})
+		}
+		gatewayAllow[gatewayID] = struct{}{}
+	}
+
+	for _, idStr := range conf.Filters.GatewayFilterDeny {
+		var gatewayID lorawan.EUI64
+		if err := gatewayID.UnmarshalText([]byte(idStr)); err != nil {
+			return errors.Wrap(err, "unmarshal denied gateway ID error")
+		}
+
+		if gatewayDeny == nil {
+			gatewayDeny = make(map[lorawan.EUI64]struct{})
+		}
+		gatewayDeny[gatewayID] = struct{}{}
+	}
+
+	if len(gatewayAllow) != 0 {
+		log.WithFields(log.Fields{
+			"gateways": len(gatewayAllow),
+		}).Info("filters: gateway allow list configured")
+
+		if len(gatewayDeny) != 0 {
+			log.Warning("filters: gateway allow list is configured, the gateway deny list is ignored")
+		}
+	} else if len(gatewayDeny) != 0 {
+		log.WithFields(log.Fields{
+			"gateways": len(gatewayDeny),
+		}).Info("filters: gateway deny list configured")
+	}
+
 	return nil
 }
 
+// MatchGateway returns true when the events of the given gateway must be
+// forwarded. When an allow list is configured, only the allowed gateways are
+// forwarded (the deny list is then ignored). Else all gateways are forwarded,
+// except for the denied gateways. The first dropped event of each gateway is
+// logged.
+func MatchGateway(gatewayID lorawan.EUI64) bool {
+	var ok bool
+	if len(gatewayAllow) != 0 {
+		_, ok = gatewayAllow[gatewayID]
+	} else {
+		_, denied := gatewayDeny[gatewayID]
+		ok = !denied
+	}
+
+	if ok {
+		return true
+	}
+
+	gatewayDroppedMux.Lock()
+	defer gatewayDroppedMux.Unlock()
+
+	if _, logged := gatewayDroppedLogged[gatewayID]; !logged {
+		gatewayDroppedLogged[gatewayID] = struct{}{}
+		log.WithFields(log.Fields{
+			"gateway_id": gatewayID,
+		}).Warning("filters: gateway is not allowed, dropping its events")
+	}
+
+	return false
+}
+
 // MatchFilters will match the given LoRaWAN frame against the configured
 // filters. This function returns true in the following cases:
 // * If the PHYPayload matches the configured filters
@@ -234,3 +234,60 @@ func TestFilters(t *testing.T) {
 		})
 	}
 }
+
+func TestMatchGateway(t *testing.T) {
+	allowed := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	denied := lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}
+	unknown := lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1}
+
+	tests := []struct {
+		Name     string
+		Allow    []string
+		Deny     []string
+		Expected map[lorawan.EUI64]bool
+	}{
+		{
+			Name:     "no lists",
+			Expected: map[lorawan.EUI64]bool{allowed: true, denied: true, unknown: true},
+		},
+		{
+			Name:     "allow list",
+			Allow:    []string{allowed.String()},
+			Expected: map[lorawan.EUI64]bool{allowed: true, denied: false, unknown: false},
+		},
+		{
+			Name:     "deny list",
+			Deny:     []string{denied.String()},
+			Expected: map[lorawan.EUI64]bool{allowed: true, denied: false, unknown: true},
+		},
+		{
+			Name:     "allow list takes precedence",
+			Allow:    []string{allowed.String(), denied.String()},
+			Deny:     []string{denied.String()},
+			Expected: map[lorawan.EUI64]bool{allowed: true, denied: true, unknown: false},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			var conf config.Config
+			conf.Filters.GatewayFilterAllow = tst.Allow
+			conf.Filters.GatewayFilterDeny = tst.Deny
+			assert.NoError(Setup(conf))
+
+			for gatewayID, expected := range tst.Expected {
+				assert.Equal(expected, MatchGateway(gatewayID), gatewayID.String())
+			}
+		})
+	}
+
+	t.Run("invalid gateway ID", func(t *testing.T) {
+		assert := require.New(t)
+
+		var conf config.Config
+		conf.Filters.GatewayFilterAllow = []string{"foo"}
+		assert.Error(Setup(conf))
+	})
+}
@@ -6,16 +6,34 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/ackaggregation"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/backend"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/backend/events"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/capabilities"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/coalesce"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/diagnostics"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/downlinkinjection"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/downlinkoverride"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/downlinkqueue"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/filters"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/gatewaycontrol"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/inflight"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/integration"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/metadata"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/ratelimit"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/status"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/validation"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/virtualgateway"
 	"github.com/brocaar/lorawan"
 )
 
+var configAck bool
+
 // Setup configures the forwarder.
 func Setup(conf config.Config) error {
+	configAck = conf.Integration.ConfigAck
+
 	b := backend.GetBackend()
 	i := integration.GetIntegration()
 
@@ -39,10 +57,24 @@ func Setup(conf config.Config) error {
 	i.SetGatewayConfigurationFunc(gatewayConfigurationFunc)
 	i.SetRawPacketForwarderCommandFunc(rawPacketForwarderCommandFunc)
 
+	// injected downlinks are handled as downlinks from the integration
+	downlinkinjection.SetDownlinkFrameFunc(downlinkFrameFunc)
+
 	return nil
 }
 
 func gatewaySubscribeFunc(pl events.Subscribe) {
+	if !filters.MatchGateway(pl.GatewayID) {
+		return
+	}
+
+	diagnostics.GatewaySubscription(pl.Subscribe, pl.GatewayID)
+
+	pl, ok := virtualgateway.Subscribe(pl)
+	if !ok {
+		return
+	}
+
 	go func(pl events.Subscribe) {
 		if err := integration.GetIntegration().SetGatewaySubscription(pl.Subscribe, pl.GatewayID); err != nil {
 			log.WithError(err).Error("set gateway subscription error")
@@ -57,6 +89,52 @@ func uplinkFrameFunc(pl gw.UplinkFrame) {
 		copy(gatewayID[:], pl.GetRxInfo().GatewayId)
 		copy(uplinkID[:], pl.GetRxInfo().UplinkId)
 
+		if !filters.MatchGateway(gatewayID) {
+			return
+		}
+
+		diagnostics.GatewaySeen(gatewayID)
+
+		if gatewaycontrol.DropEvent(gatewayID, integration.EventUp) {
+			log.WithFields(log.Fields{
+				"gateway_id": gatewayID,
+				"uplink_id":  uplinkID,
+			}).Info("uplink dropped, gateway is disabled")
+			return
+		}
+
+		if !ratelimit.Uplink(gatewayID) {
+			return
+		}
+
+		if !validation.UplinkFrame(&pl) {
+			return
+		}
+
+		capabilities.UplinkFrame(pl)
+
+		if !virtualgateway.UplinkFrame(&pl) {
+			log.WithFields(log.Fields{
+				"gateway_id": gatewayID,
+				"uplink_id":  uplinkID,
+			}).Debug("uplink dropped, duplicate for virtual gateway")
+			return
+		}
+		copy(gatewayID[:], pl.GetRxInfo().GatewayId)
+
+		if coalesce.Enabled() {
+			coalesce.UplinkFrame(pl, func(set gw.UplinkFrameSet) {
+				if err := integration.GetIntegration().PublishEvent(gatewayID, integration.EventUpSet, uplinkID, &set); err != nil {
+					log.WithError(err).WithFields(log.Fields{
+						"gateway_id": gatewayID,
+						"event_type": integration.EventUpSet,
+						"uplink_id":  uplinkID,
+					}).Error("publish event error")
+				}
+			})
+			return
+		}
+
 		if err := integration.GetIntegration().PublishEvent(gatewayID, integration.EventUp, uplinkID, &pl); err != nil {
 			log.WithError(err).WithFields(log.Fields{
 				"gateway_id": gatewayID,
@@ -69,6 +147,24 @@ func uplinkFrameFunc(pl gw.UplinkFrame) {
 
 func gatewayStatsFunc(pl gw.GatewayStats) {
 	go func(pl gw.GatewayStats) {
+		var physicalID lorawan.EUI64
+		copy(physicalID[:], pl.GatewayId)
+
+		if !filters.MatchGateway(physicalID) {
+			return
+		}
+
+		diagnostics.GatewaySeen(physicalID)
+
+		if gatewaycontrol.DropEvent(physicalID, integration.EventStats) {
+			log.WithFields(log.Fields{
+				"gateway_id": physicalID,
+			}).Info("stats dropped, gateway is disabled")
+			return
+		}
+
+		virtualgateway.GatewayStats(&pl)
+
 		var gatewayID lorawan.EUI64
 		var statsID uuid.UUID
 		copy(gatewayID[:], pl.GatewayId)
@@ -94,38 +190,76 @@ func gatewayStatsFunc(pl gw.GatewayStats) {
 
 func downlinkTxAckFunc(pl gw.DownlinkTXAck) {
 	go func(pl gw.DownlinkTXAck) {
-		var gatewayID lorawan.EUI64
-		var downID uuid.UUID
-		copy(gatewayID[:], pl.GatewayId)
-		copy(downID[:], pl.DownlinkId)
+		inflight.Release(pl)
+		capabilities.DownlinkTXAck(&pl)
+
+		if !virtualgateway.DownlinkTXAck(&pl) {
+			return
+		}
 
 		// for backwards compatibility
-		for _, err := range pl.Items {
-			if err.Status == gw.TxAckStatus_OK {
-				pl.Error = ""
-				break
-			}
+		// note: a descriptive error (e.g. set on a capability mismatch) is
+		// kept as-is
+		if pl.Error == "" {
+			for _, err := range pl.Items {
+				if err.Status == gw.TxAckStatus_OK {
+					pl.Error = ""
+					break
+				}
 
-			pl.Error = err.String()
+				pl.Error = err.String()
+			}
 		}
 
-		if err := integration.GetIntegration().PublishEvent(gatewayID, integration.EventAck, downID, &pl); err != nil {
-			log.WithError(err).WithFields(log.Fields{
-				"gateway_id":  gatewayID,
-				"event_type":  integration.EventAck,
-				"downlink_id": downID,
-			}).Error("publish event error")
+		if ackaggregation.Enabled() {
+			ackaggregation.DownlinkTXAck(pl, publishDownlinkTXAck)
+			return
 		}
+
+		publishDownlinkTXAck(pl)
 	}(pl)
 }
 
+func publishDownlinkTXAck(pl gw.DownlinkTXAck) {
+	var gatewayID lorawan.EUI64
+	var downID uuid.UUID
+	copy(gatewayID[:], pl.GatewayId)
+	copy(downID[:], pl.DownlinkId)
+
+	if err := integration.GetIntegration().PublishEvent(gatewayID, integration.EventAck, downID, &pl); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"gateway_id":  gatewayID,
+			"event_type":  integration.EventAck,
+			"downlink_id": downID,
+		}).Error("publish event error")
+	}
+}
+
 func rawPacketForwarderEventFunc(pl gw.RawPacketForwarderEvent) {
 	go func(pl gw.RawPacketForwarderEvent) {
-		var gatewayID lorawan.EUI64
+		var physicalID lorawan.EUI64
+		copy(physicalID[:], pl.GatewayId)
+
 		var rawID uuid.UUID
-		copy(gatewayID[:], pl.GatewayId)
 		copy(rawID[:], pl.RawId)
 
+		if !filters.MatchGateway(physicalID) {
+			return
+		}
+
+		if gatewaycontrol.DropEvent(physicalID, integration.EventRaw) {
+			log.WithFields(log.Fields{
+				"gateway_id": physicalID,
+				"raw_id":     rawID,
+			}).Info("raw packet-forwarder event dropped, gateway is disabled")
+			return
+		}
+
+		virtualgateway.RawPacketForwarderEvent(&pl)
+
+		var gatewayID lorawan.EUI64
+		copy(gatewayID[:], pl.GatewayId)
+
 		if err := integration.GetIntegration().PublishEvent(gatewayID, integration.EventRaw, rawID, &pl); err != nil {
 			log.WithError(err).WithFields(log.Fields{
 				"gateway_id": gatewayID,
@@ -137,21 +271,116 @@ func rawPacketForwarderEventFunc(pl gw.RawPacketForwarderEvent) {
 }
 
 func downlinkFrameFunc(pl gw.DownlinkFrame) {
-	go func(pl gw.DownlinkFrame) {
-		if err := backend.GetBackend().SendDownlinkFrame(pl); err != nil {
-			log.WithError(err).Error("send downlink frame error")
-		}
-	}(pl)
+	frames, err := virtualgateway.DownlinkFrames(pl)
+	if err != nil {
+		log.WithError(err).Error("get virtual gateway downlink frames error")
+		return
+	}
+
+	for _, df := range frames {
+		downlinkqueue.Enqueue(df, sendDownlinkFrame)
+	}
+}
+
+// sendDownlinkFrame sends the given downlink frame to the gateway.
+func sendDownlinkFrame(df gw.DownlinkFrame) {
+	var gatewayID lorawan.EUI64
+	var downID uuid.UUID
+	copy(gatewayID[:], df.GatewayId)
+	copy(downID[:], df.DownlinkId)
+
+	if !filters.MatchGateway(gatewayID) {
+		return
+	}
+
+	if gatewaycontrol.DropEvent(gatewayID, "down") {
+		log.WithFields(log.Fields{
+			"gateway_id":  gatewayID,
+			"downlink_id": downID,
+		}).Info("downlink dropped, gateway is disabled")
+		return
+	}
+
+	if !validation.DownlinkFrame(&df) {
+		return
+	}
+
+	// the overridden tx parameters are validated by the capabilities check
+	downlinkoverride.DownlinkFrame(&df)
+
+	if ack, ok := capabilities.DownlinkFrame(&df); !ok {
+		log.WithFields(log.Fields{
+			"gateway_id":  gatewayID,
+			"downlink_id": downID,
+			"error":       ack.Error,
+		}).Warning("downlink rejected, unsupported by gateway capabilities")
+
+		downlinkTxAckFunc(ack)
+		return
+	}
+
+	if !inflight.Acquire(df) {
+		log.WithFields(log.Fields{
+			"gateway_id":  gatewayID,
+			"downlink_id": downID,
+		}).Warning("downlink rejected, max. in-flight downlinks reached")
+
+		downlinkTxAckFunc(inflight.BusyAck(df))
+		return
+	}
+
+	if err := backend.GetBackend().SendDownlinkFrame(df); err != nil {
+		inflight.Release(gw.DownlinkTXAck{
+			GatewayId:  df.GatewayId,
+			Token:      df.Token,
+			DownlinkId: df.DownlinkId,
+		})
+		log.WithError(err).Error("send downlink frame error")
+	}
 }
 
 func gatewayConfigurationFunc(pl gw.GatewayConfiguration) {
 	go func(pl gw.GatewayConfiguration) {
-		if err := backend.GetBackend().ApplyConfiguration(pl); err != nil {
+		err := backend.GetBackend().ApplyConfiguration(pl)
+		if err != nil {
+			status.SetLastError(status.Config, errors.Wrap(err, "apply gateway-configuration error"))
 			log.WithError(err).Error("apply gateway-configuration error")
 		}
+
+		if configAck {
+			publishGatewayConfigAck(pl, err)
+		}
 	}(pl)
 }
 
+// publishGatewayConfigAck publishes the config_ack event for the given
+// gateway configuration. On failure, applyErr holds the apply error.
+func publishGatewayConfigAck(pl gw.GatewayConfiguration, applyErr error) {
+	var gatewayID lorawan.EUI64
+	copy(gatewayID[:], pl.GetGatewayId())
+
+	ack := events.GatewayConfigAck{
+		GatewayId: pl.GetGatewayId(),
+		Version:   pl.GetVersion(),
+	}
+	if applyErr != nil {
+		ack.Error = applyErr.Error()
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.WithError(err).Error("get random config ack id error")
+		return
+	}
+
+	if err := integration.GetIntegration().PublishEvent(gatewayID, integration.EventConfigAck, id, &ack); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"gateway_id": gatewayID,
+			"event_type": integration.EventConfigAck,
+		}).Error("publish event error")
+	}
+}
+
 func rawPacketForwarderCommandFunc(pl gw.RawPacketForwarderCommand) {
 	go func(pl gw.RawPacketForwarderCommand) {
 		if err := backend.GetBackend().RawPacketForwarderCommand(pl); err != nil {
@@ -0,0 +1,165 @@
+package forwarder
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/capabilities"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/coalesce"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/diagnostics"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/filters"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/gatewaycontrol"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/integration"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/ratelimit"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/validation"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/virtualgateway"
+	"github.com/brocaar/lorawan"
+)
+
+func TestDisabledGateway(t *testing.T) {
+	assert := require.New(t)
+
+	level := log.GetLevel()
+	log.SetLevel(log.InfoLevel)
+	hook := logtest.NewGlobal()
+	defer func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetLevel(level)
+	}()
+
+	// the events are logged by the dry-run integration instead of published
+	var conf config.Config
+	conf.Integration.DryRun = true
+	for _, setup := range []func(config.Config) error{
+		filters.Setup,
+		diagnostics.Setup,
+		gatewaycontrol.Setup,
+		ratelimit.Setup,
+		validation.Setup,
+		capabilities.Setup,
+		virtualgateway.Setup,
+		coalesce.Setup,
+		integration.Setup,
+	} {
+		assert.NoError(setup(conf))
+	}
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	assert.NoError(gatewaycontrol.Disable(gatewayID))
+
+	// published returns the event types that were published (logged) by the
+	// integration.
+	published := func() []string {
+		var events []string
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == "integration/dryrun: event" {
+				events = append(events, entry.Data["event"].(string))
+			}
+		}
+		return events
+	}
+
+	// waitFor waits (max. one second) until an entry with the given message
+	// has been logged, as the events are handled asynchronously.
+	waitFor := func(msg string) bool {
+		for i := 0; i < 100; i++ {
+			for _, entry := range hook.AllEntries() {
+				if entry.Message == msg {
+					return true
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+
+	uplinkFrame := gw.UplinkFrame{
+		PhyPayload: []byte{1, 2, 3, 4},
+		TxInfo: &gw.UplinkTXInfo{
+			Frequency: 868100000,
+		},
+		RxInfo: &gw.UplinkRXInfo{
+			GatewayId: gatewayID[:],
+		},
+	}
+
+	gatewayStats := gw.GatewayStats{
+		GatewayId: gatewayID[:],
+	}
+
+	rawEvent := gw.RawPacketForwarderEvent{
+		GatewayId: gatewayID[:],
+		Payload:   []byte{1, 2, 3},
+	}
+
+	downlinkFrame := gw.DownlinkFrame{
+		GatewayId: gatewayID[:],
+		Token:     1234,
+		Items: []*gw.DownlinkFrameItem{
+			{
+				PhyPayload: []byte{1, 2, 3},
+				TxInfo: &gw.DownlinkTXInfo{
+					GatewayId: gatewayID[:],
+					Frequency: 868100000,
+				},
+			},
+		},
+	}
+
+	t.Run("Uplink is not published", func(t *testing.T) {
+		assert := require.New(t)
+		hook.Reset()
+
+		uplinkFrameFunc(uplinkFrame)
+		assert.True(waitFor("uplink dropped, gateway is disabled"))
+		assert.Empty(published())
+	})
+
+	t.Run("Stats are not published", func(t *testing.T) {
+		assert := require.New(t)
+		hook.Reset()
+
+		gatewayStatsFunc(gatewayStats)
+		assert.True(waitFor("stats dropped, gateway is disabled"))
+		assert.Empty(published())
+	})
+
+	t.Run("Raw packet-forwarder events are not published", func(t *testing.T) {
+		assert := require.New(t)
+		hook.Reset()
+
+		rawPacketForwarderEventFunc(rawEvent)
+		assert.True(waitFor("raw packet-forwarder event dropped, gateway is disabled"))
+		assert.Empty(published())
+	})
+
+	t.Run("Downlinks are not sent", func(t *testing.T) {
+		assert := require.New(t)
+		hook.Reset()
+
+		// no backend is set, the downlink must be dropped before it would
+		// be sent to the backend
+		sendDownlinkFrame(downlinkFrame)
+		assert.True(waitFor("downlink dropped, gateway is disabled"))
+	})
+
+	t.Run("Enabled gateway is published", func(t *testing.T) {
+		assert := require.New(t)
+		assert.NoError(gatewaycontrol.Enable(gatewayID))
+		hook.Reset()
+
+		uplinkFrameFunc(uplinkFrame)
+		gatewayStatsFunc(gatewayStats)
+		rawPacketForwarderEventFunc(rawEvent)
+
+		assert.True(waitFor("integration/dryrun: event"))
+		time.Sleep(100 * time.Millisecond)
+		assert.ElementsMatch([]string{integration.EventUp, integration.EventStats, integration.EventRaw}, published())
+	})
+}
@@ -0,0 +1,242 @@
+// Package gatewaycontrol implements disabling and re-enabling the forwarding
+// of individual gateways at runtime (e.g. during maintenance).
+package gatewaycontrol
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+var (
+	mux       sync.RWMutex
+	disabled  map[lorawan.EUI64]struct{}
+	stateFile string
+)
+
+// Setup configures the gateway control package. When a state file is
+// configured, the disabled gateways are restored from this file. When a bind
+// is configured, the HTTP control endpoint is started.
+func Setup(conf config.Config) error {
+	mux.Lock()
+	disabled = make(map[lorawan.EUI64]struct{})
+	stateFile = conf.GatewayControl.StateFile
+	mux.Unlock()
+
+	if stateFile != "" {
+		if err := loadState(); err != nil {
+			return errors.Wrap(err, "load state error")
+		}
+	}
+
+	if conf.GatewayControl.Bind == "" {
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"bind": conf.GatewayControl.Bind,
+	}).Info("gatewaycontrol: starting gateway control server")
+
+	server := http.Server{
+		Handler: Handler(),
+		Addr:    conf.GatewayControl.Bind,
+	}
+
+	go func() {
+		err := server.ListenAndServe()
+		log.WithError(err).Error("gatewaycontrol: gateway control server error")
+	}()
+
+	return nil
+}
+
+// Disable disables the forwarding for the given gateway. When the state can
+// not be persisted, the gateway is not disabled.
+func Disable(gatewayID lorawan.EUI64) error {
+	mux.Lock()
+	defer mux.Unlock()
+
+	_, wasDisabled := disabled[gatewayID]
+	disabled[gatewayID] = struct{}{}
+
+	if err := saveState(); err != nil {
+		if !wasDisabled {
+			delete(disabled, gatewayID)
+		}
+		return err
+	}
+
+	log.WithField("gateway_id", gatewayID).Info("gatewaycontrol: gateway disabled")
+
+	return nil
+}
+
+// Enable re-enables the forwarding for the given gateway. When the state can
+// not be persisted, the gateway is not re-enabled.
+func Enable(gatewayID lorawan.EUI64) error {
+	mux.Lock()
+	defer mux.Unlock()
+
+	_, wasDisabled := disabled[gatewayID]
+	delete(disabled, gatewayID)
+
+	if err := saveState(); err != nil {
+		if wasDisabled {
+			disabled[gatewayID] = struct{}{}
+		}
+		return err
+	}
+
+	log.WithField("gateway_id", gatewayID).Info("gatewaycontrol: gateway enabled")
+
+	return nil
+}
+
+// IsDisabled returns true when the forwarding for the given gateway has been
+// disabled.
+func IsDisabled(gatewayID lorawan.EUI64) bool {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	_, ok := disabled[gatewayID]
+	return ok
+}
+
+// DropEvent returns true when the given event of the given gateway must be
+// dropped, because the gateway has been disabled. The dropped events are
+// counted per event type.
+func DropEvent(gatewayID lorawan.EUI64, event string) bool {
+	if !IsDisabled(gatewayID) {
+		return false
+	}
+
+	eventDroppedCounter(event).Inc()
+	return true
+}
+
+// GetDisabled returns the (sorted) IDs of the disabled gateways.
+func GetDisabled() []lorawan.EUI64 {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	return getDisabled()
+}
+
+// Handler returns the HTTP handler of the gateway control endpoint. Note that
+// the endpoint does not implement authentication:
+//
+//	GET    /gateways/disabled              returns the disabled gateway IDs
+//	PUT    /gateways/<gateway_id>/disabled disables the given gateway
+//	DELETE /gateways/<gateway_id>/disabled re-enables the given gateway
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+		if len(parts) == 2 && parts[0] == "gateways" && parts[1] == "disabled" && r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(GetDisabled()); err != nil {
+				log.WithError(err).Error("gatewaycontrol: encode disabled gateways error")
+			}
+			return
+		}
+
+		if len(parts) != 3 || parts[0] != "gateways" || parts[2] != "disabled" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var gatewayID lorawan.EUI64
+		if err := gatewayID.UnmarshalText([]byte(parts[1])); err != nil {
+			http.Error(w, "invalid gateway id", http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		switch r.Method {
+		case http.MethodPut:
+			err = Disable(gatewayID)
+		case http.MethodDelete:
+			err = Enable(gatewayID)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err != nil {
+			log.WithError(err).Error("gatewaycontrol: save state error")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func getDisabled() []lorawan.EUI64 {
+	out := make([]lorawan.EUI64, 0, len(disabled))
+	for id := range disabled {
+		out = append(out, id)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].String() < out[j].String()
+	})
+	return out
+}
+
+// saveState persists the disabled gateways to the state file.
+// Note: the caller must hold the lock.
+func saveState() error {
+	if stateFile == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(getDisabled())
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	if err := ioutil.WriteFile(stateFile, b, 0660); err != nil {
+		return errors.Wrap(err, "write file error")
+	}
+
+	return nil
+}
+
+// loadState restores the disabled gateways from the state file.
+func loadState() error {
+	b, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "read file error")
+	}
+
+	var ids []lorawan.EUI64
+	if err := json.Unmarshal(b, &ids); err != nil {
+		return errors.Wrap(err, "unmarshal json error")
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	for _, id := range ids {
+		disabled[id] = struct{}{}
+	}
+
+	log.WithFields(log.Fields{
+		"gateway_ids": ids,
+	}).Info("gatewaycontrol: disabled gateways restored")
+
+	return nil
+}
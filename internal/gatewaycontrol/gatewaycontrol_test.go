@@ -0,0 +1,137 @@
+package gatewaycontrol
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+func TestGatewayControl(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "gatewaycontrol")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	var conf config.Config
+	conf.GatewayControl.StateFile = filepath.Join(dir, "state.json")
+	assert.NoError(Setup(conf))
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	otherID := lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		Handler().ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	t.Run("Disable", func(t *testing.T) {
+		assert := require.New(t)
+
+		w := request(http.MethodPut, "/gateways/0102030405060708/disabled")
+		assert.Equal(http.StatusNoContent, w.Code)
+
+		assert.True(IsDisabled(gatewayID))
+		assert.False(IsDisabled(otherID))
+
+		w = request(http.MethodGet, "/gateways/disabled")
+		assert.Equal(http.StatusOK, w.Code)
+		var ids []lorawan.EUI64
+		assert.NoError(json.NewDecoder(w.Body).Decode(&ids))
+		assert.Equal([]lorawan.EUI64{gatewayID}, ids)
+	})
+
+	t.Run("Restore from state file", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(Setup(conf))
+		assert.True(IsDisabled(gatewayID))
+	})
+
+	t.Run("Enable", func(t *testing.T) {
+		assert := require.New(t)
+
+		w := request(http.MethodDelete, "/gateways/0102030405060708/disabled")
+		assert.Equal(http.StatusNoContent, w.Code)
+		assert.False(IsDisabled(gatewayID))
+
+		assert.NoError(Setup(conf))
+		assert.False(IsDisabled(gatewayID))
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.Equal(http.StatusBadRequest, request(http.MethodPut, "/gateways/foo/disabled").Code)
+		assert.Equal(http.StatusMethodNotAllowed, request(http.MethodPost, "/gateways/0102030405060708/disabled").Code)
+		assert.Equal(http.StatusNotFound, request(http.MethodGet, "/foo").Code)
+	})
+}
+
+func TestDropEvent(t *testing.T) {
+	assert := require.New(t)
+	assert.NoError(Setup(config.Config{}))
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	otherID := lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}
+	assert.NoError(Disable(gatewayID))
+
+	dropped := testutil.ToFloat64(eventDroppedCounter("raw"))
+
+	assert.True(DropEvent(gatewayID, "raw"))
+	assert.False(DropEvent(otherID, "raw"))
+	assert.Equal(dropped+1, testutil.ToFloat64(eventDroppedCounter("raw")))
+}
+
+func TestSaveStateError(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "gatewaycontrol")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	var conf config.Config
+	conf.GatewayControl.StateFile = filepath.Join(dir, "state.json")
+	assert.NoError(Setup(conf))
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	otherID := lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}
+	assert.NoError(Disable(gatewayID))
+
+	// the state file can not be written once its directory has been removed
+	assert.NoError(os.RemoveAll(dir))
+
+	t.Run("Disable is rolled back", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.Error(Disable(otherID))
+		assert.False(IsDisabled(otherID))
+	})
+
+	t.Run("Enable is rolled back", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.Error(Enable(gatewayID))
+		assert.True(IsDisabled(gatewayID))
+	})
+
+	t.Run("HTTP endpoint returns an error", func(t *testing.T) {
+		assert := require.New(t)
+
+		w := httptest.NewRecorder()
+		Handler().ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/gateways/0102030405060708/disabled", nil))
+		assert.Equal(http.StatusInternalServerError, w.Code)
+		assert.True(IsDisabled(gatewayID))
+	})
+}
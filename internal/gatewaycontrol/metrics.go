@@ -0,0 +1,17 @@
+package gatewaycontrol
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	edc = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gatewaycontrol_event_dropped_count",
+		Help: "The number of events dropped because the gateway is disabled (per event type).",
+	}, []string{"event"})
+)
+
+func eventDroppedCounter(event string) prometheus.Counter {
+	return edc.With(prometheus.Labels{"event": event})
+}
@@ -0,0 +1,139 @@
+// Package inflight keeps track of the downlinks that have been sent to a
+// gateway, but that have not yet been acknowledged.
+package inflight
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+var (
+	mux sync.Mutex
+
+	// max. number of in-flight downlinks per gateway (0 = unlimited)
+	maxInFlight int
+
+	// duration after which an unacknowledged downlink is no longer
+	// considered to be in-flight
+	timeout time.Duration
+
+	// gateway ID to downlink key to expiration
+	downlinks map[lorawan.EUI64]map[string]time.Time
+)
+
+// Setup configures the inflight package.
+func Setup(conf config.Config) error {
+	mux.Lock()
+	defer mux.Unlock()
+
+	maxInFlight = conf.Backend.MaxInFlightDownlinks
+	timeout = conf.Backend.InFlightDownlinkTimeout
+	downlinks = make(map[lorawan.EUI64]map[string]time.Time)
+
+	if maxInFlight != 0 {
+		log.WithFields(log.Fields{
+			"max_in_flight_downlinks":    maxInFlight,
+			"in_flight_downlink_timeout": timeout,
+		}).Info("inflight: in-flight downlink limit configured")
+	}
+
+	return nil
+}
+
+// Acquire registers the given downlink frame as in-flight. It returns false
+// when the max. number of in-flight downlinks for the gateway has been
+// reached, in which case the downlink must be rejected.
+func Acquire(pl gw.DownlinkFrame) bool {
+	mux.Lock()
+	defer mux.Unlock()
+
+	if maxInFlight == 0 {
+		return true
+	}
+
+	var gatewayID lorawan.EUI64
+	copy(gatewayID[:], pl.GetGatewayId())
+
+	if downlinks[gatewayID] == nil {
+		downlinks[gatewayID] = make(map[string]time.Time)
+	}
+
+	// remove expired downlinks
+	now := time.Now()
+	for k, exp := range downlinks[gatewayID] {
+		if now.After(exp) {
+			delete(downlinks[gatewayID], k)
+		}
+	}
+
+	if len(downlinks[gatewayID]) >= maxInFlight {
+		return false
+	}
+
+	key, ok := getKey(pl.GetDownlinkId(), pl.GetToken())
+	if !ok {
+		// the backend assigns a token to frames without token, there is no
+		// way to match the acknowledgement of such a frame
+		return true
+	}
+
+	downlinks[gatewayID][key] = now.Add(timeout)
+	return true
+}
+
+// Release removes the downlink matching the given acknowledgement from the
+// in-flight downlinks.
+func Release(pl gw.DownlinkTXAck) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	var gatewayID lorawan.EUI64
+	copy(gatewayID[:], pl.GetGatewayId())
+
+	if key, ok := getKey(pl.GetDownlinkId(), pl.GetToken()); ok {
+		delete(downlinks[gatewayID], key)
+	}
+}
+
+// BusyAck returns the tx acknowledgement for a downlink frame that has been
+// rejected because the max. number of in-flight downlinks was reached.
+func BusyAck(pl gw.DownlinkFrame) gw.DownlinkTXAck {
+	items := make([]*gw.DownlinkTXAckItem, len(pl.Items))
+	for i := range items {
+		items[i] = &gw.DownlinkTXAckItem{
+			Status: gw.TxAckStatus_IGNORED,
+		}
+	}
+	if len(items) != 0 {
+		items[0].Status = gw.TxAckStatus_QUEUE_FULL
+	}
+
+	return gw.DownlinkTXAck{
+		GatewayId:  pl.GatewayId,
+		Token:      pl.Token,
+		DownlinkId: pl.DownlinkId,
+		Items:      items,
+	}
+}
+
+// getKey returns the key identifying the downlink. The downlink ID is used
+// when set, as the token might be replaced by the backend (e.g. when it is 0).
+// The token is only used for downlinks without downlink ID. It returns false
+// when the downlink can not be identified.
+func getKey(downlinkID []byte, token uint32) (string, bool) {
+	if len(downlinkID) != 0 {
+		return hex.EncodeToString(downlinkID), true
+	}
+	if token != 0 {
+		return fmt.Sprintf("token:%d", token), true
+	}
+	return "", false
+}
@@ -0,0 +1,112 @@
+package inflight
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+)
+
+func TestInFlight(t *testing.T) {
+	var conf config.Config
+	conf.Backend.MaxInFlightDownlinks = 2
+	conf.Backend.InFlightDownlinkTimeout = 100 * time.Millisecond
+	require.NoError(t, Setup(conf))
+
+	downlink := func(gatewayID byte, token uint32) gw.DownlinkFrame {
+		return gw.DownlinkFrame{
+			GatewayId:  []byte{gatewayID, 2, 3, 4, 5, 6, 7, 8},
+			Token:      token,
+			DownlinkId: []byte{byte(token), 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+			Items:      []*gw.DownlinkFrameItem{{}, {}},
+		}
+	}
+
+	ack := func(pl gw.DownlinkFrame) gw.DownlinkTXAck {
+		return gw.DownlinkTXAck{
+			GatewayId:  pl.GatewayId,
+			Token:      pl.Token,
+			DownlinkId: pl.DownlinkId,
+		}
+	}
+
+	t.Run("Exceeding the cap", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.True(Acquire(downlink(1, 1)))
+		assert.True(Acquire(downlink(1, 2)))
+		assert.False(Acquire(downlink(1, 3)))
+
+		// other gateway
+		assert.True(Acquire(downlink(2, 1)))
+
+		assert.Equal(gw.DownlinkTXAck{
+			GatewayId:  downlink(1, 3).GatewayId,
+			Token:      3,
+			DownlinkId: downlink(1, 3).DownlinkId,
+			Items: []*gw.DownlinkTXAckItem{
+				{Status: gw.TxAckStatus_QUEUE_FULL},
+				{Status: gw.TxAckStatus_IGNORED},
+			},
+		}, BusyAck(downlink(1, 3)))
+	})
+
+	t.Run("Ack frees a slot", func(t *testing.T) {
+		assert := require.New(t)
+
+		Release(ack(downlink(1, 1)))
+		assert.True(Acquire(downlink(1, 3)))
+		assert.False(Acquire(downlink(1, 4)))
+	})
+
+	t.Run("Timeout frees all slots", func(t *testing.T) {
+		assert := require.New(t)
+
+		time.Sleep(150 * time.Millisecond)
+		assert.True(Acquire(downlink(1, 4)))
+		assert.True(Acquire(downlink(1, 5)))
+	})
+}
+
+func TestInFlightTokenReplaced(t *testing.T) {
+	assert := require.New(t)
+
+	var conf config.Config
+	conf.Backend.MaxInFlightDownlinks = 1
+	conf.Backend.InFlightDownlinkTimeout = time.Minute
+	assert.NoError(Setup(conf))
+
+	gatewayID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	downlinkID := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+
+	assert.True(Acquire(gw.DownlinkFrame{
+		GatewayId:  gatewayID,
+		DownlinkId: downlinkID,
+		Items:      []*gw.DownlinkFrameItem{{}},
+	}))
+
+	// the backend replaced the 0 token by a random token
+	Release(gw.DownlinkTXAck{
+		GatewayId:  gatewayID,
+		Token:      12345,
+		DownlinkId: downlinkID,
+	})
+
+	assert.True(Acquire(gw.DownlinkFrame{
+		GatewayId:  gatewayID,
+		DownlinkId: []byte{2, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		Items:      []*gw.DownlinkFrameItem{{}},
+	}))
+}
+
+func TestInFlightUnlimited(t *testing.T) {
+	assert := require.New(t)
+	assert.NoError(Setup(config.Config{}))
+
+	for i := 0; i < 10; i++ {
+		assert.True(Acquire(gw.DownlinkFrame{Token: uint32(i)}))
+	}
+}
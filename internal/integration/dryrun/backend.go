@@ -0,0 +1,98 @@
+// Package dryrun implements an integration that logs the gateway events and
+// states instead of publishing these, e.g. to verify that a gateway is
+// forwarding correctly before the MQTT broker has been provisioned.
+package dryrun
+
+import (
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+// Backend implements a dry-run integration.
+type Backend struct {
+	marshaler *jsonpb.Marshaler
+}
+
+// NewBackend creates a new Backend.
+func NewBackend(conf config.Config) (*Backend, error) {
+	return &Backend{
+		marshaler: &jsonpb.Marshaler{
+			EnumsAsInts:  false,
+			EmitDefaults: false,
+		},
+	}, nil
+}
+
+// Start starts the integration.
+func (b *Backend) Start() error {
+	log.Warning("integration/dryrun: dry-run mode enabled, events are logged instead of published")
+	return nil
+}
+
+// Stop stops the integration.
+func (b *Backend) Stop() error {
+	return nil
+}
+
+// SetGatewaySubscription logs the gateway subscription for the given
+// gateway ID.
+func (b *Backend) SetGatewaySubscription(subscribe bool, gatewayID lorawan.EUI64) error {
+	log.WithFields(log.Fields{
+		"gateway_id": gatewayID,
+		"subscribe":  subscribe,
+	}).Info("integration/dryrun: gateway subscription")
+	return nil
+}
+
+// PublishEvent logs the given event.
+func (b *Backend) PublishEvent(gatewayID lorawan.EUI64, event string, id uuid.UUID, v proto.Message) error {
+	str, err := b.marshaler.MarshalToString(v)
+	if err != nil {
+		return errors.Wrap(err, "marshal message error")
+	}
+
+	log.WithFields(log.Fields{
+		"gateway_id": gatewayID,
+		"event":      event,
+		"id":         id,
+		"payload":    str,
+	}).Info("integration/dryrun: event")
+	return nil
+}
+
+// PublishState logs the given state.
+func (b *Backend) PublishState(gatewayID lorawan.EUI64, state string, v proto.Message) error {
+	str, err := b.marshaler.MarshalToString(v)
+	if err != nil {
+		return errors.Wrap(err, "marshal message error")
+	}
+
+	log.WithFields(log.Fields{
+		"gateway_id": gatewayID,
+		"state":      state,
+		"payload":    str,
+	}).Info("integration/dryrun: state")
+	return nil
+}
+
+// SetDownlinkFrameFunc is a no-op, as no downlink frames are received.
+func (b *Backend) SetDownlinkFrameFunc(f func(gw.DownlinkFrame)) {}
+
+// SetGatewayConfigurationFunc is a no-op, as no gateway configuration is
+// received.
+func (b *Backend) SetGatewayConfigurationFunc(f func(gw.GatewayConfiguration)) {}
+
+// SetGatewayCommandExecRequestFunc is a no-op, as no gateway command
+// execution requests are received.
+func (b *Backend) SetGatewayCommandExecRequestFunc(f func(gw.GatewayCommandExecRequest)) {}
+
+// SetRawPacketForwarderCommandFunc is a no-op, as no raw packet-forwarder
+// commands are received.
+func (b *Backend) SetRawPacketForwarderCommandFunc(f func(gw.RawPacketForwarderCommand)) {}
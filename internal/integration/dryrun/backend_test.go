@@ -0,0 +1,68 @@
+package dryrun
+
+import (
+	"testing"
+
+	"github.com/gofrs/uuid"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+func TestBackend(t *testing.T) {
+	level := log.GetLevel()
+	log.SetLevel(log.InfoLevel)
+	hook := logtest.NewGlobal()
+	defer func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetLevel(level)
+	}()
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	b, err := NewBackend(config.Config{})
+	require.NoError(t, err)
+
+	t.Run("PublishEvent", func(t *testing.T) {
+		assert := require.New(t)
+		hook.Reset()
+
+		id, err := uuid.NewV4()
+		assert.NoError(err)
+
+		assert.NoError(b.PublishEvent(gatewayID, "up", id, &gw.UplinkFrame{
+			PhyPayload: []byte{1, 2, 3, 4},
+			RxInfo: &gw.UplinkRXInfo{
+				Rssi: -60,
+			},
+		}))
+
+		entry := hook.LastEntry()
+		assert.NotNil(entry)
+		assert.Equal(log.InfoLevel, entry.Level)
+		assert.Equal("integration/dryrun: event", entry.Message)
+		assert.Equal(gatewayID, entry.Data["gateway_id"])
+		assert.Equal("up", entry.Data["event"])
+		assert.Equal(id, entry.Data["id"])
+		assert.Equal(`{"phyPayload":"AQIDBA==","rxInfo":{"rssi":-60}}`, entry.Data["payload"])
+	})
+
+	t.Run("PublishState", func(t *testing.T) {
+		assert := require.New(t)
+		hook.Reset()
+
+		assert.NoError(b.PublishState(gatewayID, "conn", &gw.ConnState{
+			State: gw.ConnState_ONLINE,
+		}))
+
+		entry := hook.LastEntry()
+		assert.NotNil(entry)
+		assert.Equal("integration/dryrun: state", entry.Message)
+		assert.Equal("conn", entry.Data["state"])
+		assert.Equal(`{"state":"ONLINE"}`, entry.Data["payload"])
+	})
+}
@@ -0,0 +1,488 @@
+// Package gcppubsub implements a Google Cloud Pub/Sub integration,
+// publishing the gateway events to a Pub/Sub topic and pulling the gateway
+// commands from a Pub/Sub subscription (using the Pub/Sub REST API).
+package gcppubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+const (
+	// pullMaxMessages defines the max. number of messages returned by a
+	// single pull request.
+	pullMaxMessages = 100
+
+	// pullTimeout defines the timeout of a (blocking) pull request.
+	pullTimeout = 30 * time.Second
+
+	// pullErrorInterval defines the interval before retrying a failed pull
+	// request.
+	pullErrorInterval = 2 * time.Second
+)
+
+// message implements the Pub/Sub PubsubMessage.
+type message struct {
+	Data       string            `json:"data"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	MessageID  string            `json:"messageId,omitempty"`
+}
+
+// Backend implements a Google Cloud Pub/Sub integration.
+type Backend struct {
+	client              *http.Client
+	creds               *credentials
+	endpoint            string
+	eventTopic          string
+	commandSubscription string
+	publishTimeout      time.Duration
+
+	marshal   func(proto.Message) ([]byte, error)
+	unmarshal func([]byte, proto.Message) error
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	gatewaysMux sync.RWMutex
+	gateways    map[lorawan.EUI64]struct{}
+
+	downlinkFrameFunc             func(gw.DownlinkFrame)
+	gatewayConfigurationFunc      func(gw.GatewayConfiguration)
+	gatewayCommandExecRequestFunc func(gw.GatewayCommandExecRequest)
+	rawPacketForwarderCommandFunc func(gw.RawPacketForwarderCommand)
+}
+
+// NewBackend creates a new Backend.
+func NewBackend(conf config.Config) (*Backend, error) {
+	c := conf.Integration.GCPPubSub
+
+	if c.ProjectID == "" || c.EventTopicName == "" || c.CommandSubscriptionName == "" {
+		return nil, errors.New("integration/gcp_pub_sub: project_id, event_topic_name and command_subscription_name must be set")
+	}
+
+	b := Backend{
+		client:              &http.Client{},
+		endpoint:            strings.TrimRight(c.Endpoint, "/"),
+		eventTopic:          fmt.Sprintf("projects/%s/topics/%s", c.ProjectID, c.EventTopicName),
+		commandSubscription: fmt.Sprintf("projects/%s/subscriptions/%s", c.ProjectID, c.CommandSubscriptionName),
+		publishTimeout:      c.PublishTimeout,
+		gateways:            make(map[lorawan.EUI64]struct{}),
+	}
+
+	var err error
+	b.creds, err = newCredentialsFromEnv()
+	if err != nil {
+		return nil, errors.Wrap(err, "integration/gcp_pub_sub: load credentials error")
+	}
+	if b.creds == nil {
+		if !isEmulator(b.endpoint) {
+			return nil, fmt.Errorf("integration/gcp_pub_sub: %s must be set", credentialsEnv)
+		}
+		log.Warningf("integration/gcp_pub_sub: %s is not set, requests to the emulator are not authenticated", credentialsEnv)
+	}
+
+	switch conf.Integration.Marshaler {
+	case "json":
+		b.marshal = func(msg proto.Message) ([]byte, error) {
+			marshaler := &jsonpb.Marshaler{
+				EnumsAsInts:  false,
+				EmitDefaults: true,
+			}
+			str, err := marshaler.MarshalToString(msg)
+			return []byte(str), err
+		}
+		b.unmarshal = func(data []byte, msg proto.Message) error {
+			unmarshaler := &jsonpb.Unmarshaler{
+				AllowUnknownFields: true,
+			}
+			return unmarshaler.Unmarshal(bytes.NewReader(data), msg)
+		}
+	case "protobuf":
+		b.marshal = proto.Marshal
+		b.unmarshal = proto.Unmarshal
+	default:
+		return nil, fmt.Errorf("integration/gcp_pub_sub: unknown marshaler: %s", conf.Integration.Marshaler)
+	}
+
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+
+	return &b, nil
+}
+
+// Start starts the integration.
+func (b *Backend) Start() error {
+	log.WithFields(log.Fields{
+		"topic":        b.eventTopic,
+		"subscription": b.commandSubscription,
+	}).Info("integration/gcp_pub_sub: starting pub/sub integration")
+
+	b.wg.Add(1)
+	go b.pullLoop()
+
+	return nil
+}
+
+// Stop stops the integration.
+func (b *Backend) Stop() error {
+	b.cancel()
+	b.wg.Wait()
+	return nil
+}
+
+// SetDownlinkFrameFunc sets the DownlinkFrame handler func.
+func (b *Backend) SetDownlinkFrameFunc(f func(gw.DownlinkFrame)) {
+	b.downlinkFrameFunc = f
+}
+
+// SetGatewayConfigurationFunc sets the GatewayConfiguration handler func.
+func (b *Backend) SetGatewayConfigurationFunc(f func(gw.GatewayConfiguration)) {
+	b.gatewayConfigurationFunc = f
+}
+
+// SetGatewayCommandExecRequestFunc sets the GatewayCommandExecRequest handler func.
+func (b *Backend) SetGatewayCommandExecRequestFunc(f func(gw.GatewayCommandExecRequest)) {
+	b.gatewayCommandExecRequestFunc = f
+}
+
+// SetRawPacketForwarderCommandFunc sets the RawPacketForwarderCommand handler func.
+func (b *Backend) SetRawPacketForwarderCommandFunc(f func(gw.RawPacketForwarderCommand)) {
+	b.rawPacketForwarderCommandFunc = f
+}
+
+// SetGatewaySubscription sets or removes the gateway from the gateways
+// served by this integration. As the commands of all gateways are pulled from
+// a single subscription, the commands for other gateways are not
+// acknowledged, so that they are redelivered to the other subscribers.
+func (b *Backend) SetGatewaySubscription(subscribe bool, gatewayID lorawan.EUI64) error {
+	b.gatewaysMux.Lock()
+	defer b.gatewaysMux.Unlock()
+
+	if subscribe {
+		b.gateways[gatewayID] = struct{}{}
+	} else {
+		delete(b.gateways, gatewayID)
+	}
+
+	return nil
+}
+
+// PublishEvent publishes the given event to the event topic.
+func (b *Backend) PublishEvent(gatewayID lorawan.EUI64, event string, id uuid.UUID, v proto.Message) error {
+	if err := b.publish(map[string]string{
+		"gatewayID": gatewayID.String(),
+		"event":     event,
+	}, v); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"gateway_id": gatewayID,
+		"event":      event,
+		"id":         id,
+	}).Info("integration/gcp_pub_sub: publishing event")
+
+	pubSubEventCounter(event).Inc()
+	return nil
+}
+
+// PublishState publishes the given state to the event topic. Pub/Sub does
+// not support retained messages.
+func (b *Backend) PublishState(gatewayID lorawan.EUI64, state string, v proto.Message) error {
+	return b.publish(map[string]string{
+		"gatewayID": gatewayID.String(),
+		"state":     state,
+	}, v)
+}
+
+func (b *Backend) publish(attributes map[string]string, v proto.Message) error {
+	data, err := b.marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "marshal message error")
+	}
+
+	req := struct {
+		Messages []message `json:"messages"`
+	}{
+		Messages: []message{
+			{
+				Data:       base64.StdEncoding.EncodeToString(data),
+				Attributes: attributes,
+			},
+		},
+	}
+
+	ctx := b.ctx
+	if b.publishTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.publishTimeout)
+		defer cancel()
+	}
+
+	if err := b.request(ctx, b.eventTopic+":publish", req, nil); err != nil {
+		return errors.Wrap(err, "integration/gcp_pub_sub: publish message error")
+	}
+
+	return nil
+}
+
+func (b *Backend) pullLoop() {
+	defer b.wg.Done()
+
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		default:
+		}
+
+		if err := b.pull(); err != nil {
+			if b.ctx.Err() != nil {
+				return
+			}
+
+			log.WithError(err).Error("integration/gcp_pub_sub: pull commands error")
+
+			select {
+			case <-b.ctx.Done():
+				return
+			case <-time.After(pullErrorInterval):
+			}
+		}
+	}
+}
+
+// pull pulls the pending commands from the command subscription, handles
+// and acknowledges them.
+func (b *Backend) pull() error {
+	ctx, cancel := context.WithTimeout(b.ctx, pullTimeout)
+	defer cancel()
+
+	req := struct {
+		MaxMessages int `json:"maxMessages"`
+	}{
+		MaxMessages: pullMaxMessages,
+	}
+
+	var resp struct {
+		ReceivedMessages []struct {
+			AckID   string  `json:"ackId"`
+			Message message `json:"message"`
+		} `json:"receivedMessages"`
+	}
+
+	if err := b.request(ctx, b.commandSubscription+":pull", req, &resp); err != nil {
+		if ctx.Err() == context.DeadlineExceeded && b.ctx.Err() == nil {
+			return nil
+		}
+		return err
+	}
+
+	if len(resp.ReceivedMessages) == 0 {
+		return nil
+	}
+
+	ack := struct {
+		AckIDs []string `json:"ackIds"`
+	}{}
+
+	// the ack deadline set to 0 makes the messages immediately available for
+	// redelivery
+	nack := struct {
+		AckIDs             []string `json:"ackIds"`
+		AckDeadlineSeconds int      `json:"ackDeadlineSeconds"`
+	}{}
+
+	for _, rm := range resp.ReceivedMessages {
+		if !b.isServed(rm.Message) {
+			log.WithFields(log.Fields{
+				"message_id": rm.Message.MessageID,
+				"gateway_id": rm.Message.Attributes["gatewayID"],
+			}).Debug("integration/gcp_pub_sub: command for other gateway, not acknowledging")
+			nack.AckIDs = append(nack.AckIDs, rm.AckID)
+			continue
+		}
+
+		ack.AckIDs = append(ack.AckIDs, rm.AckID)
+		b.handleCommand(rm.Message)
+	}
+
+	if len(nack.AckIDs) != 0 {
+		if err := b.request(b.ctx, b.commandSubscription+":modifyAckDeadline", nack, nil); err != nil {
+			return errors.Wrap(err, "modify ack deadline error")
+		}
+	}
+
+	if len(ack.AckIDs) == 0 {
+		// prevent pulling the same (redelivered) messages in a tight loop
+		select {
+		case <-b.ctx.Done():
+		case <-time.After(pullErrorInterval):
+		}
+		return nil
+	}
+
+	if err := b.request(b.ctx, b.commandSubscription+":acknowledge", ack, nil); err != nil {
+		return errors.Wrap(err, "acknowledge messages error")
+	}
+
+	return nil
+}
+
+// isServed returns true when the command is for a gateway served by this
+// integration. Commands without (valid) gatewayID attribute are always
+// handled.
+func (b *Backend) isServed(msg message) bool {
+	var gatewayID lorawan.EUI64
+	if err := gatewayID.UnmarshalText([]byte(msg.Attributes["gatewayID"])); err != nil {
+		return true
+	}
+
+	b.gatewaysMux.RLock()
+	defer b.gatewaysMux.RUnlock()
+
+	_, ok := b.gateways[gatewayID]
+	return ok
+}
+
+func (b *Backend) handleCommand(msg message) {
+	command := msg.Attributes["command"]
+
+	data, err := base64.StdEncoding.DecodeString(msg.Data)
+	if err != nil {
+		log.WithError(err).WithField("message_id", msg.MessageID).Error("integration/gcp_pub_sub: decode message data error")
+		return
+	}
+
+	var v proto.Message
+	switch command {
+	case "down":
+		v = &gw.DownlinkFrame{}
+	case "config":
+		v = &gw.GatewayConfiguration{}
+	case "exec":
+		v = &gw.GatewayCommandExecRequest{}
+	case "raw":
+		v = &gw.RawPacketForwarderCommand{}
+	default:
+		log.WithFields(log.Fields{
+			"message_id": msg.MessageID,
+			"command":    command,
+		}).Warning("integration/gcp_pub_sub: unexpected command received")
+		return
+	}
+
+	if err := b.unmarshal(data, v); err != nil {
+		log.WithFields(log.Fields{
+			"message_id": msg.MessageID,
+			"command":    command,
+		}).WithError(err).Error("integration/gcp_pub_sub: unmarshal command error")
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"message_id": msg.MessageID,
+		"gateway_id": msg.Attributes["gatewayID"],
+		"command":    command,
+	}).Info("integration/gcp_pub_sub: command received")
+
+	pubSubCommandCounter(command).Inc()
+
+	switch pl := v.(type) {
+	case *gw.DownlinkFrame:
+		if b.downlinkFrameFunc != nil {
+			b.downlinkFrameFunc(*pl)
+		}
+	case *gw.GatewayConfiguration:
+		if b.gatewayConfigurationFunc != nil {
+			b.gatewayConfigurationFunc(*pl)
+		}
+	case *gw.GatewayCommandExecRequest:
+		if b.gatewayCommandExecRequestFunc != nil {
+			b.gatewayCommandExecRequestFunc(*pl)
+		}
+	case *gw.RawPacketForwarderCommand:
+		if b.rawPacketForwarderCommandFunc != nil {
+			b.rawPacketForwarderCommandFunc(*pl)
+		}
+	}
+}
+
+// request performs the given Pub/Sub API request (e.g.
+// projects/p/topics/t:publish). When out is not nil, the response is
+// decoded into out.
+func (b *Backend) request(ctx context.Context, resource string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return errors.Wrap(err, "marshal request error")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, b.endpoint+"/v1/"+resource, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "new request error")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	if b.creds != nil {
+		token, err := b.creds.getToken()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "request error")
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "read response error")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status: %s (body: %s)", resp.Status, bytes.TrimSpace(respBody))
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return errors.Wrap(err, "unmarshal response error")
+	}
+
+	return nil
+}
+
+// isEmulator returns true when the given endpoint is not a Google API
+// endpoint (e.g. the Pub/Sub emulator).
+func isEmulator(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	return !strings.HasSuffix(u.Hostname(), ".googleapis.com")
+}
@@ -0,0 +1,283 @@
+package gcppubsub
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+// pubSubServer implements a minimal Pub/Sub REST API server.
+type pubSubServer struct {
+	sync.Mutex
+
+	published []message
+	pending   []message
+	acked     []string
+	nacked    []string
+	auth      []string
+}
+
+func (s *pubSubServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.auth = append(s.auth, r.Header.Get("Authorization"))
+
+	switch {
+	case r.URL.Path == "/v1/projects/test-project/topics/events:publish":
+		var req struct {
+			Messages []message `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		s.published = append(s.published, req.Messages...)
+		w.Write([]byte(`{"messageIds":["1"]}`))
+	case r.URL.Path == "/v1/projects/test-project/subscriptions/commands:pull":
+		type receivedMessage struct {
+			AckID   string  `json:"ackId"`
+			Message message `json:"message"`
+		}
+		var resp struct {
+			ReceivedMessages []receivedMessage `json:"receivedMessages,omitempty"`
+		}
+		for _, msg := range s.pending {
+			resp.ReceivedMessages = append(resp.ReceivedMessages, receivedMessage{
+				AckID:   "ack-" + msg.MessageID,
+				Message: msg,
+			})
+		}
+		s.pending = nil
+		json.NewEncoder(w).Encode(resp)
+	case r.URL.Path == "/v1/projects/test-project/subscriptions/commands:acknowledge":
+		var req struct {
+			AckIDs []string `json:"ackIds"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		s.acked = append(s.acked, req.AckIDs...)
+		w.Write([]byte(`{}`))
+	case r.URL.Path == "/v1/projects/test-project/subscriptions/commands:modifyAckDeadline":
+		var req struct {
+			AckIDs             []string `json:"ackIds"`
+			AckDeadlineSeconds int      `json:"ackDeadlineSeconds"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.AckDeadlineSeconds == 0 {
+			s.nacked = append(s.nacked, req.AckIDs...)
+		}
+		w.Write([]byte(`{}`))
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func testConfig(endpoint string) config.Config {
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.GCPPubSub.Endpoint = endpoint
+	conf.Integration.GCPPubSub.ProjectID = "test-project"
+	conf.Integration.GCPPubSub.EventTopicName = "events"
+	conf.Integration.GCPPubSub.CommandSubscriptionName = "commands"
+	conf.Integration.GCPPubSub.PublishTimeout = time.Second
+	return conf
+}
+
+func TestNewBackend(t *testing.T) {
+	assert := require.New(t)
+
+	conf := testConfig("http://127.0.0.1")
+	conf.Integration.GCPPubSub.EventTopicName = ""
+	_, err := NewBackend(conf)
+	assert.Error(err)
+
+	conf = testConfig("http://127.0.0.1")
+	conf.Integration.Marshaler = "xml"
+	_, err = NewBackend(conf)
+	assert.EqualError(err, "integration/gcp_pub_sub: unknown marshaler: xml")
+
+	// credentials are required, unless using the emulator
+	_, err = NewBackend(testConfig("https://pubsub.googleapis.com"))
+	assert.EqualError(err, "integration/gcp_pub_sub: GOOGLE_APPLICATION_CREDENTIALS must be set")
+}
+
+func TestPublishEvent(t *testing.T) {
+	assert := require.New(t)
+
+	server := &pubSubServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	b, err := NewBackend(testConfig(ts.URL))
+	assert.NoError(err)
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	pl := gw.GatewayStats{
+		GatewayId:         gatewayID[:],
+		RxPacketsReceived: 10,
+	}
+	assert.NoError(b.PublishEvent(gatewayID, "stats", uuid.Nil, &pl))
+	assert.NoError(b.PublishState(gatewayID, "conn", &gw.ConnState{GatewayId: gatewayID[:]}))
+
+	server.Lock()
+	defer server.Unlock()
+
+	assert.Len(server.published, 2)
+	assert.Equal(map[string]string{"gatewayID": "0102030405060708", "event": "stats"}, server.published[0].Attributes)
+	assert.Equal(map[string]string{"gatewayID": "0102030405060708", "state": "conn"}, server.published[1].Attributes)
+
+	data, err := base64.StdEncoding.DecodeString(server.published[0].Data)
+	assert.NoError(err)
+
+	var stats gw.GatewayStats
+	assert.NoError(jsonpb.UnmarshalString(string(data), &stats))
+	assert.Equal(pl.RxPacketsReceived, stats.RxPacketsReceived)
+	assert.Equal(pl.GatewayId, stats.GatewayId)
+
+	// no credentials configured
+	assert.Equal("", server.auth[0])
+}
+
+func TestPullCommands(t *testing.T) {
+	assert := require.New(t)
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	down, err := (&jsonpb.Marshaler{}).MarshalToString(&gw.DownlinkFrame{
+		GatewayId: gatewayID[:],
+		Token:     12345,
+		Items: []*gw.DownlinkFrameItem{
+			{PhyPayload: []byte{1, 2, 3}},
+		},
+	})
+	assert.NoError(err)
+
+	server := &pubSubServer{
+		pending: []message{
+			{
+				MessageID:  "1",
+				Data:       base64.StdEncoding.EncodeToString([]byte(down)),
+				Attributes: map[string]string{"gatewayID": gatewayID.String(), "command": "down"},
+			},
+			{
+				MessageID:  "2",
+				Data:       base64.StdEncoding.EncodeToString([]byte("{}")),
+				Attributes: map[string]string{"gatewayID": gatewayID.String(), "command": "foo"},
+			},
+			{
+				MessageID:  "3",
+				Data:       base64.StdEncoding.EncodeToString([]byte(down)),
+				Attributes: map[string]string{"gatewayID": "0807060504030201", "command": "down"},
+			},
+		},
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	b, err := NewBackend(testConfig(ts.URL))
+	assert.NoError(err)
+
+	downChan := make(chan gw.DownlinkFrame, 2)
+	b.SetDownlinkFrameFunc(func(pl gw.DownlinkFrame) {
+		downChan <- pl
+	})
+	assert.NoError(b.SetGatewaySubscription(true, gatewayID))
+
+	assert.NoError(b.Start())
+	defer b.Stop()
+
+	select {
+	case pl := <-downChan:
+		assert.Equal(uint32(12345), pl.Token)
+		assert.Equal(gatewayID[:], pl.GatewayId)
+	case <-time.After(time.Second):
+		t.Fatal("expected downlink frame")
+	}
+
+	assert.Eventually(func() bool {
+		server.Lock()
+		defer server.Unlock()
+		return len(server.acked) == 2 && len(server.nacked) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// the command for the other gateway is not handled nor acknowledged
+	server.Lock()
+	assert.Equal([]string{"ack-1", "ack-2"}, server.acked)
+	assert.Equal([]string{"ack-3"}, server.nacked)
+	server.Unlock()
+	assert.Len(downChan, 0)
+}
+
+func TestCredentials(t *testing.T) {
+	assert := require.New(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(err)
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	b, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "bridge@test-project.iam.gserviceaccount.com",
+		"private_key_id": "key-id",
+		"private_key":    string(keyPEM),
+	})
+	assert.NoError(err)
+
+	dir, err := ioutil.TempDir("", "gcppubsub")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "credentials.json")
+	assert.NoError(ioutil.WriteFile(path, b, 0600))
+
+	os.Setenv(credentialsEnv, path)
+	defer os.Unsetenv(credentialsEnv)
+
+	server := &pubSubServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	backend, err := NewBackend(testConfig(ts.URL))
+	assert.NoError(err)
+	assert.NoError(backend.PublishState(lorawan.EUI64{1}, "conn", &gw.ConnState{}))
+
+	server.Lock()
+	auth := server.auth[0]
+	server.Unlock()
+
+	assert.True(strings.HasPrefix(auth, "Bearer "))
+
+	token, err := jwt.ParseWithClaims(strings.TrimPrefix(auth, "Bearer "), &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	})
+	assert.NoError(err)
+	assert.True(token.Valid)
+	assert.Equal("key-id", token.Header["kid"])
+
+	claims := token.Claims.(*jwt.StandardClaims)
+	assert.Equal("bridge@test-project.iam.gserviceaccount.com", claims.Issuer)
+	assert.Equal("bridge@test-project.iam.gserviceaccount.com", claims.Subject)
+	assert.Equal(tokenAudience, claims.Audience)
+
+	// the token is re-used
+	token2, err := backend.creds.getToken()
+	assert.NoError(err)
+	assert.Equal(strings.TrimPrefix(auth, "Bearer "), token2)
+}
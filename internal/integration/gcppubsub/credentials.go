@@ -0,0 +1,108 @@
+package gcppubsub
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// credentialsEnv defines the environment variable containing the path to
+// the service-account credentials file.
+const credentialsEnv = "GOOGLE_APPLICATION_CREDENTIALS"
+
+// tokenAudience defines the audience of the self-signed JWT access tokens.
+const tokenAudience = "https://pubsub.googleapis.com/"
+
+// tokenExpiration defines the expiration of the self-signed JWT access
+// tokens. Tokens are renewed before they expire.
+const tokenExpiration = time.Hour
+
+// credentials implements the service-account authentication, using
+// self-signed JWT access tokens.
+type credentials struct {
+	clientEmail  string
+	privateKeyID string
+	privateKey   *rsa.PrivateKey
+
+	mux     sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// newCredentialsFromEnv returns the credentials from the file set by the
+// GOOGLE_APPLICATION_CREDENTIALS environment variable. It returns nil when
+// this variable is not set.
+func newCredentialsFromEnv() (*credentials, error) {
+	path := os.Getenv(credentialsEnv)
+	if path == "" {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read credentials file error")
+	}
+
+	var file struct {
+		Type         string `json:"type"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKeyID string `json:"private_key_id"`
+		PrivateKey   string `json:"private_key"`
+	}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, errors.Wrap(err, "unmarshal credentials file error")
+	}
+
+	if file.Type != "service_account" {
+		return nil, errors.Errorf("unsupported credentials type: %s", file.Type)
+	}
+
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(file.PrivateKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse private key error")
+	}
+
+	return &credentials{
+		clientEmail:  file.ClientEmail,
+		privateKeyID: file.PrivateKeyID,
+		privateKey:   privateKey,
+	}, nil
+}
+
+// getToken returns the access token, a new token is signed when the current
+// token is (about to be) expired.
+func (c *credentials) getToken() (string, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	now := time.Now()
+	if c.token != "" && now.Add(time.Minute).Before(c.expires) {
+		return c.token, nil
+	}
+
+	expires := now.Add(tokenExpiration)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{
+		Issuer:    c.clientEmail,
+		Subject:   c.clientEmail,
+		Audience:  tokenAudience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expires.Unix(),
+	})
+	token.Header["kid"] = c.privateKeyID
+
+	signed, err := token.SignedString(c.privateKey)
+	if err != nil {
+		return "", errors.Wrap(err, "sign access token error")
+	}
+
+	c.token = signed
+	c.expires = expires
+
+	return c.token, nil
+}
@@ -0,0 +1,26 @@
+package gcppubsub
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "integration_gcp_pub_sub_event_count",
+		Help: "The number of gateway events published by the GCP Pub/Sub integration (per event).",
+	}, []string{"event"})
+
+	cc = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "integration_gcp_pub_sub_command_count",
+		Help: "The number of commands received by the GCP Pub/Sub integration (per command).",
+	}, []string{"command"})
+)
+
+func pubSubEventCounter(e string) prometheus.Counter {
+	return ec.With(prometheus.Labels{"event": e})
+}
+
+func pubSubCommandCounter(c string) prometheus.Counter {
+	return cc.With(prometheus.Labels{"command": c})
+}
@@ -0,0 +1,183 @@
+// Package grpc implements a gRPC integration, streaming the gateway events
+// to the connected clients and accepting downlink frames.
+package grpc
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	gogrpc "google.golang.org/grpc"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+// streamBufferSize defines the number of events that are buffered per
+// client. Events are dropped when a client is not able to keep up.
+const streamBufferSize = 100
+
+// Backend implements a gRPC integration.
+type Backend struct {
+	bind     string
+	server   *gogrpc.Server
+	listener net.Listener
+
+	downlinkFrameFunc             func(gw.DownlinkFrame)
+	gatewayConfigurationFunc      func(gw.GatewayConfiguration)
+	gatewayCommandExecRequestFunc func(gw.GatewayCommandExecRequest)
+	rawPacketForwarderCommandFunc func(gw.RawPacketForwarderCommand)
+
+	streamsMux sync.RWMutex
+	streams    map[chan proto.Message]string
+}
+
+// NewBackend creates a new Backend.
+func NewBackend(conf config.Config) (*Backend, error) {
+	b := Backend{
+		bind:    conf.Integration.GRPC.Bind,
+		server:  gogrpc.NewServer(),
+		streams: make(map[chan proto.Message]string),
+	}
+
+	b.server.RegisterService(&serviceDesc, &b)
+
+	return &b, nil
+}
+
+// Start starts the integration.
+func (b *Backend) Start() error {
+	var err error
+	b.listener, err = net.Listen("tcp", b.bind)
+	if err != nil {
+		return errors.Wrap(err, "integration/grpc: listen error")
+	}
+
+	log.WithFields(log.Fields{
+		"bind": b.listener.Addr(),
+	}).Info("integration/grpc: starting gRPC server")
+
+	go func() {
+		if err := b.server.Serve(b.listener); err != nil {
+			log.WithError(err).Error("integration/grpc: gRPC server error")
+		}
+	}()
+
+	return nil
+}
+
+// Stop stops the integration.
+func (b *Backend) Stop() error {
+	b.server.Stop()
+	return nil
+}
+
+// SetDownlinkFrameFunc sets the DownlinkFrame handler func.
+func (b *Backend) SetDownlinkFrameFunc(f func(gw.DownlinkFrame)) {
+	b.downlinkFrameFunc = f
+}
+
+// SetGatewayConfigurationFunc sets the GatewayConfiguration handler func.
+func (b *Backend) SetGatewayConfigurationFunc(f func(gw.GatewayConfiguration)) {
+	b.gatewayConfigurationFunc = f
+}
+
+// SetGatewayCommandExecRequestFunc sets the GatewayCommandExecRequest handler func.
+func (b *Backend) SetGatewayCommandExecRequestFunc(f func(gw.GatewayCommandExecRequest)) {
+	b.gatewayCommandExecRequestFunc = f
+}
+
+// SetRawPacketForwarderCommandFunc sets the RawPacketForwarderCommand handler func.
+func (b *Backend) SetRawPacketForwarderCommandFunc(f func(gw.RawPacketForwarderCommand)) {
+	b.rawPacketForwarderCommandFunc = f
+}
+
+// SetGatewaySubscription is a no-op, as the events of all gateways are
+// streamed to the connected clients.
+func (b *Backend) SetGatewaySubscription(subscribe bool, gatewayID lorawan.EUI64) error {
+	return nil
+}
+
+// PublishEvent sends the given event to the clients streaming this event.
+func (b *Backend) PublishEvent(gatewayID lorawan.EUI64, event string, id uuid.UUID, v proto.Message) error {
+	b.streamsMux.RLock()
+	defer b.streamsMux.RUnlock()
+
+	for ch, e := range b.streams {
+		if e != event {
+			continue
+		}
+
+		select {
+		case ch <- v:
+			grpcEventCounter(event).Inc()
+		default:
+			log.WithFields(log.Fields{
+				"gateway_id": gatewayID,
+				"event":      event,
+			}).Warning("integration/grpc: stream buffer is full, event dropped")
+		}
+	}
+
+	return nil
+}
+
+// PublishState is a no-op, as the gRPC integration does not expose states.
+func (b *Backend) PublishState(gatewayID lorawan.EUI64, state string, v proto.Message) error {
+	return nil
+}
+
+func (b *Backend) sendDownlinkFrame(ctx context.Context, pl *gw.DownlinkFrame) (*empty.Empty, error) {
+	grpcCommandCounter("down").Inc()
+
+	var gatewayID lorawan.EUI64
+	var downID uuid.UUID
+	copy(gatewayID[:], pl.GetGatewayId())
+	copy(downID[:], pl.GetDownlinkId())
+
+	log.WithFields(log.Fields{
+		"gateway_id":  gatewayID,
+		"downlink_id": downID,
+	}).Info("integration/grpc: downlink frame received")
+
+	if b.downlinkFrameFunc != nil {
+		b.downlinkFrameFunc(*pl)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+func (b *Backend) streamEvents(event string, stream gogrpc.ServerStream) error {
+	ch := make(chan proto.Message, streamBufferSize)
+
+	b.streamsMux.Lock()
+	b.streams[ch] = event
+	b.streamsMux.Unlock()
+
+	log.WithField("event", event).Info("integration/grpc: client started streaming events")
+
+	defer func() {
+		b.streamsMux.Lock()
+		delete(b.streams, ch)
+		b.streamsMux.Unlock()
+
+		log.WithField("event", event).Info("integration/grpc: client stopped streaming events")
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case v := <-ch:
+			if err := stream.SendMsg(v); err != nil {
+				return errors.Wrap(err, "send event error")
+			}
+		}
+	}
+}
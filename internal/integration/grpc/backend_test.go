@@ -0,0 +1,126 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	gogrpc "google.golang.org/grpc"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+type GRPCBackendTestSuite struct {
+	suite.Suite
+
+	backend   *Backend
+	conn      *gogrpc.ClientConn
+	gatewayID lorawan.EUI64
+}
+
+func (ts *GRPCBackendTestSuite) SetupSuite() {
+	assert := require.New(ts.T())
+
+	ts.gatewayID = lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}
+
+	var conf config.Config
+	conf.Integration.GRPC.Enabled = true
+	conf.Integration.GRPC.Bind = "127.0.0.1:0"
+
+	var err error
+	ts.backend, err = NewBackend(conf)
+	assert.NoError(err)
+	assert.NoError(ts.backend.Start())
+
+	ts.conn, err = gogrpc.Dial(ts.backend.listener.Addr().String(), gogrpc.WithInsecure(), gogrpc.WithBlock())
+	assert.NoError(err)
+}
+
+func (ts *GRPCBackendTestSuite) TearDownSuite() {
+	ts.conn.Close()
+	ts.backend.Stop()
+}
+
+func (ts *GRPCBackendTestSuite) TestStreamUplinkFrames() {
+	assert := require.New(ts.T())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := ts.conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+ServiceName+"/StreamUplinkFrames")
+	assert.NoError(err)
+	assert.NoError(stream.SendMsg(&empty.Empty{}))
+	assert.NoError(stream.CloseSend())
+
+	// wait until the stream has been registered
+	for i := 0; i < 100; i++ {
+		ts.backend.streamsMux.RLock()
+		n := len(ts.backend.streams)
+		ts.backend.streamsMux.RUnlock()
+		if n != 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	id, err := uuid.NewV4()
+	assert.NoError(err)
+
+	uplink := gw.UplinkFrame{
+		PhyPayload: []byte{1, 2, 3, 4},
+		RxInfo: &gw.UplinkRXInfo{
+			GatewayId: ts.gatewayID[:],
+			UplinkId:  id[:],
+		},
+	}
+
+	// stats must not be sent to the uplink stream
+	assert.NoError(ts.backend.PublishEvent(ts.gatewayID, "stats", id, &gw.GatewayStats{}))
+	assert.NoError(ts.backend.PublishEvent(ts.gatewayID, "up", id, &uplink))
+
+	var received gw.UplinkFrame
+	assert.NoError(stream.RecvMsg(&received))
+	assert.Equal(uplink.PhyPayload, received.PhyPayload)
+	assert.Equal(uplink.RxInfo.UplinkId, received.RxInfo.UplinkId)
+}
+
+func (ts *GRPCBackendTestSuite) TestSendDownlinkFrame() {
+	assert := require.New(ts.T())
+
+	downlinkFrameChan := make(chan gw.DownlinkFrame, 1)
+	ts.backend.SetDownlinkFrameFunc(func(pl gw.DownlinkFrame) {
+		downlinkFrameChan <- pl
+	})
+
+	id, err := uuid.NewV4()
+	assert.NoError(err)
+
+	pl := gw.DownlinkFrame{
+		GatewayId:  ts.gatewayID[:],
+		DownlinkId: id[:],
+		Token:      1234,
+		Items: []*gw.DownlinkFrameItem{
+			{
+				PhyPayload: []byte{1, 2, 3, 4},
+			},
+		},
+	}
+
+	var resp empty.Empty
+	assert.NoError(ts.conn.Invoke(context.Background(), "/"+ServiceName+"/SendDownlinkFrame", &pl, &resp))
+
+	received := <-downlinkFrameChan
+	assert.Equal(pl.DownlinkId, received.DownlinkId)
+	assert.Equal(pl.Token, received.Token)
+	assert.Equal(pl.Items[0].PhyPayload, received.Items[0].PhyPayload)
+}
+
+func TestGRPCBackend(t *testing.T) {
+	suite.Run(t, new(GRPCBackendTestSuite))
+}
@@ -0,0 +1,85 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	gogrpc "google.golang.org/grpc"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+)
+
+// The gRPC service is defined by hand as it only uses the messages that are
+// already defined by the gw package:
+//
+//   service GatewayBridgeService {
+//       rpc StreamUplinkFrames(google.protobuf.Empty) returns (stream gw.UplinkFrame);
+//       rpc StreamUplinkFrameSets(google.protobuf.Empty) returns (stream gw.UplinkFrameSet);
+//       rpc StreamGatewayStats(google.protobuf.Empty) returns (stream gw.GatewayStats);
+//       rpc StreamDownlinkTXAcks(google.protobuf.Empty) returns (stream gw.DownlinkTXAck);
+//       rpc SendDownlinkFrame(gw.DownlinkFrame) returns (google.protobuf.Empty);
+//   }
+
+// ServiceName defines the name of the gRPC service.
+const ServiceName = "gw.GatewayBridgeService"
+
+var serviceDesc = gogrpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []gogrpc.MethodDesc{
+		{
+			MethodName: "SendDownlinkFrame",
+			Handler:    sendDownlinkFrameHandler,
+		},
+	},
+	Streams: []gogrpc.StreamDesc{
+		{
+			StreamName:    "StreamUplinkFrames",
+			Handler:       streamEventsHandler("up"),
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamUplinkFrameSets",
+			Handler:       streamEventsHandler("up_set"),
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamGatewayStats",
+			Handler:       streamEventsHandler("stats"),
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamDownlinkTXAcks",
+			Handler:       streamEventsHandler("ack"),
+			ServerStreams: true,
+		},
+	},
+	Metadata: "gw/gw.proto",
+}
+
+func sendDownlinkFrameHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor gogrpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(gw.DownlinkFrame)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(*Backend).sendDownlinkFrame(ctx, in)
+	}
+	info := &gogrpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + ServiceName + "/SendDownlinkFrame",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(*Backend).sendDownlinkFrame(ctx, req.(*gw.DownlinkFrame))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func streamEventsHandler(event string) gogrpc.StreamHandler {
+	return func(srv interface{}, stream gogrpc.ServerStream) error {
+		if err := stream.RecvMsg(new(empty.Empty)); err != nil {
+			return err
+		}
+		return srv.(*Backend).streamEvents(event, stream)
+	}
+}
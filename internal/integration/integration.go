@@ -1,12 +1,17 @@
 package integration
 
 import (
+	"fmt"
+
 	"github.com/gofrs/uuid"
 	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
 
 	"github.com/brocaar/chirpstack-api/go/v3/gw"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/integration/dryrun"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/integration/gcppubsub"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/integration/grpc"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/integration/mqtt"
 	"github.com/brocaar/lorawan"
 )
@@ -14,19 +19,55 @@ import (
 // Event types.
 const (
 	EventUp    = "up"
+	EventUpSet = "up_set"
 	EventStats = "stats"
 	EventAck   = "ack"
 	EventRaw   = "raw"
+
+	EventConfigAck = "config_ack"
 )
 
 var integration Integration
 
-// Setup configures the integration.
+// Setup configures the integration. The integration is selected by the
+// configured integration type. When the gRPC integration is enabled, it is
+// used in addition to the selected integration. In dry-run mode, the events
+// are logged instead of published using the selected integration.
 func Setup(conf config.Config) error {
-	var err error
-	integration, err = mqtt.NewBackend(conf)
+	var (
+		typeIntegration Integration
+		err             error
+	)
+
+	if conf.Integration.DryRun {
+		typeIntegration, err = dryrun.NewBackend(conf)
+	} else {
+		switch conf.Integration.Type {
+		case "mqtt":
+			typeIntegration, err = mqtt.NewBackend(conf)
+		case "gcp_pub_sub":
+			typeIntegration, err = gcppubsub.NewBackend(conf)
+		default:
+			return fmt.Errorf("unknown integration type: %s", conf.Integration.Type)
+		}
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "setup %s integration error", conf.Integration.Type)
+	}
+
+	if !conf.Integration.GRPC.Enabled {
+		integration = typeIntegration
+		return nil
+	}
+
+	grpcIntegration, err := grpc.NewBackend(conf)
 	if err != nil {
-		return errors.Wrap(err, "setup mqtt integration error")
+		return errors.Wrap(err, "setup grpc integration error")
+	}
+
+	integration = &multiIntegration{
+		integrations: []Integration{typeIntegration, grpcIntegration},
 	}
 
 	return nil
@@ -37,6 +78,15 @@ func GetIntegration() Integration {
 	return integration
 }
 
+// Reload applies the given configuration to the integration, in case the
+// integration supports reloading (see Reloader).
+func Reload(conf config.Config) error {
+	if r, ok := integration.(Reloader); ok {
+		return r.Reload(conf)
+	}
+	return nil
+}
+
 // Integration defines the interface that an integration must implement.
 type Integration interface {
 	// SetGatewaySubscription updates the gateway subscription for the given
@@ -68,3 +118,11 @@ type Integration interface {
 	// Stop stops the integration.
 	Stop() error
 }
+
+// Reloader defines the interface of an integration that can apply a new
+// configuration without restart.
+type Reloader interface {
+	// Reload applies the given configuration. Options that can't be applied
+	// without restart are ignored.
+	Reload(config.Config) error
+}
@@ -0,0 +1,28 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/integration/dryrun"
+)
+
+func TestSetupUnknownType(t *testing.T) {
+	assert := require.New(t)
+
+	var conf config.Config
+	conf.Integration.Type = "amqp"
+	assert.EqualError(Setup(conf), "unknown integration type: amqp")
+}
+
+func TestSetupDryRun(t *testing.T) {
+	assert := require.New(t)
+
+	// no broker (or integration type) is required in dry-run mode
+	var conf config.Config
+	conf.Integration.DryRun = true
+	assert.NoError(Setup(conf))
+	assert.IsType(&dryrun.Backend{}, GetIntegration())
+}
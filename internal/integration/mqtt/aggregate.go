@@ -0,0 +1,71 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// aggregateMetrics holds the aggregate counters of the MQTT integration
+// since start, as published to the aggregate metrics topic.
+type aggregateMetrics struct {
+	Time       time.Time `json:"time"`
+	Uplinks    uint64    `json:"uplinks"`
+	Stats      uint64    `json:"stats"`
+	Acks       uint64    `json:"acks"`
+	Downlinks  uint64    `json:"downlinks"`
+	Errors     uint64    `json:"errors"`
+	Reconnects uint64    `json:"reconnects"`
+}
+
+// getAggregateMetrics returns the aggregate metrics, based on the current
+// values of the Prometheus counters.
+func getAggregateMetrics() aggregateMetrics {
+	return aggregateMetrics{
+		Time:       time.Now().UTC(),
+		Uplinks:    counterValue(mqttEventCounter("up")) + counterValue(mqttEventCounter("up_set")),
+		Stats:      counterValue(mqttEventCounter("stats")),
+		Acks:       counterValue(mqttEventCounter("ack")),
+		Downlinks:  counterValue(mqttCommandCounter("down")),
+		Errors:     counterValue(mqttPublishErrorCounter()),
+		Reconnects: counterValue(mqttReconnectCounter()),
+	}
+}
+
+// aggregateMetricsLoop publishes the aggregate metrics using the given
+// interval, until the integration is closed.
+func (b *Backend) aggregateMetricsLoop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		if b.isClosed() {
+			return
+		}
+
+		if !b.conn.IsConnected() {
+			continue
+		}
+
+		if err := b.publishAggregateMetrics(); err != nil {
+			log.WithError(err).Error("integration/mqtt: publish aggregate metrics error")
+		}
+	}
+}
+
+// publishAggregateMetrics publishes the aggregate metrics as JSON to the
+// aggregate metrics topic.
+func (b *Backend) publishAggregateMetrics() error {
+	bytes, err := json.Marshal(getAggregateMetrics())
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	log.WithFields(log.Fields{
+		"topic": b.aggregateMetricsTopic,
+		"qos":   b.qos,
+	}).Debug("integration/mqtt: publishing aggregate metrics")
+
+	return waitToken(b.conn.Publish(b.aggregateMetricsTopic, b.qos, false, bytes), b.publishTimeout)
+}
@@ -3,6 +3,7 @@ package auth
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"io/ioutil"
 	"time"
 
@@ -28,26 +29,72 @@ type Authentication interface {
 	ReconnectAfter() time.Duration
 }
 
-func newTLSConfig(cafile, certFile, certKeyFile string) (*tls.Config, error) {
-	if cafile == "" && certFile == "" && certKeyFile == "" {
+// tlsVersions contains the supported TLS min. versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsOptions holds the options of the TLS config.
+type tlsOptions struct {
+	CACert            string
+	TLSCert           string
+	TLSKey            string
+	TLSKeyPassword    string
+	MinVersion        string
+	CipherSuites      []string
+	UseSystemCertPool bool
+}
+
+// newTLSConfig returns the TLS config. When the min. version or the cipher
+// suites are not set, the Go defaults are used. The key password must be set
+// when the key file is encrypted. When the system cert pool is used, the CA
+// certificate is added to the system cert pool instead of replacing it. It
+// returns nil when nothing is configured.
+func newTLSConfig(opts tlsOptions) (*tls.Config, error) {
+	if opts.CACert == "" && opts.TLSCert == "" && opts.TLSKey == "" && opts.MinVersion == "" && len(opts.CipherSuites) == 0 {
 		return nil, nil
 	}
 
 	tlsConfig := &tls.Config{}
 
-	if cafile != "" {
-		cacert, err := ioutil.ReadFile(cafile)
+	if opts.MinVersion != "" {
+		v, ok := tlsVersions[opts.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid tls min. version: %s (valid: 1.0, 1.1, 1.2, 1.3)", opts.MinVersion)
+		}
+		tlsConfig.MinVersion = v
+	}
+
+	if len(opts.CipherSuites) != 0 {
+		ids, err := tlsCipherSuiteIDs(opts.CipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.CipherSuites = ids
+	}
+
+	if opts.CACert != "" {
+		cacert, err := ioutil.ReadFile(opts.CACert)
 		if err != nil {
 			return nil, errors.Wrap(err, "load ca-cert error")
 		}
 		certpool := x509.NewCertPool()
+		if opts.UseSystemCertPool {
+			certpool, err = x509.SystemCertPool()
+			if err != nil {
+				return nil, errors.Wrap(err, "load system cert pool error")
+			}
+		}
 		certpool.AppendCertsFromPEM(cacert)
 
 		tlsConfig.RootCAs = certpool // RootCAs = certs used to verify server cert.
 	}
 
-	if certFile != "" && certKeyFile != "" {
-		kp, err := tls.LoadX509KeyPair(certFile, certKeyFile)
+	if opts.TLSCert != "" && opts.TLSKey != "" {
+		kp, err := loadX509KeyPair(opts.TLSCert, opts.TLSKey, opts.TLSKeyPassword)
 		if err != nil {
 			return nil, errors.Wrap(err, "load tls key-pair error")
 		}
@@ -56,3 +103,23 @@ func newTLSConfig(cafile, certFile, certKeyFile string) (*tls.Config, error) {
 
 	return tlsConfig, nil
 }
+
+// tlsCipherSuiteIDs returns the IDs of the given cipher suite names
+// (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256). Note that the cipher suites
+// are not configurable for TLS 1.3.
+func tlsCipherSuiteIDs(names []string) ([]uint16, error) {
+	suites := make(map[string]uint16)
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[cs.Name] = cs.ID
+	}
+
+	var out []uint16
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid tls cipher suite: %s", name)
+		}
+		out = append(out, id)
+	}
+	return out, nil
+}
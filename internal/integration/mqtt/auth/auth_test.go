@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTLSConfig(t *testing.T) {
+	tests := []struct {
+		Name         string
+		MinVersion   string
+		CipherSuites []string
+
+		ExpectedNil          bool
+		ExpectedMinVersion   uint16
+		ExpectedCipherSuites []uint16
+		ExpectedError        bool
+	}{
+		{
+			Name:        "nothing configured",
+			ExpectedNil: true,
+		},
+		{
+			Name:               "min. version",
+			MinVersion:         "1.2",
+			ExpectedMinVersion: tls.VersionTLS12,
+		},
+		{
+			Name:          "invalid min. version",
+			MinVersion:    "1.4",
+			ExpectedError: true,
+		},
+		{
+			Name:                 "cipher suites",
+			CipherSuites:         []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+			ExpectedCipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		},
+		{
+			Name:          "invalid cipher suite",
+			CipherSuites:  []string{"TLS_FOO"},
+			ExpectedError: true,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			tlsConfig, err := newTLSConfig(tlsOptions{MinVersion: tst.MinVersion, CipherSuites: tst.CipherSuites})
+			if tst.ExpectedError {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+
+			if tst.ExpectedNil {
+				assert.Nil(tlsConfig)
+				return
+			}
+
+			assert.Equal(tst.ExpectedMinVersion, tlsConfig.MinVersion)
+			assert.Equal(tst.ExpectedCipherSuites, tlsConfig.CipherSuites)
+		})
+	}
+}
+
+func TestNewTLSConfigSystemCertPool(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	ca := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, &ca, &ca, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	f, err := ioutil.TempFile("", "ca")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	require.NoError(t, pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: caDER}))
+	require.NoError(t, f.Close())
+
+	systemPool, err := x509.SystemCertPool()
+	require.NoError(t, err)
+
+	t.Run("CA certificate only", func(t *testing.T) {
+		assert := require.New(t)
+
+		tlsConfig, err := newTLSConfig(tlsOptions{CACert: f.Name()})
+		assert.NoError(err)
+		assert.Len(tlsConfig.RootCAs.Subjects(), 1)
+	})
+
+	t.Run("CA certificate and system cert pool", func(t *testing.T) {
+		assert := require.New(t)
+
+		tlsConfig, err := newTLSConfig(tlsOptions{CACert: f.Name(), UseSystemCertPool: true})
+		assert.NoError(err)
+		assert.Len(tlsConfig.RootCAs.Subjects(), len(systemPool.Subjects())+1)
+	})
+}
@@ -1,7 +1,14 @@
 package auth
 
 import (
+	"bytes"
 	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -12,6 +19,25 @@ import (
 	"github.com/brocaar/lorawan"
 )
 
+// serverSchemes contains the supported MQTT server URL schemes.
+var serverSchemes = map[string]struct{}{
+	"tcp":      {},
+	"mqtt":     {},
+	"ssl":      {},
+	"tls":      {},
+	"mqtts":    {},
+	"mqtt+ssl": {},
+	"tcps":     {},
+	"ws":       {},
+	"wss":      {},
+}
+
+// clientIDContext holds the variables that can be used within the client ID
+// template.
+type clientIDContext struct {
+	Hostname string
+}
+
 // GenericAuthentication implements a generic MQTT authentication.
 type GenericAuthentication struct {
 	servers      []string
@@ -19,31 +45,109 @@ type GenericAuthentication struct {
 	password     string
 	cleanSession bool
 	clientID     string
+	httpHeaders  http.Header
+
+	// The password is read on each (re)connect from the output of the
+	// password command or from the password file, when configured.
+	passwordCommand string
+	passwordFile    string
 
 	tlsConfig *tls.Config
 }
 
 // NewGenericAuthentication creates a GenericAuthentication.
 func NewGenericAuthentication(conf config.Config) (Authentication, error) {
-	tlsConfig, err := newTLSConfig(
-		conf.Integration.MQTT.Auth.Generic.CACert,
-		conf.Integration.MQTT.Auth.Generic.TLSCert,
-		conf.Integration.MQTT.Auth.Generic.TLSKey,
-	)
+	tlsConfig, err := newTLSConfig(tlsOptions{
+		CACert:            conf.Integration.MQTT.Auth.Generic.CACert,
+		TLSCert:           conf.Integration.MQTT.Auth.Generic.TLSCert,
+		TLSKey:            conf.Integration.MQTT.Auth.Generic.TLSKey,
+		TLSKeyPassword:    conf.Integration.MQTT.Auth.Generic.TLSKeyPassword,
+		MinVersion:        conf.Integration.MQTT.Auth.Generic.TLSMinVersion,
+		CipherSuites:      conf.Integration.MQTT.Auth.Generic.TLSCipherSuites,
+		UseSystemCertPool: conf.Integration.MQTT.Auth.Generic.TLSUseSystemCertPool,
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "mqtt/auth: new tls config error")
 	}
 
+	clientID, err := renderClientID(conf.Integration.MQTT.Auth.Generic.ClientID)
+	if err != nil {
+		return nil, errors.Wrap(err, "mqtt/auth: render client id error")
+	}
+
+	for _, server := range conf.Integration.MQTT.Auth.Generic.Servers {
+		if err := validateServer(server); err != nil {
+			return nil, errors.Wrap(err, "mqtt/auth: invalid server")
+		}
+	}
+
+	if conf.Integration.MQTT.Auth.Generic.PasswordCommand != "" && conf.Integration.MQTT.Auth.Generic.PasswordFile != "" {
+		return nil, errors.New("mqtt/auth: password_command and password_file can not be used together")
+	}
+
+	if conf.Integration.MQTT.Auth.Generic.PasswordCommand != "" && strings.TrimSpace(conf.Integration.MQTT.Auth.Generic.PasswordCommand) == "" {
+		return nil, errors.New("mqtt/auth: password_command must contain a command")
+	}
+
+	httpHeaders := make(http.Header)
+	for k, v := range conf.Integration.MQTT.Auth.Generic.HTTPHeaders {
+		httpHeaders.Set(k, v)
+	}
+
 	return &GenericAuthentication{
 		tlsConfig:    tlsConfig,
 		servers:      conf.Integration.MQTT.Auth.Generic.Servers,
 		username:     conf.Integration.MQTT.Auth.Generic.Username,
 		password:     conf.Integration.MQTT.Auth.Generic.Password,
 		cleanSession: conf.Integration.MQTT.Auth.Generic.CleanSession,
-		clientID:     conf.Integration.MQTT.Auth.Generic.ClientID,
+		clientID:     clientID,
+		httpHeaders:  httpHeaders,
+
+		passwordCommand: conf.Integration.MQTT.Auth.Generic.PasswordCommand,
+		passwordFile:    conf.Integration.MQTT.Auth.Generic.PasswordFile,
 	}, nil
 }
 
+// validateServer validates that the given server is a valid URL using one of
+// the supported schemes.
+func validateServer(server string) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return errors.Wrap(err, "parse url error")
+	}
+
+	if _, ok := serverSchemes[u.Scheme]; !ok {
+		return fmt.Errorf("unsupported scheme %q in server %s", u.Scheme, server)
+	}
+
+	return nil
+}
+
+// renderClientID renders the given client ID template. An empty client ID is
+// returned as-is, in which case a random client ID is generated on connect.
+func renderClientID(clientID string) (string, error) {
+	if clientID == "" {
+		return "", nil
+	}
+
+	tmpl, err := template.New("client_id").Option("missingkey=error").Parse(clientID)
+	if err != nil {
+		return "", errors.Wrap(err, "parse template error")
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", errors.Wrap(err, "get hostname error")
+	}
+
+	out := bytes.NewBuffer(nil)
+	if err := tmpl.Execute(out, clientIDContext{Hostname: hostname}); err != nil {
+		return "", errors.Wrap(err, "execute template error")
+	}
+
+	return out.String(), nil
+}
+
 // Init applies the initial configuration.
 func (a *GenericAuthentication) Init(opts *mqtt.ClientOptions) error {
 	for _, server := range a.servers {
@@ -54,6 +158,16 @@ func (a *GenericAuthentication) Init(opts *mqtt.ClientOptions) error {
 	opts.SetCleanSession(a.cleanSession)
 	opts.SetClientID(a.clientID)
 
+	if a.passwordCommand != "" || a.passwordFile != "" {
+		opts.SetCredentialsProvider(a.credentialsProvider)
+	}
+
+	// the headers are sent with the WebSocket upgrade request (ws and wss)
+	if len(a.httpHeaders) != 0 {
+		opts.SetHTTPHeaders(a.httpHeaders)
+	}
+
+	// the tls config applies to both the ssl and wss schemes
 	if a.tlsConfig != nil {
 		opts.SetTLSConfig(a.tlsConfig)
 	}
@@ -1,8 +1,12 @@
 package auth
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 
+	paho "github.com/eclipse/paho.mqtt.golang"
+
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
 	"github.com/brocaar/lorawan"
 	"github.com/stretchr/testify/require"
@@ -34,4 +38,162 @@ func TestGenericAuthentication(t *testing.T) {
 			assert.Equal(&gatewayID, auth.GetGatewayID())
 		})
 	})
+
+	t.Run("Client ID template", func(t *testing.T) {
+		assert := require.New(t)
+
+		hostname, err := os.Hostname()
+		assert.NoError(err)
+
+		conf := conf
+		conf.Integration.MQTT.Auth.Generic.ClientID = "bridge-{{ .Hostname }}"
+
+		auth, err := NewGenericAuthentication(conf)
+		assert.NoError(err)
+		assert.Equal("bridge-"+hostname, auth.(*GenericAuthentication).clientID)
+		assert.Nil(auth.GetGatewayID())
+	})
+
+	t.Run("Empty client ID", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := conf
+		conf.Integration.MQTT.Auth.Generic.ClientID = ""
+
+		auth, err := NewGenericAuthentication(conf)
+		assert.NoError(err)
+		assert.Equal("", auth.(*GenericAuthentication).clientID)
+	})
+
+	t.Run("Invalid client ID template", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := conf
+		conf.Integration.MQTT.Auth.Generic.ClientID = "bridge-{{ .Foo }}"
+
+		_, err := NewGenericAuthentication(conf)
+		assert.Error(err)
+	})
+
+	t.Run("Server schemes", func(t *testing.T) {
+		tests := []struct {
+			Server string
+			Error  bool
+		}{
+			{"tcp://localhost:1883", false},
+			{"ssl://localhost:8883", false},
+			{"ws://localhost:8080/mqtt", false},
+			{"wss://localhost:8443/mqtt", false},
+			{"http://localhost:8080", true},
+			{"localhost:1883", true},
+			{"tcp://local host:1883", true},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.Server, func(t *testing.T) {
+				assert := require.New(t)
+
+				conf := conf
+				conf.Integration.MQTT.Auth.Generic.Servers = []string{tst.Server}
+
+				_, err := NewGenericAuthentication(conf)
+				if tst.Error {
+					assert.Error(err)
+				} else {
+					assert.NoError(err)
+				}
+			})
+		}
+	})
+
+	t.Run("HTTP headers", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := conf
+		conf.Integration.MQTT.Auth.Generic.Servers = []string{"wss://localhost:8443/mqtt"}
+		conf.Integration.MQTT.Auth.Generic.HTTPHeaders = map[string]string{"x-auth-token": "secret"}
+
+		auth, err := NewGenericAuthentication(conf)
+		assert.NoError(err)
+
+		opts := paho.NewClientOptions()
+		assert.NoError(auth.Init(opts))
+		assert.Equal("secret", opts.HTTPHeaders.Get("X-Auth-Token"))
+		assert.Equal("wss", opts.Servers[0].Scheme)
+	})
+
+	t.Run("Password command", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := conf
+		conf.Integration.MQTT.Auth.Generic.PasswordCommand = "echo token-1"
+
+		auth, err := NewGenericAuthentication(conf)
+		assert.NoError(err)
+
+		opts := paho.NewClientOptions()
+		assert.NoError(auth.Init(opts))
+		assert.NotNil(opts.CredentialsProvider)
+
+		username, password := opts.CredentialsProvider()
+		assert.Equal("foo", username)
+		assert.Equal("token-1", password)
+
+		t.Run("Command error", func(t *testing.T) {
+			assert := require.New(t)
+
+			auth.(*GenericAuthentication).passwordCommand = "false"
+			_, password := opts.CredentialsProvider()
+			assert.Equal("bar", password)
+		})
+	})
+
+	t.Run("Password file", func(t *testing.T) {
+		assert := require.New(t)
+
+		f, err := ioutil.TempFile("", "password")
+		assert.NoError(err)
+		defer os.Remove(f.Name())
+		assert.NoError(ioutil.WriteFile(f.Name(), []byte("token-1\n"), 0600))
+
+		conf := conf
+		conf.Integration.MQTT.Auth.Generic.PasswordFile = f.Name()
+
+		auth, err := NewGenericAuthentication(conf)
+		assert.NoError(err)
+
+		opts := paho.NewClientOptions()
+		assert.NoError(auth.Init(opts))
+
+		_, password := opts.CredentialsProvider()
+		assert.Equal("token-1", password)
+
+		// the file is re-read on each (re)connect
+		assert.NoError(ioutil.WriteFile(f.Name(), []byte("token-2\n"), 0600))
+		_, password = opts.CredentialsProvider()
+		assert.Equal("token-2", password)
+	})
+
+	t.Run("Password command and file", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := conf
+		conf.Integration.MQTT.Auth.Generic.PasswordCommand = "echo token"
+		conf.Integration.MQTT.Auth.Generic.PasswordFile = "/tmp/password"
+
+		_, err := NewGenericAuthentication(conf)
+		assert.Error(err)
+	})
+
+	t.Run("Static password", func(t *testing.T) {
+		assert := require.New(t)
+
+		auth, err := NewGenericAuthentication(conf)
+		assert.NoError(err)
+
+		opts := paho.NewClientOptions()
+		assert.NoError(auth.Init(opts))
+		assert.Nil(opts.CredentialsProvider)
+		assert.Equal("bar", opts.Password)
+	})
 }
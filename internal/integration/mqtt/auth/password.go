@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// passwordCommandTimeout defines the max. execution duration of the password
+// command.
+const passwordCommandTimeout = 10 * time.Second
+
+// credentialsProvider returns the credentials used on each (re)connect. The
+// password is read from the password command or file. In case this fails,
+// the configured (static) password is used.
+func (a *GenericAuthentication) credentialsProvider() (string, string) {
+	password, err := a.getPassword()
+	if err != nil {
+		log.WithError(err).Error("integration/mqtt/auth: get password error")
+		return a.username, a.password
+	}
+
+	return a.username, password
+}
+
+// getPassword returns the password, from the output of the password command
+// or from the password file. Trailing whitespace (e.g. a newline) is removed.
+func (a *GenericAuthentication) getPassword() (string, error) {
+	switch {
+	case a.passwordCommand != "":
+		parts := strings.Fields(a.passwordCommand)
+
+		ctx, cancel := context.WithTimeout(context.Background(), passwordCommandTimeout)
+		defer cancel()
+
+		out, err := exec.CommandContext(ctx, parts[0], parts[1:]...).Output()
+		if err != nil {
+			return "", errors.Wrap(err, "execute password command error")
+		}
+		return strings.TrimRight(string(out), " \t\r\n"), nil
+
+	case a.passwordFile != "":
+		b, err := ioutil.ReadFile(a.passwordFile)
+		if err != nil {
+			return "", errors.Wrap(err, "read password file error")
+		}
+		return strings.TrimRight(string(b), " \t\r\n"), nil
+	}
+
+	return a.password, nil
+}
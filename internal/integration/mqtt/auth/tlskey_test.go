@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// encryptPKCS8 returns the PKCS#8 EncryptedPrivateKeyInfo of the given
+// PrivateKeyInfo, using PBKDF2 (hmacWithSHA256) and AES-256-CBC.
+func encryptPKCS8(der, password []byte) ([]byte, error) {
+	salt := make([]byte, 8)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key(password, salt, 2048, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+
+	pad := aes.BlockSize - len(der)%aes.BlockSize
+	for i := 0; i < pad; i++ {
+		der = append(der, byte(pad))
+	}
+	encrypted := make([]byte, len(der))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, der)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: 2048,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ivBytes, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivBytes}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		EncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData:       encrypted,
+	})
+}
+
+func TestLoadX509KeyPair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlskey")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	certDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test"}}, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600))
+
+	ecDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	pkcs1Block, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", ecDER, []byte("secret"), x509.PEMCipherAES256)
+	require.NoError(t, err)
+
+	pkcs8DER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	encryptedPKCS8DER, err := encryptPKCS8(pkcs8DER, []byte("secret"))
+	require.NoError(t, err)
+
+	keys := map[string]*pem.Block{
+		"pkcs1": pkcs1Block,
+		"pkcs8": {Type: "ENCRYPTED PRIVATE KEY", Bytes: encryptedPKCS8DER},
+		"plain": {Type: "PRIVATE KEY", Bytes: pkcs8DER},
+	}
+	for name, block := range keys {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(block), 0600))
+	}
+
+	tests := []struct {
+		Name          string
+		Key           string
+		Password      string
+		ExpectedError error
+		Error         bool
+	}{
+		{
+			Name: "unencrypted key",
+			Key:  "plain",
+		},
+		{
+			Name:     "encrypted pkcs#1 key",
+			Key:      "pkcs1",
+			Password: "secret",
+		},
+		{
+			Name:          "encrypted pkcs#1 key, invalid password",
+			Key:           "pkcs1",
+			Password:      "foo",
+			ExpectedError: errInvalidKeyPassword,
+		},
+		{
+			Name:     "encrypted pkcs#8 key",
+			Key:      "pkcs8",
+			Password: "secret",
+		},
+		{
+			Name:          "encrypted pkcs#8 key, invalid password",
+			Key:           "pkcs8",
+			Password:      "foo",
+			ExpectedError: errInvalidKeyPassword,
+		},
+		{
+			Name:  "encrypted key, no password",
+			Key:   "pkcs8",
+			Error: true,
+		},
+		{
+			Name:     "unencrypted key, password",
+			Key:      "plain",
+			Password: "secret",
+			Error:    true,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			cert, err := loadX509KeyPair(certFile, filepath.Join(dir, tst.Key+".pem"), tst.Password)
+			if tst.ExpectedError != nil {
+				assert.Equal(tst.ExpectedError, err)
+				return
+			}
+			if tst.Error {
+				assert.Error(err)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(key.Public(), cert.PrivateKey.(*ecdsa.PrivateKey).Public())
+		})
+	}
+}
+
+func TestUnpadPKCS7(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Data     []byte
+		Expected []byte
+		Error    bool
+	}{
+		{
+			Name:     "valid padding",
+			Data:     []byte{1, 2, 3, 4, 5, 3, 3, 3},
+			Expected: []byte{1, 2, 3, 4, 5},
+		},
+		{
+			Name:     "full padding block",
+			Data:     []byte{1, 2, 3, 4, 5, 6, 7, 8, 8, 8, 8, 8, 8, 8, 8, 8},
+			Expected: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		},
+		{
+			Name:  "invalid padding byte",
+			Data:  []byte{1, 2, 3, 4, 5, 2, 3, 3},
+			Error: true,
+		},
+		{
+			Name:  "zero padding",
+			Data:  []byte{1, 2, 3, 4, 5, 6, 7, 0},
+			Error: true,
+		},
+		{
+			Name:  "padding exceeds block size",
+			Data:  []byte{9, 9, 9, 9, 9, 9, 9, 9, 9},
+			Error: true,
+		},
+		{
+			Name:  "empty",
+			Error: true,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			b, err := unpadPKCS7(tst.Data, 8)
+			if tst.Error {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tst.Expected, b)
+		})
+	}
+}
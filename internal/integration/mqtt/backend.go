@@ -2,13 +2,20 @@ package mqtt
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
 	"strings"
 	"sync"
 	"text/template"
 	"time"
 
 	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
 	"github.com/gofrs/uuid"
 	"github.com/golang/protobuf/jsonpb"
 	"github.com/golang/protobuf/proto"
@@ -18,9 +25,107 @@ import (
 	"github.com/brocaar/chirpstack-api/go/v3/gw"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/integration/mqtt/auth"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/status"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/uplinkmeta"
 	"github.com/brocaar/lorawan"
 )
 
+// jsonGatewayIDKey defines the key used by the JSON marshaler for the
+// gateway ID.
+const jsonGatewayIDKey = "gatewayID"
+
+// jsonPayloadKey defines the key used by the JSON marshaler for the
+// PHYPayload.
+const jsonPayloadKey = "phyPayload"
+
+// Payload encodings of the PHYPayload of the JSON encoded messages.
+const (
+	PayloadEncodingBase64 = "base64"
+	PayloadEncodingHex    = "hex"
+)
+
+// connectRetryInterval defines the default initial interval between two
+// connection attempts. This interval is doubled after each failed attempt, up
+// to the max. reconnect interval.
+const connectRetryInterval = 2 * time.Second
+
+// topicProbeTimeout defines the max. duration to wait for the broker to
+// acknowledge a topic probe.
+const topicProbeTimeout = 5 * time.Second
+
+// topicProbeType defines the event and state type used for rendering the
+// topic probe topics.
+const topicProbeType = "probe"
+
+// ErrEmptyTopic is returned when a topic template renders to an empty topic.
+var ErrEmptyTopic = errors.New("topic template rendered an empty topic")
+
+// ErrSubscribeDenied is returned when the broker rejects a subscription,
+// e.g. because of an ACL denial.
+var ErrSubscribeDenied = errors.New("subscription denied by broker")
+
+// ErrTimeout is returned when the broker did not acknowledge a publish or
+// (un)subscribe within the configured timeout.
+var ErrTimeout = errors.New("timeout waiting for broker acknowledgement")
+
+// subscribeRetry holds the number of failed subscribe attempts of a gateway
+// and the time of the next attempt. When stopped is set, the subscription is
+// not retried until the gateway re-registers or the connection is restored.
+type subscribeRetry struct {
+	failures int
+	next     time.Time
+	stopped  bool
+}
+
+// maintenanceWindow holds a maintenance window of the MQTT broker.
+type maintenanceWindow struct {
+	start time.Time
+	end   time.Time
+}
+
+// gatewayTopicContext holds the gateway ID variables that can be used within
+// the topic templates.
+type gatewayTopicContext struct {
+	GatewayID       lorawan.EUI64
+	GatewayIDString string
+	GatewayIDUpper  string
+	GatewayIDBytes  []byte
+
+	// MAC is an alias of GatewayID, for backwards compatibility.
+	MAC lorawan.EUI64
+}
+
+// newGatewayTopicContext returns the gateway ID variables of the given gateway.
+func newGatewayTopicContext(gatewayID lorawan.EUI64) gatewayTopicContext {
+	return gatewayTopicContext{
+		GatewayID:       gatewayID,
+		GatewayIDString: gatewayID.String(),
+		GatewayIDUpper:  strings.ToUpper(gatewayID.String()),
+		GatewayIDBytes:  gatewayID[:],
+		MAC:             gatewayID,
+	}
+}
+
+// eventTopicContext holds the variables that can be used within the event
+// topic template. The uplink variables are only set for uplink events.
+type eventTopicContext struct {
+	gatewayTopicContext
+	EventType string
+
+	Frequency       uint32
+	Modulation      string
+	DataRate        string
+	SpreadingFactor uint32
+	Bandwidth       uint32
+}
+
+// statsGroupTopicContext holds the variables that can be used within the
+// stats group topic template.
+type statsGroupTopicContext struct {
+	gatewayTopicContext
+	Group string
+}
+
 // Backend implements a MQTT backend.
 type Backend struct {
 	auth auth.Authentication
@@ -30,25 +135,135 @@ type Backend struct {
 	connClosed bool
 	clientOpts *paho.ClientOptions
 
+	authFailures         int
+	fallbackAuthFailures int
+	fallbackServer       string
+	fallbackUsername     string
+	fallbackPassword     string
+	fallbackActive       bool
+
 	downlinkFrameFunc             func(gw.DownlinkFrame)
 	gatewayConfigurationFunc      func(gw.GatewayConfiguration)
 	gatewayCommandExecRequestFunc func(gw.GatewayCommandExecRequest)
 	rawPacketForwarderCommandFunc func(gw.RawPacketForwarderCommand)
 
+	// Called once the gateways have been (re-)subscribed after connecting.
+	// connectedPending is set on connect and is guarded by the
+	// gatewaysSubscribedMux.
+	connectedFunc    func()
+	connectedPending bool
+
+	// Buffer of the received downlink frames, so that a slow downlink handler
+	// does not block the MQTT client. This is nil when the buffer is disabled.
+	downlinkBuffer         chan gw.DownlinkFrame
+	downlinkBufferOverflow string
+
 	gatewaysMux             sync.RWMutex
 	gateways                map[lorawan.EUI64]struct{}
+	gatewaysRemoved         map[lorawan.EUI64]time.Time
+	unsubscribeGrace        time.Duration
+	gatewaysRestored        map[lorawan.EUI64]time.Time
+	gatewaysReregistered    map[lorawan.EUI64]struct{}
+	gatewayStateFile        string
+	gatewayRestoreTimeout   time.Duration
 	gatewaysSubscribedMux   sync.Mutex
 	gatewaysSubscribed      map[lorawan.EUI64]struct{}
+	subscribeRetries        map[lorawan.EUI64]subscribeRetry
+	subscribeRetryInitial   time.Duration
+	subscribeRetryMax       time.Duration
+	subscribeRetryAttempts  int
+	subscribeBatchSize      int
 	terminateOnConnectError bool
+	maxConnectAttempts      int
+	connectRetryInterval    time.Duration
+	maxReconnectInterval    time.Duration
+	autoReconnect           bool
+	publishTimeout          time.Duration
+	subscribeTimeout        time.Duration
 	stateRetained           bool
+	topicProbe              bool
+
+	// Wildcard topic of the commands, when set this topic is subscribed
+	// instead of the command topic of each gateway. wildcardSubscribed and
+	// wildcardRetry are guarded by the gatewaysSubscribedMux.
+	downlinkWildcardTopic string
+	wildcardSubscribed    bool
+	wildcardRetry         time.Time
+
+	combineUplinkStats bool
+	statsCacheMux      sync.RWMutex
+	statsCache         map[lorawan.EUI64]*gw.GatewayStats
+
+	macSummary   bool
+	envelope     string
+	sortJSONKeys bool
+
+	// Compression of the published events. When compressionEvents is empty,
+	// all events are compressed.
+	compression            string
+	compressionEvents      map[string]struct{}
+	compressionTopicSuffix string
+
+	// Allowed keys of the published uplinks, this is nil when all keys are
+	// published.
+	uplinkAllowList allowTree
+
+	// Stats groups by gateway ID. The stats of these gateways are also
+	// published to the topic of each group (or only, when statsGroupOnly is
+	// set).
+	statsGroups             map[lorawan.EUI64][]string
+	statsGroupOnly          bool
+	statsGroupTopicTemplate *template.Template
+
+	// Topic and interval of the aggregate metrics, this is disabled when the
+	// topic is empty.
+	aggregateMetricsTopic    string
+	aggregateMetricsInterval time.Duration
+
+	// Monitored gateways of the keepalive alerts, this is disabled when no
+	// keepalive groups are configured.
+	keepaliveMux           sync.Mutex
+	keepaliveGateways      map[lorawan.EUI64]*keepaliveGateway
+	keepaliveInterval      time.Duration
+	keepaliveTopicTemplate *template.Template
+
+	// Maintenance windows of the MQTT broker, during which connection errors
+	// are logged at a lower level.
+	maintenanceWindows []maintenanceWindow
+
+	// Fields of the startup banner, logged once after the first successful
+	// connect. This is nil when the startup banner is disabled.
+	startupBanner     log.Fields
+	startupBannerOnce sync.Once
+
+	// Topic of the connection state of the bridge, set as last will and
+	// testament. This is empty when the connection state is disabled.
+	connectionStateTopic string
 
 	qos                  uint8
+	downlinkQOS          uint8
+	eventQOS             map[string]uint8
+	eventRetained        map[string]bool
 	eventTopicTemplate   *template.Template
 	stateTopicTemplate   *template.Template
 	commandTopicTemplate *template.Template
+	templateFuncs        template.FuncMap
+
+	// Topic template of the gateway connection states, this is nil when the
+	// connection states are published to the state topic.
+	gatewayConnStateTopicTemplate *template.Template
+
+	// templatesMux guards the topic templates above, as these can be
+	// replaced on a configuration reload (see Reload).
+	templatesMux sync.RWMutex
+
+	marshal    func(msg proto.Message) ([]byte, error)
+	unmarshal  func(b []byte, msg proto.Message) error
+	ackMarshal func(msg proto.Message) ([]byte, error)
 
-	marshal   func(msg proto.Message) ([]byte, error)
-	unmarshal func(b []byte, msg proto.Message) error
+	// jsonMarshaler is set when the json marshaler is used, in which case
+	// the uplink meta-data (see uplinkmeta) is added to the published uplinks.
+	jsonMarshaler bool
 }
 
 // NewBackend creates a new Backend.
@@ -58,10 +273,52 @@ func NewBackend(conf config.Config) (*Backend, error) {
 	b := Backend{
 		qos:                     conf.Integration.MQTT.Auth.Generic.QOS,
 		terminateOnConnectError: conf.Integration.MQTT.TerminateOnConnectError,
+		maxConnectAttempts:      conf.Integration.MQTT.MaxConnectAttempts,
+		connectRetryInterval:    connectRetryInterval,
+		maxReconnectInterval:    conf.Integration.MQTT.MaxReconnectInterval,
+		autoReconnect:           conf.Integration.MQTT.AutoReconnect,
+		publishTimeout:          conf.Integration.MQTT.PublishTimeout,
+		subscribeTimeout:        conf.Integration.MQTT.SubscribeTimeout,
 		clientOpts:              paho.NewClientOptions(),
 		gateways:                make(map[lorawan.EUI64]struct{}),
+		gatewaysRemoved:         make(map[lorawan.EUI64]time.Time),
+		gatewaysRestored:        make(map[lorawan.EUI64]time.Time),
+		gatewaysReregistered:    make(map[lorawan.EUI64]struct{}),
+		gatewayStateFile:        conf.Integration.MQTT.GatewayState.File,
+		gatewayRestoreTimeout:   conf.Integration.MQTT.GatewayState.RestoreTimeout,
+		unsubscribeGrace:        conf.Integration.MQTT.UnsubscribeGrace,
 		gatewaysSubscribed:      make(map[lorawan.EUI64]struct{}),
+		subscribeRetries:        make(map[lorawan.EUI64]subscribeRetry),
+		subscribeRetryInitial:   conf.Integration.MQTT.SubscribeRetry.InitialInterval,
+		subscribeRetryMax:       conf.Integration.MQTT.SubscribeRetry.MaxInterval,
+		subscribeRetryAttempts:  conf.Integration.MQTT.SubscribeRetry.MaxAttempts,
+		subscribeBatchSize:      conf.Integration.MQTT.SubscribeBatchSize,
+		downlinkWildcardTopic:   conf.Integration.MQTT.DownlinkWildcardTopic,
 		stateRetained:           conf.Integration.MQTT.StateRetained,
+		topicProbe:              conf.Integration.MQTT.TopicProbe,
+		combineUplinkStats:      conf.Integration.MQTT.CombineUplinkStats,
+		statsCache:              make(map[lorawan.EUI64]*gw.GatewayStats),
+		macSummary:              conf.Integration.MQTT.MACSummary,
+		envelope:                conf.Integration.MQTT.Envelope,
+		sortJSONKeys:            conf.Integration.MQTT.SortJSONKeys,
+		compression:             conf.Integration.MQTT.Compression,
+		compressionEvents:       make(map[string]struct{}),
+		compressionTopicSuffix:  conf.Integration.MQTT.CompressionTopicSuffix,
+		eventQOS:                make(map[string]uint8),
+		templateFuncs:           newTemplateFuncs(conf.Integration.MQTT.TopicTemplateEnvStrict),
+		eventRetained: map[string]bool{
+			"up":     conf.Integration.MQTT.EventRetained.Up,
+			"up_set": conf.Integration.MQTT.EventRetained.Up,
+			"stats":  conf.Integration.MQTT.EventRetained.Stats,
+			"ack":    conf.Integration.MQTT.EventRetained.Ack,
+		},
+
+		aggregateMetricsTopic:    conf.Integration.MQTT.AggregateMetrics.Topic,
+		aggregateMetricsInterval: conf.Integration.MQTT.AggregateMetrics.Interval,
+	}
+
+	if conf.Integration.MQTT.ReconnectInterval > 0 {
+		b.connectRetryInterval = conf.Integration.MQTT.ReconnectInterval
 	}
 
 	switch conf.Integration.MQTT.Auth.Type {
@@ -70,6 +327,19 @@ func NewBackend(conf config.Config) (*Backend, error) {
 		if err != nil {
 			return nil, errors.Wrap(err, "integation/mqtt: new generic authentication error")
 		}
+
+		b.fallbackServer = conf.Integration.MQTT.Auth.Generic.Fallback.Server
+		b.fallbackUsername = conf.Integration.MQTT.Auth.Generic.Fallback.Username
+		b.fallbackPassword = conf.Integration.MQTT.Auth.Generic.Fallback.Password
+		b.fallbackAuthFailures = conf.Integration.MQTT.Auth.Generic.Fallback.AuthFailures
+
+		// The auto reconnect of the MQTT client uses its own copy of the
+		// client options, the connect loop is required in order to count
+		// the authentication failures and to switch to the fallback server.
+		if b.fallbackServer != "" && b.autoReconnect {
+			log.Info("integration/mqtt: fallback server configured, auto reconnect disabled")
+			b.autoReconnect = false
+		}
 	case "gcp_cloud_iot_core":
 		b.auth, err = auth.NewGCPCloudIoTCoreAuthentication(conf)
 		if err != nil {
@@ -79,6 +349,7 @@ func NewBackend(conf config.Config) (*Backend, error) {
 		conf.Integration.MQTT.EventTopicTemplate = "/devices/gw-{{ .GatewayID }}/events/{{ .EventType }}"
 		conf.Integration.MQTT.CommandTopicTemplate = "/devices/gw-{{ .GatewayID }}/commands/#"
 		conf.Integration.MQTT.StateTopicTemplate = ""
+		conf.Integration.MQTT.GatewayConnStateTopicTemplate = ""
 	case "azure_iot_hub":
 		b.auth, err = auth.NewAzureIoTHubAuthentication(conf)
 		if err != nil {
@@ -88,58 +359,244 @@ func NewBackend(conf config.Config) (*Backend, error) {
 		conf.Integration.MQTT.EventTopicTemplate = "devices/{{ .GatewayID }}/messages/events/{{ .EventType }}"
 		conf.Integration.MQTT.CommandTopicTemplate = "devices/{{ .GatewayID }}/messages/devicebound/#"
 		conf.Integration.MQTT.StateTopicTemplate = ""
+		conf.Integration.MQTT.GatewayConnStateTopicTemplate = ""
 	default:
 		return nil, fmt.Errorf("integration/mqtt: unknown auth type: %s", conf.Integration.MQTT.Auth.Type)
 	}
 
-	switch conf.Integration.Marshaler {
-	case "json":
-		b.marshal = func(msg proto.Message) ([]byte, error) {
-			marshaler := &jsonpb.Marshaler{
-				EnumsAsInts:  false,
-				EmitDefaults: true,
+	b.marshal, b.unmarshal, err = newMarshaler(conf.Integration.Marshaler, conf.Integration.JSONGatewayIDKey, conf.Integration.JSONPayloadEncoding)
+	if err != nil {
+		return nil, errors.Wrap(err, "integration/mqtt: new marshaler error")
+	}
+	b.jsonMarshaler = conf.Integration.Marshaler == "json"
+
+	// gzip compressed commands are decompressed transparently
+	b.unmarshal = gzipUnmarshal(b.unmarshal)
+
+	// the acks use the marshaler of the other events, unless configured
+	// otherwise
+	b.ackMarshal = b.marshal
+	if conf.Integration.AckMarshaler != "" {
+		b.ackMarshal, _, err = newMarshaler(conf.Integration.AckMarshaler, conf.Integration.JSONGatewayIDKey, conf.Integration.JSONPayloadEncoding)
+		if err != nil {
+			return nil, errors.Wrap(err, "integration/mqtt: new ack marshaler error")
+		}
+	}
+
+	if b.gatewayStateFile != "" {
+		if err := b.loadGatewayState(); err != nil {
+			return nil, errors.Wrap(err, "integration/mqtt: load gateway state error")
+		}
+	}
+
+	if b.combineUplinkStats && conf.Integration.Marshaler != "json" {
+		return nil, errors.New("integration/mqtt: combine_uplink_stats requires the json marshaler")
+	}
+
+	if b.macSummary && conf.Integration.Marshaler != "json" {
+		return nil, errors.New("integration/mqtt: mac_summary requires the json marshaler")
+	}
+
+	if b.sortJSONKeys && (conf.Integration.Marshaler != "json" || (conf.Integration.AckMarshaler != "" && conf.Integration.AckMarshaler != "json")) {
+		return nil, errors.New("integration/mqtt: sort_json_keys requires the json marshaler")
+	}
+
+	if len(conf.Integration.MQTT.UplinkAllowList) != 0 {
+		if conf.Integration.Marshaler != "json" {
+			return nil, errors.New("integration/mqtt: uplink_allow_list requires the json marshaler")
+		}
+
+		gatewayIDKey := jsonGatewayIDKey
+		if conf.Integration.JSONGatewayIDKey != "" {
+			gatewayIDKey = conf.Integration.JSONGatewayIDKey
+		}
+
+		// the identifiers are always published
+		b.uplinkAllowList = newAllowTree(append([]string{
+			"rxInfo." + gatewayIDKey,
+			"rxInfo.uplinkID",
+		}, conf.Integration.MQTT.UplinkAllowList...))
+	}
+
+	switch b.compression {
+	case "", CompressionNone, CompressionGzip:
+	default:
+		return nil, fmt.Errorf("integration/mqtt: unknown compression: %s", b.compression)
+	}
+
+	for _, event := range conf.Integration.MQTT.CompressionEvents {
+		b.compressionEvents[event] = struct{}{}
+	}
+
+	if len(conf.Integration.MQTT.StatsGroups.Groups) != 0 {
+		b.statsGroupTopicTemplate, err = template.New("stats_group").Funcs(b.templateFuncs).Parse(conf.Integration.MQTT.StatsGroups.TopicTemplate)
+		if err != nil {
+			return nil, errors.Wrap(err, "integration/mqtt: parse stats group topic template error")
+		}
+		b.statsGroupOnly = conf.Integration.MQTT.StatsGroups.GroupOnly
+		b.statsGroups = make(map[lorawan.EUI64][]string)
+
+		for _, group := range conf.Integration.MQTT.StatsGroups.Groups {
+			for _, id := range group.GatewayIDs {
+				var gatewayID lorawan.EUI64
+				if err := gatewayID.UnmarshalText([]byte(id)); err != nil {
+					return nil, errors.Wrap(err, "integration/mqtt: unmarshal stats group gateway id error")
+				}
+				b.statsGroups[gatewayID] = append(b.statsGroups[gatewayID], group.Name)
 			}
-			str, err := marshaler.MarshalToString(msg)
-			return []byte(str), err
+		}
+	}
+
+	if b.qos > 2 {
+		return nil, fmt.Errorf("integration/mqtt: invalid qos %d, must be 0, 1 or 2", b.qos)
+	}
+
+	// The keep alive is sent in seconds, a sub-second value would silently
+	// disable the keep alive.
+	if ka := conf.Integration.MQTT.KeepAlive; ka < 0 || (ka > 0 && ka < time.Second) {
+		return nil, fmt.Errorf("integration/mqtt: invalid keep alive %s, must be at least 1s (or 0 to disable)", ka)
+	}
+
+	if size := conf.Integration.MQTT.DownlinkBuffer.Size; size != 0 {
+		if size < 0 {
+			return nil, fmt.Errorf("integration/mqtt: invalid downlink buffer size %d, must be 0 or greater", size)
 		}
 
-		b.unmarshal = func(b []byte, msg proto.Message) error {
-			unmarshaler := &jsonpb.Unmarshaler{
-				AllowUnknownFields: true, // we don't want to fail on unknown fields
+		b.downlinkBuffer = make(chan gw.DownlinkFrame, size)
+		b.downlinkBufferOverflow = conf.Integration.MQTT.DownlinkBuffer.Overflow
+		switch b.downlinkBufferOverflow {
+		case "":
+			b.downlinkBufferOverflow = DownlinkBufferDropOldest
+		case DownlinkBufferDropOldest, DownlinkBufferDropNewest:
+		default:
+			return nil, fmt.Errorf("integration/mqtt: unknown downlink buffer overflow: %s", b.downlinkBufferOverflow)
+		}
+	}
+
+	b.downlinkQOS = b.qos
+	if qos := conf.Integration.MQTT.DownlinkQOS; qos >= 0 {
+		if qos > 2 {
+			return nil, fmt.Errorf("integration/mqtt: invalid downlink qos %d, must be 0, 1 or 2 (or -1 to use the default qos)", qos)
+		}
+		b.downlinkQOS = uint8(qos)
+	}
+
+	for event, qos := range map[string]int{
+		"up":     conf.Integration.MQTT.EventQOS.Up,
+		"up_set": conf.Integration.MQTT.EventQOS.Up,
+		"stats":  conf.Integration.MQTT.EventQOS.Stats,
+		"ack":    conf.Integration.MQTT.EventQOS.Ack,
+	} {
+		if qos < 0 {
+			continue
+		}
+		if qos > 2 {
+			return nil, fmt.Errorf("integration/mqtt: invalid %s event qos %d, must be 0, 1 or 2 (or -1 to use the default qos)", event, qos)
+		}
+		b.eventQOS[event] = uint8(qos)
+	}
+
+	if b.aggregateMetricsTopic != "" && b.aggregateMetricsInterval <= 0 {
+		return nil, errors.New("integration/mqtt: aggregate_metrics interval must be greater than 0")
+	}
+
+	if len(conf.Integration.MQTT.KeepaliveAlerts.Groups) != 0 {
+		b.keepaliveTopicTemplate, err = template.New("keepalive").Funcs(b.templateFuncs).Parse(conf.Integration.MQTT.KeepaliveAlerts.TopicTemplate)
+		if err != nil {
+			return nil, errors.Wrap(err, "integration/mqtt: parse keepalive topic template error")
+		}
+		b.keepaliveInterval = conf.Integration.MQTT.KeepaliveAlerts.Interval
+		b.keepaliveGateways = make(map[lorawan.EUI64]*keepaliveGateway)
+
+		if b.keepaliveInterval <= 0 {
+			return nil, errors.New("integration/mqtt: keepalive_alerts interval must be greater than 0")
+		}
+
+		for _, group := range conf.Integration.MQTT.KeepaliveAlerts.Groups {
+			if group.Threshold <= 0 {
+				return nil, fmt.Errorf("integration/mqtt: keepalive group %s threshold must be greater than 0", group.Name)
+			}
+
+			for _, id := range group.GatewayIDs {
+				var gatewayID lorawan.EUI64
+				if err := gatewayID.UnmarshalText([]byte(id)); err != nil {
+					return nil, errors.Wrap(err, "integration/mqtt: unmarshal keepalive group gateway id error")
+				}
+				if _, ok := b.keepaliveGateways[gatewayID]; ok {
+					return nil, fmt.Errorf("integration/mqtt: gateway %s is part of multiple keepalive groups", gatewayID)
+				}
+
+				// gateways that never send events after start are also
+				// reported
+				b.keepaliveGateways[gatewayID] = &keepaliveGateway{
+					group:     group.Name,
+					threshold: group.Threshold,
+					lastSeen:  time.Now(),
+				}
 			}
-			return unmarshaler.Unmarshal(bytes.NewReader(b), msg)
 		}
-	case "protobuf":
-		b.marshal = func(msg proto.Message) ([]byte, error) {
-			return proto.Marshal(msg)
+	}
+
+	for _, w := range conf.Integration.MQTT.MaintenanceWindows {
+		start, err := time.Parse(time.RFC3339, w.Start)
+		if err != nil {
+			return nil, errors.Wrap(err, "integration/mqtt: parse maintenance window start error")
+		}
+		end, err := time.Parse(time.RFC3339, w.End)
+		if err != nil {
+			return nil, errors.Wrap(err, "integration/mqtt: parse maintenance window end error")
+		}
+		b.maintenanceWindows = append(b.maintenanceWindows, maintenanceWindow{start: start, end: end})
+	}
+
+	switch b.envelope {
+	case "", EnvelopeNone:
+	case EnvelopeCloudEvents:
+		if conf.Integration.Marshaler != "json" || (conf.Integration.AckMarshaler != "" && conf.Integration.AckMarshaler != "json") {
+			return nil, errors.New("integration/mqtt: cloudevents envelope requires the json marshaler")
 		}
 
+		unmarshal := b.unmarshal
 		b.unmarshal = func(b []byte, msg proto.Message) error {
-			return proto.Unmarshal(b, msg)
+			return unmarshal(unwrapCloudEvent(b), msg)
 		}
 	default:
-		return nil, fmt.Errorf("integration/mqtt: unknown marshaler: %s", conf.Integration.Marshaler)
+		return nil, fmt.Errorf("integration/mqtt: unknown envelope: %s", b.envelope)
 	}
 
-	b.eventTopicTemplate, err = template.New("event").Parse(conf.Integration.MQTT.EventTopicTemplate)
+	b.eventTopicTemplate, err = template.New("event").Funcs(b.templateFuncs).Parse(conf.Integration.MQTT.EventTopicTemplate)
 	if err != nil {
 		return nil, errors.Wrap(err, "integration/mqtt: parse event-topic template error")
 	}
 
 	if conf.Integration.MQTT.StateTopicTemplate != "" {
-		b.stateTopicTemplate, err = template.New("state").Parse(conf.Integration.MQTT.StateTopicTemplate)
+		b.stateTopicTemplate, err = template.New("state").Funcs(b.templateFuncs).Parse(conf.Integration.MQTT.StateTopicTemplate)
 		if err != nil {
 			return nil, errors.Wrap(err, "integration/mqtt: parse state-topic template error")
 		}
 	}
 
-	b.commandTopicTemplate, err = template.New("event").Parse(conf.Integration.MQTT.CommandTopicTemplate)
+	b.commandTopicTemplate, err = template.New("event").Funcs(b.templateFuncs).Parse(conf.Integration.MQTT.CommandTopicTemplate)
 	if err != nil {
 		return nil, errors.Wrap(err, "integration/mqtt: parse event-topic template error")
 	}
 
+	if conf.Integration.MQTT.GatewayConnStateTopicTemplate != "" {
+		b.gatewayConnStateTopicTemplate, err = template.New("gateway_conn_state").Funcs(b.templateFuncs).Parse(conf.Integration.MQTT.GatewayConnStateTopicTemplate)
+		if err != nil {
+			return nil, errors.Wrap(err, "integration/mqtt: parse gateway-conn-state-topic template error")
+		}
+	}
+
+	if err := b.validateTopicTemplates(); err != nil {
+		return nil, errors.Wrap(err, "integration/mqtt: validate topic templates error")
+	}
+
 	b.clientOpts.SetProtocolVersion(4)
-	b.clientOpts.SetAutoReconnect(true) // this is required for buffering messages in case offline!
+	// Note: the auto reconnect is required for buffering messages in case
+	// offline! When disabled, the integration reconnects using the connect
+	// retry interval (see onConnectionLost).
+	b.clientOpts.SetAutoReconnect(b.autoReconnect)
 	b.clientOpts.SetOnConnectHandler(b.onConnected)
 	b.clientOpts.SetConnectionLostHandler(b.onConnectionLost)
 	b.clientOpts.SetKeepAlive(conf.Integration.MQTT.KeepAlive)
@@ -149,6 +606,10 @@ func NewBackend(conf config.Config) (*Backend, error) {
 		return nil, errors.Wrap(err, "mqtt: init authentication error")
 	}
 
+	if conf.General.StartupBanner {
+		b.startupBanner = b.getStartupBanner(conf)
+	}
+
 	if gatewayID := b.auth.GetGatewayID(); gatewayID != nil {
 		log.WithFields(log.Fields{
 			"gateway_id": gatewayID,
@@ -159,7 +620,7 @@ func NewBackend(conf config.Config) (*Backend, error) {
 
 		// As we know the Gateway ID and a state topic has been configured, we set
 		// the last will and testament.
-		if b.stateTopicTemplate != nil {
+		if b.getStateTopicTemplate("conn") != nil {
 			pl := gw.ConnState{
 				GatewayId: gatewayID[:],
 				State:     gw.ConnState_OFFLINE,
@@ -169,20 +630,25 @@ func NewBackend(conf config.Config) (*Backend, error) {
 				return nil, errors.Wrap(err, "marshal error")
 			}
 
-			topic := bytes.NewBuffer(nil)
-			if err := b.stateTopicTemplate.Execute(topic, struct {
-				GatewayID lorawan.EUI64
-				StateType string
-			}{*gatewayID, "conn"}); err != nil {
-				return nil, errors.Wrap(err, "execute state template error")
+			topic, err := b.getStateTopic(*gatewayID, "conn")
+			if err != nil {
+				return nil, errors.Wrap(err, "integration/mqtt: get state topic error")
 			}
 
 			log.WithFields(log.Fields{
 				"gateway_id": gatewayID,
-				"topic":      topic.String(),
+				"topic":      topic,
 			}).Info("integration/mqtt: setting last will and testament")
 
-			b.clientOpts.SetBinaryWill(topic.String(), bb, b.qos, true)
+			b.clientOpts.SetBinaryWill(topic, bb, b.qos, true)
+		}
+	}
+
+	// The connection state will replaces the gateway state will (if set), as
+	// only a single last will and testament can be set.
+	if conf.Integration.MQTT.ConnectionStateTopicTemplate != "" {
+		if err := b.setConnectionStateWill(conf.Integration.MQTT.ConnectionStateTopicTemplate); err != nil {
+			return nil, errors.Wrap(err, "integration/mqtt: set connection state will error")
 		}
 	}
 
@@ -191,9 +657,25 @@ func NewBackend(conf config.Config) (*Backend, error) {
 
 // Start starts the integration.
 func (b *Backend) Start() error {
-	b.connectLoop()
+	if err := b.connectLoop(b.maxConnectAttempts); err != nil {
+		return err
+	}
+	if b.topicProbe {
+		if err := b.probeTopics(); err != nil {
+			log.WithError(err).Error("integration/mqtt: topic probe error, check the broker ACL and topic configuration")
+		}
+	}
 	go b.reconnectLoop()
 	go b.subscribeLoop()
+	if b.aggregateMetricsTopic != "" {
+		go b.aggregateMetricsLoop(b.aggregateMetricsInterval)
+	}
+	if len(b.keepaliveGateways) != 0 {
+		go b.keepaliveLoop(b.keepaliveInterval)
+	}
+	if b.downlinkBuffer != nil {
+		go b.downlinkLoop()
+	}
 	return nil
 }
 
@@ -205,6 +687,13 @@ func (b *Backend) Stop() error {
 	b.gatewaysMux.Lock()
 	defer b.gatewaysMux.Unlock()
 
+	// The will is not sent on a clean disconnect.
+	if b.connectionStateTopic != "" {
+		if err := b.publishConnectionState(ConnectionStateOffline); err != nil {
+			log.WithError(err).Error("integration/mqtt: publish connection state error")
+		}
+	}
+
 	// Set gateway state to offline for all gateways.
 	for gatewayID := range b.gateways {
 		pl := gw.ConnState{
@@ -241,10 +730,21 @@ func (b *Backend) SetRawPacketForwarderCommandFunc(f func(gw.RawPacketForwarderC
 	b.rawPacketForwarderCommandFunc = f
 }
 
+// SetConnectedFunc sets the function that is called after each (re)connect,
+// once the subscribe operations of the registered gateways have completed.
+// Failed subscriptions are retried in the background and do not delay this
+// call. This can be used to defer work until the backend is ready.
+func (b *Backend) SetConnectedFunc(f func()) {
+	b.connectedFunc = f
+}
+
 // SetGatewaySubscription sets or unsets the gateway.
 // Note: the actual MQTT (un)subscribe happens in a separate function to avoid
 // race conditions in case of connection issues. This way, the gateways map
-// always reflect the desired state.
+// always reflect the desired state. Setting the same state multiple times
+// is a no-op and does not result in additional MQTT (un)subscribe calls.
+// The MQTT unsubscribe is delayed by the unsubscribe grace, and is cancelled
+// when the gateway is set again within this grace.
 func (b *Backend) SetGatewaySubscription(subscribe bool, gatewayID lorawan.EUI64) error {
 	// In this case we don't want to (un)subscribe as the Gateway ID is provided by
 	// the authentication and is set before connect.
@@ -256,50 +756,242 @@ func (b *Backend) SetGatewaySubscription(subscribe bool, gatewayID lorawan.EUI64
 		return nil
 	}
 
+	b.gatewaysMux.Lock()
+	defer b.gatewaysMux.Unlock()
+
+	// a (re)registered gateway must be subscribed again, also when a previous
+	// subscribe has been stopped
+	if subscribe {
+		b.gatewaysReregistered[gatewayID] = struct{}{}
+	}
+
+	if _, ok := b.gateways[gatewayID]; ok == subscribe {
+		log.WithFields(log.Fields{
+			"gateway_id": gatewayID,
+			"subscribe":  subscribe,
+		}).Debug("integration/mqtt: gateway subscription already set")
+		return nil
+	}
+
 	log.WithFields(log.Fields{
 		"gateway_id": gatewayID,
 		"subscribe":  subscribe,
 	}).Debug("integration/mqtt: set gateway subscription")
 
-	b.gatewaysMux.Lock()
-	defer b.gatewaysMux.Unlock()
-
 	if subscribe {
 		b.gateways[gatewayID] = struct{}{}
+		delete(b.gatewaysRemoved, gatewayID)
 	} else {
 		delete(b.gateways, gatewayID)
+		b.gatewaysRemoved[gatewayID] = time.Now()
+	}
+	delete(b.gatewaysRestored, gatewayID)
+
+	if err := b.saveGatewayState(); err != nil {
+		log.WithError(err).Error("integration/mqtt: save gateway state error")
+	}
+
+	return nil
+}
+
+// loadGatewayState restores the gateways from the gateway state file, so
+// that these are subscribed before they re-register with the backend.
+func (b *Backend) loadGatewayState() error {
+	data, err := ioutil.ReadFile(b.gatewayStateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "read file error")
+	}
+
+	var ids []lorawan.EUI64
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return errors.Wrap(err, "unmarshal json error")
+	}
+
+	b.gatewaysMux.Lock()
+	defer b.gatewaysMux.Unlock()
+
+	for _, id := range ids {
+		b.gateways[id] = struct{}{}
+		b.gatewaysRestored[id] = time.Now()
+	}
+
+	log.WithFields(log.Fields{
+		"gateway_ids": ids,
+	}).Info("integration/mqtt: gateways restored from gateway state file")
+
+	return nil
+}
+
+// saveGatewayState persists the gateways to the gateway state file.
+// Note: the caller must hold the gatewaysMux lock.
+func (b *Backend) saveGatewayState() error {
+	if b.gatewayStateFile == "" {
+		return nil
+	}
+
+	ids := make([]lorawan.EUI64, 0, len(b.gateways))
+	for id := range b.gateways {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].String() < ids[j].String()
+	})
+
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	if err := ioutil.WriteFile(b.gatewayStateFile, data, 0660); err != nil {
+		return errors.Wrap(err, "write file error")
 	}
 
 	return nil
 }
 
 func (b *Backend) subscribeGateway(gatewayID lorawan.EUI64) error {
-	topic := bytes.NewBuffer(nil)
-	if err := b.commandTopicTemplate.Execute(topic, struct{ GatewayID lorawan.EUI64 }{gatewayID}); err != nil {
-		return errors.Wrap(err, "execute command topic template error")
+	topic, err := b.getCommandTopic(gatewayID)
+	if err != nil {
+		return err
 	}
 	log.WithFields(log.Fields{
-		"topic": topic.String(),
-		"qos":   b.qos,
+		"topic": topic,
+		"qos":   b.downlinkQOS,
 	}).Info("integration/mqtt: subscribing to topic")
 
-	if token := b.conn.Subscribe(topic.String(), b.qos, b.handleCommand); token.Wait() && token.Error() != nil {
-		return errors.Wrap(token.Error(), "subscribe topic error")
+	token := b.conn.Subscribe(topic, b.downlinkQOS, b.handleCommand)
+	if err := waitToken(token, b.subscribeTimeout); err != nil {
+		return errors.Wrap(err, "subscribe topic error")
 	}
+
+	if subscribeDenied(token, topic) {
+		return errors.Wrap(ErrSubscribeDenied, "subscribe topic error")
+	}
+
 	return nil
 }
 
+// subscribeGateways subscribes to the command topics of the given gateways.
+// When a subscribe batch size is configured, the topics are subscribed in
+// batches of this size, each batch using a single SUBSCRIBE packet. It
+// returns the subscribe errors by gateway ID.
+func (b *Backend) subscribeGateways(gatewayIDs []lorawan.EUI64) map[lorawan.EUI64]error {
+	errs := make(map[lorawan.EUI64]error)
+
+	// the commands are received through the wildcard subscription
+	if b.downlinkWildcardTopic != "" {
+		return errs
+	}
+
+	if b.subscribeBatchSize <= 0 {
+		for _, gatewayID := range gatewayIDs {
+			if err := b.subscribeGateway(gatewayID); err != nil {
+				errs[gatewayID] = err
+			}
+		}
+		return errs
+	}
+
+	for start := 0; start < len(gatewayIDs); start += b.subscribeBatchSize {
+		end := start + b.subscribeBatchSize
+		if end > len(gatewayIDs) {
+			end = len(gatewayIDs)
+		}
+
+		for gatewayID, err := range b.subscribeGatewayBatch(gatewayIDs[start:end]) {
+			errs[gatewayID] = err
+		}
+	}
+
+	return errs
+}
+
+// subscribeGatewayBatch subscribes to the command topics of the given
+// gateways using a single SUBSCRIBE packet. It returns the subscribe errors
+// by gateway ID.
+func (b *Backend) subscribeGatewayBatch(gatewayIDs []lorawan.EUI64) map[lorawan.EUI64]error {
+	errs := make(map[lorawan.EUI64]error)
+	filters := make(map[string]byte)
+	topics := make(map[lorawan.EUI64]string)
+
+	for _, gatewayID := range gatewayIDs {
+		topic, err := b.getCommandTopic(gatewayID)
+		if err != nil {
+			errs[gatewayID] = err
+			continue
+		}
+		filters[topic] = b.downlinkQOS
+		topics[gatewayID] = topic
+	}
+
+	if len(filters) == 0 {
+		return errs
+	}
+
+	log.WithFields(log.Fields{
+		"topics": len(filters),
+		"qos":    b.downlinkQOS,
+	}).Info("integration/mqtt: subscribing to topic batch")
+
+	token := b.conn.SubscribeMultiple(filters, b.handleCommand)
+	err := waitToken(token, b.subscribeTimeout)
+
+	for gatewayID, topic := range topics {
+		if err != nil {
+			errs[gatewayID] = errors.Wrap(err, "subscribe topic error")
+		} else if subscribeDenied(token, topic) {
+			errs[gatewayID] = errors.Wrap(ErrSubscribeDenied, "subscribe topic error")
+		}
+	}
+
+	return errs
+}
+
+// waitToken waits until the given token has completed and returns its error.
+// When a timeout is given, ErrTimeout is returned when the token did not
+// complete within this timeout.
+func waitToken(token paho.Token, timeout time.Duration) error {
+	if timeout == 0 {
+		token.Wait()
+		return token.Error()
+	}
+
+	if !token.WaitTimeout(timeout) {
+		return ErrTimeout
+	}
+	return token.Error()
+}
+
+// subscribeDenied returns true when the broker denied the subscription of
+// the given topic. A denied subscription is not returned as error by the
+// client, but is reported by the 0x80 return code in the SUBACK.
+func subscribeDenied(token paho.Token, topic string) bool {
+	if t, ok := token.(interface{ Result() map[string]byte }); ok {
+		if code, ok := t.Result()[topic]; ok && code == 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *Backend) unsubscribeGateway(gatewayID lorawan.EUI64) error {
-	topic := bytes.NewBuffer(nil)
-	if err := b.commandTopicTemplate.Execute(topic, struct{ GatewayID lorawan.EUI64 }{gatewayID}); err != nil {
-		return errors.Wrap(err, "execute command topic template error")
+	if b.downlinkWildcardTopic != "" {
+		return nil
+	}
+
+	topic, err := b.getCommandTopic(gatewayID)
+	if err != nil {
+		return err
 	}
 	log.WithFields(log.Fields{
-		"topic": topic.String(),
+		"topic": topic,
 	}).Info("integration/mqtt: unsubscribing from topic")
 
-	if token := b.conn.Unsubscribe(topic.String()); token.Wait() && token.Error() != nil {
-		return errors.Wrap(token.Error(), "unsubscribe topic error")
+	if err := waitToken(b.conn.Unsubscribe(topic), b.subscribeTimeout); err != nil {
+		return errors.Wrap(err, "unsubscribe topic error")
 	}
 
 	return nil
@@ -308,21 +1000,33 @@ func (b *Backend) unsubscribeGateway(gatewayID lorawan.EUI64) error {
 // PublishEvent publishes the given event.
 func (b *Backend) PublishEvent(gatewayID lorawan.EUI64, event string, id uuid.UUID, v proto.Message) error {
 	mqttEventCounter(event).Inc()
+	b.keepaliveSeen(gatewayID)
+
 	idPrefix := map[string]string{
-		"up":    "uplink_",
-		"ack":   "downlink_",
-		"stats": "stats_",
-		"exec":  "exec_",
-		"raw":   "raw_",
+		"up":     "uplink_",
+		"up_set": "uplink_",
+		"ack":    "downlink_",
+		"stats":  "stats_",
+		"exec":   "exec_",
+		"raw":    "raw_",
+
+		"config_ack": "config_ack_",
+	}
+
+	if stats, ok := v.(*gw.GatewayStats); ok && b.combineUplinkStats {
+		b.statsCacheMux.Lock()
+		b.statsCache[gatewayID] = proto.Clone(stats).(*gw.GatewayStats)
+		b.statsCacheMux.Unlock()
 	}
-	return b.publishEvent(gatewayID, event, log.Fields{
+
+	return b.publishEvent(gatewayID, event, id, log.Fields{
 		idPrefix[event] + "id": id,
 	}, v)
 }
 
 // PublishState publishes the given state as retained message.
 func (b *Backend) PublishState(gatewayID lorawan.EUI64, state string, v proto.Message) error {
-	if b.stateTopicTemplate == nil {
+	if b.getStateTopicTemplate(state) == nil {
 		log.WithFields(log.Fields{
 			"state":      state,
 			"gateway_id": gatewayID,
@@ -332,12 +1036,9 @@ func (b *Backend) PublishState(gatewayID lorawan.EUI64, state string, v proto.Me
 
 	mqttStateCounter(state).Inc()
 
-	topic := bytes.NewBuffer(nil)
-	if err := b.stateTopicTemplate.Execute(topic, struct {
-		GatewayID lorawan.EUI64
-		StateType string
-	}{gatewayID, state}); err != nil {
-		return errors.Wrap(err, "execute state template error")
+	topic, err := b.getStateTopic(gatewayID, state)
+	if err != nil {
+		return err
 	}
 
 	bytes, err := b.marshal(v)
@@ -345,14 +1046,22 @@ func (b *Backend) PublishState(gatewayID lorawan.EUI64, state string, v proto.Me
 		return errors.Wrap(err, "marshal message error")
 	}
 
+	if b.sortJSONKeys {
+		bytes, err = sortJSONKeys(bytes)
+		if err != nil {
+			return errors.Wrap(err, "sort json keys error")
+		}
+	}
+
 	log.WithFields(log.Fields{
-		"topic":      topic.String(),
+		"topic":      topic,
 		"qos":        b.qos,
 		"state":      state,
 		"gateway_id": gatewayID,
 	}).Info("integration/mqtt: publishing state")
-	if token := b.conn.Publish(topic.String(), b.qos, b.stateRetained, bytes); token.Wait() && token.Error() != nil {
-		return token.Error()
+	if err := waitToken(b.conn.Publish(topic, b.qos, b.stateRetained, bytes), b.publishTimeout); err != nil {
+		mqttPublishErrorCounter().Inc()
+		return err
 	}
 	return nil
 }
@@ -373,31 +1082,99 @@ func (b *Backend) connect() error {
 	return nil
 }
 
-// connectLoop blocks until the client is connected
-func (b *Backend) connectLoop() {
-	for {
-		if err := b.connect(); err != nil {
-			if b.terminateOnConnectError {
-				log.Fatal(err)
-			}
+// connectLoop blocks until the client is connected. When maxAttempts is set
+// to a value greater than 0, an error is returned after the given number of
+// failed connection attempts.
+func (b *Backend) connectLoop(maxAttempts int) error {
+	interval := b.connectRetryInterval
+
+	for attempt := 1; ; attempt++ {
+		err := b.connect()
+		b.handleConnectError(err)
+		if err == nil {
+			return nil
+		}
 
-			log.WithError(err).Error("integration/mqtt: connection error")
-			time.Sleep(time.Second * 2)
+		status.SetLastError(status.MQTT, err)
+		if b.terminateOnConnectError {
+			log.Fatal(err)
+		}
 
-		} else {
-			break
+		if maxAttempts > 0 && attempt >= maxAttempts {
+			return errors.Wrapf(err, "integration/mqtt: connect failed after %d attempts", attempt)
+		}
+
+		log.WithError(err).WithFields(log.Fields{
+			"attempt":     attempt,
+			"retry_after": interval,
+		}).Log(b.connectionErrorLevel(time.Now()), "integration/mqtt: connection error")
+		time.Sleep(interval)
+
+		interval = interval * 2
+		if b.maxReconnectInterval > 0 && interval > b.maxReconnectInterval {
+			interval = b.maxReconnectInterval
 		}
 	}
 }
 
-func (b *Backend) disconnect() error {
-	mqttDisconnectCounter().Inc()
-
+// handleConnectError keeps track of the number of consecutive authentication
+// failures. Once the configured threshold has been reached, it switches the
+// client options to the fallback server and credentials.
+func (b *Backend) handleConnectError(err error) {
 	b.connMux.Lock()
 	defer b.connMux.Unlock()
 
-	b.conn.Disconnect(250)
-	return nil
+	if !isAuthError(err) {
+		b.authFailures = 0
+		return
+	}
+
+	b.authFailures++
+
+	if b.fallbackServer == "" || b.fallbackActive || b.authFailures < b.fallbackAuthFailures {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"server":        b.fallbackServer,
+		"auth_failures": b.authFailures,
+	}).Warning("integration/mqtt: switching to fallback mqtt broker")
+
+	b.clientOpts.Servers = nil
+	b.clientOpts.AddBroker(b.fallbackServer)
+	b.clientOpts.SetUsername(b.fallbackUsername)
+	b.clientOpts.SetPassword(b.fallbackPassword)
+	b.clientOpts.SetCredentialsProvider(nil)
+	b.fallbackActive = true
+	b.authFailures = 0
+
+	mqttFallbackCounter().Inc()
+}
+
+// isAuthError returns true when the given error indicates that the broker
+// rejected the credentials.
+func isAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	for _, code := range []byte{packets.ErrRefusedBadUsernameOrPassword, packets.ErrRefusedNotAuthorised} {
+		if strings.Contains(err.Error(), packets.ConnErrors[code].Error()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *Backend) disconnect() error {
+	mqttDisconnectCounter().Inc()
+
+	b.connMux.Lock()
+	defer b.connMux.Unlock()
+
+	b.conn.Disconnect(250)
+	return nil
 }
 
 func (b *Backend) reconnectLoop() {
@@ -413,7 +1190,7 @@ func (b *Backend) reconnectLoop() {
 			mqttReconnectCounter().Inc()
 
 			b.disconnect()
-			b.connectLoop()
+			_ = b.connectLoop(0)
 		}
 	}
 }
@@ -422,6 +1199,18 @@ func (b *Backend) onConnected(c paho.Client) {
 	mqttConnectCounter().Inc()
 	log.Info("integration/mqtt: connected to mqtt broker")
 
+	if b.startupBanner != nil {
+		b.startupBannerOnce.Do(func() {
+			log.WithFields(b.startupBanner).Info("integration/mqtt: effective endpoints")
+		})
+	}
+
+	if b.connectionStateTopic != "" {
+		if err := b.publishConnectionState(ConnectionStateOnline); err != nil {
+			log.WithError(err).Error("integration/mqtt: publish connection state error")
+		}
+	}
+
 	b.gatewaysSubscribedMux.Lock()
 	defer b.gatewaysSubscribedMux.Unlock()
 
@@ -432,6 +1221,10 @@ func (b *Backend) onConnected(c paho.Client) {
 	// onConnectionLost function, the function could block until the connection
 	// is restored because the (un)subscribe operations will block until then.
 	b.gatewaysSubscribed = make(map[lorawan.EUI64]struct{})
+	b.subscribeRetries = make(map[lorawan.EUI64]subscribeRetry)
+	b.wildcardSubscribed = false
+	b.wildcardRetry = time.Time{}
+	b.connectedPending = true
 }
 
 func (b *Backend) subscribeLoop() {
@@ -446,43 +1239,106 @@ func (b *Backend) subscribeLoop() {
 			continue
 		}
 
+		// the gateways are marked as subscribed once the wildcard topic has
+		// been subscribed
+		b.gatewaysSubscribedMux.Lock()
+		subscribed := b.subscribeWildcard()
+		b.gatewaysSubscribedMux.Unlock()
+		if !subscribed {
+			continue
+		}
+
 		var subscribe []lorawan.EUI64
 		var unsubscribe []lorawan.EUI64
 
-		b.gatewaysMux.RLock()
+		b.gatewaysMux.Lock()
 		b.gatewaysSubscribedMux.Lock()
 
+		// remove the restored gateways that did not re-register in time
+		if b.gatewayRestoreTimeout > 0 {
+			var removed bool
+			for gatewayID, restoredAt := range b.gatewaysRestored {
+				if time.Since(restoredAt) < b.gatewayRestoreTimeout {
+					continue
+				}
+
+				log.WithField("gateway_id", gatewayID).Info("integration/mqtt: restored gateway did not re-register, removing")
+				delete(b.gatewaysRestored, gatewayID)
+				delete(b.gateways, gatewayID)
+				b.gatewaysRemoved[gatewayID] = restoredAt
+				removed = true
+			}
+
+			if removed {
+				if err := b.saveGatewayState(); err != nil {
+					log.WithError(err).Error("integration/mqtt: save gateway state error")
+				}
+			}
+		}
+
+		// reset the retries of the re-registered gateways
+		for gatewayID := range b.gatewaysReregistered {
+			delete(b.subscribeRetries, gatewayID)
+			delete(b.gatewaysReregistered, gatewayID)
+		}
+
 		// subscribe
 		for gatewayID := range b.gateways {
-			if _, ok := b.gatewaysSubscribed[gatewayID]; !ok {
-				subscribe = append(subscribe, gatewayID)
+			if _, ok := b.gatewaysSubscribed[gatewayID]; ok {
+				continue
+			}
+
+			// a previous attempt failed, wait until the next retry
+			if retry, ok := b.subscribeRetries[gatewayID]; ok && (retry.stopped || time.Now().Before(retry.next)) {
+				continue
+			}
+
+			subscribe = append(subscribe, gatewayID)
+		}
+
+		// forget the retries of gateways that have been removed
+		for gatewayID := range b.subscribeRetries {
+			if _, ok := b.gateways[gatewayID]; !ok {
+				delete(b.subscribeRetries, gatewayID)
 			}
 		}
 
 		// unsubscribe
 		for gatewayID := range b.gatewaysSubscribed {
-			if _, ok := b.gateways[gatewayID]; !ok {
-				unsubscribe = append(unsubscribe, gatewayID)
+			if _, ok := b.gateways[gatewayID]; ok {
+				continue
+			}
+
+			// the gateway might re-subscribe within the unsubscribe grace
+			if removedAt, ok := b.gatewaysRemoved[gatewayID]; ok && time.Since(removedAt) < b.unsubscribeGrace {
+				continue
 			}
+
+			delete(b.gatewaysRemoved, gatewayID)
+			unsubscribe = append(unsubscribe, gatewayID)
 		}
 
 		// unlock gatewaysMux so that SetGatewaySubscription can write again
 		// to the map, in which case changes are picked up in the next run
-		b.gatewaysMux.RUnlock()
+		b.gatewaysMux.Unlock()
 
+		subscribeErrs := b.subscribeGateways(subscribe)
 		for _, gatewayID := range subscribe {
 			statePL := gw.ConnState{
 				GatewayId: gatewayID[:],
 				State:     gw.ConnState_ONLINE,
 			}
 
-			if err := b.subscribeGateway(gatewayID); err != nil {
+			if err := subscribeErrs[gatewayID]; err != nil {
 				log.WithError(err).WithField("gateway_id", gatewayID).Error("integration/mqtt: subscribe gateway error")
+				b.scheduleSubscribeRetry(gatewayID, err)
 			} else {
 				if err := b.PublishState(gatewayID, "conn", &statePL); err != nil {
 					log.WithError(err).WithField("gateway_id", gatewayID).Error("integration/mqtt: publish conn state error")
+					b.scheduleSubscribeRetry(gatewayID, err)
 				} else {
 					b.gatewaysSubscribed[gatewayID] = struct{}{}
+					delete(b.subscribeRetries, gatewayID)
 				}
 			}
 		}
@@ -504,8 +1360,91 @@ func (b *Backend) subscribeLoop() {
 			}
 		}
 
+		// the gateways have been re-subscribed after connecting
+		connected := b.connectedPending
+		b.connectedPending = false
+
 		b.gatewaysSubscribedMux.Unlock()
+
+		if connected && b.connectedFunc != nil {
+			b.connectedFunc()
+		}
+	}
+}
+
+// getStartupBanner returns the log fields summarizing the effective MQTT
+// brokers, TLS status, backend bind and topic templates.
+func (b *Backend) getStartupBanner(conf config.Config) log.Fields {
+	var servers []string
+	tls := false
+	for _, u := range b.clientOpts.Servers {
+		servers = append(servers, u.String())
+		switch u.Scheme {
+		case "ssl", "tls", "tcps", "wss":
+			tls = true
+		}
+	}
+
+	fields := log.Fields{
+		"mqtt_servers":           strings.Join(servers, ","),
+		"mqtt_tls":               tls,
+		"event_topic_template":   conf.Integration.MQTT.EventTopicTemplate,
+		"state_topic_template":   conf.Integration.MQTT.StateTopicTemplate,
+		"command_topic_template": conf.Integration.MQTT.CommandTopicTemplate,
+		"backend":                conf.Backend.Type,
+	}
+
+	switch conf.Backend.Type {
+	case "semtech_udp":
+		fields["udp_bind"] = conf.Backend.SemtechUDP.UDPBind
+	case "basic_station":
+		fields["bind"] = conf.Backend.BasicStation.Bind
+	case "concentratord":
+		fields["event_url"] = conf.Backend.Concentratord.EventURL
+		fields["command_url"] = conf.Backend.Concentratord.CommandURL
+	}
+
+	return fields
+}
+
+// scheduleSubscribeRetry increments the number of failed subscribe attempts
+// for the given gateway and schedules the next attempt. The retry interval
+// starts at the initial interval and is doubled after each failure, up to the
+// max. interval.
+// Note: the caller must hold the gatewaysSubscribedMux lock.
+func (b *Backend) scheduleSubscribeRetry(gatewayID lorawan.EUI64, err error) {
+	retry := b.subscribeRetries[gatewayID]
+	retry.failures++
+
+	// a denied subscription will not succeed by retrying it, the same
+	// applies when the max. number of attempts has been reached
+	if errors.Is(err, ErrSubscribeDenied) || (b.subscribeRetryAttempts != 0 && retry.failures >= b.subscribeRetryAttempts) {
+		retry.stopped = true
+		b.subscribeRetries[gatewayID] = retry
+
+		log.WithFields(log.Fields{
+			"gateway_id": gatewayID,
+			"failures":   retry.failures,
+		}).Error("integration/mqtt: subscribe gateway failed, not retrying until gateway re-registers")
+		return
+	}
+
+	interval := b.subscribeRetryInitial
+	for i := 1; i < retry.failures && interval < b.subscribeRetryMax; i++ {
+		interval = interval * 2
 	}
+	if b.subscribeRetryMax != 0 && interval > b.subscribeRetryMax {
+		interval = b.subscribeRetryMax
+	}
+
+	retry.next = time.Now().Add(interval)
+	b.subscribeRetries[gatewayID] = retry
+
+	log.WithFields(log.Fields{
+		"gateway_id": gatewayID,
+		"failures":   retry.failures,
+		"retry_in":   interval,
+	}).Warning("integration/mqtt: subscribe gateway failed, scheduling retry")
 }
 
 func (b *Backend) onConnectionLost(c paho.Client, err error) {
@@ -513,7 +1452,29 @@ func (b *Backend) onConnectionLost(c paho.Client, err error) {
 		log.Fatal(err)
 	}
 	mqttDisconnectCounter().Inc()
-	log.WithError(err).Error("mqtt: connection error")
+	status.SetLastError(status.MQTT, err)
+	log.WithError(err).Log(b.connectionErrorLevel(time.Now()), "mqtt: connection error")
+
+	// Without auto reconnect, the connection is restored using the connect
+	// loop (with exponential backoff).
+	if !b.autoReconnect && !b.isClosed() {
+		go func() {
+			mqttReconnectCounter().Inc()
+			_ = b.connectLoop(0)
+		}()
+	}
+}
+
+// connectionErrorLevel returns the log level for connection errors at the
+// given time. This is the warning level during a maintenance window and the
+// error level otherwise.
+func (b *Backend) connectionErrorLevel(t time.Time) log.Level {
+	for _, w := range b.maintenanceWindows {
+		if !t.Before(w.start) && t.Before(w.end) {
+			return log.WarnLevel
+		}
+	}
+	return log.ErrorLevel
 }
 
 func (b *Backend) handleDownlinkFrame(c paho.Client, msg paho.Message) {
@@ -553,9 +1514,7 @@ func (b *Backend) handleDownlinkFrame(c paho.Client, msg paho.Message) {
 		"downlink_id": downID,
 	}).Info("integration/mqtt: downlink frame received")
 
-	if b.downlinkFrameFunc != nil {
-		b.downlinkFrameFunc(downlinkFrame)
-	}
+	b.queueDownlinkFrame(downlinkFrame)
 }
 
 func (b *Backend) handleGatewayConfiguration(c paho.Client, msg paho.Message) {
@@ -565,6 +1524,7 @@ func (b *Backend) handleGatewayConfiguration(c paho.Client, msg paho.Message) {
 
 	var gatewayConfig gw.GatewayConfiguration
 	if err := b.unmarshal(msg.Payload(), &gatewayConfig); err != nil {
+		status.SetLastError(status.Config, errors.Wrap(err, "unmarshal gateway configuration error"))
 		log.WithError(err).Error("integration/mqtt: unmarshal gateway configuration error")
 		return
 	}
@@ -623,6 +1583,28 @@ func (b *Backend) handleRawPacketForwarderCommand(c paho.Client, msg paho.Messag
 }
 
 func (b *Backend) handleCommand(c paho.Client, msg paho.Message) {
+	// the wildcard subscription also matches the commands of gateways that
+	// are not connected to this bridge
+	if b.downlinkWildcardTopic != "" {
+		gatewayID, err := b.gatewayIDFromTopic(msg.Topic())
+		if err != nil {
+			log.WithError(err).WithField("topic", msg.Topic()).Warning("integration/mqtt: get gateway id from command topic error")
+			return
+		}
+
+		b.gatewaysMux.RLock()
+		_, ok := b.gateways[gatewayID]
+		b.gatewaysMux.RUnlock()
+
+		if !ok {
+			log.WithFields(log.Fields{
+				"gateway_id": gatewayID,
+				"topic":      msg.Topic(),
+			}).Debug("integration/mqtt: ignoring command of unknown gateway")
+			return
+		}
+	}
+
 	if strings.HasSuffix(msg.Topic(), "down") || strings.Contains(msg.Topic(), "command=down") {
 		mqttCommandCounter("down").Inc()
 		b.handleDownlinkFrame(c, msg)
@@ -640,31 +1622,726 @@ func (b *Backend) handleCommand(c paho.Client, msg paho.Message) {
 	}
 }
 
-func (b *Backend) publishEvent(gatewayID lorawan.EUI64, event string, fields log.Fields, msg proto.Message) error {
-	topic := bytes.NewBuffer(nil)
-	if err := b.eventTopicTemplate.Execute(topic, struct {
-		GatewayID lorawan.EUI64
-		EventType string
-	}{gatewayID, event}); err != nil {
-		return errors.Wrap(err, "execute event template error")
+func (b *Backend) publishEvent(gatewayID lorawan.EUI64, event string, id uuid.UUID, fields log.Fields, msg proto.Message) error {
+	topic, err := b.getEventTopic(gatewayID, event, msg)
+	if err != nil {
+		return err
+	}
+
+	marshal := b.marshal
+	if event == "ack" {
+		marshal = b.ackMarshal
 	}
 
-	bytes, err := b.marshal(msg)
+	bytes, err := marshal(msg)
 	if err != nil {
 		return errors.Wrap(err, "marshal message error")
 	}
 
-	fields["topic"] = topic.String()
-	fields["qos"] = b.qos
+	if isUplinkEvent(event) && b.combineUplinkStats {
+		bytes, err = b.attachStats(gatewayID, bytes)
+		if err != nil {
+			return errors.Wrap(err, "attach stats error")
+		}
+	}
+
+	if isUplinkEvent(event) && b.macSummary {
+		bytes, err = attachMACSummary(msg, bytes)
+		if err != nil {
+			return errors.Wrap(err, "attach mac summary error")
+		}
+	}
+
+	if isUplinkEvent(event) && b.jsonMarshaler {
+		bytes, err = attachMetaData(msg, bytes)
+		if err != nil {
+			return errors.Wrap(err, "attach meta-data error")
+		}
+	}
+
+	if isUplinkEvent(event) && b.uplinkAllowList != nil {
+		bytes, err = filterJSONKeys(bytes, b.uplinkAllowList)
+		if err != nil {
+			return errors.Wrap(err, "filter uplink keys error")
+		}
+	}
+
+	if b.envelope == EnvelopeCloudEvents {
+		bytes, err = wrapCloudEvent(gatewayID, event, id, bytes)
+		if err != nil {
+			return errors.Wrap(err, "wrap cloudevent error")
+		}
+	}
+
+	if b.sortJSONKeys {
+		bytes, err = sortJSONKeys(bytes)
+		if err != nil {
+			return errors.Wrap(err, "sort json keys error")
+		}
+	}
+
+	if b.compressEvent(event) {
+		bytes, err = gzipCompress(bytes)
+		if err != nil {
+			return errors.Wrap(err, "compress payload error")
+		}
+		fields["compression"] = b.compression
+	}
+
+	topics := []string{topic}
+	if event == "stats" {
+		topics, err = b.getStatsTopics(gatewayID, topic)
+		if err != nil {
+			return err
+		}
+	}
+
+	if b.compressEvent(event) {
+		for i := range topics {
+			topics[i] += b.compressionTopicSuffix
+		}
+	}
+
+	qos := b.getEventQOS(event)
+	retained := b.eventRetained[event]
+	fields["qos"] = qos
+	fields["retained"] = retained
 	fields["event"] = event
 
-	log.WithFields(fields).Info("integration/mqtt: publishing event")
-	if token := b.conn.Publish(topic.String(), b.qos, false, bytes); token.Wait() && token.Error() != nil {
-		return token.Error()
+	for _, topic := range topics {
+		fields["topic"] = topic
+
+		log.WithFields(fields).Info("integration/mqtt: publishing event")
+		if err := waitToken(b.conn.Publish(topic, qos, retained, bytes), b.publishTimeout); err != nil {
+			mqttPublishErrorCounter().Inc()
+			return err
+		}
 	}
 	return nil
 }
 
+// isUplinkEvent returns true when the given event contains an uplink frame
+// (up) or a coalesced uplink frame-set (up_set).
+func isUplinkEvent(event string) bool {
+	return event == "up" || event == "up_set"
+}
+
+// getEventQOS returns the QoS for publishing the given event.
+func (b *Backend) getEventQOS(event string) uint8 {
+	if qos, ok := b.eventQOS[event]; ok {
+		return qos
+	}
+	return b.qos
+}
+
+// getStatsTopics returns the topics to which the stats of the given gateway
+// must be published: the given (per gateway) event topic and the topics of
+// the stats groups of the gateway. When statsGroupOnly is set, the event
+// topic is omitted for gateways that belong to a group.
+func (b *Backend) getStatsTopics(gatewayID lorawan.EUI64, eventTopic string) ([]string, error) {
+	groups := b.statsGroups[gatewayID]
+	if len(groups) == 0 {
+		return []string{eventTopic}, nil
+	}
+
+	var topics []string
+	if !b.statsGroupOnly {
+		topics = append(topics, eventTopic)
+	}
+
+	for _, group := range groups {
+		topic := bytes.NewBuffer(nil)
+		if err := b.statsGroupTopicTemplate.Execute(topic, statsGroupTopicContext{gatewayTopicContext: newGatewayTopicContext(gatewayID), Group: group}); err != nil {
+			return nil, errors.Wrap(err, "execute stats group topic template error")
+		}
+		if topic.Len() == 0 {
+			return nil, ErrEmptyTopic
+		}
+		topics = append(topics, topic.String())
+	}
+
+	return topics, nil
+}
+
+// attachStats adds the most recent cached stats of the given gateway to the
+// given JSON encoded uplink under the stats key. The uplink is returned as-is
+// when no stats have been cached yet.
+func (b *Backend) attachStats(gatewayID lorawan.EUI64, uplink []byte) ([]byte, error) {
+	b.statsCacheMux.RLock()
+	stats, ok := b.statsCache[gatewayID]
+	b.statsCacheMux.RUnlock()
+	if !ok {
+		return uplink, nil
+	}
+
+	statsBytes, err := b.marshal(stats)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal stats error")
+	}
+
+	var v map[string]json.RawMessage
+	if err := json.Unmarshal(uplink, &v); err != nil {
+		return nil, errors.Wrap(err, "decode json error")
+	}
+	v["stats"] = statsBytes
+
+	return json.Marshal(v)
+}
+
+// macSummary contains the summary of the decoded LoRaWAN header of an
+// uplink. The data-frame fields are only set for data frames.
+type macSummary struct {
+	MType   lorawan.MType    `json:"mType"`
+	DevAddr *lorawan.DevAddr `json:"devAddr,omitempty"`
+	FCnt    *uint32          `json:"fCnt,omitempty"`
+	FPort   *uint8           `json:"fPort,omitempty"`
+}
+
+// attachMACSummary adds the summary of the decoded LoRaWAN header of the
+// given uplink frame or frame-set to the given JSON encoded uplink under the
+// macSummary key. The uplink is returned as-is when the PHYPayload can't be
+// decoded.
+func attachMACSummary(msg proto.Message, uplink []byte) ([]byte, error) {
+	var phyPayload []byte
+	switch pl := msg.(type) {
+	case *gw.UplinkFrame:
+		phyPayload = pl.GetPhyPayload()
+	case *gw.UplinkFrameSet:
+		phyPayload = pl.GetPhyPayload()
+	}
+
+	var phy lorawan.PHYPayload
+	if err := phy.UnmarshalBinary(phyPayload); err != nil {
+		log.WithError(err).Debug("integration/mqtt: skipping mac summary, decode phypayload error")
+		return uplink, nil
+	}
+
+	summary := macSummary{
+		MType: phy.MHDR.MType,
+	}
+
+	if macPL, ok := phy.MACPayload.(*lorawan.MACPayload); ok {
+		summary.DevAddr = &macPL.FHDR.DevAddr
+		summary.FCnt = &macPL.FHDR.FCnt
+		summary.FPort = macPL.FPort
+	}
+
+	return setJSONKey(uplink, "macSummary", summary)
+}
+
+// attachMetaData adds the uplink meta-data (e.g. the source address) of each
+// rxInfo element of the given uplink frame or frame-set to the JSON encoded
+// rxInfo under the metaData key. The uplink is returned as-is when there is
+// no meta-data.
+func attachMetaData(msg proto.Message, uplink []byte) ([]byte, error) {
+	var rxInfo []*gw.UplinkRXInfo
+	switch pl := msg.(type) {
+	case *gw.UplinkFrame:
+		rxInfo = []*gw.UplinkRXInfo{pl.GetRxInfo()}
+	case *gw.UplinkFrameSet:
+		rxInfo = pl.GetRxInfo()
+	}
+
+	var found bool
+	metaData := make([]map[string]string, len(rxInfo))
+	for i := range rxInfo {
+		metaData[i] = uplinkmeta.Get(rxInfo[i].GetUplinkId())
+		if metaData[i] != nil {
+			found = true
+		}
+	}
+	if !found {
+		return uplink, nil
+	}
+
+	var v map[string]json.RawMessage
+	if err := json.Unmarshal(uplink, &v); err != nil {
+		return nil, errors.Wrap(err, "decode json error")
+	}
+
+	var err error
+	switch msg.(type) {
+	case *gw.UplinkFrame:
+		v["rxInfo"], err = setJSONKey(v["rxInfo"], "metaData", metaData[0])
+		if err != nil {
+			return nil, err
+		}
+	case *gw.UplinkFrameSet:
+		var items []json.RawMessage
+		if err := json.Unmarshal(v["rxInfo"], &items); err != nil {
+			return nil, errors.Wrap(err, "decode json error")
+		}
+		for i := range items {
+			if i >= len(metaData) || metaData[i] == nil {
+				continue
+			}
+			items[i], err = setJSONKey(items[i], "metaData", metaData[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		v["rxInfo"], err = json.Marshal(items)
+		if err != nil {
+			return nil, errors.Wrap(err, "encode json error")
+		}
+	}
+
+	return json.Marshal(v)
+}
+
+// setJSONKey sets the given key of the given JSON object to the JSON encoded
+// value.
+func setJSONKey(obj json.RawMessage, key string, value interface{}) (json.RawMessage, error) {
+	var v map[string]json.RawMessage
+	if err := json.Unmarshal(obj, &v); err != nil {
+		return nil, errors.Wrap(err, "decode json error")
+	}
+	if v == nil {
+		v = make(map[string]json.RawMessage)
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode json error")
+	}
+	v[key] = b
+
+	return json.Marshal(v)
+}
+
+// getEventTopic returns the event topic for the given event and message.
+func (b *Backend) getEventTopic(gatewayID lorawan.EUI64, event string, msg proto.Message) (string, error) {
+	ctx := eventTopicContext{
+		gatewayTopicContext: newGatewayTopicContext(gatewayID),
+		EventType:           event,
+	}
+
+	var txInfo *gw.UplinkTXInfo
+	switch pl := msg.(type) {
+	case *gw.UplinkFrame:
+		txInfo = pl.GetTxInfo()
+	case *gw.UplinkFrameSet:
+		txInfo = pl.GetTxInfo()
+	}
+
+	if txInfo != nil {
+		ctx.Frequency = txInfo.Frequency
+		ctx.Modulation = txInfo.Modulation.String()
+
+		if modInfo := txInfo.GetLoraModulationInfo(); modInfo != nil {
+			ctx.SpreadingFactor = modInfo.SpreadingFactor
+			ctx.Bandwidth = modInfo.Bandwidth
+			ctx.DataRate = fmt.Sprintf("SF%dBW%d", modInfo.SpreadingFactor, modInfo.Bandwidth)
+		}
+
+		if modInfo := txInfo.GetFskModulationInfo(); modInfo != nil {
+			ctx.DataRate = fmt.Sprintf("%d", modInfo.Datarate)
+		}
+
+		if modInfo := txInfo.GetLrFhssModulationInfo(); modInfo != nil {
+			ctx.DataRate = fmt.Sprintf("M0CW%d", modInfo.OperatingChannelWidth/1000)
+		}
+	}
+
+	b.templatesMux.RLock()
+	eventTopicTemplate := b.eventTopicTemplate
+	b.templatesMux.RUnlock()
+
+	topic := bytes.NewBuffer(nil)
+	if err := eventTopicTemplate.Execute(topic, ctx); err != nil {
+		return "", errors.Wrap(err, "execute event template error")
+	}
+	if topic.Len() == 0 {
+		return "", errors.Wrap(ErrEmptyTopic, "execute event template error")
+	}
+
+	return topic.String(), nil
+}
+
+// getStateTopicTemplate returns the topic template for the given state. The
+// connection state (conn) uses the connection state topic template when set.
+func (b *Backend) getStateTopicTemplate(state string) *template.Template {
+	b.templatesMux.RLock()
+	defer b.templatesMux.RUnlock()
+
+	if state == "conn" && b.gatewayConnStateTopicTemplate != nil {
+		return b.gatewayConnStateTopicTemplate
+	}
+	return b.stateTopicTemplate
+}
+
+// getStateTopic returns the state topic for the given gateway and state.
+func (b *Backend) getStateTopic(gatewayID lorawan.EUI64, state string) (string, error) {
+	stateTopicTemplate := b.getStateTopicTemplate(state)
+
+	topic := bytes.NewBuffer(nil)
+	if err := stateTopicTemplate.Execute(topic, struct {
+		gatewayTopicContext
+		StateType string
+	}{newGatewayTopicContext(gatewayID), state}); err != nil {
+		return "", errors.Wrap(err, "execute state template error")
+	}
+	if topic.Len() == 0 {
+		return "", errors.Wrap(ErrEmptyTopic, "execute state template error")
+	}
+
+	return topic.String(), nil
+}
+
+// getCommandTopic returns the command topic for the given gateway.
+func (b *Backend) getCommandTopic(gatewayID lorawan.EUI64) (string, error) {
+	b.templatesMux.RLock()
+	commandTopicTemplate := b.commandTopicTemplate
+	b.templatesMux.RUnlock()
+
+	topic := bytes.NewBuffer(nil)
+	if err := commandTopicTemplate.Execute(topic, newGatewayTopicContext(gatewayID)); err != nil {
+		return "", errors.Wrap(err, "execute command topic template error")
+	}
+	if topic.Len() == 0 {
+		return "", errors.Wrap(ErrEmptyTopic, "execute command topic template error")
+	}
+
+	return topic.String(), nil
+}
+
+// validateTopicTemplates renders the topic templates using a sample gateway
+// ID, so that templates that can't be executed or that render to an empty
+// topic are reported on startup instead of on the first publish.
+func (b *Backend) validateTopicTemplates() error {
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	for _, event := range []string{"up", "up_set", "stats", "ack", "exec", "raw"} {
+		if _, err := b.getEventTopic(gatewayID, event, nil); err != nil {
+			return err
+		}
+	}
+
+	if b.getStateTopicTemplate("conn") != nil {
+		if _, err := b.getStateTopic(gatewayID, "conn"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := b.getCommandTopic(gatewayID); err != nil {
+		return err
+	}
+
+	return b.validateWildcardTopic()
+}
+
+// probeTopics publishes a zero-length message to a dedicated probe topic of
+// each topic class, so that ACL or topic issues are reported before any
+// traffic flows. The probe topics are rendered using the probe event and
+// state type, e.g. gateway/0102030405060708/event/probe, so that the messages
+// are not received by the consumers of the actual events and states. The
+// gateway ID provided by the authentication is used, or the zero gateway ID
+// in case it is not provided.
+func (b *Backend) probeTopics() error {
+	var gatewayID lorawan.EUI64
+	if id := b.auth.GetGatewayID(); id != nil {
+		gatewayID = *id
+	}
+
+	eventTopic, err := b.getEventTopic(gatewayID, topicProbeType, nil)
+	if err != nil {
+		return err
+	}
+	topics := []string{eventTopic}
+
+	if b.getStateTopicTemplate(topicProbeType) != nil {
+		stateTopic, err := b.getStateTopic(gatewayID, topicProbeType)
+		if err != nil {
+			return err
+		}
+		topics = append(topics, stateTopic)
+	}
+
+	var failed []string
+	for _, topic := range topics {
+		// QoS 1 is used so that the broker must acknowledge the publish.
+		token := b.conn.Publish(topic, 1, false, []byte{})
+		if !token.WaitTimeout(topicProbeTimeout) {
+			log.WithField("topic", topic).Error("integration/mqtt: topic probe timeout")
+			failed = append(failed, topic)
+		} else if err := token.Error(); err != nil {
+			log.WithError(err).WithField("topic", topic).Error("integration/mqtt: topic probe error")
+			failed = append(failed, topic)
+		} else {
+			log.WithField("topic", topic).Info("integration/mqtt: topic probe succeeded")
+		}
+	}
+
+	if len(failed) != 0 {
+		return fmt.Errorf("topic probe failed for: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// renameJSONKey renames the given key of all (nested) objects within the
+// given JSON document.
+func renameJSONKey(b []byte, from, to string) ([]byte, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.Wrap(err, "decode json error")
+	}
+
+	var rename func(v interface{})
+	rename = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if val, ok := v[from]; ok {
+				delete(v, from)
+				v[to] = val
+			}
+			for _, val := range v {
+				rename(val)
+			}
+		case []interface{}:
+			for _, val := range v {
+				rename(val)
+			}
+		}
+	}
+	rename(v)
+
+	return json.Marshal(v)
+}
+
+// convertJSONPayload converts the value of all (nested) PHYPayload keys
+// within the given JSON document using the given convert function.
+func convertJSONPayload(b []byte, convert func(string) (string, error)) ([]byte, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.Wrap(err, "decode json error")
+	}
+
+	var walk func(v interface{}) error
+	walk = func(v interface{}) error {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if val, ok := v[jsonPayloadKey].(string); ok {
+				converted, err := convert(val)
+				if err != nil {
+					return errors.Wrapf(err, "convert %s error", jsonPayloadKey)
+				}
+				v[jsonPayloadKey] = converted
+			}
+			for _, val := range v {
+				if err := walk(val); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for _, val := range v {
+				if err := walk(val); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(v); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+// base64ToHex converts the given base64 encoded string to hex.
+func base64ToHex(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hexToBase64 converts the given hex encoded string to base64.
+func hexToBase64(s string) (string, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// newMarshaler returns the marshal and unmarshal functions for the given
+// marshaler (json, cbor or protobuf). When set, the gateway ID key of the
+// JSON encoded messages is renamed to the given gateway ID key. The payload
+// encoding (base64 or hex) defines the encoding of the PHYPayload of the
+// JSON encoded messages, base64 is used when empty. The CBOR encoded
+// messages use the same keys and values as the JSON encoded messages.
+func newMarshaler(marshaler, gatewayIDKey, payloadEncoding string) (func(msg proto.Message) ([]byte, error), func(b []byte, msg proto.Message) error, error) {
+	switch payloadEncoding {
+	case "", PayloadEncodingBase64, PayloadEncodingHex:
+	default:
+		return nil, nil, fmt.Errorf("unknown payload encoding: %s", payloadEncoding)
+	}
+	// the payload of the cbor marshaler is encoded as byte string
+	hexPayload := payloadEncoding == PayloadEncodingHex && marshaler == "json"
+
+	switch marshaler {
+	case "json", "cbor":
+		marshal := func(msg proto.Message) ([]byte, error) {
+			marshaler := &jsonpb.Marshaler{
+				EnumsAsInts:  false,
+				EmitDefaults: true,
+			}
+			str, err := marshaler.MarshalToString(msg)
+			if err != nil {
+				return nil, err
+			}
+			b := []byte(str)
+
+			if hexPayload {
+				b, err = convertJSONPayload(b, base64ToHex)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if gatewayIDKey == "" {
+				return b, nil
+			}
+			return renameJSONKey(b, jsonGatewayIDKey, gatewayIDKey)
+		}
+
+		unmarshal := func(b []byte, msg proto.Message) error {
+			if hexPayload {
+				var err error
+				b, err = convertJSONPayload(b, hexToBase64)
+				if err != nil {
+					return err
+				}
+			}
+
+			unmarshaler := &jsonpb.Unmarshaler{
+				AllowUnknownFields: true, // we don't want to fail on unknown fields
+			}
+			return unmarshaler.Unmarshal(bytes.NewReader(b), msg)
+		}
+
+		if marshaler == "cbor" {
+			var err error
+			marshal, unmarshal, err = newCBORMarshaler(marshal, unmarshal, gatewayIDKey)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		return marshal, unmarshal, nil
+	case "protobuf":
+		marshal := func(msg proto.Message) ([]byte, error) {
+			return proto.Marshal(msg)
+		}
+
+		unmarshal := func(b []byte, msg proto.Message) error {
+			return proto.Unmarshal(b, msg)
+		}
+
+		return marshal, unmarshal, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown marshaler: %s", marshaler)
+	}
+}
+
+// sortJSONKeys re-encodes the given JSON document with the keys of all
+// (nested) objects sorted, so that the same message always results in the
+// same bytes.
+func sortJSONKeys(b []byte) ([]byte, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.Wrap(err, "decode json error")
+	}
+
+	// encoding/json writes the keys of a map in sorted order
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, errors.Wrap(err, "encode json error")
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// allowTree holds the allowed (nested) JSON keys. A nil value means that the
+// key is allowed including all its nested keys.
+type allowTree map[string]allowTree
+
+// newAllowTree creates an allowTree from the given dot separated key paths
+// (e.g. txInfo.frequency).
+func newAllowTree(paths []string) allowTree {
+	tree := make(allowTree)
+	for _, path := range paths {
+		node := tree
+		keys := strings.Split(path, ".")
+		for i, key := range keys {
+			if i == len(keys)-1 {
+				node[key] = nil
+				break
+			}
+
+			child, ok := node[key]
+			if ok && child == nil {
+				// the parent key is already allowed as a whole
+				break
+			}
+			if !ok {
+				child = make(allowTree)
+				node[key] = child
+			}
+			node = child
+		}
+	}
+	return tree
+}
+
+// filterJSONKeys removes all the keys from the given JSON document that are
+// not allowed by the given allowTree. For arrays, the allowTree is applied
+// to each element.
+func filterJSONKeys(b []byte, tree allowTree) ([]byte, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.Wrap(err, "decode json error")
+	}
+
+	var filter func(v interface{}, tree allowTree) interface{}
+	filter = func(v interface{}, tree allowTree) interface{} {
+		if tree == nil {
+			return v
+		}
+
+		switch v := v.(type) {
+		case map[string]interface{}:
+			out := make(map[string]interface{})
+			for key, subTree := range tree {
+				if val, ok := v[key]; ok {
+					out[key] = filter(val, subTree)
+				}
+			}
+			return out
+		case []interface{}:
+			for i := range v {
+				v[i] = filter(v[i], tree)
+			}
+		}
+		return v
+	}
+
+	return json.Marshal(filter(v, tree))
+}
+
 // isClosed returns true when the integration is shutting down.
 func (b *Backend) isClosed() bool {
 	b.connMux.RLock()
@@ -1,19 +1,39 @@
 package mqtt
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"text/template"
 	"time"
 
+	"github.com/brocaar/chirpstack-api/go/v3/common"
 	"github.com/brocaar/chirpstack-api/go/v3/gw"
 	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/proto"
 
 	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
 	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/backend/events"
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/status"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/uplinkmeta"
 	"github.com/brocaar/lorawan"
 )
 
@@ -30,21 +50,11 @@ func (ts *MQTTBackendTestSuite) SetupSuite() {
 
 	log.SetLevel(log.ErrorLevel)
 
-	server := "tcp://127.0.0.1:1883/1"
-	var username string
-	var password string
-
-	if v := os.Getenv("TEST_MQTT_SERVER"); v != "" {
-		server = v
-	}
-	if v := os.Getenv("TEST_MQTT_USERNAME"); v != "" {
-		username = v
-	}
-	if v := os.Getenv("TEST_MQTT_PASSWORD"); v != "" {
-		password = v
-	}
+	server, username, password := getTestMQTTServer()
 
-	opts := paho.NewClientOptions().AddBroker(server).SetUsername(username).SetPassword(password)
+	// the subscriptions of the tests are not removed, a handler blocking on
+	// an unread channel must not block the handlers of the other tests
+	opts := paho.NewClientOptions().AddBroker(server).SetUsername(username).SetPassword(password).SetOrderMatters(false)
 	ts.mqttClient = paho.NewClient(opts)
 	token := ts.mqttClient.Connect()
 	token.Wait()
@@ -58,6 +68,7 @@ func (ts *MQTTBackendTestSuite) SetupSuite() {
 	conf.Integration.MQTT.StateTopicTemplate = "gateway/{{ .GatewayID }}/state/{{ .StateType }}"
 	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
 	conf.Integration.MQTT.StateRetained = true
+	conf.Integration.MQTT.AutoReconnect = true
 	conf.Integration.MQTT.Auth.Type = "generic"
 	conf.Integration.MQTT.Auth.Generic.Servers = []string{server}
 	conf.Integration.MQTT.Auth.Generic.Username = username
@@ -80,6 +91,34 @@ func (ts *MQTTBackendTestSuite) TearDownSuite() {
 	ts.backend.Stop()
 }
 
+// getTestMQTTServer returns the server, username and password of the MQTT
+// broker to test against. These can be set using the TEST_MQTT_SERVER,
+// TEST_MQTT_USERNAME and TEST_MQTT_PASSWORD environment variables.
+func getTestMQTTServer() (string, string, string) {
+	server := "tcp://127.0.0.1:1883/1"
+	if v := os.Getenv("TEST_MQTT_SERVER"); v != "" {
+		server = v
+	}
+
+	return server, os.Getenv("TEST_MQTT_USERNAME"), os.Getenv("TEST_MQTT_PASSWORD")
+}
+
+// setTestMQTTServer configures the generic authentication of the given
+// configuration to connect to the MQTT broker to test against.
+func setTestMQTTServer(conf *config.Config) {
+	server, username, password := getTestMQTTServer()
+	conf.Integration.MQTT.Auth.Generic.Servers = []string{server}
+	conf.Integration.MQTT.Auth.Generic.Username = username
+	conf.Integration.MQTT.Auth.Generic.Password = password
+}
+
+// newTestMQTTClient returns a (not yet connected) client for the MQTT broker
+// to test against.
+func newTestMQTTClient() paho.Client {
+	server, username, password := getTestMQTTServer()
+	return paho.NewClient(paho.NewClientOptions().AddBroker(server).SetUsername(username).SetPassword(password))
+}
+
 func (ts *MQTTBackendTestSuite) TestLastWill() {
 	assert := require.New(ts.T())
 
@@ -157,6 +196,358 @@ func (ts *MQTTBackendTestSuite) TestSubscribeGateway() {
 	assert.NoError(token.Error())
 }
 
+func (ts *MQTTBackendTestSuite) TestSubscribeGatewayTwice() {
+	assert := require.New(ts.T())
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 9}
+	topic := "gateway/0102030405060709/state/conn"
+	connStateChan := make(chan gw.ConnState, 10)
+
+	// clear the retained state of previous runs
+	token := ts.mqttClient.Publish(topic, 0, true, []byte{})
+	token.Wait()
+	assert.NoError(token.Error())
+
+	token = ts.mqttClient.Subscribe(topic, 0, func(c paho.Client, msg paho.Message) {
+		var pl gw.ConnState
+		assert.NoError(ts.backend.unmarshal(msg.Payload(), &pl))
+		connStateChan <- pl
+	})
+	token.Wait()
+	assert.NoError(token.Error())
+
+	// Each MQTT subscribe results in an ONLINE ConnState. Wait for the
+	// subscribe loop to pick up the change between both calls.
+	assert.NoError(ts.backend.SetGatewaySubscription(true, gatewayID))
+	time.Sleep(200 * time.Millisecond)
+	assert.NoError(ts.backend.SetGatewaySubscription(true, gatewayID))
+	time.Sleep(200 * time.Millisecond)
+
+	assert.Len(connStateChan, 1)
+	assert.Equal(gw.ConnState{
+		GatewayId: gatewayID[:],
+		State:     gw.ConnState_ONLINE,
+	}, <-connStateChan)
+
+	assert.NoError(ts.backend.SetGatewaySubscription(false, gatewayID))
+	assert.Equal(gw.ConnState_OFFLINE, (<-connStateChan).State)
+
+	token = ts.mqttClient.Unsubscribe(topic)
+	token.Wait()
+	assert.NoError(token.Error())
+}
+
+func (ts *MQTTBackendTestSuite) TestSubscribeRetry() {
+	assert := require.New(ts.T())
+
+	goodID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 11}
+	badID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 12}
+
+	// the command topic of the bad gateway is invalid, so that its
+	// subscription keeps failing
+	commandTopicTemplate, err := template.New("command").Parse(`{{ if eq .GatewayID.String "010203040506070c" }}gateway/#/{{ .GatewayID }}{{ else }}gateway/{{ .GatewayID }}/command/#{{ end }}`)
+	assert.NoError(err)
+
+	ts.backend.gatewaysSubscribedMux.Lock()
+	origTemplate := ts.backend.commandTopicTemplate
+	ts.backend.commandTopicTemplate = commandTopicTemplate
+	ts.backend.subscribeRetryInitial = 200 * time.Millisecond
+	ts.backend.subscribeRetryMax = 400 * time.Millisecond
+	ts.backend.gatewaysSubscribedMux.Unlock()
+
+	defer func() {
+		ts.backend.gatewaysSubscribedMux.Lock()
+		ts.backend.commandTopicTemplate = origTemplate
+		ts.backend.subscribeRetryInitial = 0
+		ts.backend.subscribeRetryMax = 0
+		ts.backend.gatewaysSubscribedMux.Unlock()
+	}()
+
+	assert.NoError(ts.backend.SetGatewaySubscription(true, badID))
+	assert.NoError(ts.backend.SetGatewaySubscription(true, goodID))
+
+	ts.T().Run("Independent retry", func(t *testing.T) {
+		assert := require.New(t)
+
+		time.Sleep(200 * time.Millisecond)
+
+		ts.backend.gatewaysSubscribedMux.Lock()
+		_, ok := ts.backend.gatewaysSubscribed[goodID]
+		assert.True(ok)
+		_, ok = ts.backend.gatewaysSubscribed[badID]
+		assert.False(ok)
+		assert.NotContains(ts.backend.subscribeRetries, goodID)
+		assert.Equal(1, ts.backend.subscribeRetries[badID].failures)
+		ts.backend.gatewaysSubscribedMux.Unlock()
+	})
+
+	ts.T().Run("Backoff", func(t *testing.T) {
+		assert := require.New(t)
+
+		// retries after 200ms, 400ms and 400ms (max. interval), without
+		// backoff this would be retried every 100ms
+		time.Sleep(time.Second)
+
+		ts.backend.gatewaysSubscribedMux.Lock()
+		retry := ts.backend.subscribeRetries[badID]
+		ts.backend.gatewaysSubscribedMux.Unlock()
+
+		assert.True(retry.failures >= 3 && retry.failures <= 4, "failures: %d", retry.failures)
+		assert.True(retry.next.After(time.Now()))
+	})
+
+	ts.T().Run("Removed gateway", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(ts.backend.SetGatewaySubscription(false, badID))
+		assert.NoError(ts.backend.SetGatewaySubscription(false, goodID))
+		time.Sleep(200 * time.Millisecond)
+
+		ts.backend.gatewaysSubscribedMux.Lock()
+		assert.Len(ts.backend.subscribeRetries, 0)
+		assert.NotContains(ts.backend.gatewaysSubscribed, goodID)
+		ts.backend.gatewaysSubscribedMux.Unlock()
+	})
+}
+
+func (ts *MQTTBackendTestSuite) TestSubscribeRetryTransient() {
+	assert := require.New(ts.T())
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 13}
+
+	// the command topic is invalid until the template has been restored
+	commandTopicTemplate, err := template.New("command").Parse(`gateway/#/{{ .GatewayID }}`)
+	assert.NoError(err)
+
+	ts.backend.gatewaysSubscribedMux.Lock()
+	origTemplate := ts.backend.commandTopicTemplate
+	ts.backend.commandTopicTemplate = commandTopicTemplate
+	ts.backend.subscribeRetryInitial = 200 * time.Millisecond
+	ts.backend.subscribeRetryMax = 200 * time.Millisecond
+	ts.backend.subscribeRetryAttempts = 3
+	ts.backend.gatewaysSubscribedMux.Unlock()
+
+	defer func() {
+		ts.backend.gatewaysSubscribedMux.Lock()
+		ts.backend.commandTopicTemplate = origTemplate
+		ts.backend.subscribeRetryInitial = 0
+		ts.backend.subscribeRetryMax = 0
+		ts.backend.subscribeRetryAttempts = 0
+		ts.backend.gatewaysSubscribedMux.Unlock()
+
+		assert.NoError(ts.backend.SetGatewaySubscription(false, gatewayID))
+	}()
+
+	assert.NoError(ts.backend.SetGatewaySubscription(true, gatewayID))
+
+	ts.T().Run("Failed", func(t *testing.T) {
+		assert := require.New(t)
+
+		time.Sleep(150 * time.Millisecond)
+
+		ts.backend.gatewaysSubscribedMux.Lock()
+		assert.NotContains(ts.backend.gatewaysSubscribed, gatewayID)
+		assert.Equal(1, ts.backend.subscribeRetries[gatewayID].failures)
+		assert.False(ts.backend.subscribeRetries[gatewayID].stopped)
+
+		// the broker ACL has been provisioned
+		ts.backend.commandTopicTemplate = origTemplate
+		ts.backend.gatewaysSubscribedMux.Unlock()
+	})
+
+	ts.T().Run("Succeeded on retry", func(t *testing.T) {
+		assert := require.New(t)
+
+		time.Sleep(300 * time.Millisecond)
+
+		ts.backend.gatewaysSubscribedMux.Lock()
+		assert.Contains(ts.backend.gatewaysSubscribed, gatewayID)
+		assert.NotContains(ts.backend.subscribeRetries, gatewayID)
+		ts.backend.gatewaysSubscribedMux.Unlock()
+	})
+}
+
+func (ts *MQTTBackendTestSuite) TestSubscribeRetryStoppedReregister() {
+	assert := require.New(ts.T())
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 14}
+
+	// the command topic is invalid until the template has been restored
+	commandTopicTemplate, err := template.New("command").Parse(`gateway/#/{{ .GatewayID }}`)
+	assert.NoError(err)
+
+	ts.backend.gatewaysSubscribedMux.Lock()
+	origTemplate := ts.backend.commandTopicTemplate
+	ts.backend.commandTopicTemplate = commandTopicTemplate
+	ts.backend.subscribeRetryAttempts = 1
+	ts.backend.gatewaysSubscribedMux.Unlock()
+
+	defer func() {
+		ts.backend.gatewaysSubscribedMux.Lock()
+		ts.backend.commandTopicTemplate = origTemplate
+		ts.backend.subscribeRetryAttempts = 0
+		ts.backend.gatewaysSubscribedMux.Unlock()
+
+		assert.NoError(ts.backend.SetGatewaySubscription(false, gatewayID))
+	}()
+
+	assert.NoError(ts.backend.SetGatewaySubscription(true, gatewayID))
+
+	ts.T().Run("Stopped", func(t *testing.T) {
+		assert := require.New(t)
+
+		time.Sleep(200 * time.Millisecond)
+
+		ts.backend.gatewaysSubscribedMux.Lock()
+		assert.NotContains(ts.backend.gatewaysSubscribed, gatewayID)
+		assert.True(ts.backend.subscribeRetries[gatewayID].stopped)
+
+		// the broker ACL has been provisioned
+		ts.backend.commandTopicTemplate = origTemplate
+		ts.backend.gatewaysSubscribedMux.Unlock()
+
+		time.Sleep(200 * time.Millisecond)
+
+		ts.backend.gatewaysSubscribedMux.Lock()
+		assert.NotContains(ts.backend.gatewaysSubscribed, gatewayID)
+		ts.backend.gatewaysSubscribedMux.Unlock()
+	})
+
+	ts.T().Run("Subscribed after re-register", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(ts.backend.SetGatewaySubscription(true, gatewayID))
+		time.Sleep(200 * time.Millisecond)
+
+		ts.backend.gatewaysSubscribedMux.Lock()
+		assert.Contains(ts.backend.gatewaysSubscribed, gatewayID)
+		assert.NotContains(ts.backend.subscribeRetries, gatewayID)
+		ts.backend.gatewaysSubscribedMux.Unlock()
+	})
+}
+
+func (ts *MQTTBackendTestSuite) TestSubscribeBatchSize() {
+	assert := require.New(ts.T())
+
+	var gatewayIDs []lorawan.EUI64
+	for i := 0; i < 5; i++ {
+		gatewayIDs = append(gatewayIDs, lorawan.EUI64{1, 2, 3, 4, 5, 6, 8, byte(i)})
+	}
+
+	ts.backend.gatewaysSubscribedMux.Lock()
+	ts.backend.subscribeBatchSize = 2
+	ts.backend.gatewaysSubscribedMux.Unlock()
+
+	defer func() {
+		ts.backend.gatewaysSubscribedMux.Lock()
+		ts.backend.subscribeBatchSize = 0
+		ts.backend.gatewaysSubscribedMux.Unlock()
+
+		for _, gatewayID := range gatewayIDs {
+			assert.NoError(ts.backend.SetGatewaySubscription(false, gatewayID))
+		}
+	}()
+
+	for _, gatewayID := range gatewayIDs {
+		assert.NoError(ts.backend.SetGatewaySubscription(true, gatewayID))
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	ts.backend.gatewaysSubscribedMux.Lock()
+	for _, gatewayID := range gatewayIDs {
+		assert.Contains(ts.backend.gatewaysSubscribed, gatewayID)
+	}
+	ts.backend.gatewaysSubscribedMux.Unlock()
+
+	ts.T().Run("Downlink", func(t *testing.T) {
+		assert := require.New(t)
+
+		downlinkFrameChan := make(chan gw.DownlinkFrame, 1)
+		ts.backend.SetDownlinkFrameFunc(func(pl gw.DownlinkFrame) {
+			downlinkFrameChan <- pl
+		})
+
+		downlink := gw.DownlinkFrame{
+			GatewayId: gatewayIDs[4][:],
+			Token:     1234,
+			Items: []*gw.DownlinkFrameItem{
+				{
+					PhyPayload: []byte{1, 2, 3, 4},
+				},
+			},
+		}
+		b, err := ts.backend.marshal(&downlink)
+		assert.NoError(err)
+
+		token := ts.mqttClient.Publish("gateway/0102030405060804/command/down", 0, false, b)
+		token.Wait()
+		assert.NoError(token.Error())
+
+		received := <-downlinkFrameChan
+		assert.True(proto.Equal(&downlink, &received))
+	})
+}
+
+func (ts *MQTTBackendTestSuite) TestUnsubscribeGrace() {
+	assert := require.New(ts.T())
+
+	ts.backend.gatewaysMux.Lock()
+	ts.backend.unsubscribeGrace = 500 * time.Millisecond
+	ts.backend.gatewaysMux.Unlock()
+
+	defer func() {
+		ts.backend.gatewaysMux.Lock()
+		ts.backend.unsubscribeGrace = 0
+		ts.backend.gatewaysMux.Unlock()
+	}()
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 10}
+	topic := "gateway/010203040506070a/state/conn"
+	connStateChan := make(chan gw.ConnState, 10)
+
+	// clear the retained state of previous runs
+	token := ts.mqttClient.Publish(topic, 0, true, []byte{})
+	token.Wait()
+	assert.NoError(token.Error())
+
+	token = ts.mqttClient.Subscribe(topic, 0, func(c paho.Client, msg paho.Message) {
+		var pl gw.ConnState
+		assert.NoError(ts.backend.unmarshal(msg.Payload(), &pl))
+		connStateChan <- pl
+	})
+	token.Wait()
+	assert.NoError(token.Error())
+
+	assert.NoError(ts.backend.SetGatewaySubscription(true, gatewayID))
+	assert.Equal(gw.ConnState_ONLINE, (<-connStateChan).State)
+
+	ts.T().Run("Re-subscribe within grace", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(ts.backend.SetGatewaySubscription(false, gatewayID))
+		time.Sleep(200 * time.Millisecond)
+		assert.NoError(ts.backend.SetGatewaySubscription(true, gatewayID))
+		time.Sleep(600 * time.Millisecond)
+
+		// no unsubscribe (OFFLINE) and re-subscribe (ONLINE) is expected
+		assert.Len(connStateChan, 0)
+	})
+
+	ts.T().Run("Unsubscribe after grace", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(ts.backend.SetGatewaySubscription(false, gatewayID))
+		time.Sleep(200 * time.Millisecond)
+		assert.Len(connStateChan, 0)
+
+		assert.Equal(gw.ConnState_OFFLINE, (<-connStateChan).State)
+	})
+
+	token = ts.mqttClient.Unsubscribe(topic)
+	token.Wait()
+	assert.NoError(token.Error())
+}
+
 func (ts *MQTTBackendTestSuite) TestPublishUplinkFrame() {
 	assert := require.New(ts.T())
 	id, err := uuid.NewV4()
@@ -183,6 +574,33 @@ func (ts *MQTTBackendTestSuite) TestPublishUplinkFrame() {
 	assert.Equal(uplink, uplinkReceived)
 }
 
+func (ts *MQTTBackendTestSuite) TestPublishUplinkFrameSet() {
+	assert := require.New(ts.T())
+	id, err := uuid.NewV4()
+	assert.NoError(err)
+
+	uplinkSet := gw.UplinkFrameSet{
+		PhyPayload: []byte{1, 2, 3, 4},
+		RxInfo: []*gw.UplinkRXInfo{
+			{UplinkId: id[:], Antenna: 0},
+			{UplinkId: id[:], Antenna: 1},
+		},
+	}
+
+	uplinkSetChan := make(chan gw.UplinkFrameSet)
+	token := ts.mqttClient.Subscribe("gateway/+/event/up_set", 0, func(c paho.Client, msg paho.Message) {
+		var pl gw.UplinkFrameSet
+		assert.NoError(ts.backend.unmarshal(msg.Payload(), &pl))
+		uplinkSetChan <- pl
+	})
+	token.Wait()
+	assert.NoError(token.Error())
+
+	assert.NoError(ts.backend.PublishEvent(ts.gatewayID, "up_set", id, &uplinkSet))
+	uplinkSetReceived := <-uplinkSetChan
+	assert.Equal(uplinkSet, uplinkSetReceived)
+}
+
 func (ts *MQTTBackendTestSuite) TestGatewayStats() {
 	assert := require.New(ts.T())
 	id, err := uuid.NewV4()
@@ -207,168 +625,3219 @@ func (ts *MQTTBackendTestSuite) TestGatewayStats() {
 	assert.Equal(stats, statsReceived)
 }
 
-func (ts *MQTTBackendTestSuite) TestPublishDownlinkTXAck() {
+func (ts *MQTTBackendTestSuite) TestStatsGroups() {
 	assert := require.New(ts.T())
-	id, err := uuid.NewV4()
+
+	groupedID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 9, 1}
+	ungroupedID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 9, 2}
+
+	var err error
+	ts.backend.statsGroupTopicTemplate, err = template.New("stats_group").Parse("group/{{ .Group }}/event/stats")
 	assert.NoError(err)
+	ts.backend.statsGroups = map[lorawan.EUI64][]string{
+		groupedID: {"north", "all"},
+	}
 
-	txAck := gw.DownlinkTXAck{
-		GatewayId:  ts.gatewayID[:],
-		Token:      1234,
-		DownlinkId: id[:],
-		Items: []*gw.DownlinkTXAckItem{
-			{
-				Status: gw.TxAckStatus_OK,
+	defer func() {
+		ts.backend.statsGroupTopicTemplate = nil
+		ts.backend.statsGroups = nil
+		ts.backend.statsGroupOnly = false
+	}()
+
+	topicChan := make(chan string, 10)
+	for _, topic := range []string{"group/+/event/stats", "gateway/+/event/stats"} {
+		token := ts.mqttClient.Subscribe(topic, 0, func(c paho.Client, msg paho.Message) {
+			var stats gw.GatewayStats
+			assert.NoError(ts.backend.unmarshal(msg.Payload(), &stats))
+			topicChan <- msg.Topic()
+		})
+		token.Wait()
+		assert.NoError(token.Error())
+	}
+
+	defer func() {
+		token := ts.mqttClient.Unsubscribe("group/+/event/stats", "gateway/+/event/stats")
+		token.Wait()
+		assert.NoError(token.Error())
+	}()
+
+	receivedTopics := func(n int) []string {
+		var topics []string
+		for i := 0; i < n; i++ {
+			topics = append(topics, <-topicChan)
+		}
+		time.Sleep(100 * time.Millisecond)
+		assert.Len(topicChan, 0)
+		return topics
+	}
+
+	tests := []struct {
+		Name           string
+		GatewayID      lorawan.EUI64
+		GroupOnly      bool
+		ExpectedTopics []string
+	}{
+		{
+			Name:      "grouped gateway",
+			GatewayID: groupedID,
+			ExpectedTopics: []string{
+				"gateway/0102030405060901/event/stats",
+				"group/north/event/stats",
+				"group/all/event/stats",
+			},
+		},
+		{
+			Name:      "grouped gateway, group only",
+			GatewayID: groupedID,
+			GroupOnly: true,
+			ExpectedTopics: []string{
+				"group/north/event/stats",
+				"group/all/event/stats",
+			},
+		},
+		{
+			Name:      "ungrouped gateway",
+			GatewayID: ungroupedID,
+			GroupOnly: true,
+			ExpectedTopics: []string{
+				"gateway/0102030405060902/event/stats",
 			},
 		},
 	}
 
-	txAckChan := make(chan gw.DownlinkTXAck)
-	token := ts.mqttClient.Subscribe("gateway/+/event/ack", 0, func(c paho.Client, msg paho.Message) {
-		var pl gw.DownlinkTXAck
-		assert.NoError(ts.backend.unmarshal(msg.Payload(), &pl))
-		txAckChan <- pl
-	})
-	token.Wait()
-	assert.NoError(token.Error())
+	for _, tst := range tests {
+		ts.T().Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			ts.backend.statsGroupOnly = tst.GroupOnly
 
-	assert.NoError(ts.backend.PublishEvent(ts.gatewayID, "ack", id, &txAck))
+			id, err := uuid.NewV4()
+			assert.NoError(err)
+			assert.NoError(ts.backend.PublishEvent(tst.GatewayID, "stats", id, &gw.GatewayStats{
+				GatewayId: tst.GatewayID[:],
+			}))
 
-	txAckReceived := <-txAckChan
-	assert.Equal(txAck, txAckReceived)
+			assert.ElementsMatch(tst.ExpectedTopics, receivedTopics(len(tst.ExpectedTopics)))
+		})
+	}
 }
 
-func (ts *MQTTBackendTestSuite) TestPublishConnState() {
+func (ts *MQTTBackendTestSuite) TestCombineUplinkStats() {
 	assert := require.New(ts.T())
+	id, err := uuid.NewV4()
+	assert.NoError(err)
 
-	// We publish first
-	state := gw.ConnState{
-		GatewayId: ts.gatewayID[:],
-		State:     gw.ConnState_ONLINE,
+	ts.backend.combineUplinkStats = true
+	defer func() {
+		ts.backend.combineUplinkStats = false
+	}()
+
+	stats := gw.GatewayStats{
+		GatewayId:         ts.gatewayID[:],
+		StatsId:           id[:],
+		RxPacketsReceived: 10,
 	}
-	assert.NoError(ts.backend.PublishState(ts.gatewayID, "conn", &state))
+	assert.NoError(ts.backend.PublishEvent(ts.gatewayID, "stats", id, &stats))
 
-	// And then subscribe to test that the message has been retained
-	stateChan := make(chan gw.ConnState)
-	token := ts.mqttClient.Subscribe("gateway/0807060504030201/state/conn", 0, func(c paho.Client, msg paho.Message) {
-		var pl gw.ConnState
-		assert.NoError(ts.backend.unmarshal(msg.Payload(), &pl))
-		stateChan <- pl
+	uplink := gw.UplinkFrame{
+		PhyPayload: []byte{1, 2, 3, 4},
+		RxInfo: &gw.UplinkRXInfo{
+			GatewayId: ts.gatewayID[:],
+			UplinkId:  id[:],
+		},
+	}
+
+	payloadChan := make(chan []byte)
+	token := ts.mqttClient.Subscribe("gateway/+/event/up", 0, func(c paho.Client, msg paho.Message) {
+		payloadChan <- msg.Payload()
 	})
 	token.Wait()
 	assert.NoError(token.Error())
 
-	assert.Equal(state, <-stateChan)
+	assert.NoError(ts.backend.PublishEvent(ts.gatewayID, "up", id, &uplink))
+	payload := <-payloadChan
 
-	token = ts.mqttClient.Unsubscribe("gateway/0807060504030201/state/conn")
+	var uplinkReceived gw.UplinkFrame
+	assert.NoError(ts.backend.unmarshal(payload, &uplinkReceived))
+	assert.True(proto.Equal(&uplink, &uplinkReceived))
+
+	var v map[string]json.RawMessage
+	assert.NoError(json.Unmarshal(payload, &v))
+	var statsReceived gw.GatewayStats
+	assert.NoError(ts.backend.unmarshal(v["stats"], &statsReceived))
+	assert.True(proto.Equal(&stats, &statsReceived))
+
+	token = ts.mqttClient.Unsubscribe("gateway/+/event/up")
 	token.Wait()
 	assert.NoError(token.Error())
 }
 
-func (ts *MQTTBackendTestSuite) TestDownlinkFrameHandler() {
+func (ts *MQTTBackendTestSuite) TestMACSummary() {
 	assert := require.New(ts.T())
-	downlinkFrameChan := make(chan gw.DownlinkFrame, 1)
-	ts.backend.SetDownlinkFrameFunc(func(pl gw.DownlinkFrame) {
-		downlinkFrameChan <- pl
-	})
 
-	downlink := gw.DownlinkFrame{
-		Items: []*gw.DownlinkFrameItem{
-			{
-				PhyPayload: []byte{1, 2, 3, 4},
+	ts.backend.macSummary = true
+	defer func() {
+		ts.backend.macSummary = false
+	}()
+
+	fPort := uint8(10)
+	phy := lorawan.PHYPayload{
+		MHDR: lorawan.MHDR{
+			MType: lorawan.UnconfirmedDataUp,
+			Major: lorawan.LoRaWANR1,
+		},
+		MACPayload: &lorawan.MACPayload{
+			FHDR: lorawan.FHDR{
+				DevAddr: lorawan.DevAddr{1, 2, 3, 4},
+				FCnt:    123,
 			},
+			FPort:      &fPort,
+			FRMPayload: []lorawan.Payload{&lorawan.DataPayload{Bytes: []byte{1, 2, 3}}},
 		},
 	}
-
-	b, err := ts.backend.marshal(&downlink)
+	phyBytes, err := phy.MarshalBinary()
 	assert.NoError(err)
 
-	token := ts.mqttClient.Publish("gateway/0807060504030201/command/down", 0, false, b)
+	payloadChan := make(chan []byte)
+	token := ts.mqttClient.Subscribe("gateway/+/event/up", 0, func(c paho.Client, msg paho.Message) {
+		payloadChan <- msg.Payload()
+	})
 	token.Wait()
 	assert.NoError(token.Error())
 
-	receivedDownlink := <-downlinkFrameChan
-	assert.Equal(downlink, receivedDownlink)
+	tests := []struct {
+		Name       string
+		PHYPayload []byte
+		Expected   string
+	}{
+		{
+			Name:       "data uplink",
+			PHYPayload: phyBytes,
+			Expected:   `{"mType": "UnconfirmedDataUp", "devAddr": "01020304", "fCnt": 123, "fPort": 10}`,
+		},
+		{
+			Name:       "malformed phypayload",
+			PHYPayload: []byte{1, 2, 3},
+		},
+	}
+
+	for _, tst := range tests {
+		ts.T().Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			id, err := uuid.NewV4()
+			assert.NoError(err)
+
+			uplink := gw.UplinkFrame{
+				PhyPayload: tst.PHYPayload,
+				RxInfo: &gw.UplinkRXInfo{
+					GatewayId: ts.gatewayID[:],
+					UplinkId:  id[:],
+				},
+			}
+			assert.NoError(ts.backend.PublishEvent(ts.gatewayID, "up", id, &uplink))
+			payload := <-payloadChan
+
+			var uplinkReceived gw.UplinkFrame
+			assert.NoError(ts.backend.unmarshal(payload, &uplinkReceived))
+			assert.True(proto.Equal(&uplink, &uplinkReceived))
+
+			var v map[string]json.RawMessage
+			assert.NoError(json.Unmarshal(payload, &v))
+
+			if tst.Expected != "" {
+				assert.JSONEq(tst.Expected, string(v["macSummary"]))
+			} else {
+				assert.NotContains(v, "macSummary")
+			}
+		})
+	}
+
+	token = ts.mqttClient.Unsubscribe("gateway/+/event/up")
+	token.Wait()
+	assert.NoError(token.Error())
 }
 
-func (ts *MQTTBackendTestSuite) TestGatewayConfigHandler() {
+func (ts *MQTTBackendTestSuite) TestUplinkAllowList() {
 	assert := require.New(ts.T())
-	gatewayConfigurationChan := make(chan gw.GatewayConfiguration, 1)
-	ts.backend.SetGatewayConfigurationFunc(func(pl gw.GatewayConfiguration) {
-		gatewayConfigurationChan <- pl
+
+	ts.backend.uplinkAllowList = newAllowTree([]string{"rxInfo.gatewayID", "rxInfo.uplinkID", "phyPayload", "txInfo.frequency", "rxInfo.rssi"})
+	defer func() {
+		ts.backend.uplinkAllowList = nil
+	}()
+
+	payloadChan := make(chan []byte)
+	token := ts.mqttClient.Subscribe("gateway/+/event/up", 0, func(c paho.Client, msg paho.Message) {
+		payloadChan <- msg.Payload()
 	})
+	token.Wait()
+	assert.NoError(token.Error())
 
-	config := gw.GatewayConfiguration{
-		GatewayId: ts.gatewayID[:],
-		Version:   "123",
+	id, err := uuid.NewV4()
+	assert.NoError(err)
+	uplink := gw.UplinkFrame{
+		PhyPayload: []byte{1, 2, 3, 4},
+		TxInfo: &gw.UplinkTXInfo{
+			Frequency:  868100000,
+			Modulation: common.Modulation_LORA,
+		},
+		RxInfo: &gw.UplinkRXInfo{
+			GatewayId: ts.gatewayID[:],
+			UplinkId:  id[:],
+			Rssi:      -50,
+			LoraSnr:   5.5,
+			Channel:   2,
+		},
 	}
+	assert.NoError(ts.backend.PublishEvent(ts.gatewayID, "up", id, &uplink))
+	payload := <-payloadChan
 
-	b, err := ts.backend.marshal(&config)
-	assert.NoError(err)
+	assert.JSONEq(fmt.Sprintf(`{
+		"phyPayload": "AQIDBA==",
+		"txInfo": {"frequency": 868100000},
+		"rxInfo": {"gatewayID": "CAcGBQQDAgE=", "uplinkID": "%s", "rssi": -50}
+	}`, base64.StdEncoding.EncodeToString(id[:])), string(payload))
 
-	token := ts.mqttClient.Publish("gateway/0807060504030201/command/config", 0, false, b)
+	token = ts.mqttClient.Unsubscribe("gateway/+/event/up")
 	token.Wait()
 	assert.NoError(token.Error())
+}
 
-	receivedConfig := <-gatewayConfigurationChan
-	assert.Equal(config, receivedConfig)
+func (ts *MQTTBackendTestSuite) TestUplinkMetaData() {
+	assert := require.New(ts.T())
+
+	payloadChan := make(chan []byte)
+	token := ts.mqttClient.Subscribe("gateway/+/event/up", 0, func(c paho.Client, msg paho.Message) {
+		payloadChan <- msg.Payload()
+	})
+	token.Wait()
+	assert.NoError(token.Error())
+
+	for _, withMetaData := range []bool{false, true} {
+		ts.T().Run(fmt.Sprintf("with meta-data %t", withMetaData), func(t *testing.T) {
+			assert := require.New(t)
+			id, err := uuid.NewV4()
+			assert.NoError(err)
+
+			if withMetaData {
+				uplinkmeta.Set(id[:], uplinkmeta.SourceAddr, "192.168.1.10:1700")
+			}
+
+			uplink := gw.UplinkFrame{
+				PhyPayload: []byte{1, 2, 3, 4},
+				RxInfo: &gw.UplinkRXInfo{
+					GatewayId: ts.gatewayID[:],
+					UplinkId:  id[:],
+				},
+			}
+			assert.NoError(ts.backend.PublishEvent(ts.gatewayID, "up", id, &uplink))
+			payload := <-payloadChan
+
+			var uplinkReceived gw.UplinkFrame
+			assert.NoError(ts.backend.unmarshal(payload, &uplinkReceived))
+			assert.True(proto.Equal(&uplink, &uplinkReceived))
+
+			var v struct {
+				RxInfo map[string]json.RawMessage `json:"rxInfo"`
+			}
+			assert.NoError(json.Unmarshal(payload, &v))
+
+			if withMetaData {
+				assert.JSONEq(`{"source_addr": "192.168.1.10:1700"}`, string(v.RxInfo["metaData"]))
+			} else {
+				assert.NotContains(v.RxInfo, "metaData")
+			}
+		})
+	}
+
+	token = ts.mqttClient.Unsubscribe("gateway/+/event/up")
+	token.Wait()
+	assert.NoError(token.Error())
 }
 
-func (ts *MQTTBackendTestSuite) TestGatewayCommandExecRequest() {
+func (ts *MQTTBackendTestSuite) TestSortJSONKeys() {
 	assert := require.New(ts.T())
-	gatewayComandExecRequestChan := make(chan gw.GatewayCommandExecRequest, 1)
-	ts.backend.SetGatewayCommandExecRequestFunc(func(pl gw.GatewayCommandExecRequest) {
-		gatewayComandExecRequestChan <- pl
+
+	ts.backend.sortJSONKeys = true
+	defer func() {
+		ts.backend.sortJSONKeys = false
+	}()
+
+	payloadChan := make(chan []byte)
+	token := ts.mqttClient.Subscribe("gateway/+/event/up", 0, func(c paho.Client, msg paho.Message) {
+		payloadChan <- msg.Payload()
 	})
+	token.Wait()
+	assert.NoError(token.Error())
+
+	defer func() {
+		token := ts.mqttClient.Unsubscribe("gateway/+/event/up")
+		token.Wait()
+		assert.NoError(token.Error())
+	}()
 
 	id, err := uuid.NewV4()
 	assert.NoError(err)
 
-	execReq := gw.GatewayCommandExecRequest{
-		GatewayId: ts.gatewayID[:],
-		ExecId:    id[:],
-		Command:   "reboot",
-		Environment: map[string]string{
-			"FOO": "bar",
+	uplinkmeta.Set(id[:], uplinkmeta.SourceAddr, "192.168.1.10:1700")
+	uplinkmeta.Set(id[:], "region", "eu868")
+	uplinkmeta.Set(id[:], "antenna", "a")
+
+	uplink := gw.UplinkFrame{
+		PhyPayload: []byte{1, 2, 3, 4},
+		TxInfo: &gw.UplinkTXInfo{
+			Frequency: 868100000,
+		},
+		RxInfo: &gw.UplinkRXInfo{
+			GatewayId: ts.gatewayID[:],
+			UplinkId:  id[:],
 		},
 	}
 
-	b, err := ts.backend.marshal(&execReq)
+	var payloads [][]byte
+	for i := 0; i < 5; i++ {
+		assert.NoError(ts.backend.PublishEvent(ts.gatewayID, "up", id, &uplink))
+		payloads = append(payloads, <-payloadChan)
+	}
+
+	for _, payload := range payloads[1:] {
+		assert.Equal(string(payloads[0]), string(payload))
+	}
+
+	sorted, err := sortJSONKeys(payloads[0])
 	assert.NoError(err)
+	assert.Equal(string(sorted), string(payloads[0]))
 
-	token := ts.mqttClient.Publish("gateway/0807060504030201/command/exec", 0, false, b)
+	var uplinkReceived gw.UplinkFrame
+	assert.NoError(ts.backend.unmarshal(payloads[0], &uplinkReceived))
+	assert.True(proto.Equal(&uplink, &uplinkReceived))
+}
+
+func (ts *MQTTBackendTestSuite) TestAggregateMetrics() {
+	assert := require.New(ts.T())
+
+	ts.backend.aggregateMetricsTopic = "bridge/metrics"
+
+	metricsChan := make(chan aggregateMetrics)
+	token := ts.mqttClient.Subscribe("bridge/metrics", 0, func(c paho.Client, msg paho.Message) {
+		var m aggregateMetrics
+		assert.NoError(json.Unmarshal(msg.Payload(), &m))
+
+		// the loop keeps publishing after the test has completed
+		select {
+		case metricsChan <- m:
+		default:
+		}
+	})
 	token.Wait()
 	assert.NoError(token.Error())
 
-	receivedExecReq := <-gatewayComandExecRequestChan
-	assert.Equal(execReq, receivedExecReq)
+	defer func() {
+		token := ts.mqttClient.Unsubscribe("bridge/metrics")
+		token.Wait()
+		assert.NoError(token.Error())
+	}()
+
+	id, err := uuid.NewV4()
+	assert.NoError(err)
+	uplink := gw.UplinkFrame{
+		PhyPayload: []byte{1, 2, 3, 4},
+		RxInfo: &gw.UplinkRXInfo{
+			GatewayId: ts.gatewayID[:],
+			UplinkId:  id[:],
+		},
+	}
+	assert.NoError(ts.backend.PublishEvent(ts.gatewayID, "up", id, &uplink))
+
+	go ts.backend.aggregateMetricsLoop(100 * time.Millisecond)
+
+	first := <-metricsChan
+	assert.True(first.Uplinks >= 1)
+	assert.Equal(counterValue(mqttEventCounter("up")), first.Uplinks)
+	assert.Equal(counterValue(mqttEventCounter("stats")), first.Stats)
+	assert.Equal(counterValue(mqttEventCounter("ack")), first.Acks)
+	assert.Equal(counterValue(mqttCommandCounter("down")), first.Downlinks)
+	assert.Equal(counterValue(mqttPublishErrorCounter()), first.Errors)
+	assert.WithinDuration(time.Now(), first.Time, time.Second)
+
+	assert.NoError(ts.backend.PublishEvent(ts.gatewayID, "up", id, &uplink))
+
+	second := <-metricsChan
+	assert.Equal(first.Uplinks+1, second.Uplinks)
+	assert.True(second.Time.Sub(first.Time) >= 100*time.Millisecond)
 }
 
-func (ts *MQTTBackendTestSuite) TestRawPacketForwarderCommand() {
+func (ts *MQTTBackendTestSuite) TestKeepaliveAlerts() {
 	assert := require.New(ts.T())
-	rawPacketForwarderCommandChan := make(chan gw.RawPacketForwarderCommand, 1)
-	ts.backend.SetRawPacketForwarderCommandFunc(func(pl gw.RawPacketForwarderCommand) {
-		rawPacketForwarderCommandChan <- pl
+
+	var err error
+	ts.backend.keepaliveTopicTemplate, err = template.New("keepalive").Parse("gateway/{{ .GatewayID }}/alert/keepalive")
+	assert.NoError(err)
+
+	start := time.Now()
+	ts.backend.keepaliveGateways = map[lorawan.EUI64]*keepaliveGateway{
+		ts.gatewayID: {group: "critical", threshold: time.Minute, lastSeen: start},
+	}
+	defer func() {
+		ts.backend.keepaliveGateways = nil
+	}()
+
+	keepaliveChan := make(chan keepaliveMessage, 10)
+	token := ts.mqttClient.Subscribe("gateway/0807060504030201/alert/keepalive", 0, func(c paho.Client, msg paho.Message) {
+		var m keepaliveMessage
+		assert.NoError(json.Unmarshal(msg.Payload(), &m))
+		keepaliveChan <- m
+	})
+	token.Wait()
+	assert.NoError(token.Error())
+
+	defer func() {
+		token := ts.mqttClient.Unsubscribe("gateway/0807060504030201/alert/keepalive")
+		token.Wait()
+		assert.NoError(token.Error())
+	}()
+
+	ts.T().Run("Within threshold", func(t *testing.T) {
+		assert := require.New(t)
+
+		ts.backend.checkKeepalive(start.Add(30 * time.Second))
+
+		select {
+		case m := <-keepaliveChan:
+			assert.Fail("unexpected keepalive message", "%+v", m)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	ts.T().Run("Threshold exceeded", func(t *testing.T) {
+		assert := require.New(t)
+
+		ts.backend.checkKeepalive(start.Add(2 * time.Minute))
+
+		m := <-keepaliveChan
+		assert.Equal(ts.gatewayID, m.GatewayID)
+		assert.Equal("critical", m.Group)
+		assert.Equal(KeepaliveAlert, m.State)
+		assert.Equal("1m0s", m.Threshold)
+		assert.True(m.LastSeen.Equal(start))
+
+		t.Run("Alert is published once", func(t *testing.T) {
+			assert := require.New(t)
+
+			ts.backend.checkKeepalive(start.Add(3 * time.Minute))
+
+			select {
+			case m := <-keepaliveChan:
+				assert.Fail("unexpected keepalive message", "%+v", m)
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	})
+
+	ts.T().Run("Traffic resumes", func(t *testing.T) {
+		assert := require.New(t)
+
+		id, err := uuid.NewV4()
+		assert.NoError(err)
+		assert.NoError(ts.backend.PublishEvent(ts.gatewayID, "stats", id, &gw.GatewayStats{GatewayId: ts.gatewayID[:]}))
+
+		m := <-keepaliveChan
+		assert.Equal(ts.gatewayID, m.GatewayID)
+		assert.Equal(KeepaliveRecovery, m.State)
+		assert.True(m.LastSeen.Equal(start))
+
+		ts.backend.keepaliveMux.Lock()
+		kg := *ts.backend.keepaliveGateways[ts.gatewayID]
+		ts.backend.keepaliveMux.Unlock()
+		assert.False(kg.alerted)
+		assert.True(kg.lastSeen.After(start))
+	})
+}
+
+func (ts *MQTTBackendTestSuite) TestCompression() {
+	assert := require.New(ts.T())
+
+	ts.backend.compression = CompressionGzip
+	defer func() {
+		ts.backend.compression = ""
+		ts.backend.compressionEvents = nil
+	}()
+
+	payloadChan := make(chan []byte)
+	token := ts.mqttClient.Subscribe("gateway/+/event/+", 0, func(c paho.Client, msg paho.Message) {
+		payloadChan <- msg.Payload()
 	})
+	token.Wait()
+	assert.NoError(token.Error())
+
+	defer func() {
+		token := ts.mqttClient.Unsubscribe("gateway/+/event/+")
+		token.Wait()
+		assert.NoError(token.Error())
+	}()
 
 	id, err := uuid.NewV4()
 	assert.NoError(err)
 
-	pl := gw.RawPacketForwarderCommand{
-		GatewayId: ts.gatewayID[:],
-		RawId:     id[:],
-		Payload:   []byte{0x01, 0x02, 0x03, 0x04},
+	messages := map[string]proto.Message{
+		"up": &gw.UplinkFrame{
+			PhyPayload: []byte{1, 2, 3, 4},
+			RxInfo: &gw.UplinkRXInfo{
+				GatewayId: ts.gatewayID[:],
+				UplinkId:  id[:],
+			},
+		},
+		"stats": &gw.GatewayStats{
+			GatewayId:         ts.gatewayID[:],
+			RxPacketsReceived: 10,
+		},
+		"ack": &gw.DownlinkTXAck{
+			GatewayId: ts.gatewayID[:],
+			Token:     1234,
+		},
 	}
 
-	b, err := ts.backend.marshal(&pl)
+	tests := []struct {
+		Name       string
+		Events     []string
+		Compressed map[string]bool
+	}{
+		{
+			Name:       "all events",
+			Compressed: map[string]bool{"up": true, "stats": true, "ack": true},
+		},
+		{
+			Name:       "stats only",
+			Events:     []string{"stats"},
+			Compressed: map[string]bool{"up": false, "stats": true, "ack": false},
+		},
+		{
+			Name:       "uplinks and stats",
+			Events:     []string{"up", "stats"},
+			Compressed: map[string]bool{"up": true, "stats": true, "ack": false},
+		},
+	}
+
+	for _, tst := range tests {
+		ts.T().Run(tst.Name, func(t *testing.T) {
+			ts.backend.compressionEvents = make(map[string]struct{})
+			for _, event := range tst.Events {
+				ts.backend.compressionEvents[event] = struct{}{}
+			}
+
+			for _, event := range []string{"up", "stats", "ack"} {
+				t.Run(event, func(t *testing.T) {
+					assert := require.New(t)
+
+					assert.NoError(ts.backend.PublishEvent(ts.gatewayID, event, id, messages[event]))
+					payload := <-payloadChan
+
+					if tst.Compressed[event] {
+						r, err := gzip.NewReader(bytes.NewReader(payload))
+						assert.NoError(err)
+						payload, err = ioutil.ReadAll(r)
+						assert.NoError(err)
+					}
+
+					msg := proto.Clone(messages[event])
+					msg.Reset()
+					assert.NoError(ts.backend.unmarshal(payload, msg))
+					assert.True(proto.Equal(messages[event], msg))
+				})
+			}
+		})
+	}
+
+	ts.T().Run("topic suffix", func(t *testing.T) {
+		assert := require.New(t)
+
+		ts.backend.compressionEvents = nil
+		ts.backend.compressionTopicSuffix = "/gzip"
+		defer func() {
+			ts.backend.compressionTopicSuffix = ""
+		}()
+
+		topicChan := make(chan string, 1)
+		token := ts.mqttClient.Subscribe("gateway/+/event/+/gzip", 0, func(c paho.Client, msg paho.Message) {
+			topicChan <- msg.Topic()
+		})
+		token.Wait()
+		assert.NoError(token.Error())
+
+		defer func() {
+			token := ts.mqttClient.Unsubscribe("gateway/+/event/+/gzip")
+			token.Wait()
+			assert.NoError(token.Error())
+		}()
+
+		assert.NoError(ts.backend.PublishEvent(ts.gatewayID, "up", id, messages["up"]))
+		assert.Equal("gateway/0807060504030201/event/up/gzip", <-topicChan)
+	})
+}
+
+func (ts *MQTTBackendTestSuite) TestPublishDownlinkTXAck() {
+	assert := require.New(ts.T())
+	id, err := uuid.NewV4()
 	assert.NoError(err)
 
-	token := ts.mqttClient.Publish("gateway/0807060504030201/command/raw", 0, false, b)
+	txAck := gw.DownlinkTXAck{
+		GatewayId:  ts.gatewayID[:],
+		Token:      1234,
+		DownlinkId: id[:],
+		Items: []*gw.DownlinkTXAckItem{
+			{
+				Status: gw.TxAckStatus_OK,
+			},
+		},
+	}
+
+	txAckChan := make(chan gw.DownlinkTXAck)
+	token := ts.mqttClient.Subscribe("gateway/+/event/ack", 0, func(c paho.Client, msg paho.Message) {
+		var pl gw.DownlinkTXAck
+		assert.NoError(ts.backend.unmarshal(msg.Payload(), &pl))
+		txAckChan <- pl
+	})
 	token.Wait()
 	assert.NoError(token.Error())
 
-	received := <-rawPacketForwarderCommandChan
-	assert.Equal(pl, received)
+	assert.NoError(ts.backend.PublishEvent(ts.gatewayID, "ack", id, &txAck))
+
+	txAckReceived := <-txAckChan
+	assert.Equal(txAck, txAckReceived)
+}
+
+func (ts *MQTTBackendTestSuite) TestPublishGatewayConfigAck() {
+	assert := require.New(ts.T())
+
+	ackChan := make(chan []byte)
+	token := ts.mqttClient.Subscribe("gateway/+/event/config_ack", 0, func(c paho.Client, msg paho.Message) {
+		ackChan <- msg.Payload()
+	})
+	token.Wait()
+	assert.NoError(token.Error())
+
+	defer func() {
+		token := ts.mqttClient.Unsubscribe("gateway/+/event/config_ack")
+		token.Wait()
+		assert.NoError(token.Error())
+	}()
+
+	tests := []struct {
+		Name     string
+		Ack      events.GatewayConfigAck
+		Expected string
+	}{
+		{
+			Name: "success",
+			Ack: events.GatewayConfigAck{
+				GatewayId: ts.gatewayID[:],
+				Version:   "1.2.3",
+			},
+			Expected: `{"gatewayID":"CAcGBQQDAgE=","version":"1.2.3","error":""}`,
+		},
+		{
+			Name: "failure",
+			Ack: events.GatewayConfigAck{
+				GatewayId: ts.gatewayID[:],
+				Version:   "1.2.3",
+				Error:     "invalid channel configuration",
+			},
+			Expected: `{"gatewayID":"CAcGBQQDAgE=","version":"1.2.3","error":"invalid channel configuration"}`,
+		},
+	}
+
+	for _, tst := range tests {
+		ts.T().Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			id, err := uuid.NewV4()
+			assert.NoError(err)
+			assert.NoError(ts.backend.PublishEvent(ts.gatewayID, "config_ack", id, &tst.Ack))
+
+			b := <-ackChan
+			assert.JSONEq(tst.Expected, string(b))
+
+			var ack events.GatewayConfigAck
+			assert.NoError(ts.backend.unmarshal(b, &ack))
+			assert.Equal(tst.Ack, ack)
+		})
+	}
+}
+
+func (ts *MQTTBackendTestSuite) TestPublishConnState() {
+	assert := require.New(ts.T())
+
+	// We publish first
+	state := gw.ConnState{
+		GatewayId: ts.gatewayID[:],
+		State:     gw.ConnState_ONLINE,
+	}
+	assert.NoError(ts.backend.PublishState(ts.gatewayID, "conn", &state))
+
+	// And then subscribe to test that the message has been retained
+	stateChan := make(chan gw.ConnState)
+	token := ts.mqttClient.Subscribe("gateway/0807060504030201/state/conn", 0, func(c paho.Client, msg paho.Message) {
+		var pl gw.ConnState
+		assert.NoError(ts.backend.unmarshal(msg.Payload(), &pl))
+		stateChan <- pl
+	})
+	token.Wait()
+	assert.NoError(token.Error())
+
+	assert.Equal(state, <-stateChan)
+
+	token = ts.mqttClient.Unsubscribe("gateway/0807060504030201/state/conn")
+	token.Wait()
+	assert.NoError(token.Error())
+}
+
+func (ts *MQTTBackendTestSuite) TestDownlinkFrameHandler() {
+	assert := require.New(ts.T())
+	downlinkFrameChan := make(chan gw.DownlinkFrame, 1)
+	ts.backend.SetDownlinkFrameFunc(func(pl gw.DownlinkFrame) {
+		downlinkFrameChan <- pl
+	})
+
+	downlink := gw.DownlinkFrame{
+		Items: []*gw.DownlinkFrameItem{
+			{
+				PhyPayload: []byte{1, 2, 3, 4},
+			},
+		},
+	}
+
+	b, err := ts.backend.marshal(&downlink)
+	assert.NoError(err)
+
+	token := ts.mqttClient.Publish("gateway/0807060504030201/command/down", 0, false, b)
+	token.Wait()
+	assert.NoError(token.Error())
+
+	receivedDownlink := <-downlinkFrameChan
+	assert.Equal(downlink, receivedDownlink)
+}
+
+func (ts *MQTTBackendTestSuite) TestReload() {
+	assert := require.New(ts.T())
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 9}
+	downlinkFrameChan := make(chan gw.DownlinkFrame, 1)
+	ts.backend.SetDownlinkFrameFunc(func(pl gw.DownlinkFrame) {
+		downlinkFrameChan <- pl
+	})
+
+	assert.NoError(ts.backend.SetGatewaySubscription(true, gatewayID))
+	time.Sleep(200 * time.Millisecond)
+
+	var conf config.Config
+	conf.Integration.MQTT.Auth.Type = "generic"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.StateTopicTemplate = "gateway/{{ .GatewayID }}/state/{{ .StateType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/downlink/#"
+	assert.NoError(ts.backend.Reload(conf))
+
+	defer func() {
+		conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+		assert.NoError(ts.backend.Reload(conf))
+		assert.NoError(ts.backend.SetGatewaySubscription(false, gatewayID))
+		time.Sleep(300 * time.Millisecond)
+
+		// wait until the subscribe loop has completed the re-subscriptions
+		ts.backend.gatewaysSubscribedMux.Lock()
+		ts.backend.gatewaysSubscribedMux.Unlock()
+	}()
+
+	ts.T().Run("Invalid template", func(t *testing.T) {
+		assert := require.New(t)
+
+		invalid := conf
+		invalid.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID"
+		assert.Error(ts.backend.Reload(invalid))
+
+		topic, err := ts.backend.getCommandTopic(gatewayID)
+		assert.NoError(err)
+		assert.Equal("gateway/0102030405060709/downlink/#", topic)
+	})
+
+	// wait for the subscribe loop to re-subscribe the gateway
+	time.Sleep(200 * time.Millisecond)
+
+	downlink := gw.DownlinkFrame{
+		Items: []*gw.DownlinkFrameItem{
+			{
+				PhyPayload: []byte{1, 2, 3, 4},
+			},
+		},
+	}
+	b, err := ts.backend.marshal(&downlink)
+	assert.NoError(err)
+
+	// the old command topic has been unsubscribed
+	token := ts.mqttClient.Publish("gateway/0102030405060709/command/down", 0, false, b)
+	token.Wait()
+	assert.NoError(token.Error())
+
+	select {
+	case <-downlinkFrameChan:
+		assert.Fail("unexpected downlink on old command topic")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	token = ts.mqttClient.Publish("gateway/0102030405060709/downlink/down", 0, false, b)
+	token.Wait()
+	assert.NoError(token.Error())
+
+	assert.Equal(downlink, <-downlinkFrameChan)
+}
+
+func (ts *MQTTBackendTestSuite) TestCompressedDownlinkFrameHandler() {
+	assert := require.New(ts.T())
+	downlinkFrameChan := make(chan gw.DownlinkFrame, 1)
+	ts.backend.SetDownlinkFrameFunc(func(pl gw.DownlinkFrame) {
+		downlinkFrameChan <- pl
+	})
+
+	downlink := gw.DownlinkFrame{
+		Items: []*gw.DownlinkFrameItem{
+			{
+				PhyPayload: []byte{1, 2, 3, 4},
+			},
+		},
+	}
+
+	b, err := ts.backend.marshal(&downlink)
+	assert.NoError(err)
+	b, err = gzipCompress(b)
+	assert.NoError(err)
+
+	token := ts.mqttClient.Publish("gateway/0807060504030201/command/down", 0, false, b)
+	token.Wait()
+	assert.NoError(token.Error())
+
+	receivedDownlink := <-downlinkFrameChan
+	assert.Equal(downlink, receivedDownlink)
+}
+
+func (ts *MQTTBackendTestSuite) TestGatewayConfigHandler() {
+	assert := require.New(ts.T())
+	gatewayConfigurationChan := make(chan gw.GatewayConfiguration, 1)
+	ts.backend.SetGatewayConfigurationFunc(func(pl gw.GatewayConfiguration) {
+		gatewayConfigurationChan <- pl
+	})
+
+	config := gw.GatewayConfiguration{
+		GatewayId: ts.gatewayID[:],
+		Version:   "123",
+	}
+
+	b, err := ts.backend.marshal(&config)
+	assert.NoError(err)
+
+	token := ts.mqttClient.Publish("gateway/0807060504030201/command/config", 0, false, b)
+	token.Wait()
+	assert.NoError(token.Error())
+
+	receivedConfig := <-gatewayConfigurationChan
+	assert.Equal(config, receivedConfig)
+}
+
+func (ts *MQTTBackendTestSuite) TestGatewayConfigHandlerLastError() {
+	assert := require.New(ts.T())
+
+	token := ts.mqttClient.Publish("gateway/0807060504030201/command/config", 0, false, []byte("invalid"))
+	token.Wait()
+	assert.NoError(token.Error())
+
+	time.Sleep(100 * time.Millisecond)
+
+	lastErr, ok := status.GetLastErrors()[status.Config]
+	assert.True(ok)
+	assert.Contains(lastErr.Error, "unmarshal gateway configuration error")
+}
+
+func (ts *MQTTBackendTestSuite) TestGatewayCommandExecRequest() {
+	assert := require.New(ts.T())
+	gatewayComandExecRequestChan := make(chan gw.GatewayCommandExecRequest, 1)
+	ts.backend.SetGatewayCommandExecRequestFunc(func(pl gw.GatewayCommandExecRequest) {
+		gatewayComandExecRequestChan <- pl
+	})
+
+	id, err := uuid.NewV4()
+	assert.NoError(err)
+
+	execReq := gw.GatewayCommandExecRequest{
+		GatewayId: ts.gatewayID[:],
+		ExecId:    id[:],
+		Command:   "reboot",
+		Environment: map[string]string{
+			"FOO": "bar",
+		},
+	}
+
+	b, err := ts.backend.marshal(&execReq)
+	assert.NoError(err)
+
+	token := ts.mqttClient.Publish("gateway/0807060504030201/command/exec", 0, false, b)
+	token.Wait()
+	assert.NoError(token.Error())
+
+	receivedExecReq := <-gatewayComandExecRequestChan
+	assert.Equal(execReq, receivedExecReq)
+}
+
+func (ts *MQTTBackendTestSuite) TestRawPacketForwarderCommand() {
+	assert := require.New(ts.T())
+	rawPacketForwarderCommandChan := make(chan gw.RawPacketForwarderCommand, 1)
+	ts.backend.SetRawPacketForwarderCommandFunc(func(pl gw.RawPacketForwarderCommand) {
+		rawPacketForwarderCommandChan <- pl
+	})
+
+	id, err := uuid.NewV4()
+	assert.NoError(err)
+
+	pl := gw.RawPacketForwarderCommand{
+		GatewayId: ts.gatewayID[:],
+		RawId:     id[:],
+		Payload:   []byte{0x01, 0x02, 0x03, 0x04},
+	}
+
+	b, err := ts.backend.marshal(&pl)
+	assert.NoError(err)
+
+	token := ts.mqttClient.Publish("gateway/0807060504030201/command/raw", 0, false, b)
+	token.Wait()
+	assert.NoError(token.Error())
+
+	received := <-rawPacketForwarderCommandChan
+	assert.Equal(pl, received)
+}
+
+func (ts *MQTTBackendTestSuite) TestTopicProbe() {
+	ts.T().Run("Allowed", func(t *testing.T) {
+		assert := require.New(t)
+
+		probeChan := make(chan string, 10)
+		eventChan := make(chan string, 10)
+		subscriptions := map[string]chan string{
+			"gateway/+/event/probe":               probeChan,
+			"gateway/+/state/probe":               probeChan,
+			"gateway/0807060504030201/event/up":   eventChan,
+			"gateway/0807060504030201/state/conn": eventChan,
+		}
+		for topic, topicChan := range subscriptions {
+			topicChan := topicChan
+			token := ts.mqttClient.Subscribe(topic, 0, func(c paho.Client, msg paho.Message) {
+				if !msg.Retained() {
+					topicChan <- msg.Topic()
+				}
+			})
+			token.Wait()
+			assert.NoError(token.Error())
+		}
+		defer func() {
+			for topic := range subscriptions {
+				token := ts.mqttClient.Unsubscribe(topic)
+				token.Wait()
+				assert.NoError(token.Error())
+			}
+		}()
+
+		assert.NoError(ts.backend.probeTopics())
+
+		// the dedicated probe topics are used
+		probed := map[string]struct{}{}
+		for i := 0; i < 2; i++ {
+			probed[<-probeChan] = struct{}{}
+		}
+		assert.Equal(map[string]struct{}{
+			"gateway/0807060504030201/event/probe": {},
+			"gateway/0807060504030201/state/probe": {},
+		}, probed)
+
+		// the actual event and state topics are not used
+		select {
+		case topic := <-eventChan:
+			t.Fatalf("unexpected probe on topic %s", topic)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	ts.T().Run("Denied", func(t *testing.T) {
+		assert := require.New(t)
+
+		b := Backend{
+			auth:               ts.backend.auth,
+			eventTopicTemplate: ts.backend.eventTopicTemplate,
+			stateTopicTemplate: ts.backend.stateTopicTemplate,
+			conn: &denyTopicClient{
+				Client: ts.backend.conn,
+				topic:  "gateway/0807060504030201/event/probe",
+			},
+		}
+
+		assert.EqualError(b.probeTopics(), "topic probe failed for: gateway/0807060504030201/event/probe")
+	})
+}
+
+// denyTopicClient wraps a paho.Client and fails the publish to the denied
+// topic.
+type denyTopicClient struct {
+	paho.Client
+	topic string
+}
+
+func (c *denyTopicClient) Publish(topic string, qos byte, retained bool, payload interface{}) paho.Token {
+	if topic == c.topic {
+		return &errorToken{err: errors.New("not authorized")}
+	}
+	return c.Client.Publish(topic, qos, retained, payload)
+}
+
+// errorToken implements a completed paho.Token with the given error.
+type errorToken struct {
+	err error
+}
+
+func (t *errorToken) Wait() bool {
+	return true
+}
+
+func (t *errorToken) WaitTimeout(time.Duration) bool {
+	return true
+}
+
+func (t *errorToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+func (t *errorToken) Error() error {
+	return t.err
+}
+
+// pendingToken implements a paho.Token which never completes.
+type pendingToken struct {
+	errorToken
+}
+
+func (t *pendingToken) Wait() bool {
+	select {}
+}
+
+func (t *pendingToken) WaitTimeout(d time.Duration) bool {
+	time.Sleep(d)
+	return false
+}
+
+// pendingClient implements a paho.Client of which the publish and
+// (un)subscribe operations never complete, e.g. a half-open connection.
+type pendingClient struct {
+	paho.Client
+}
+
+func (c *pendingClient) Publish(topic string, qos byte, retained bool, payload interface{}) paho.Token {
+	return &pendingToken{}
+}
+
+func (c *pendingClient) Subscribe(topic string, qos byte, callback paho.MessageHandler) paho.Token {
+	return &pendingToken{}
+}
+
+func (c *pendingClient) SubscribeMultiple(filters map[string]byte, callback paho.MessageHandler) paho.Token {
+	return &pendingToken{}
+}
+
+func (c *pendingClient) Unsubscribe(topics ...string) paho.Token {
+	return &pendingToken{}
+}
+
+func TestTimeout(t *testing.T) {
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	commandTopicTemplate, err := template.New("command").Parse("gateway/{{ .GatewayID }}/command/#")
+	require.NoError(t, err)
+	stateTopicTemplate, err := template.New("state").Parse("gateway/{{ .GatewayID }}/state/{{ .StateType }}")
+	require.NoError(t, err)
+	marshal, _, err := newMarshaler("json", "", "")
+	require.NoError(t, err)
+
+	b := Backend{
+		conn:                 &pendingClient{},
+		commandTopicTemplate: commandTopicTemplate,
+		stateTopicTemplate:   stateTopicTemplate,
+		marshal:              marshal,
+		publishTimeout:       10 * time.Millisecond,
+		subscribeTimeout:     10 * time.Millisecond,
+	}
+
+	t.Run("Completed token", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(waitToken(&errorToken{}, time.Second))
+		assert.EqualError(waitToken(&errorToken{err: errors.New("boom")}, time.Second), "boom")
+		assert.EqualError(waitToken(&errorToken{err: errors.New("boom")}, 0), "boom")
+	})
+
+	t.Run("Publish", func(t *testing.T) {
+		assert := require.New(t)
+
+		err := b.PublishState(gatewayID, "conn", &gw.ConnState{GatewayId: gatewayID[:]})
+		assert.True(errors.Is(err, ErrTimeout))
+	})
+
+	t.Run("Subscribe", func(t *testing.T) {
+		assert := require.New(t)
+
+		err := b.subscribeGateway(gatewayID)
+		assert.True(errors.Is(err, ErrTimeout))
+	})
+
+	t.Run("Subscribe batch", func(t *testing.T) {
+		assert := require.New(t)
+
+		b.subscribeBatchSize = 2
+		defer func() {
+			b.subscribeBatchSize = 0
+		}()
+
+		errs := b.subscribeGateways([]lorawan.EUI64{gatewayID})
+		assert.True(errors.Is(errs[gatewayID], ErrTimeout))
+	})
+
+	t.Run("Unsubscribe", func(t *testing.T) {
+		assert := require.New(t)
+
+		err := b.unsubscribeGateway(gatewayID)
+		assert.True(errors.Is(err, ErrTimeout))
+	})
+}
+
+func TestSubscribeDenied(t *testing.T) {
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	commandTopicTemplate, err := template.New("command").Parse("gateway/{{ .GatewayID }}/command/#")
+	require.NoError(t, err)
+
+	t.Run("Denied by broker", func(t *testing.T) {
+		assert := require.New(t)
+
+		b := Backend{
+			commandTopicTemplate:  commandTopicTemplate,
+			subscribeRetries:      make(map[lorawan.EUI64]subscribeRetry),
+			subscribeRetryInitial: time.Second,
+			conn: &denySubscribeClient{
+				topic: "gateway/0102030405060708/command/#",
+			},
+		}
+
+		err := b.subscribeGateway(gatewayID)
+		assert.True(errors.Is(err, ErrSubscribeDenied))
+
+		// a permanent failure is not retried
+		b.scheduleSubscribeRetry(gatewayID, err)
+		assert.True(b.subscribeRetries[gatewayID].stopped)
+		assert.Equal(1, b.subscribeRetries[gatewayID].failures)
+	})
+
+	t.Run("Max attempts", func(t *testing.T) {
+		assert := require.New(t)
+
+		b := Backend{
+			subscribeRetries:       make(map[lorawan.EUI64]subscribeRetry),
+			subscribeRetryInitial:  time.Second,
+			subscribeRetryAttempts: 2,
+		}
+
+		b.scheduleSubscribeRetry(gatewayID, errors.New("timeout"))
+		assert.False(b.subscribeRetries[gatewayID].stopped)
+
+		b.scheduleSubscribeRetry(gatewayID, errors.New("timeout"))
+		assert.True(b.subscribeRetries[gatewayID].stopped)
+	})
+}
+
+func TestSubscribeGatewayBatches(t *testing.T) {
+	commandTopicTemplate, err := template.New("command").Parse("gateway/{{ .GatewayID }}/command/#")
+	require.NoError(t, err)
+
+	var gatewayIDs []lorawan.EUI64
+	for i := 0; i < 5; i++ {
+		gatewayIDs = append(gatewayIDs, lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, byte(i)})
+	}
+
+	tests := []struct {
+		Name            string
+		BatchSize       int
+		DeniedTopic     string
+		ExpectedBatches []int
+		ExpectedErrors  []lorawan.EUI64
+	}{
+		{
+			Name:            "batch size 2",
+			BatchSize:       2,
+			ExpectedBatches: []int{2, 2, 1},
+		},
+		{
+			Name:            "batch size exceeds gateways",
+			BatchSize:       10,
+			ExpectedBatches: []int{5},
+		},
+		{
+			Name:            "denied topic within batch",
+			BatchSize:       3,
+			DeniedTopic:     "gateway/0102030405060701/command/#",
+			ExpectedBatches: []int{3, 2},
+			ExpectedErrors:  []lorawan.EUI64{gatewayIDs[1]},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			conn := denySubscribeClient{
+				topic: tst.DeniedTopic,
+			}
+			b := Backend{
+				commandTopicTemplate: commandTopicTemplate,
+				subscribeBatchSize:   tst.BatchSize,
+				conn:                 &conn,
+			}
+
+			errs := b.subscribeGateways(gatewayIDs)
+			assert.Equal(tst.ExpectedBatches, conn.batches)
+			assert.Len(errs, len(tst.ExpectedErrors))
+			for _, gatewayID := range tst.ExpectedErrors {
+				assert.True(errors.Is(errs[gatewayID], ErrSubscribeDenied))
+			}
+		})
+	}
+}
+
+// denySubscribeClient implements a paho.Client which denies the
+// subscription to the given topic, like a broker would do by its ACL. It
+// records the number of topics of each SubscribeMultiple call.
+type denySubscribeClient struct {
+	paho.Client
+	topic   string
+	batches []int
+}
+
+func (c *denySubscribeClient) SubscribeMultiple(filters map[string]byte, callback paho.MessageHandler) paho.Token {
+	c.batches = append(c.batches, len(filters))
+
+	result := make(map[string]byte)
+	for topic, qos := range filters {
+		result[topic] = qos
+		if topic == c.topic {
+			result[topic] = 0x80
+		}
+	}
+	return &subscribeResultToken{result: result}
+}
+
+func (c *denySubscribeClient) Subscribe(topic string, qos byte, callback paho.MessageHandler) paho.Token {
+	result := map[string]byte{topic: qos}
+	if topic == c.topic {
+		result[topic] = 0x80
+	}
+	return &subscribeResultToken{result: result}
+}
+
+// subscribeResultToken implements a completed paho.Token with the given
+// SUBACK return codes.
+type subscribeResultToken struct {
+	errorToken
+	result map[string]byte
+}
+
+func (t *subscribeResultToken) Result() map[string]byte {
+	return t.result
+}
+
+// recordQOSClient implements a paho.Client which records the QoS of each
+// subscribed topic.
+type recordQOSClient struct {
+	paho.Client
+
+	mux    sync.Mutex
+	qos    []byte
+	topics []string
+}
+
+func (c *recordQOSClient) IsConnected() bool {
+	return true
+}
+
+func (c *recordQOSClient) Subscribe(topic string, qos byte, callback paho.MessageHandler) paho.Token {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.qos = append(c.qos, qos)
+	c.topics = append(c.topics, topic)
+	return &subscribeResultToken{result: map[string]byte{topic: qos}}
+}
+
+func (c *recordQOSClient) SubscribeMultiple(filters map[string]byte, callback paho.MessageHandler) paho.Token {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for topic, qos := range filters {
+		c.qos = append(c.qos, qos)
+		c.topics = append(c.topics, topic)
+	}
+	return &subscribeResultToken{result: filters}
+}
+
+func (c *recordQOSClient) recorded() []byte {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return append([]byte(nil), c.qos...)
+}
+
+func (c *recordQOSClient) recordedTopics() []string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return append([]string(nil), c.topics...)
+}
+
+func TestResubscribeCommandTopic(t *testing.T) {
+	commandTopicTemplate, err := template.New("command").Parse("eu868/gw/{{ .GatewayIDUpper }}/down/#")
+	require.NoError(t, err)
+
+	gatewayID := lorawan.EUI64{0xaa, 2, 3, 4, 5, 6, 7, 8}
+
+	for _, batchSize := range []int{0, 2} {
+		t.Run(fmt.Sprintf("Batch size %d", batchSize), func(t *testing.T) {
+			assert := require.New(t)
+
+			conn := recordQOSClient{}
+			b := Backend{
+				commandTopicTemplate: commandTopicTemplate,
+				subscribeBatchSize:   batchSize,
+				conn:                 &conn,
+				gateways:             map[lorawan.EUI64]struct{}{gatewayID: {}},
+				gatewaysSubscribed:   make(map[lorawan.EUI64]struct{}),
+				subscribeRetries:     make(map[lorawan.EUI64]subscribeRetry),
+			}
+
+			go b.subscribeLoop()
+			defer func() {
+				b.connMux.Lock()
+				b.connClosed = true
+				b.connMux.Unlock()
+			}()
+
+			waitTopics := func(n int) {
+				assert.Eventually(func() bool {
+					return len(conn.recordedTopics()) == n
+				}, time.Second, 20*time.Millisecond)
+			}
+
+			waitTopics(1)
+
+			// re-subscribe after a reconnect
+			b.onConnected(nil)
+			waitTopics(2)
+
+			assert.Equal([]string{
+				"eu868/gw/AA02030405060708/down/#",
+				"eu868/gw/AA02030405060708/down/#",
+			}, conn.recordedTopics())
+		})
+	}
+}
+
+func TestDownlinkQOS(t *testing.T) {
+	commandTopicTemplate, err := template.New("command").Parse("gateway/{{ .GatewayID }}/command/#")
+	require.NoError(t, err)
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	for _, batchSize := range []int{0, 2} {
+		t.Run(fmt.Sprintf("Batch size %d", batchSize), func(t *testing.T) {
+			assert := require.New(t)
+
+			conn := recordQOSClient{}
+			b := Backend{
+				commandTopicTemplate: commandTopicTemplate,
+				subscribeBatchSize:   batchSize,
+				conn:                 &conn,
+				qos:                  0,
+				downlinkQOS:          1,
+				gateways:             map[lorawan.EUI64]struct{}{gatewayID: {}},
+				gatewaysSubscribed:   make(map[lorawan.EUI64]struct{}),
+				subscribeRetries:     make(map[lorawan.EUI64]subscribeRetry),
+			}
+
+			go b.subscribeLoop()
+			defer func() {
+				b.connMux.Lock()
+				b.connClosed = true
+				b.connMux.Unlock()
+			}()
+
+			waitSubscribed := func() {
+				for i := 0; i < 50; i++ {
+					b.gatewaysSubscribedMux.Lock()
+					_, ok := b.gatewaysSubscribed[gatewayID]
+					b.gatewaysSubscribedMux.Unlock()
+					if ok {
+						return
+					}
+					time.Sleep(20 * time.Millisecond)
+				}
+				assert.Fail("gateway was not subscribed")
+			}
+
+			waitSubscribed()
+			assert.Equal([]byte{1}, conn.recorded())
+
+			// re-subscribe after a reconnect
+			b.onConnected(nil)
+			waitSubscribed()
+			assert.Equal([]byte{1, 1}, conn.recorded())
+		})
+	}
+
+	t.Run("Config", func(t *testing.T) {
+		var conf config.Config
+		conf.Integration.Marshaler = "json"
+		conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+		conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+		conf.Integration.MQTT.Auth.Type = "generic"
+		setTestMQTTServer(&conf)
+		conf.Integration.MQTT.Auth.Generic.QOS = 2
+
+		tests := []struct {
+			Name          string
+			DownlinkQOS   int
+			ExpectedQOS   uint8
+			ExpectedError string
+		}{
+			{Name: "default qos", DownlinkQOS: -1, ExpectedQOS: 2},
+			{Name: "qos 0", DownlinkQOS: 0, ExpectedQOS: 0},
+			{Name: "qos 1", DownlinkQOS: 1, ExpectedQOS: 1},
+			{Name: "invalid qos", DownlinkQOS: 3, ExpectedError: "integration/mqtt: invalid downlink qos 3, must be 0, 1 or 2 (or -1 to use the default qos)"},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.Name, func(t *testing.T) {
+				assert := require.New(t)
+
+				conf := conf
+				conf.Integration.MQTT.DownlinkQOS = tst.DownlinkQOS
+
+				b, err := NewBackend(conf)
+				if tst.ExpectedError != "" {
+					assert.EqualError(err, tst.ExpectedError)
+					return
+				}
+				assert.NoError(err)
+				assert.Equal(tst.ExpectedQOS, b.downlinkQOS)
+			})
+		}
+	})
+}
+
+func TestGatewayTopicContext(t *testing.T) {
+	assert := require.New(t)
+
+	ctx := newGatewayTopicContext(lorawan.EUI64{0xaa, 0xbb, 0xcc, 0xdd, 0x01, 0x02, 0x03, 0x04})
+	assert.Equal(gatewayTopicContext{
+		GatewayID:       lorawan.EUI64{0xaa, 0xbb, 0xcc, 0xdd, 0x01, 0x02, 0x03, 0x04},
+		GatewayIDString: "aabbccdd01020304",
+		GatewayIDUpper:  "AABBCCDD01020304",
+		GatewayIDBytes:  []byte{0xaa, 0xbb, 0xcc, 0xdd, 0x01, 0x02, 0x03, 0x04},
+		MAC:             lorawan.EUI64{0xaa, 0xbb, 0xcc, 0xdd, 0x01, 0x02, 0x03, 0x04},
+	}, ctx)
+
+	tmpl, err := template.New("command").Parse("eu868/gw/{{ .GatewayIDUpper }}/command/#")
+	assert.NoError(err)
+
+	b := Backend{commandTopicTemplate: tmpl}
+	topic, err := b.getCommandTopic(ctx.GatewayID)
+	assert.NoError(err)
+	assert.Equal("eu868/gw/AABBCCDD01020304/command/#", topic)
+}
+
+func TestConnStateTopic(t *testing.T) {
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.StateTopicTemplate = "gateway/{{ .GatewayID }}/state/{{ .StateType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.Auth.Type = "generic"
+	setTestMQTTServer(&conf)
+
+	t.Run("State topic", func(t *testing.T) {
+		assert := require.New(t)
+
+		b, err := NewBackend(conf)
+		assert.NoError(err)
+
+		topic, err := b.getStateTopic(gatewayID, "conn")
+		assert.NoError(err)
+		assert.Equal("gateway/0102030405060708/state/conn", topic)
+	})
+
+	t.Run("Conn state topic", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := conf
+		conf.Integration.MQTT.GatewayConnStateTopicTemplate = "gateway/{{ .GatewayID }}/presence"
+		b, err := NewBackend(conf)
+		assert.NoError(err)
+
+		topic, err := b.getStateTopic(gatewayID, "conn")
+		assert.NoError(err)
+		assert.Equal("gateway/0102030405060708/presence", topic)
+
+		// the other states are not affected
+		topic, err = b.getStateTopic(gatewayID, "config")
+		assert.NoError(err)
+		assert.Equal("gateway/0102030405060708/state/config", topic)
+	})
+
+	t.Run("Conn state topic without state topic", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := conf
+		conf.Integration.MQTT.StateTopicTemplate = ""
+		conf.Integration.MQTT.GatewayConnStateTopicTemplate = "gateway/{{ .GatewayID }}/presence"
+		b, err := NewBackend(conf)
+		assert.NoError(err)
+
+		assert.NotNil(b.getStateTopicTemplate("conn"))
+		assert.Nil(b.getStateTopicTemplate("config"))
+	})
+
+	t.Run("Invalid template", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := conf
+		conf.Integration.MQTT.GatewayConnStateTopicTemplate = "gateway/{{ .GatewayID"
+		_, err := NewBackend(conf)
+		assert.Error(err)
+	})
+}
+
+func TestEventTopic(t *testing.T) {
+	gatewayID := lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}
+
+	tests := []struct {
+		Name     string
+		Template string
+		Event    string
+		Message  proto.Message
+		Expected string
+	}{
+		{
+			Name:     "uplink data-rate and frequency",
+			Template: "gateway/{{ .GatewayID }}/event/{{ .EventType }}/{{ .DataRate }}/{{ .Frequency }}",
+			Event:    "up",
+			Message: &gw.UplinkFrame{
+				TxInfo: &gw.UplinkTXInfo{
+					Frequency:  868100000,
+					Modulation: common.Modulation_LORA,
+					ModulationInfo: &gw.UplinkTXInfo_LoraModulationInfo{
+						LoraModulationInfo: &gw.LoRaModulationInfo{
+							SpreadingFactor: 7,
+							Bandwidth:       125,
+						},
+					},
+				},
+			},
+			Expected: "gateway/0807060504030201/event/up/SF7BW125/868100000",
+		},
+		{
+			Name:     "gateway id variables",
+			Template: "gateway/{{ .GatewayIDString }}/{{ .GatewayIDUpper }}/{{ .MAC }}/{{ index .GatewayIDBytes 0 }}/{{ .EventType }}",
+			Event:    "stats",
+			Expected: "gateway/0807060504030201/0807060504030201/0807060504030201/8/stats",
+		},
+		{
+			Name:     "uplink fsk modulation",
+			Template: "gateway/{{ .GatewayID }}/event/{{ .EventType }}/{{ .Modulation }}/{{ .DataRate }}",
+			Event:    "up",
+			Message: &gw.UplinkFrame{
+				TxInfo: &gw.UplinkTXInfo{
+					Frequency:  868800000,
+					Modulation: common.Modulation_FSK,
+					ModulationInfo: &gw.UplinkTXInfo_FskModulationInfo{
+						FskModulationInfo: &gw.FSKModulationInfo{
+							Datarate: 50000,
+						},
+					},
+				},
+			},
+			Expected: "gateway/0807060504030201/event/up/FSK/50000",
+		},
+		{
+			Name:     "coalesced uplink frame-set",
+			Template: "gateway/{{ .GatewayID }}/event/{{ .EventType }}/{{ .DataRate }}",
+			Event:    "up_set",
+			Message: &gw.UplinkFrameSet{
+				TxInfo: &gw.UplinkTXInfo{
+					Frequency:  868100000,
+					Modulation: common.Modulation_LORA,
+					ModulationInfo: &gw.UplinkTXInfo_LoraModulationInfo{
+						LoraModulationInfo: &gw.LoRaModulationInfo{
+							SpreadingFactor: 12,
+							Bandwidth:       125,
+						},
+					},
+				},
+			},
+			Expected: "gateway/0807060504030201/event/up_set/SF12BW125",
+		},
+		{
+			Name:     "stats without uplink variables",
+			Template: "gateway/{{ .GatewayID }}/event/{{ .EventType }}{{ if .DataRate }}/{{ .DataRate }}{{ end }}",
+			Event:    "stats",
+			Message:  &gw.GatewayStats{},
+			Expected: "gateway/0807060504030201/event/stats",
+		},
+		{
+			Name:     "config ack",
+			Template: "gateway/{{ .GatewayID }}/event/{{ .EventType }}",
+			Event:    "config_ack",
+			Message:  &events.GatewayConfigAck{},
+			Expected: "gateway/0807060504030201/event/config_ack",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			var err error
+			var b Backend
+			b.eventTopicTemplate, err = template.New("event").Parse(tst.Template)
+			assert.NoError(err)
+
+			topic, err := b.getEventTopic(gatewayID, tst.Event, tst.Message)
+			assert.NoError(err)
+			assert.Equal(tst.Expected, topic)
+		})
+	}
+}
+
+func TestEmptyTopic(t *testing.T) {
+	gatewayID := lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}
+
+	t.Run("Event topic", func(t *testing.T) {
+		assert := require.New(t)
+
+		var err error
+		var b Backend
+		b.eventTopicTemplate, err = template.New("event").Parse(`{{ if eq .EventType "up" }}gateway/{{ .GatewayID }}/event/up{{ end }}`)
+		assert.NoError(err)
+
+		topic, err := b.getEventTopic(gatewayID, "up", nil)
+		assert.NoError(err)
+		assert.Equal("gateway/0807060504030201/event/up", topic)
+
+		_, err = b.getEventTopic(gatewayID, "stats", nil)
+		assert.True(errors.Is(err, ErrEmptyTopic))
+	})
+
+	t.Run("State topic", func(t *testing.T) {
+		assert := require.New(t)
+
+		var err error
+		var b Backend
+		b.stateTopicTemplate, err = template.New("state").Parse(`{{ .Foo }}`)
+		assert.NoError(err)
+
+		_, err = b.getStateTopic(gatewayID, "conn")
+		assert.Error(err)
+	})
+
+	t.Run("Command topic", func(t *testing.T) {
+		assert := require.New(t)
+
+		var err error
+		var b Backend
+		b.commandTopicTemplate, err = template.New("command").Parse(`{{ if false }}gateway/{{ .GatewayID }}/command/#{{ end }}`)
+		assert.NoError(err)
+
+		_, err = b.getCommandTopic(gatewayID)
+		assert.True(errors.Is(err, ErrEmptyTopic))
+	})
+
+	t.Run("Startup validation", func(t *testing.T) {
+		assert := require.New(t)
+
+		var conf config.Config
+		conf.Integration.Marshaler = "json"
+		conf.Integration.MQTT.EventTopicTemplate = `{{ if eq .EventType "up" }}gateway/{{ .GatewayID }}/event/up{{ end }}`
+		conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+		conf.Integration.MQTT.Auth.Type = "generic"
+		setTestMQTTServer(&conf)
+
+		_, err := NewBackend(conf)
+		assert.True(errors.Is(err, ErrEmptyTopic))
+	})
+}
+
+func TestTopicTemplateEnv(t *testing.T) {
+	gatewayID := lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}
+
+	os.Setenv("CGB_TEST_REGION", "eu868")
+	defer os.Unsetenv("CGB_TEST_REGION")
+
+	tests := []struct {
+		Name          string
+		Template      string
+		EnvStrict     bool
+		Expected      string
+		ExpectedError string
+	}{
+		{
+			Name:     "set",
+			Template: `{{ env "CGB_TEST_REGION" }}/gateway/{{ .GatewayID }}/event/{{ .EventType }}`,
+			Expected: "eu868/gateway/0807060504030201/event/up",
+		},
+		{
+			Name:     "not set",
+			Template: `gateway{{ env "CGB_TEST_NOT_SET" }}/{{ .GatewayID }}/event/{{ .EventType }}`,
+			Expected: "gateway/0807060504030201/event/up",
+		},
+		{
+			Name:          "not set strict",
+			Template:      `gateway{{ env "CGB_TEST_NOT_SET" }}/{{ .GatewayID }}/event/{{ .EventType }}`,
+			EnvStrict:     true,
+			ExpectedError: "environment variable CGB_TEST_NOT_SET is not set",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			var err error
+			var b Backend
+			b.eventTopicTemplate, err = template.New("event").Funcs(newTemplateFuncs(tst.EnvStrict)).Parse(tst.Template)
+			assert.NoError(err)
+
+			topic, err := b.getEventTopic(gatewayID, "up", nil)
+			if tst.ExpectedError != "" {
+				assert.Error(err)
+				assert.Contains(err.Error(), tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tst.Expected, topic)
+		})
+	}
+
+	t.Run("Startup validation", func(t *testing.T) {
+		assert := require.New(t)
+
+		var conf config.Config
+		conf.Integration.Marshaler = "json"
+		conf.Integration.MQTT.EventTopicTemplate = `{{ env "CGB_TEST_NOT_SET" }}/gateway/{{ .GatewayID }}/event/{{ .EventType }}`
+		conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+		conf.Integration.MQTT.TopicTemplateEnvStrict = true
+		conf.Integration.MQTT.Auth.Type = "generic"
+		setTestMQTTServer(&conf)
+
+		_, err := NewBackend(conf)
+		assert.Error(err)
+
+		conf.Integration.MQTT.TopicTemplateEnvStrict = false
+		_, err = NewBackend(conf)
+		assert.NoError(err)
+	})
+}
+
+func TestSortJSONKeys(t *testing.T) {
+	tests := []struct {
+		Name     string
+		JSON     string
+		Expected string
+	}{
+		{
+			Name:     "nested objects",
+			JSON:     `{"rxInfo":{"rssi":-50,"gatewayID":"AQID"},"phyPayload":"AQID","txInfo":{"frequency":868100000}}`,
+			Expected: `{"phyPayload":"AQID","rxInfo":{"gatewayID":"AQID","rssi":-50},"txInfo":{"frequency":868100000}}`,
+		},
+		{
+			Name:     "objects in array",
+			JSON:     `{"rxInfo":[{"rssi":-50,"loRaSNR":5.5},{"rssi":-60,"loRaSNR":1}]}`,
+			Expected: `{"rxInfo":[{"loRaSNR":5.5,"rssi":-50},{"loRaSNR":1,"rssi":-60}]}`,
+		},
+		{
+			Name:     "numbers and html characters are kept as-is",
+			JSON:     `{"timeSinceGPSEpoch":1234567890123456789,"error":"<none> & more"}`,
+			Expected: `{"error":"<none> & more","timeSinceGPSEpoch":1234567890123456789}`,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			b, err := sortJSONKeys([]byte(tst.JSON))
+			assert.NoError(err)
+			assert.Equal(tst.Expected, string(b))
+
+			// sorting is stable
+			b, err = sortJSONKeys(b)
+			assert.NoError(err)
+			assert.Equal(tst.Expected, string(b))
+		})
+	}
+}
+
+func TestFilterJSONKeys(t *testing.T) {
+	tests := []struct {
+		Name      string
+		AllowList []string
+		JSON      string
+		Expected  string
+	}{
+		{
+			Name:      "top-level keys",
+			AllowList: []string{"a", "c"},
+			JSON:      `{"a": 1, "b": 2, "c": {"d": 3}}`,
+			Expected:  `{"a": 1, "c": {"d": 3}}`,
+		},
+		{
+			Name:      "nested keys",
+			AllowList: []string{"a.b", "a.c.d"},
+			JSON:      `{"a": {"b": 1, "c": {"d": 2, "e": 3}, "f": 4}, "g": 5}`,
+			Expected:  `{"a": {"b": 1, "c": {"d": 2}}}`,
+		},
+		{
+			Name:      "parent key allows all nested keys",
+			AllowList: []string{"a.b", "a"},
+			JSON:      `{"a": {"b": 1, "c": 2}, "d": 3}`,
+			Expected:  `{"a": {"b": 1, "c": 2}}`,
+		},
+		{
+			Name:      "array elements",
+			AllowList: []string{"rxInfo.rssi"},
+			JSON:      `{"rxInfo": [{"rssi": -50, "channel": 1}, {"rssi": -60, "channel": 2}]}`,
+			Expected:  `{"rxInfo": [{"rssi": -50}, {"rssi": -60}]}`,
+		},
+		{
+			Name:      "missing keys",
+			AllowList: []string{"a", "b.c"},
+			JSON:      `{"d": 1}`,
+			Expected:  `{}`,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			b, err := filterJSONKeys([]byte(tst.JSON), newAllowTree(tst.AllowList))
+			assert.NoError(err)
+			assert.JSONEq(tst.Expected, string(b))
+		})
+	}
+}
+
+func TestUplinkAllowListIdentifiers(t *testing.T) {
+	assert := require.New(t)
+
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.JSONGatewayIDKey = "gatewayEUI"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.Auth.Type = "generic"
+	conf.Integration.MQTT.UplinkAllowList = []string{"phyPayload"}
+
+	b, err := NewBackend(conf)
+	assert.NoError(err)
+	assert.Equal(allowTree{
+		"phyPayload": nil,
+		"rxInfo": allowTree{
+			"gatewayEUI": nil,
+			"uplinkID":   nil,
+		},
+	}, b.uplinkAllowList)
+
+	conf.Integration.Marshaler = "protobuf"
+	_, err = NewBackend(conf)
+	assert.Error(err)
+}
+
+func TestNewMarshaler(t *testing.T) {
+	pl := gw.UplinkFrame{
+		PhyPayload: []byte{1, 2, 3, 4},
+		RxInfo: &gw.UplinkRXInfo{
+			GatewayId: []byte{8, 7, 6, 5, 4, 3, 2, 1},
+			Rssi:      -60,
+		},
+	}
+
+	tests := []struct {
+		Marshaler string
+		IsJSON    bool
+	}{
+		{Marshaler: "json", IsJSON: true},
+		{Marshaler: "cbor"},
+		{Marshaler: "protobuf"},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Marshaler, func(t *testing.T) {
+			assert := require.New(t)
+
+			marshal, unmarshal, err := newMarshaler(tst.Marshaler, "", "")
+			assert.NoError(err)
+
+			b, err := marshal(&pl)
+			assert.NoError(err)
+			assert.Equal(tst.IsJSON, json.Valid(b))
+
+			var out gw.UplinkFrame
+			assert.NoError(unmarshal(b, &out))
+			assert.True(proto.Equal(&pl, &out))
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, _, err := newMarshaler("xml", "", "")
+		assert.Error(err)
+	})
+}
+
+func TestJSONGatewayIDKey(t *testing.T) {
+	assert := require.New(t)
+
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.JSONGatewayIDKey = "gatewayEUI"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.Auth.Type = "generic"
+	setTestMQTTServer(&conf)
+
+	b, err := NewBackend(conf)
+	assert.NoError(err)
+
+	gatewayID := []byte{8, 7, 6, 5, 4, 3, 2, 1}
+
+	tests := []struct {
+		Name    string
+		Message proto.Message
+		Path    []string
+	}{
+		{
+			Name: "uplink",
+			Message: &gw.UplinkFrame{
+				RxInfo: &gw.UplinkRXInfo{
+					GatewayId: gatewayID,
+				},
+			},
+			Path: []string{"rxInfo"},
+		},
+		{
+			Name: "stats",
+			Message: &gw.GatewayStats{
+				GatewayId: gatewayID,
+			},
+		},
+		{
+			Name: "ack",
+			Message: &gw.DownlinkTXAck{
+				GatewayId: gatewayID,
+			},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			bb, err := b.marshal(tst.Message)
+			assert.NoError(err)
+
+			var obj map[string]interface{}
+			assert.NoError(json.Unmarshal(bb, &obj))
+			for _, p := range tst.Path {
+				obj = obj[p].(map[string]interface{})
+			}
+
+			assert.Equal("CAcGBQQDAgE=", obj["gatewayEUI"])
+			assert.NotContains(obj, "gatewayID")
+		})
+	}
+}
+
+func TestJSONPayloadEncoding(t *testing.T) {
+	uplink := gw.UplinkFrame{
+		PhyPayload: []byte{0x01, 0x02, 0xab, 0xcd},
+		RxInfo: &gw.UplinkRXInfo{
+			GatewayId: []byte{8, 7, 6, 5, 4, 3, 2, 1},
+		},
+	}
+
+	t.Run("base64", func(t *testing.T) {
+		assert := require.New(t)
+
+		marshal, _, err := newMarshaler("json", "", PayloadEncodingBase64)
+		assert.NoError(err)
+
+		b, err := marshal(&uplink)
+		assert.NoError(err)
+
+		var obj map[string]interface{}
+		assert.NoError(json.Unmarshal(b, &obj))
+		assert.Equal("AQKrzQ==", obj["phyPayload"])
+	})
+
+	t.Run("hex", func(t *testing.T) {
+		assert := require.New(t)
+
+		marshal, unmarshal, err := newMarshaler("json", "", PayloadEncodingHex)
+		assert.NoError(err)
+
+		b, err := marshal(&uplink)
+		assert.NoError(err)
+
+		var obj map[string]interface{}
+		assert.NoError(json.Unmarshal(b, &obj))
+		assert.Equal("0102abcd", obj["phyPayload"])
+
+		// the other bytes fields are not affected
+		assert.Equal("CAcGBQQDAgE=", obj["rxInfo"].(map[string]interface{})["gatewayID"])
+
+		var out gw.DownlinkFrame
+		assert.NoError(unmarshal([]byte(`{"items":[{"phyPayload":"0102abcd"}]}`), &out))
+		assert.Len(out.Items, 1)
+		assert.Equal([]byte{0x01, 0x02, 0xab, 0xcd}, out.Items[0].PhyPayload)
+
+		assert.Error(unmarshal([]byte(`{"items":[{"phyPayload":"AQKrzQ=="}]}`), &out))
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, _, err := newMarshaler("json", "", "base32")
+		assert.Error(err)
+	})
+}
+
+func TestAuthFailureFallback(t *testing.T) {
+	assert := require.New(t)
+
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.Auth.Type = "generic"
+	setTestMQTTServer(&conf)
+	conf.Integration.MQTT.Auth.Generic.Username = "primary"
+	conf.Integration.MQTT.Auth.Generic.PasswordCommand = "echo token"
+	conf.Integration.MQTT.Auth.Generic.Fallback.Server = "tcp://127.0.0.1:1884"
+	conf.Integration.MQTT.Auth.Generic.Fallback.Username = "fallback"
+	conf.Integration.MQTT.Auth.Generic.Fallback.Password = "secret"
+	conf.Integration.MQTT.Auth.Generic.Fallback.AuthFailures = 3
+	conf.Integration.MQTT.AutoReconnect = true
+
+	b, err := NewBackend(conf)
+	assert.NoError(err)
+
+	// the connection is restored by the connect loop, counting the
+	// authentication failures
+	assert.False(b.autoReconnect)
+	assert.False(b.clientOpts.AutoReconnect)
+
+	authErr := packets.ConnErrors[packets.ErrRefusedBadUsernameOrPassword]
+
+	t.Run("Other errors reset the counter", func(t *testing.T) {
+		assert := require.New(t)
+
+		b.handleConnectError(authErr)
+		b.handleConnectError(authErr)
+		b.handleConnectError(errors.New("network error"))
+		b.handleConnectError(authErr)
+
+		assert.False(b.fallbackActive)
+		assert.Equal("primary", b.clientOpts.Username)
+		assert.NotNil(b.clientOpts.CredentialsProvider)
+		assert.Len(b.clientOpts.Servers, 1)
+		assert.Equal(conf.Integration.MQTT.Auth.Generic.Servers[0], b.clientOpts.Servers[0].String())
+	})
+
+	t.Run("Repeated auth failures trigger failover", func(t *testing.T) {
+		assert := require.New(t)
+
+		b.handleConnectError(fmt.Errorf("%s : %s", packets.ConnErrors[packets.ErrRefusedNotAuthorised], "EOF"))
+		b.handleConnectError(authErr)
+
+		assert.True(b.fallbackActive)
+		assert.Equal("fallback", b.clientOpts.Username)
+		assert.Equal("secret", b.clientOpts.Password)
+		assert.Nil(b.clientOpts.CredentialsProvider)
+		assert.Len(b.clientOpts.Servers, 1)
+		assert.Equal("127.0.0.1:1884", b.clientOpts.Servers[0].Host)
+	})
+}
+
+func TestConnectionLostLastError(t *testing.T) {
+	assert := require.New(t)
+
+	b := Backend{autoReconnect: true}
+	b.onConnectionLost(nil, errors.New("connection reset by peer"))
+
+	lastErr, ok := status.GetLastErrors()[status.MQTT]
+	assert.True(ok)
+	assert.Equal("connection reset by peer", lastErr.Error)
+}
+
+func TestConnectionLostMaintenanceWindow(t *testing.T) {
+	level := log.GetLevel()
+	log.SetLevel(log.InfoLevel)
+	hook := logtest.NewGlobal()
+	defer func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetLevel(level)
+	}()
+
+	now := time.Now()
+
+	tests := []struct {
+		Name          string
+		Windows       []maintenanceWindow
+		ExpectedLevel log.Level
+	}{
+		{
+			Name:          "no maintenance window",
+			ExpectedLevel: log.ErrorLevel,
+		},
+		{
+			Name: "within maintenance window",
+			Windows: []maintenanceWindow{
+				{start: now.Add(-time.Hour), end: now.Add(time.Hour)},
+			},
+			ExpectedLevel: log.WarnLevel,
+		},
+		{
+			Name: "outside maintenance window",
+			Windows: []maintenanceWindow{
+				{start: now.Add(-2 * time.Hour), end: now.Add(-time.Hour)},
+				{start: now.Add(time.Hour), end: now.Add(2 * time.Hour)},
+			},
+			ExpectedLevel: log.ErrorLevel,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			hook.Reset()
+
+			b := Backend{
+				maintenanceWindows: tst.Windows,
+				autoReconnect:      true,
+			}
+			b.onConnectionLost(nil, errors.New("connection reset by peer"))
+
+			entry := hook.LastEntry()
+			assert.NotNil(entry)
+			assert.Equal("mqtt: connection error", entry.Message)
+			assert.Equal(tst.ExpectedLevel, entry.Level)
+		})
+	}
+
+	t.Run("Invalid maintenance window", func(t *testing.T) {
+		assert := require.New(t)
+
+		var conf config.Config
+		conf.Integration.Marshaler = "json"
+		conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+		conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+		conf.Integration.MQTT.Auth.Type = "generic"
+		conf.Integration.MQTT.MaintenanceWindows = []config.MQTTMaintenanceWindow{
+			{Start: "2020-10-01 02:00", End: "2020-10-01T04:00:00Z"},
+		}
+
+		_, err := NewBackend(conf)
+		assert.Error(err)
+	})
+}
+
+func TestMaxConnectAttempts(t *testing.T) {
+	assert := require.New(t)
+
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.MaxConnectAttempts = 3
+	conf.Integration.MQTT.MaxReconnectInterval = 20 * time.Millisecond
+	conf.Integration.MQTT.Auth.Type = "generic"
+	conf.Integration.MQTT.Auth.Generic.Servers = []string{"tcp://127.0.0.1:1"}
+
+	b, err := NewBackend(conf)
+	assert.NoError(err)
+	b.connectRetryInterval = 10 * time.Millisecond
+
+	start := time.Now()
+	err = b.Start()
+	assert.Error(err)
+	assert.Contains(err.Error(), "connect failed after 3 attempts")
+	assert.Less(int64(time.Since(start)), int64(time.Second))
+}
+
+// tcpProxy forwards the accepted connections to the given address, until
+// closed.
+type tcpProxy struct {
+	sync.Mutex
+
+	listener net.Listener
+	conns    []net.Conn
+	accepted int
+}
+
+func newTCPProxy(addr string) (*tcpProxy, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	p := tcpProxy{listener: l}
+	go func() {
+		for {
+			in, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			out, err := net.Dial("tcp", addr)
+			if err != nil {
+				in.Close()
+				continue
+			}
+
+			p.Lock()
+			p.conns = append(p.conns, in, out)
+			p.accepted++
+			p.Unlock()
+
+			go io.Copy(in, out)
+			go io.Copy(out, in)
+		}
+	}()
+
+	return &p, nil
+}
+
+func (p *tcpProxy) Accepted() int {
+	p.Lock()
+	defer p.Unlock()
+	return p.accepted
+}
+
+func (p *tcpProxy) Close() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.listener.Close()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+}
+
+func TestMultipleServers(t *testing.T) {
+	assert := require.New(t)
+
+	server, username, password := getTestMQTTServer()
+
+	// the proxies forward the connections to the test server
+	serverURL, err := url.Parse(server)
+	assert.NoError(err)
+	addr := serverURL.Host
+	if serverURL.Port() == "" {
+		addr = net.JoinHostPort(serverURL.Hostname(), "1883")
+	}
+
+	primary, err := newTCPProxy(addr)
+	assert.NoError(err)
+	defer primary.Close()
+
+	secondary, err := newTCPProxy(addr)
+	assert.NoError(err)
+	defer secondary.Close()
+
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.AutoReconnect = true
+	conf.Integration.MQTT.MaxReconnectInterval = 100 * time.Millisecond
+	conf.Integration.MQTT.Auth.Type = "generic"
+	conf.Integration.MQTT.Auth.Generic.Servers = []string{
+		"tcp://" + primary.listener.Addr().String(),
+		"tcp://" + secondary.listener.Addr().String(),
+	}
+	conf.Integration.MQTT.Auth.Generic.Username = username
+	conf.Integration.MQTT.Auth.Generic.Password = password
+	conf.Integration.MQTT.Auth.Generic.CleanSession = true
+
+	b, err := NewBackend(conf)
+	assert.NoError(err)
+	assert.NoError(b.Start())
+	defer b.Stop()
+
+	// the servers are tried in the configured order
+	assert.Equal(1, primary.Accepted())
+	assert.Equal(0, secondary.Accepted())
+
+	// on connection loss, the next available server is used
+	primary.Close()
+	assert.Eventually(func() bool {
+		return secondary.Accepted() == 1 && b.conn.IsConnectionOpen()
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestConnectedFunc(t *testing.T) {
+	assert := require.New(t)
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 24}
+
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.Auth.Type = "generic"
+	setTestMQTTServer(&conf)
+	conf.Integration.MQTT.Auth.Generic.CleanSession = true
+
+	b, err := NewBackend(conf)
+	assert.NoError(err)
+
+	connectedChan := make(chan struct{}, 1)
+	b.SetConnectedFunc(func() {
+		connectedChan <- struct{}{}
+	})
+
+	assert.NoError(b.SetGatewaySubscription(true, gatewayID))
+	assert.NoError(b.Start())
+	defer b.Stop()
+
+	isSubscribed := func() bool {
+		b.gatewaysSubscribedMux.Lock()
+		defer b.gatewaysSubscribedMux.Unlock()
+		_, ok := b.gatewaysSubscribed[gatewayID]
+		return ok
+	}
+
+	select {
+	case <-connectedChan:
+		assert.True(isSubscribed())
+	case <-time.After(time.Second):
+		t.Fatal("expected connected func to be called")
+	}
+
+	// after a reconnect, the gateways are re-subscribed first
+	b.onConnected(b.conn)
+	assert.False(isSubscribed())
+
+	select {
+	case <-connectedChan:
+		assert.True(isSubscribed())
+	case <-time.After(time.Second):
+		t.Fatal("expected connected func to be called")
+	}
+
+	// the function is only called once per connect
+	select {
+	case <-connectedChan:
+		t.Fatal("unexpected connected func call")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestDownlinkWildcardTopic(t *testing.T) {
+	t.Run("Gateway ID from topic", func(t *testing.T) {
+		tests := []struct {
+			Template      string
+			Topic         string
+			Expected      lorawan.EUI64
+			ExpectedError bool
+		}{
+			{Template: "gateway/{{ .GatewayID }}/command/#", Topic: "gateway/0807060504030201/command/down", Expected: lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}},
+			{Template: "eu868/gw/{{ .GatewayIDUpper }}/command/#", Topic: "eu868/gw/AABBCCDD01020304/command/down", Expected: lorawan.EUI64{0xaa, 0xbb, 0xcc, 0xdd, 1, 2, 3, 4}},
+			{Template: "/devices/gw-{{ .GatewayID }}/commands/#", Topic: "/devices/gw-0807060504030201/commands/down", Expected: lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}},
+			{Template: "gateway/{{ .GatewayID }}/command/#", Topic: "gateway/invalid/command/down", ExpectedError: true},
+			{Template: "gateway/{{ .GatewayID }}/command/#", Topic: "gateway", ExpectedError: true},
+			{Template: "gateway/command/#", Topic: "gateway/command/down", ExpectedError: true},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.Topic, func(t *testing.T) {
+				assert := require.New(t)
+
+				tmpl, err := template.New("command").Parse(tst.Template)
+				assert.NoError(err)
+
+				b := Backend{commandTopicTemplate: tmpl}
+				gatewayID, err := b.gatewayIDFromTopic(tst.Topic)
+				if tst.ExpectedError {
+					assert.Error(err)
+					return
+				}
+				assert.NoError(err)
+				assert.Equal(tst.Expected, gatewayID)
+			})
+		}
+	})
+
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.DownlinkWildcardTopic = "gateway/+/command/#"
+	conf.Integration.MQTT.Auth.Type = "generic"
+	setTestMQTTServer(&conf)
+	conf.Integration.MQTT.Auth.Generic.CleanSession = true
+
+	t.Run("Command topic without gateway ID", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := conf
+		conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayIDBytes }}/command/#"
+		_, err := NewBackend(conf)
+		assert.Error(err)
+	})
+
+	t.Run("Downlink", func(t *testing.T) {
+		assert := require.New(t)
+
+		gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 25}
+		unknownGatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 26}
+
+		b, err := NewBackend(conf)
+		assert.NoError(err)
+
+		downlinkFrameChan := make(chan gw.DownlinkFrame, 2)
+		b.SetDownlinkFrameFunc(func(pl gw.DownlinkFrame) {
+			downlinkFrameChan <- pl
+		})
+
+		assert.NoError(b.SetGatewaySubscription(true, gatewayID))
+		assert.NoError(b.Start())
+		defer b.Stop()
+
+		assert.Eventually(func() bool {
+			b.gatewaysSubscribedMux.Lock()
+			defer b.gatewaysSubscribedMux.Unlock()
+			_, ok := b.gatewaysSubscribed[gatewayID]
+			return ok && b.wildcardSubscribed
+		}, time.Second, 20*time.Millisecond)
+
+		client := newTestMQTTClient()
+		token := client.Connect()
+		token.Wait()
+		assert.NoError(token.Error())
+		defer client.Disconnect(0)
+
+		for _, id := range []lorawan.EUI64{unknownGatewayID, gatewayID} {
+			downlink := gw.DownlinkFrame{
+				GatewayId: id[:],
+				Items: []*gw.DownlinkFrameItem{
+					{
+						PhyPayload: []byte{1, 2, 3, 4},
+					},
+				},
+			}
+			pl, err := b.marshal(&downlink)
+			assert.NoError(err)
+
+			token := client.Publish("gateway/"+id.String()+"/command/down", 0, false, pl)
+			token.Wait()
+			assert.NoError(token.Error())
+		}
+
+		// the downlink of the unknown gateway is ignored
+		downlinkReceived := <-downlinkFrameChan
+		assert.Equal(gatewayID[:], downlinkReceived.GatewayId)
+
+		select {
+		case pl := <-downlinkFrameChan:
+			t.Fatalf("unexpected downlink: %v", pl)
+		case <-time.After(200 * time.Millisecond):
+		}
+	})
+}
+
+func TestKeepAlive(t *testing.T) {
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.Auth.Type = "generic"
+	setTestMQTTServer(&conf)
+
+	tests := []struct {
+		KeepAlive time.Duration
+		Expected  int64
+		Error     bool
+	}{
+		{KeepAlive: 20 * time.Second, Expected: 20},
+		{KeepAlive: 0, Expected: 0},
+		{KeepAlive: 500 * time.Millisecond, Error: true},
+		{KeepAlive: -time.Second, Error: true},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.KeepAlive.String(), func(t *testing.T) {
+			assert := require.New(t)
+
+			conf := conf
+			conf.Integration.MQTT.KeepAlive = tst.KeepAlive
+
+			b, err := NewBackend(conf)
+			if tst.Error {
+				assert.Error(err)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tst.Expected, b.clientOpts.KeepAlive)
+		})
+	}
+}
+
+func TestAutoReconnect(t *testing.T) {
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.MaxReconnectInterval = time.Second
+	conf.Integration.MQTT.Auth.Type = "generic"
+	setTestMQTTServer(&conf)
+	conf.Integration.MQTT.Auth.Generic.CleanSession = true
+
+	t.Run("Enabled", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := conf
+		conf.Integration.MQTT.AutoReconnect = true
+
+		b, err := NewBackend(conf)
+		assert.NoError(err)
+		assert.True(b.clientOpts.AutoReconnect)
+		assert.Equal(connectRetryInterval, b.connectRetryInterval)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := conf
+		conf.Integration.MQTT.ReconnectInterval = 10 * time.Millisecond
+
+		b, err := NewBackend(conf)
+		assert.NoError(err)
+		assert.False(b.clientOpts.AutoReconnect)
+		assert.Equal(10*time.Millisecond, b.connectRetryInterval)
+
+		assert.NoError(b.Start())
+		defer b.Stop()
+
+		// the connection is restored by the integration
+		conn := b.conn
+		conn.Disconnect(0)
+		b.onConnectionLost(conn, errors.New("connection reset by peer"))
+
+		assert.Eventually(func() bool {
+			b.connMux.RLock()
+			defer b.connMux.RUnlock()
+			return b.conn != conn && b.conn.IsConnected()
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestConnectionState(t *testing.T) {
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.Auth.Type = "generic"
+	setTestMQTTServer(&conf)
+	conf.Integration.MQTT.Auth.Generic.CleanSession = true
+	conf.Integration.MQTT.Auth.Generic.ClientID = "connection-state-test"
+
+	t.Run("GatewayID not provided by authentication", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := conf
+		conf.Integration.MQTT.ConnectionStateTopicTemplate = "gateway/{{ .GatewayID }}/connection"
+
+		_, err := NewBackend(conf)
+		assert.Error(err)
+	})
+
+	t.Run("GatewayID provided by authentication", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := conf
+		conf.Integration.MQTT.StateTopicTemplate = "gateway/{{ .GatewayID }}/state/{{ .StateType }}"
+		conf.Integration.MQTT.ConnectionStateTopicTemplate = "gateway/{{ .GatewayID }}/connection"
+		conf.Integration.MQTT.Auth.Generic.ClientID = "0102030405060708"
+
+		b, err := NewBackend(conf)
+		assert.NoError(err)
+		assert.Equal("gateway/0102030405060708/connection", b.connectionStateTopic)
+		assert.Equal("gateway/0102030405060708/connection", b.clientOpts.WillTopic)
+	})
+
+	t.Run("Online and offline", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := conf
+		conf.Integration.MQTT.ConnectionStateTopicTemplate = "bridge/{{ .ClientID }}/connection"
+
+		b, err := NewBackend(conf)
+		assert.NoError(err)
+		assert.True(b.clientOpts.WillEnabled)
+		assert.Equal("bridge/connection-state-test/connection", b.clientOpts.WillTopic)
+		assert.Equal(`{"state":"offline"}`, string(b.clientOpts.WillPayload))
+		assert.True(b.clientOpts.WillRetained)
+
+		client := newTestMQTTClient()
+		token := client.Connect()
+		token.Wait()
+		assert.NoError(token.Error())
+		defer client.Disconnect(0)
+
+		stateChan := make(chan paho.Message, 2)
+		token = client.Subscribe("bridge/connection-state-test/connection", 0, func(c paho.Client, msg paho.Message) {
+			if len(msg.Payload()) != 0 {
+				stateChan <- msg
+			}
+		})
+		token.Wait()
+		assert.NoError(token.Error())
+
+		assert.NoError(b.Start())
+		msg := <-stateChan
+		assert.Equal(`{"state":"online"}`, string(msg.Payload()))
+
+		assert.NoError(b.Stop())
+		msg = <-stateChan
+		assert.Equal(`{"state":"offline"}`, string(msg.Payload()))
+
+		// remove the retained message
+		token = client.Publish("bridge/connection-state-test/connection", 0, true, []byte{})
+		token.Wait()
+		assert.NoError(token.Error())
+	})
+}
+
+func TestWebSocket(t *testing.T) {
+	server := os.Getenv("TEST_MQTT_WS_SERVER")
+	if server == "" {
+		t.Skip("TEST_MQTT_WS_SERVER is not set")
+	}
+
+	assert := require.New(t)
+
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.ConnectionStateTopicTemplate = "bridge/{{ .ClientID }}/connection"
+	conf.Integration.MQTT.Auth.Type = "generic"
+	conf.Integration.MQTT.Auth.Generic.Servers = []string{server}
+	conf.Integration.MQTT.Auth.Generic.CleanSession = true
+	conf.Integration.MQTT.Auth.Generic.ClientID = "websocket-test"
+	conf.Integration.MQTT.Auth.Generic.HTTPHeaders = map[string]string{"x-test": "websocket"}
+
+	client := paho.NewClient(paho.NewClientOptions().AddBroker(server))
+	token := client.Connect()
+	token.Wait()
+	assert.NoError(token.Error())
+	defer client.Disconnect(0)
+
+	stateChan := make(chan paho.Message, 2)
+	token = client.Subscribe("bridge/websocket-test/connection", 0, func(c paho.Client, msg paho.Message) {
+		if len(msg.Payload()) != 0 {
+			stateChan <- msg
+		}
+	})
+	token.Wait()
+	assert.NoError(token.Error())
+
+	b, err := NewBackend(conf)
+	assert.NoError(err)
+	assert.Equal("websocket", b.clientOpts.HTTPHeaders.Get("X-Test"))
+
+	assert.NoError(b.Start())
+	msg := <-stateChan
+	assert.Equal(`{"state":"online"}`, string(msg.Payload()))
+
+	assert.NoError(b.Stop())
+	msg = <-stateChan
+	assert.Equal(`{"state":"offline"}`, string(msg.Payload()))
+
+	// remove the retained message
+	token = client.Publish("bridge/websocket-test/connection", 0, true, []byte{})
+	token.Wait()
+	assert.NoError(token.Error())
+}
+
+func TestStartupBanner(t *testing.T) {
+	assert := require.New(t)
+
+	level := log.GetLevel()
+	log.SetLevel(log.InfoLevel)
+	hook := logtest.NewGlobal()
+	defer func() {
+		hook.Reset()
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetLevel(level)
+	}()
+
+	var conf config.Config
+	conf.General.StartupBanner = true
+	conf.Backend.Type = "semtech_udp"
+	conf.Backend.SemtechUDP.UDPBind = "0.0.0.0:1700"
+	conf.Integration.Marshaler = "json"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.Auth.Type = "generic"
+	setTestMQTTServer(&conf)
+	conf.Integration.MQTT.Auth.Generic.CleanSession = true
+
+	b, err := NewBackend(conf)
+	assert.NoError(err)
+	assert.NoError(b.Start())
+	defer b.Stop()
+
+	// a reconnect must not log the banner again
+	b.onConnected(b.conn)
+
+	var banners []*log.Entry
+	for _, e := range hook.AllEntries() {
+		if e.Message == "integration/mqtt: effective endpoints" {
+			banners = append(banners, e)
+		}
+	}
+	assert.Len(banners, 1)
+	assert.Equal(log.InfoLevel, banners[0].Level)
+	assert.Equal(conf.Integration.MQTT.Auth.Generic.Servers[0], banners[0].Data["mqtt_servers"])
+	assert.Equal(false, banners[0].Data["mqtt_tls"])
+	assert.Equal("0.0.0.0:1700", banners[0].Data["udp_bind"])
+	assert.Equal("gateway/{{ .GatewayID }}/event/{{ .EventType }}", banners[0].Data["event_topic_template"])
+}
+
+func TestCloudEventsEnvelope(t *testing.T) {
+	assert := require.New(t)
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 20}
+
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.MQTT.Envelope = EnvelopeCloudEvents
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.Auth.Type = "generic"
+	setTestMQTTServer(&conf)
+	conf.Integration.MQTT.Auth.Generic.CleanSession = true
+
+	b, err := NewBackend(conf)
+	assert.NoError(err)
+	assert.NoError(b.Start())
+	defer b.Stop()
+
+	assert.NoError(b.SetGatewaySubscription(true, gatewayID))
+	time.Sleep(200 * time.Millisecond)
+
+	client := newTestMQTTClient()
+	token := client.Connect()
+	token.Wait()
+	assert.NoError(token.Error())
+	defer client.Disconnect(0)
+
+	t.Run("Uplink", func(t *testing.T) {
+		assert := require.New(t)
+
+		payloadChan := make(chan []byte, 1)
+		token := client.Subscribe("gateway/0102030405060714/event/up", 0, func(c paho.Client, msg paho.Message) {
+			payloadChan <- msg.Payload()
+		})
+		token.Wait()
+		assert.NoError(token.Error())
+
+		id, err := uuid.NewV4()
+		assert.NoError(err)
+		uplink := gw.UplinkFrame{
+			PhyPayload: []byte{1, 2, 3, 4},
+			RxInfo: &gw.UplinkRXInfo{
+				GatewayId: gatewayID[:],
+				UplinkId:  id[:],
+			},
+		}
+		assert.NoError(b.PublishEvent(gatewayID, "up", id, &uplink))
+		payload := <-payloadChan
+
+		var ce map[string]json.RawMessage
+		assert.NoError(json.Unmarshal(payload, &ce))
+		assert.JSONEq(`"1.0"`, string(ce["specversion"]))
+		assert.JSONEq(`"io.chirpstack.gateway.up"`, string(ce["type"]))
+		assert.JSONEq(`"/gateway/0102030405060714"`, string(ce["source"]))
+		assert.JSONEq(`"`+id.String()+`"`, string(ce["id"]))
+		assert.Contains(ce, "time")
+
+		// the data round-trips
+		var uplinkReceived gw.UplinkFrame
+		assert.NoError(b.unmarshal(ce["data"], &uplinkReceived))
+		assert.True(proto.Equal(&uplink, &uplinkReceived))
+		assert.NoError(b.unmarshal(payload, &uplinkReceived))
+		assert.True(proto.Equal(&uplink, &uplinkReceived))
+	})
+
+	t.Run("Downlink", func(t *testing.T) {
+		assert := require.New(t)
+
+		downlinkFrameChan := make(chan gw.DownlinkFrame, 1)
+		b.SetDownlinkFrameFunc(func(pl gw.DownlinkFrame) {
+			downlinkFrameChan <- pl
+		})
+
+		id, err := uuid.NewV4()
+		assert.NoError(err)
+		downlink := gw.DownlinkFrame{
+			GatewayId:  gatewayID[:],
+			DownlinkId: id[:],
+			Items: []*gw.DownlinkFrameItem{
+				{
+					PhyPayload: []byte{1, 2, 3, 4},
+				},
+			},
+		}
+		data, err := b.marshal(&downlink)
+		assert.NoError(err)
+		pl, err := wrapCloudEvent(gatewayID, "down", id, data)
+		assert.NoError(err)
+
+		token := client.Publish("gateway/0102030405060714/command/down", 0, false, pl)
+		token.Wait()
+		assert.NoError(token.Error())
+
+		downlinkReceived := <-downlinkFrameChan
+		assert.True(proto.Equal(&downlink, &downlinkReceived))
+	})
+
+	t.Run("Requires json marshaler", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf.Integration.Marshaler = "protobuf"
+		_, err := NewBackend(conf)
+		assert.Error(err)
+	})
+}
+
+func TestAckMarshaler(t *testing.T) {
+	assert := require.New(t)
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 21}
+
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.AckMarshaler = "protobuf"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.Auth.Type = "generic"
+	setTestMQTTServer(&conf)
+	conf.Integration.MQTT.Auth.Generic.CleanSession = true
+
+	b, err := NewBackend(conf)
+	assert.NoError(err)
+	assert.NoError(b.Start())
+	defer b.Stop()
+
+	client := newTestMQTTClient()
+	token := client.Connect()
+	token.Wait()
+	assert.NoError(token.Error())
+	defer client.Disconnect(0)
+
+	payloadChan := make(chan []byte, 1)
+	token = client.Subscribe("gateway/0102030405060715/event/+", 0, func(c paho.Client, msg paho.Message) {
+		payloadChan <- msg.Payload()
+	})
+	token.Wait()
+	assert.NoError(token.Error())
+
+	id, err := uuid.NewV4()
+	assert.NoError(err)
+
+	t.Run("Ack uses the ack marshaler", func(t *testing.T) {
+		assert := require.New(t)
+
+		ack := gw.DownlinkTXAck{
+			GatewayId:  gatewayID[:],
+			DownlinkId: id[:],
+			Items: []*gw.DownlinkTXAckItem{
+				{Status: gw.TxAckStatus_OK},
+			},
+		}
+		assert.NoError(b.PublishEvent(gatewayID, "ack", id, &ack))
+
+		var ackReceived gw.DownlinkTXAck
+		assert.NoError(proto.Unmarshal(<-payloadChan, &ackReceived))
+		assert.True(proto.Equal(&ack, &ackReceived))
+	})
+
+	t.Run("Uplink uses the marshaler", func(t *testing.T) {
+		assert := require.New(t)
+
+		uplink := gw.UplinkFrame{
+			PhyPayload: []byte{1, 2, 3, 4},
+			RxInfo: &gw.UplinkRXInfo{
+				GatewayId: gatewayID[:],
+				UplinkId:  id[:],
+			},
+		}
+		assert.NoError(b.PublishEvent(gatewayID, "up", id, &uplink))
+
+		payload := <-payloadChan
+		assert.True(json.Valid(payload))
+
+		var uplinkReceived gw.UplinkFrame
+		assert.NoError(b.unmarshal(payload, &uplinkReceived))
+		assert.True(proto.Equal(&uplink, &uplinkReceived))
+	})
+
+	t.Run("Invalid ack marshaler", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf.Integration.AckMarshaler = "xml"
+		_, err := NewBackend(conf)
+		assert.Error(err)
+	})
+}
+
+func TestEventQOS(t *testing.T) {
+	assert := require.New(t)
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 22}
+
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.EventQOS.Up = -1
+	conf.Integration.MQTT.EventQOS.Stats = 0
+	conf.Integration.MQTT.EventQOS.Ack = 2
+	conf.Integration.MQTT.Auth.Type = "generic"
+	setTestMQTTServer(&conf)
+	conf.Integration.MQTT.Auth.Generic.CleanSession = true
+	conf.Integration.MQTT.Auth.Generic.QOS = 1
+
+	b, err := NewBackend(conf)
+	assert.NoError(err)
+	assert.NoError(b.Start())
+	defer b.Stop()
+
+	client := newTestMQTTClient()
+	token := client.Connect()
+	token.Wait()
+	assert.NoError(token.Error())
+	defer client.Disconnect(0)
+
+	// the broker delivers using the min. of the publish and subscribe qos
+	qosChan := make(chan byte, 1)
+	token = client.Subscribe("gateway/0102030405060716/event/+", 2, func(c paho.Client, msg paho.Message) {
+		qosChan <- msg.Qos()
+	})
+	token.Wait()
+	assert.NoError(token.Error())
+
+	id, err := uuid.NewV4()
+	assert.NoError(err)
+
+	tests := []struct {
+		Event       string
+		Message     proto.Message
+		ExpectedQOS byte
+	}{
+		{"up", &gw.UplinkFrame{RxInfo: &gw.UplinkRXInfo{GatewayId: gatewayID[:], UplinkId: id[:]}}, 1},
+		{"stats", &gw.GatewayStats{GatewayId: gatewayID[:]}, 0},
+		{"ack", &gw.DownlinkTXAck{GatewayId: gatewayID[:]}, 2},
+		{"exec", &gw.GatewayCommandExecResponse{GatewayId: gatewayID[:]}, 1},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Event, func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(b.PublishEvent(gatewayID, tst.Event, id, tst.Message))
+			assert.Equal(tst.ExpectedQOS, <-qosChan)
+		})
+	}
+
+	t.Run("Invalid qos", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := conf
+		conf.Integration.MQTT.Auth.Generic.QOS = 3
+		_, err := NewBackend(conf)
+		assert.EqualError(err, "integration/mqtt: invalid qos 3, must be 0, 1 or 2")
+	})
+
+	t.Run("Invalid event qos", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := conf
+		conf.Integration.MQTT.EventQOS.Ack = 3
+		_, err := NewBackend(conf)
+		assert.EqualError(err, "integration/mqtt: invalid ack event qos 3, must be 0, 1 or 2 (or -1 to use the default qos)")
+	})
+}
+
+func TestEventRetained(t *testing.T) {
+	assert := require.New(t)
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 23}
+
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.EventQOS.Up = -1
+	conf.Integration.MQTT.EventQOS.Stats = -1
+	conf.Integration.MQTT.EventQOS.Ack = -1
+	conf.Integration.MQTT.EventRetained.Stats = true
+	conf.Integration.MQTT.Auth.Type = "generic"
+	setTestMQTTServer(&conf)
+	conf.Integration.MQTT.Auth.Generic.CleanSession = true
+
+	b, err := NewBackend(conf)
+	assert.NoError(err)
+	assert.NoError(b.Start())
+	defer b.Stop()
+
+	client := newTestMQTTClient()
+	token := client.Connect()
+	token.Wait()
+	assert.NoError(token.Error())
+	defer client.Disconnect(0)
+
+	// clear the retained messages
+	defer func() {
+		for _, event := range []string{"up", "stats"} {
+			token := client.Publish("gateway/0102030405060717/event/"+event, 0, true, []byte{})
+			token.Wait()
+		}
+	}()
+
+	id, err := uuid.NewV4()
+	assert.NoError(err)
+
+	// publish before subscribing
+	assert.NoError(b.PublishEvent(gatewayID, "up", id, &gw.UplinkFrame{RxInfo: &gw.UplinkRXInfo{GatewayId: gatewayID[:], UplinkId: id[:]}}))
+	assert.NoError(b.PublishEvent(gatewayID, "stats", id, &gw.GatewayStats{GatewayId: gatewayID[:]}))
+
+	topicChan := make(chan string, 2)
+	token = client.Subscribe("gateway/0102030405060717/event/+", 0, func(c paho.Client, msg paho.Message) {
+		if msg.Retained() {
+			topicChan <- msg.Topic()
+		}
+	})
+	token.Wait()
+	assert.NoError(token.Error())
+
+	// only the stats are retained
+	select {
+	case topic := <-topicChan:
+		assert.Equal("gateway/0102030405060717/event/stats", topic)
+	case <-time.After(time.Second):
+		t.Fatal("expected retained stats")
+	}
+
+	select {
+	case topic := <-topicChan:
+		t.Fatalf("unexpected retained message on topic %s", topic)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestGatewayState(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "mqtt")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	var conf config.Config
+	conf.Integration.Marshaler = "json"
+	conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+	conf.Integration.MQTT.StateTopicTemplate = "gateway/{{ .GatewayID }}/state/{{ .StateType }}"
+	conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+	conf.Integration.MQTT.GatewayState.File = filepath.Join(dir, "gateways.json")
+	conf.Integration.MQTT.Auth.Type = "generic"
+	setTestMQTTServer(&conf)
+	conf.Integration.MQTT.Auth.Generic.CleanSession = true
+
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	t.Run("Persist", func(t *testing.T) {
+		assert := require.New(t)
+
+		b, err := NewBackend(conf)
+		assert.NoError(err)
+		assert.NoError(b.SetGatewaySubscription(true, gatewayID))
+
+		data, err := ioutil.ReadFile(conf.Integration.MQTT.GatewayState.File)
+		assert.NoError(err)
+		assert.JSONEq(`["0102030405060708"]`, string(data))
+	})
+
+	t.Run("Re-subscribe on startup", func(t *testing.T) {
+		assert := require.New(t)
+
+		b, err := NewBackend(conf)
+		assert.NoError(err)
+		assert.NoError(b.Start())
+		defer b.Stop()
+
+		time.Sleep(200 * time.Millisecond)
+
+		b.gatewaysSubscribedMux.Lock()
+		_, ok := b.gatewaysSubscribed[gatewayID]
+		b.gatewaysSubscribedMux.Unlock()
+		assert.True(ok)
+	})
+
+	t.Run("Restore timeout", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := conf
+		conf.Integration.MQTT.GatewayState.RestoreTimeout = 100 * time.Millisecond
+
+		b, err := NewBackend(conf)
+		assert.NoError(err)
+		assert.NoError(b.Start())
+		defer b.Stop()
+
+		time.Sleep(400 * time.Millisecond)
+
+		b.gatewaysSubscribedMux.Lock()
+		_, ok := b.gatewaysSubscribed[gatewayID]
+		b.gatewaysSubscribedMux.Unlock()
+		assert.False(ok)
+
+		data, err := ioutil.ReadFile(conf.Integration.MQTT.GatewayState.File)
+		assert.NoError(err)
+		assert.JSONEq(`[]`, string(data))
+	})
 }
 
 func TestMQTTBackend(t *testing.T) {
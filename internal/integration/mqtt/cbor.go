@@ -0,0 +1,132 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// newCBORMarshaler returns the marshal and unmarshal functions of the cbor
+// marshaler, wrapping the given json marshal and unmarshal functions. This
+// way the CBOR encoded messages use the same schema (keys and values) as the
+// JSON encoded messages, except for the bytes fields which are encoded as
+// CBOR byte strings. On unmarshal, both the byte strings and the base64
+// encoded text strings are accepted. The gatewayIDKey is the (optional)
+// renamed gateway ID key of the JSON encoded messages.
+func newCBORMarshaler(jsonMarshal func(msg proto.Message) ([]byte, error), jsonUnmarshal func(b []byte, msg proto.Message) error, gatewayIDKey string) (func(msg proto.Message) ([]byte, error), func(b []byte, msg proto.Message) error, error) {
+	// decode the CBOR maps using string keys, so that the decoded value can
+	// be encoded as JSON
+	decMode, err := cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+	}.DecMode()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cbor decode mode error")
+	}
+
+	marshal := func(msg proto.Message) ([]byte, error) {
+		b, err := jsonMarshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		return jsonToCBOR(b, proto.MessageReflect(msg).Descriptor(), gatewayIDKey)
+	}
+
+	unmarshal := func(b []byte, msg proto.Message) error {
+		b, err := cborToJSON(decMode, b)
+		if err != nil {
+			return err
+		}
+		return jsonUnmarshal(b, msg)
+	}
+
+	return marshal, unmarshal, nil
+}
+
+// jsonToCBOR re-encodes the given JSON document of a message of the given
+// type as CBOR. Numbers are encoded as integers when possible and the
+// (base64 encoded) bytes fields are encoded as byte strings.
+func jsonToCBOR(b []byte, desc protoreflect.MessageDescriptor, gatewayIDKey string) ([]byte, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.Wrap(err, "decode json error")
+	}
+
+	b, err := cbor.Marshal(cborValue(v, desc, nil, gatewayIDKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "encode cbor error")
+	}
+	return b, nil
+}
+
+// cborValue converts the given decoded JSON value for CBOR encoding. The
+// given message descriptor is set when the value is a message and the field
+// descriptor when the value is a field value (or list item) of a message.
+func cborValue(v interface{}, desc protoreflect.MessageDescriptor, fd protoreflect.FieldDescriptor, gatewayIDKey string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			var (
+				valDesc protoreflect.MessageDescriptor
+				valFD   protoreflect.FieldDescriptor
+			)
+
+			switch {
+			case fd != nil && fd.IsMap():
+				// the map value
+				valFD = fd.MapValue()
+			case desc != nil && gatewayIDKey != "" && key == gatewayIDKey:
+				valFD = desc.Fields().ByJSONName(jsonGatewayIDKey)
+			case desc != nil:
+				valFD = desc.Fields().ByJSONName(key)
+			}
+
+			if valFD != nil && valFD.Kind() == protoreflect.MessageKind && !valFD.IsMap() {
+				valDesc = valFD.Message()
+			}
+
+			v[key] = cborValue(val, valDesc, valFD, gatewayIDKey)
+		}
+	case []interface{}:
+		for i, val := range v {
+			v[i] = cborValue(val, desc, fd, gatewayIDKey)
+		}
+	case string:
+		if fd != nil && fd.Kind() == protoreflect.BytesKind {
+			if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+				return b
+			}
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+	}
+	return v
+}
+
+// cborToJSON re-encodes the given CBOR document as JSON. CBOR byte strings
+// are encoded as base64 strings, matching the JSON encoding of the bytes
+// fields.
+func cborToJSON(decMode cbor.DecMode, b []byte) ([]byte, error) {
+	var v interface{}
+	if err := decMode.Unmarshal(b, &v); err != nil {
+		return nil, errors.Wrap(err, "decode cbor error")
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode json error")
+	}
+	return b, nil
+}
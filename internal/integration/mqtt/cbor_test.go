@@ -0,0 +1,153 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+)
+
+func TestCBORMarshaler(t *testing.T) {
+	marshal, unmarshal, err := newMarshaler("cbor", "", "")
+	require.NoError(t, err)
+
+	t.Run("Same keys as json", func(t *testing.T) {
+		assert := require.New(t)
+
+		b, err := marshal(&gw.UplinkFrame{
+			PhyPayload: []byte{1, 2, 3, 4},
+			RxInfo: &gw.UplinkRXInfo{
+				GatewayId: []byte{8, 7, 6, 5, 4, 3, 2, 1},
+				Rssi:      -60,
+				LoraSnr:   5.5,
+			},
+		})
+		assert.NoError(err)
+
+		var obj map[string]interface{}
+		assert.NoError(cbor.Unmarshal(b, &obj))
+		assert.Equal([]byte{1, 2, 3, 4}, obj["phyPayload"])
+
+		rxInfo, ok := obj["rxInfo"].(map[interface{}]interface{})
+		assert.True(ok)
+		assert.Equal([]byte{8, 7, 6, 5, 4, 3, 2, 1}, rxInfo["gatewayID"])
+		assert.Equal(int64(-60), rxInfo["rssi"])
+		assert.Equal(5.5, rxInfo["loRaSNR"])
+	})
+
+	t.Run("Bytes fields of nested and repeated messages", func(t *testing.T) {
+		assert := require.New(t)
+
+		b, err := marshal(&gw.DownlinkFrame{
+			GatewayId: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			Items: []*gw.DownlinkFrameItem{
+				{PhyPayload: []byte{1, 2, 3, 4}},
+				{PhyPayload: []byte{5, 6, 7, 8}},
+			},
+		})
+		assert.NoError(err)
+
+		var obj map[string]interface{}
+		assert.NoError(cbor.Unmarshal(b, &obj))
+		assert.Equal([]byte{1, 2, 3, 4, 5, 6, 7, 8}, obj["gatewayID"])
+
+		items, ok := obj["items"].([]interface{})
+		assert.True(ok)
+		assert.Len(items, 2)
+		assert.Equal([]byte{5, 6, 7, 8}, items[1].(map[interface{}]interface{})["phyPayload"])
+
+		var downlink gw.DownlinkFrame
+		assert.NoError(unmarshal(b, &downlink))
+		assert.Equal([]byte{5, 6, 7, 8}, downlink.Items[1].PhyPayload)
+	})
+
+	t.Run("Renamed gateway ID key", func(t *testing.T) {
+		assert := require.New(t)
+
+		marshal, _, err := newMarshaler("cbor", "gatewayEUI", "")
+		assert.NoError(err)
+
+		b, err := marshal(&gw.GatewayStats{
+			GatewayId: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		})
+		assert.NoError(err)
+
+		var obj map[string]interface{}
+		assert.NoError(cbor.Unmarshal(b, &obj))
+		assert.Equal([]byte{1, 2, 3, 4, 5, 6, 7, 8}, obj["gatewayEUI"])
+	})
+
+	t.Run("Hex payload encoding is ignored", func(t *testing.T) {
+		assert := require.New(t)
+
+		marshal, _, err := newMarshaler("cbor", "", PayloadEncodingHex)
+		assert.NoError(err)
+
+		b, err := marshal(&gw.UplinkFrame{
+			PhyPayload: []byte{1, 2, 3, 4},
+		})
+		assert.NoError(err)
+
+		var obj map[string]interface{}
+		assert.NoError(cbor.Unmarshal(b, &obj))
+		assert.Equal([]byte{1, 2, 3, 4}, obj["phyPayload"])
+	})
+
+	t.Run("Byte strings", func(t *testing.T) {
+		assert := require.New(t)
+
+		b, err := cbor.Marshal(map[string]interface{}{
+			"gatewayID": []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			"token":     1234,
+			"items": []interface{}{
+				map[string]interface{}{
+					"phyPayload": []byte{1, 2, 3, 4},
+				},
+			},
+		})
+		assert.NoError(err)
+
+		var downlink gw.DownlinkFrame
+		assert.NoError(unmarshal(b, &downlink))
+		assert.True(proto.Equal(&gw.DownlinkFrame{
+			GatewayId: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			Token:     1234,
+			Items: []*gw.DownlinkFrameItem{
+				{PhyPayload: []byte{1, 2, 3, 4}},
+			},
+		}, &downlink))
+	})
+
+	t.Run("Base64 text strings", func(t *testing.T) {
+		assert := require.New(t)
+
+		b, err := cbor.Marshal(map[string]interface{}{
+			"gatewayID": "AQIDBAUGBwg=",
+			"items": []interface{}{
+				map[string]interface{}{
+					"phyPayload": "AQIDBA==",
+				},
+			},
+		})
+		assert.NoError(err)
+
+		var downlink gw.DownlinkFrame
+		assert.NoError(unmarshal(b, &downlink))
+		assert.True(proto.Equal(&gw.DownlinkFrame{
+			GatewayId: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			Items: []*gw.DownlinkFrameItem{
+				{PhyPayload: []byte{1, 2, 3, 4}},
+			},
+		}, &downlink))
+	})
+
+	t.Run("Invalid payload", func(t *testing.T) {
+		assert := require.New(t)
+
+		var downlink gw.DownlinkFrame
+		assert.Error(unmarshal([]byte{0xff, 0x00}, &downlink))
+	})
+}
@@ -0,0 +1,62 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/brocaar/lorawan"
+)
+
+// Event envelopes.
+const (
+	EnvelopeNone        = "none"
+	EnvelopeCloudEvents = "cloudevents"
+)
+
+// cloudEventsSpecVersion defines the implemented CloudEvents spec version.
+const cloudEventsSpecVersion = "1.0"
+
+// cloudEvent implements the CloudEvents (structured mode) JSON envelope.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	Type            string          `json:"type"`
+	Source          string          `json:"source"`
+	ID              string          `json:"id"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// wrapCloudEvent wraps the given JSON encoded event into a CloudEvents
+// envelope. The event type is formatted as io.chirpstack.gateway.<event>
+// and the source as /gateway/<gateway_id>.
+func wrapCloudEvent(gatewayID lorawan.EUI64, event string, id uuid.UUID, data []byte) ([]byte, error) {
+	b, err := json.Marshal(cloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		Type:            fmt.Sprintf("io.chirpstack.gateway.%s", event),
+		Source:          fmt.Sprintf("/gateway/%s", gatewayID),
+		ID:              id.String(),
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "encode json error")
+	}
+	return b, nil
+}
+
+// unwrapCloudEvent returns the data of the given CloudEvents envelope. The
+// given payload is returned as-is when it is not a CloudEvents envelope, so
+// that commands can be sent with and without envelope.
+func unwrapCloudEvent(b []byte) []byte {
+	var ce cloudEvent
+	if err := json.Unmarshal(b, &ce); err != nil || ce.SpecVersion == "" || len(ce.Data) == 0 {
+		return b
+	}
+	return ce.Data
+}
@@ -0,0 +1,46 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/lorawan"
+)
+
+func TestCloudEvents(t *testing.T) {
+	gatewayID := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	id, err := uuid.NewV4()
+	require.NoError(t, err)
+	data := []byte(`{"phyPayload":"AQID"}`)
+
+	t.Run("Wrap", func(t *testing.T) {
+		assert := require.New(t)
+
+		b, err := wrapCloudEvent(gatewayID, "up", id, data)
+		assert.NoError(err)
+
+		var ce cloudEvent
+		assert.NoError(json.Unmarshal(b, &ce))
+		assert.Equal("1.0", ce.SpecVersion)
+		assert.Equal("io.chirpstack.gateway.up", ce.Type)
+		assert.Equal("/gateway/0102030405060708", ce.Source)
+		assert.Equal(id.String(), ce.ID)
+		assert.Equal("application/json", ce.DataContentType)
+		assert.WithinDuration(time.Now(), ce.Time, time.Second)
+		assert.JSONEq(string(data), string(ce.Data))
+
+		t.Run("Unwrap", func(t *testing.T) {
+			assert := require.New(t)
+			assert.JSONEq(string(data), string(unwrapCloudEvent(b)))
+		})
+	})
+
+	t.Run("Unwrap without envelope", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal(data, unwrapCloudEvent(data))
+	})
+}
@@ -0,0 +1,86 @@
+package mqtt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+// Payload compressions.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+// gzipMagic contains the magic bytes of a gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// gzipMaxDecompressedSize defines the max. size of a decompressed payload.
+const gzipMaxDecompressedSize = 1 << 20
+
+// gzipCompress returns the gzip compressed payload.
+func gzipCompress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, errors.Wrap(err, "gzip write error")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "gzip close error")
+	}
+	return buf.Bytes(), nil
+}
+
+// compressEvent returns true when the payload of the given event must be
+// compressed.
+func (b *Backend) compressEvent(event string) bool {
+	if b.compression != CompressionGzip {
+		return false
+	}
+
+	if len(b.compressionEvents) == 0 {
+		return true
+	}
+
+	_, ok := b.compressionEvents[event]
+	return ok
+}
+
+// gzipDecompress returns the decompressed payload when the given payload is
+// gzip compressed (detected by its magic bytes), else the payload is returned
+// as-is.
+func gzipDecompress(b []byte) ([]byte, error) {
+	if !bytes.HasPrefix(b, gzipMagic) {
+		return b, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.Wrap(err, "gzip new reader error")
+	}
+
+	out, err := ioutil.ReadAll(&io.LimitedReader{R: r, N: gzipMaxDecompressedSize + 1})
+	if err != nil {
+		return nil, errors.Wrap(err, "gzip read error")
+	}
+	if len(out) > gzipMaxDecompressedSize {
+		return nil, errors.New("decompressed payload exceeds max. size")
+	}
+	return out, nil
+}
+
+// gzipUnmarshal wraps the given unmarshal function, decompressing gzip
+// compressed payloads before they are unmarshaled.
+func gzipUnmarshal(unmarshal func(b []byte, msg proto.Message) error) func(b []byte, msg proto.Message) error {
+	return func(b []byte, msg proto.Message) error {
+		b, err := gzipDecompress(b)
+		if err != nil {
+			return errors.Wrap(err, "decompress payload error")
+		}
+		return unmarshal(b, msg)
+	}
+}
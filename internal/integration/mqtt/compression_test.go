@@ -0,0 +1,59 @@
+package mqtt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGzipDecompress(t *testing.T) {
+	compressed, err := gzipCompress([]byte(`{"foo":"bar"}`))
+	require.NoError(t, err)
+
+	large, err := gzipCompress(bytes.Repeat([]byte{0}, gzipMaxDecompressedSize+1))
+	require.NoError(t, err)
+
+	tests := []struct {
+		Name     string
+		Payload  []byte
+		Expected []byte
+		Error    bool
+	}{
+		{
+			Name:     "uncompressed",
+			Payload:  []byte(`{"foo":"bar"}`),
+			Expected: []byte(`{"foo":"bar"}`),
+		},
+		{
+			Name:     "compressed",
+			Payload:  compressed,
+			Expected: []byte(`{"foo":"bar"}`),
+		},
+		{
+			Name:    "invalid gzip",
+			Payload: append([]byte{}, compressed[:10]...),
+			Error:   true,
+		},
+		{
+			Name:    "exceeds max. size",
+			Payload: large,
+			Error:   true,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			out, err := gzipDecompress(tst.Payload)
+			if tst.Error {
+				assert.Error(err)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tst.Expected, out)
+		})
+	}
+}
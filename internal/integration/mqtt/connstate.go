@@ -0,0 +1,79 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"text/template"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Connection states.
+const (
+	ConnectionStateOnline  = "online"
+	ConnectionStateOffline = "offline"
+)
+
+// connectionStateMessage holds the connection state, as published to the
+// connection state topic.
+type connectionStateMessage struct {
+	State string `json:"state"`
+}
+
+// setConnectionStateWill renders the given connection state topic template
+// and sets the offline connection state as last will and testament. The
+// .GatewayID variable is only available when the Gateway ID is provided by
+// the authentication method, using it otherwise returns an error.
+func (b *Backend) setConnectionStateWill(topicTemplate string) error {
+	tmpl, err := template.New("connection_state").Option("missingkey=error").Funcs(b.templateFuncs).Parse(topicTemplate)
+	if err != nil {
+		return errors.Wrap(err, "parse topic template error")
+	}
+
+	vars := map[string]interface{}{
+		"ClientID": b.clientOpts.ClientID,
+	}
+	if gatewayID := b.auth.GetGatewayID(); gatewayID != nil {
+		vars["GatewayID"] = *gatewayID
+	}
+
+	topic := bytes.NewBuffer(nil)
+	if err := tmpl.Execute(topic, vars); err != nil {
+		return errors.Wrap(err, "execute topic template error")
+	}
+	if topic.Len() == 0 {
+		return errors.New("topic must not be empty")
+	}
+
+	bb, err := json.Marshal(connectionStateMessage{State: ConnectionStateOffline})
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	b.connectionStateTopic = topic.String()
+
+	log.WithFields(log.Fields{
+		"topic": b.connectionStateTopic,
+	}).Info("integration/mqtt: setting connection state last will and testament")
+
+	b.clientOpts.SetBinaryWill(b.connectionStateTopic, bb, b.qos, true)
+	return nil
+}
+
+// publishConnectionState publishes the given connection state as retained
+// JSON message to the connection state topic.
+func (b *Backend) publishConnectionState(state string) error {
+	bb, err := json.Marshal(connectionStateMessage{State: state})
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	log.WithFields(log.Fields{
+		"topic": b.connectionStateTopic,
+		"qos":   b.qos,
+		"state": state,
+	}).Info("integration/mqtt: publishing connection state")
+
+	return waitToken(b.conn.Publish(b.connectionStateTopic, b.qos, true, bb), b.publishTimeout)
+}
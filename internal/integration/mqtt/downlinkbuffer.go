@@ -0,0 +1,84 @@
+package mqtt
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/lorawan"
+)
+
+// Downlink buffer overflow policies.
+const (
+	DownlinkBufferDropOldest = "drop_oldest"
+	DownlinkBufferDropNewest = "drop_newest"
+)
+
+// queueDownlinkFrame passes the given downlink frame to the downlink handler.
+// When the downlink buffer is enabled, the frame is queued instead, dropping
+// the oldest or the given frame (depending on the overflow policy) when the
+// buffer is full.
+func (b *Backend) queueDownlinkFrame(pl gw.DownlinkFrame) {
+	if b.downlinkBuffer == nil {
+		if b.downlinkFrameFunc != nil {
+			b.downlinkFrameFunc(pl)
+		}
+		return
+	}
+
+	select {
+	case b.downlinkBuffer <- pl:
+		return
+	default:
+	}
+
+	if b.downlinkBufferOverflow == DownlinkBufferDropOldest {
+		select {
+		case dropped := <-b.downlinkBuffer:
+			b.logDroppedDownlinkFrame(dropped)
+		default:
+		}
+
+		select {
+		case b.downlinkBuffer <- pl:
+			return
+		default:
+		}
+	}
+
+	b.logDroppedDownlinkFrame(pl)
+}
+
+// downlinkLoop passes the buffered downlink frames to the downlink handler,
+// until the integration is closed.
+func (b *Backend) downlinkLoop() {
+	for {
+		select {
+		case pl := <-b.downlinkBuffer:
+			if b.downlinkFrameFunc != nil {
+				b.downlinkFrameFunc(pl)
+			}
+		case <-time.After(time.Second):
+			if b.isClosed() {
+				return
+			}
+		}
+	}
+}
+
+// logDroppedDownlinkFrame logs and counts the given dropped downlink frame.
+func (b *Backend) logDroppedDownlinkFrame(pl gw.DownlinkFrame) {
+	var gatewayID lorawan.EUI64
+	var downID uuid.UUID
+	copy(gatewayID[:], pl.GetGatewayId())
+	copy(downID[:], pl.GetDownlinkId())
+
+	mqttDownlinkDroppedCounter().Inc()
+	log.WithFields(log.Fields{
+		"gateway_id":  gatewayID,
+		"downlink_id": downID,
+		"overflow":    b.downlinkBufferOverflow,
+	}).Warning("integration/mqtt: downlink buffer is full, dropping downlink frame")
+}
@@ -0,0 +1,137 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+)
+
+func TestDownlinkBuffer(t *testing.T) {
+	frames := []gw.DownlinkFrame{
+		{Token: 1},
+		{Token: 2},
+		{Token: 3},
+	}
+
+	tests := []struct {
+		Name           string
+		Overflow       string
+		ExpectedTokens []uint32
+	}{
+		{
+			Name:           "drop oldest",
+			Overflow:       DownlinkBufferDropOldest,
+			ExpectedTokens: []uint32{2, 3},
+		},
+		{
+			Name:           "drop newest",
+			Overflow:       DownlinkBufferDropNewest,
+			ExpectedTokens: []uint32{1, 2},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			b := Backend{
+				downlinkBuffer:         make(chan gw.DownlinkFrame, 2),
+				downlinkBufferOverflow: tst.Overflow,
+			}
+
+			for _, pl := range frames {
+				b.queueDownlinkFrame(pl)
+			}
+
+			var tokens []uint32
+			for len(b.downlinkBuffer) != 0 {
+				pl := <-b.downlinkBuffer
+				tokens = append(tokens, pl.Token)
+			}
+			assert.Equal(tst.ExpectedTokens, tokens)
+		})
+	}
+
+	t.Run("downlink loop", func(t *testing.T) {
+		assert := require.New(t)
+
+		downlinkFrameChan := make(chan gw.DownlinkFrame, 1)
+		b := Backend{
+			downlinkBuffer:         make(chan gw.DownlinkFrame, 2),
+			downlinkBufferOverflow: DownlinkBufferDropOldest,
+			downlinkFrameFunc: func(pl gw.DownlinkFrame) {
+				downlinkFrameChan <- pl
+			},
+		}
+		go b.downlinkLoop()
+		defer func() {
+			b.connMux.Lock()
+			b.connClosed = true
+			b.connMux.Unlock()
+		}()
+
+		b.queueDownlinkFrame(frames[0])
+		pl := <-downlinkFrameChan
+		assert.Equal(uint32(1), pl.Token)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		assert := require.New(t)
+
+		var received []gw.DownlinkFrame
+		b := Backend{
+			downlinkFrameFunc: func(pl gw.DownlinkFrame) {
+				received = append(received, pl)
+			},
+		}
+
+		b.queueDownlinkFrame(frames[0])
+		assert.Len(received, 1)
+	})
+
+	t.Run("config", func(t *testing.T) {
+		var conf config.Config
+		conf.Integration.Marshaler = "json"
+		conf.Integration.MQTT.EventTopicTemplate = "gateway/{{ .GatewayID }}/event/{{ .EventType }}"
+		conf.Integration.MQTT.CommandTopicTemplate = "gateway/{{ .GatewayID }}/command/#"
+		conf.Integration.MQTT.Auth.Type = "generic"
+		setTestMQTTServer(&conf)
+
+		configTests := []struct {
+			Name             string
+			Size             int
+			Overflow         string
+			ExpectedSize     int
+			ExpectedOverflow string
+			ExpectedError    string
+		}{
+			{Name: "disabled"},
+			{Name: "default overflow", Size: 10, ExpectedSize: 10, ExpectedOverflow: DownlinkBufferDropOldest},
+			{Name: "drop newest", Size: 5, Overflow: DownlinkBufferDropNewest, ExpectedSize: 5, ExpectedOverflow: DownlinkBufferDropNewest},
+			{Name: "invalid size", Size: -1, ExpectedError: "integration/mqtt: invalid downlink buffer size -1, must be 0 or greater"},
+			{Name: "invalid overflow", Size: 5, Overflow: "block", ExpectedError: "integration/mqtt: unknown downlink buffer overflow: block"},
+		}
+
+		for _, tst := range configTests {
+			t.Run(tst.Name, func(t *testing.T) {
+				assert := require.New(t)
+
+				conf := conf
+				conf.Integration.MQTT.DownlinkBuffer.Size = tst.Size
+				conf.Integration.MQTT.DownlinkBuffer.Overflow = tst.Overflow
+
+				b, err := NewBackend(conf)
+				if tst.ExpectedError != "" {
+					assert.EqualError(err, tst.ExpectedError)
+					return
+				}
+				assert.NoError(err)
+				assert.Equal(tst.ExpectedSize, cap(b.downlinkBuffer))
+				assert.Equal(tst.ExpectedOverflow, b.downlinkBufferOverflow)
+			})
+		}
+	})
+}
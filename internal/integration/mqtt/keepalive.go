@@ -0,0 +1,140 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/lorawan"
+)
+
+// Keepalive states.
+const (
+	KeepaliveAlert    = "alert"
+	KeepaliveRecovery = "recovery"
+)
+
+// keepaliveGateway holds the keepalive state of a monitored gateway.
+type keepaliveGateway struct {
+	group     string
+	threshold time.Duration
+	lastSeen  time.Time
+	alerted   bool
+}
+
+// keepaliveTopicContext holds the variables that can be used within the
+// keepalive topic template.
+type keepaliveTopicContext struct {
+	gatewayTopicContext
+	Group string
+}
+
+// keepaliveMessage holds the alert or recovery, as published to the
+// keepalive topic.
+type keepaliveMessage struct {
+	GatewayID lorawan.EUI64 `json:"gatewayID"`
+	Group     string        `json:"group"`
+	State     string        `json:"state"`
+	Threshold string        `json:"threshold"`
+	LastSeen  time.Time     `json:"lastSeen"`
+	Time      time.Time     `json:"time"`
+}
+
+// keepaliveLoop checks the keepalive thresholds using the given interval,
+// until the integration is closed.
+func (b *Backend) keepaliveLoop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		if b.isClosed() {
+			return
+		}
+
+		if !b.conn.IsConnected() {
+			continue
+		}
+
+		b.checkKeepalive(time.Now())
+	}
+}
+
+// checkKeepalive publishes an alert for each monitored gateway of which the
+// last event is older than its threshold. An alert is published only once
+// until the gateway recovers.
+func (b *Backend) checkKeepalive(now time.Time) {
+	alerts := make(map[lorawan.EUI64]keepaliveGateway)
+
+	b.keepaliveMux.Lock()
+	for gatewayID, kg := range b.keepaliveGateways {
+		if kg.alerted || now.Sub(kg.lastSeen) <= kg.threshold {
+			continue
+		}
+		kg.alerted = true
+		alerts[gatewayID] = *kg
+	}
+	b.keepaliveMux.Unlock()
+
+	for gatewayID, kg := range alerts {
+		if err := b.publishKeepalive(gatewayID, kg, KeepaliveAlert); err != nil {
+			log.WithError(err).WithField("gateway_id", gatewayID).Error("integration/mqtt: publish keepalive alert error")
+		}
+	}
+}
+
+// keepaliveSeen updates the last seen timestamp of the given gateway and
+// publishes a recovery when an alert was published for the gateway.
+func (b *Backend) keepaliveSeen(gatewayID lorawan.EUI64) {
+	b.keepaliveMux.Lock()
+	kg, ok := b.keepaliveGateways[gatewayID]
+	if !ok {
+		b.keepaliveMux.Unlock()
+		return
+	}
+	recovered := kg.alerted
+	recovery := *kg
+	kg.lastSeen = time.Now()
+	kg.alerted = false
+	b.keepaliveMux.Unlock()
+
+	if !recovered {
+		return
+	}
+
+	if err := b.publishKeepalive(gatewayID, recovery, KeepaliveRecovery); err != nil {
+		log.WithError(err).WithField("gateway_id", gatewayID).Error("integration/mqtt: publish keepalive recovery error")
+	}
+}
+
+// publishKeepalive publishes the given keepalive state as JSON to the
+// keepalive topic of the gateway.
+func (b *Backend) publishKeepalive(gatewayID lorawan.EUI64, kg keepaliveGateway, state string) error {
+	topic := bytes.NewBuffer(nil)
+	if err := b.keepaliveTopicTemplate.Execute(topic, keepaliveTopicContext{gatewayTopicContext: newGatewayTopicContext(gatewayID), Group: kg.group}); err != nil {
+		return errors.Wrap(err, "execute keepalive topic template error")
+	}
+
+	bb, err := json.Marshal(keepaliveMessage{
+		GatewayID: gatewayID,
+		Group:     kg.group,
+		State:     state,
+		Threshold: kg.threshold.String(),
+		LastSeen:  kg.lastSeen.UTC(),
+		Time:      time.Now().UTC(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	log.WithFields(log.Fields{
+		"gateway_id": gatewayID,
+		"group":      kg.group,
+		"state":      state,
+		"topic":      topic.String(),
+		"qos":        b.qos,
+	}).Info("integration/mqtt: publishing keepalive state")
+
+	return waitToken(b.conn.Publish(topic.String(), b.qos, false, bb), b.publishTimeout)
+}
@@ -3,6 +3,7 @@ package mqtt
 import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
 )
 
 var (
@@ -35,6 +36,21 @@ var (
 		Name: "integration_mqtt_reconnect_count",
 		Help: "The number of times the integration reconnected to the MQTT broker (this also increments the disconnect and connect counters).",
 	})
+
+	mqttf = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "integration_mqtt_fallback_count",
+		Help: "The number of times the integration switched to the fallback MQTT broker because of authentication failures.",
+	})
+
+	mqttdd = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "integration_mqtt_downlink_dropped_count",
+		Help: "The number of downlink frames dropped by the MQTT integration because the downlink buffer was full.",
+	})
+
+	mqttpe = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "integration_mqtt_publish_error_count",
+		Help: "The number of events and states that could not be published by the MQTT integration.",
+	})
 )
 
 func mqttEventCounter(e string) prometheus.Counter {
@@ -60,3 +76,24 @@ func mqttDisconnectCounter() prometheus.Counter {
 func mqttReconnectCounter() prometheus.Counter {
 	return mqttr
 }
+
+func mqttFallbackCounter() prometheus.Counter {
+	return mqttf
+}
+
+func mqttDownlinkDroppedCounter() prometheus.Counter {
+	return mqttdd
+}
+
+func mqttPublishErrorCounter() prometheus.Counter {
+	return mqttpe
+}
+
+// counterValue returns the current value of the given counter.
+func counterValue(c prometheus.Counter) uint64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		return 0
+	}
+	return uint64(m.GetCounter().GetValue())
+}
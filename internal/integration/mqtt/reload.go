@@ -0,0 +1,113 @@
+package mqtt
+
+import (
+	"text/template"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+// Reload applies the topic templates of the given configuration, without
+// re-connecting to the MQTT broker. When the command topic of a subscribed
+// gateway changes, the gateway is unsubscribed from the old topic and is
+// re-subscribed to the new topic by the subscribe loop.
+//
+// The topic templates of the GCP Cloud IoT Core and Azure IoT Hub
+// authentication types are fixed and are not reloaded.
+func (b *Backend) Reload(conf config.Config) error {
+	if conf.Integration.MQTT.Auth.Type != "generic" {
+		return nil
+	}
+
+	var (
+		next Backend
+		err  error
+	)
+	next.downlinkWildcardTopic = b.downlinkWildcardTopic
+
+	next.eventTopicTemplate, err = template.New("event").Funcs(b.templateFuncs).Parse(conf.Integration.MQTT.EventTopicTemplate)
+	if err != nil {
+		return errors.Wrap(err, "integration/mqtt: parse event-topic template error")
+	}
+
+	if conf.Integration.MQTT.StateTopicTemplate != "" {
+		next.stateTopicTemplate, err = template.New("state").Funcs(b.templateFuncs).Parse(conf.Integration.MQTT.StateTopicTemplate)
+		if err != nil {
+			return errors.Wrap(err, "integration/mqtt: parse state-topic template error")
+		}
+	}
+
+	next.commandTopicTemplate, err = template.New("command").Funcs(b.templateFuncs).Parse(conf.Integration.MQTT.CommandTopicTemplate)
+	if err != nil {
+		return errors.Wrap(err, "integration/mqtt: parse command-topic template error")
+	}
+
+	if conf.Integration.MQTT.GatewayConnStateTopicTemplate != "" {
+		next.gatewayConnStateTopicTemplate, err = template.New("gateway_conn_state").Funcs(b.templateFuncs).Parse(conf.Integration.MQTT.GatewayConnStateTopicTemplate)
+		if err != nil {
+			return errors.Wrap(err, "integration/mqtt: parse gateway-conn-state-topic template error")
+		}
+	}
+
+	if err := next.validateTopicTemplates(); err != nil {
+		return errors.Wrap(err, "integration/mqtt: validate topic templates error")
+	}
+
+	// the subscribe loop holds this lock during the (un)subscribe operations,
+	// this way the subscriptions can't change while the templates are replaced
+	b.gatewaysSubscribedMux.Lock()
+	defer b.gatewaysSubscribedMux.Unlock()
+
+	oldTopics := make(map[lorawan.EUI64]string)
+	for gatewayID := range b.gatewaysSubscribed {
+		topic, err := b.getCommandTopic(gatewayID)
+		if err != nil {
+			return errors.Wrap(err, "integration/mqtt: get command topic error")
+		}
+		oldTopics[gatewayID] = topic
+	}
+
+	b.templatesMux.Lock()
+	b.eventTopicTemplate = next.eventTopicTemplate
+	b.stateTopicTemplate = next.stateTopicTemplate
+	b.commandTopicTemplate = next.commandTopicTemplate
+	b.gatewayConnStateTopicTemplate = next.gatewayConnStateTopicTemplate
+	b.templatesMux.Unlock()
+
+	log.WithFields(log.Fields{
+		"event_topic_template":              conf.Integration.MQTT.EventTopicTemplate,
+		"state_topic_template":              conf.Integration.MQTT.StateTopicTemplate,
+		"command_topic_template":            conf.Integration.MQTT.CommandTopicTemplate,
+		"gateway_conn_state_topic_template": conf.Integration.MQTT.GatewayConnStateTopicTemplate,
+	}).Info("integration/mqtt: topic templates reloaded")
+
+	// the wildcard subscription does not depend on the command topic
+	if b.downlinkWildcardTopic != "" {
+		return nil
+	}
+
+	for gatewayID, oldTopic := range oldTopics {
+		topic, err := b.getCommandTopic(gatewayID)
+		if err == nil && topic == oldTopic {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"topic":      oldTopic,
+			"gateway_id": gatewayID,
+		}).Info("integration/mqtt: unsubscribing from topic")
+
+		if err := waitToken(b.conn.Unsubscribe(oldTopic), b.subscribeTimeout); err != nil {
+			log.WithError(err).WithField("gateway_id", gatewayID).Error("integration/mqtt: unsubscribe gateway error")
+		}
+
+		// the subscribe loop will subscribe the gateway using the new template
+		delete(b.gatewaysSubscribed, gatewayID)
+		delete(b.subscribeRetries, gatewayID)
+	}
+
+	return nil
+}
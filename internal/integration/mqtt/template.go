@@ -0,0 +1,24 @@
+package mqtt
+
+import (
+	"fmt"
+	"os"
+	"text/template"
+)
+
+// newTemplateFuncs returns the functions that can be used within the topic
+// templates. The env function returns the value of the given environment
+// variable (e.g. {{ env "REGION" }}), which is looked up each time the
+// template is executed. An unset variable renders empty, or returns an error
+// in case envStrict is set.
+func newTemplateFuncs(envStrict bool) template.FuncMap {
+	return template.FuncMap{
+		"env": func(key string) (string, error) {
+			value, ok := os.LookupEnv(key)
+			if !ok && envStrict {
+				return "", fmt.Errorf("environment variable %s is not set", key)
+			}
+			return value, nil
+		},
+	}
+}
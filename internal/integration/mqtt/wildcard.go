@@ -0,0 +1,119 @@
+package mqtt
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/lorawan"
+)
+
+// wildcardSampleGatewayID is used to locate the gateway ID within the
+// rendered command topic.
+var wildcardSampleGatewayID = lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+// subscribeWildcard subscribes to the downlink wildcard topic, in case the
+// wildcard mode is enabled and the topic has not yet been subscribed. It
+// returns false when the subscription failed, in which case it is retried
+// after the initial subscribe retry interval. The gatewaysSubscribedMux must
+// be locked by the caller.
+func (b *Backend) subscribeWildcard() bool {
+	if b.downlinkWildcardTopic == "" || b.wildcardSubscribed {
+		return true
+	}
+
+	if time.Now().Before(b.wildcardRetry) {
+		return false
+	}
+
+	log.WithFields(log.Fields{
+		"topic": b.downlinkWildcardTopic,
+		"qos":   b.downlinkQOS,
+	}).Info("integration/mqtt: subscribing to wildcard topic")
+
+	token := b.conn.Subscribe(b.downlinkWildcardTopic, b.downlinkQOS, b.handleCommand)
+	err := waitToken(token, b.subscribeTimeout)
+	if err == nil && subscribeDenied(token, b.downlinkWildcardTopic) {
+		err = ErrSubscribeDenied
+	}
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"topic":    b.downlinkWildcardTopic,
+			"retry_in": b.subscribeRetryInitial,
+		}).Error("integration/mqtt: subscribe wildcard topic error")
+		b.wildcardRetry = time.Now().Add(b.subscribeRetryInitial)
+		return false
+	}
+
+	b.wildcardSubscribed = true
+	return true
+}
+
+// gatewayIDFromTopic returns the gateway ID of the given command topic. The
+// gateway ID is parsed from the topic level that holds the gateway ID within
+// the command topic template, e.g. gateway/0102030405060708/command/down
+// returns 0102030405060708 for the gateway/{{ .GatewayID }}/command/#
+// template.
+func (b *Backend) gatewayIDFromTopic(topic string) (lorawan.EUI64, error) {
+	var gatewayID lorawan.EUI64
+
+	sample, err := b.getCommandTopic(wildcardSampleGatewayID)
+	if err != nil {
+		return gatewayID, err
+	}
+
+	sampleID := wildcardSampleGatewayID.String()
+	sampleLevels := strings.Split(sample, "/")
+	levels := strings.Split(topic, "/")
+
+	for i, level := range sampleLevels {
+		pos := strings.Index(strings.ToLower(level), sampleID)
+		if pos == -1 {
+			continue
+		}
+
+		if i >= len(levels) {
+			break
+		}
+
+		prefix := level[:pos]
+		suffix := level[pos+len(sampleID):]
+		id := levels[i]
+		if len(id) != len(level) || !strings.HasPrefix(id, prefix) || !strings.HasSuffix(id, suffix) {
+			break
+		}
+
+		if err := gatewayID.UnmarshalText([]byte(id[len(prefix) : len(id)-len(suffix)])); err != nil {
+			return gatewayID, errors.Wrap(err, "parse gateway id error")
+		}
+		return gatewayID, nil
+	}
+
+	return gatewayID, errors.Errorf("no gateway id found in topic %s", topic)
+}
+
+// validateWildcardTopic validates that the gateway ID can be parsed from the
+// command topic, in case the wildcard mode is enabled.
+func (b *Backend) validateWildcardTopic() error {
+	if b.downlinkWildcardTopic == "" {
+		return nil
+	}
+
+	gatewayID := lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}
+	topic, err := b.getCommandTopic(gatewayID)
+	if err != nil {
+		return err
+	}
+
+	id, err := b.gatewayIDFromTopic(topic)
+	if err != nil {
+		return errors.Wrap(err, "command topic template must contain the gateway id in the wildcard mode")
+	}
+	if id != gatewayID {
+		return errors.New("command topic template must contain the gateway id in the wildcard mode")
+	}
+
+	return nil
+}
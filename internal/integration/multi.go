@@ -0,0 +1,91 @@
+package integration
+
+import (
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/proto"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+// multiIntegration implements the Integration interface by calling each
+// of the wrapped integrations. Errors are returned after all integrations
+// have been called. In case multiple integrations return an error, the
+// first error is returned.
+type multiIntegration struct {
+	integrations []Integration
+}
+
+func (m *multiIntegration) SetGatewaySubscription(subscribe bool, gatewayID lorawan.EUI64) error {
+	return m.each(func(i Integration) error {
+		return i.SetGatewaySubscription(subscribe, gatewayID)
+	})
+}
+
+func (m *multiIntegration) PublishEvent(gatewayID lorawan.EUI64, event string, id uuid.UUID, v proto.Message) error {
+	return m.each(func(i Integration) error {
+		return i.PublishEvent(gatewayID, event, id, v)
+	})
+}
+
+func (m *multiIntegration) PublishState(gatewayID lorawan.EUI64, state string, v proto.Message) error {
+	return m.each(func(i Integration) error {
+		return i.PublishState(gatewayID, state, v)
+	})
+}
+
+func (m *multiIntegration) SetDownlinkFrameFunc(f func(gw.DownlinkFrame)) {
+	for _, i := range m.integrations {
+		i.SetDownlinkFrameFunc(f)
+	}
+}
+
+func (m *multiIntegration) SetRawPacketForwarderCommandFunc(f func(gw.RawPacketForwarderCommand)) {
+	for _, i := range m.integrations {
+		i.SetRawPacketForwarderCommandFunc(f)
+	}
+}
+
+func (m *multiIntegration) SetGatewayConfigurationFunc(f func(gw.GatewayConfiguration)) {
+	for _, i := range m.integrations {
+		i.SetGatewayConfigurationFunc(f)
+	}
+}
+
+func (m *multiIntegration) SetGatewayCommandExecRequestFunc(f func(gw.GatewayCommandExecRequest)) {
+	for _, i := range m.integrations {
+		i.SetGatewayCommandExecRequestFunc(f)
+	}
+}
+
+func (m *multiIntegration) Start() error {
+	return m.each(func(i Integration) error {
+		return i.Start()
+	})
+}
+
+func (m *multiIntegration) Stop() error {
+	return m.each(func(i Integration) error {
+		return i.Stop()
+	})
+}
+
+func (m *multiIntegration) Reload(conf config.Config) error {
+	return m.each(func(i Integration) error {
+		if r, ok := i.(Reloader); ok {
+			return r.Reload(conf)
+		}
+		return nil
+	})
+}
+
+func (m *multiIntegration) each(f func(Integration) error) error {
+	var out error
+	for _, i := range m.integrations {
+		if err := f(i); err != nil && out == nil {
+			out = err
+		}
+	}
+	return out
+}
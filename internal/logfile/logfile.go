@@ -0,0 +1,115 @@
+// Package logfile implements a log file writer with size-based rotation.
+// When the max. size is exceeded, the log file is renamed to <file>.1 (the
+// existing <file>.N backups are renamed to <file>.N+1) and a new log file is
+// created.
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Writer implements a rotating log file writer.
+type Writer struct {
+	mux sync.Mutex
+
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+// New opens (or creates) the given log file for appending. The file is
+// rotated when writing would exceed maxSize bytes (0 = no rotation), keeping
+// at most maxBackups rotated files.
+func New(path string, maxSize int64, maxBackups int) (*Writer, error) {
+	w := Writer{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	return &w, nil
+}
+
+// Write writes the given bytes to the log file, rotating the log file first
+// when needed.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if w.maxSize != 0 && w.size != 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close closes the log file.
+func (w *Writer) Close() error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	return w.file.Close()
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return errors.Wrap(err, "open log file error")
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "stat log file error")
+	}
+
+	w.file = f
+	w.size = fi.Size()
+
+	return nil
+}
+
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return errors.Wrap(err, "close log file error")
+	}
+
+	if w.maxBackups == 0 {
+		if err := os.Remove(w.path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "remove log file error")
+		}
+		return w.open()
+	}
+
+	// the oldest backup is overwritten by the rename below
+	for i := w.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(w.backupPath(i), w.backupPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "rename log file backup error")
+		}
+	}
+
+	if err := os.Rename(w.path, w.backupPath(1)); err != nil {
+		return errors.Wrap(err, "rename log file error")
+	}
+
+	return w.open()
+}
+
+func (w *Writer) backupPath(i int) string {
+	return fmt.Sprintf("%s.%d", w.path, i)
+}
@@ -0,0 +1,58 @@
+package logfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "logfile")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "bridge.log")
+	assert.NoError(ioutil.WriteFile(path, []byte("existing\n"), 0640))
+
+	w, err := New(path, 20, 2)
+	assert.NoError(err)
+	defer w.Close()
+
+	read := func(p string) string {
+		b, err := ioutil.ReadFile(p)
+		assert.NoError(err)
+		return string(b)
+	}
+
+	t.Run("Append to existing file", func(t *testing.T) {
+		_, err := w.Write([]byte("line 1\n"))
+		assert.NoError(err)
+		assert.Equal("existing\nline 1\n", read(path))
+	})
+
+	t.Run("Rotate when exceeding max size", func(t *testing.T) {
+		_, err := w.Write([]byte("line 2\n"))
+		assert.NoError(err)
+		assert.Equal("line 2\n", read(path))
+		assert.Equal("existing\nline 1\n", read(path+".1"))
+	})
+
+	t.Run("Keep max backups", func(t *testing.T) {
+		for _, line := range []string{"line 3 - long\n", "line 4 - long\n", "line 5 - long\n"} {
+			_, err := w.Write([]byte(line))
+			assert.NoError(err)
+		}
+
+		assert.Equal("line 5 - long\n", read(path))
+		assert.Equal("line 4 - long\n", read(path+".1"))
+		assert.Equal("line 3 - long\n", read(path+".2"))
+
+		_, err := os.Stat(path + ".3")
+		assert.True(os.IsNotExist(err))
+	})
+}
@@ -1,16 +1,32 @@
 package metrics
 
 import (
+	"fmt"
 	"net/http"
 
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/diagnostics"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/status"
 )
 
 // Setup configures the metrics package.
 func Setup(conf config.Config) error {
+	switch conf.Metrics.Backend {
+	case "", "prometheus":
+		return setupPrometheus(conf)
+	case "statsd":
+		return setupStatsD(conf)
+	default:
+		return fmt.Errorf("unknown metrics backend: %s", conf.Metrics.Backend)
+	}
+}
+
+func setupPrometheus(conf config.Config) error {
 	if !conf.Metrics.Prometheus.EndpointEnabled {
 		return nil
 	}
@@ -19,8 +35,17 @@ func Setup(conf config.Config) error {
 		"bind": conf.Metrics.Prometheus.Bind,
 	}).Info("metrics: starting prometheus metrics server")
 
+	mux := http.NewServeMux()
+	mux.Handle("/", promhttp.Handler())
+	if conf.Metrics.Prometheus.StatusEndpointEnabled {
+		mux.Handle("/status", status.Handler())
+	}
+	if conf.Metrics.Prometheus.DiagnosticsEndpointEnabled {
+		mux.Handle("/diagnostics", diagnostics.Handler())
+	}
+
 	server := http.Server{
-		Handler: promhttp.Handler(),
+		Handler: mux,
 		Addr:    conf.Metrics.Prometheus.Bind,
 	}
 
@@ -31,3 +56,24 @@ func Setup(conf config.Config) error {
 
 	return nil
 }
+
+func setupStatsD(conf config.Config) error {
+	if conf.Metrics.StatsD.Interval <= 0 {
+		return errors.New("statsd interval must be greater than 0")
+	}
+
+	log.WithFields(log.Fields{
+		"server":   conf.Metrics.StatsD.Server,
+		"prefix":   conf.Metrics.StatsD.Prefix,
+		"interval": conf.Metrics.StatsD.Interval,
+	}).Info("metrics: starting statsd metrics exporter")
+
+	exporter, err := newStatsDExporter(conf.Metrics.StatsD.Server, conf.Metrics.StatsD.Prefix, prometheus.DefaultGatherer)
+	if err != nil {
+		return errors.Wrap(err, "new statsd exporter error")
+	}
+
+	go exporter.pushLoop(conf.Metrics.StatsD.Interval)
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// statsdMaxPacketSize defines the max. size of a single StatsD UDP packet.
+const statsdMaxPacketSize = 1432
+
+var statsdReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", " ", "_", "\n", "_")
+
+// statsdExporter pushes the registered (Prometheus) counters and gauges
+// to a StatsD server.
+type statsdExporter struct {
+	conn     net.Conn
+	prefix   string
+	gatherer prometheus.Gatherer
+
+	// last pushed counter values, used to calculate the counter deltas
+	counters map[string]float64
+}
+
+func newStatsDExporter(server, prefix string, gatherer prometheus.Gatherer) (*statsdExporter, error) {
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		return nil, errors.Wrap(err, "dial statsd server error")
+	}
+
+	return &statsdExporter{
+		conn:     conn,
+		prefix:   prefix,
+		gatherer: gatherer,
+		counters: make(map[string]float64),
+	}, nil
+}
+
+// pushLoop pushes the metrics every interval.
+func (e *statsdExporter) pushLoop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		if err := e.push(); err != nil {
+			log.WithError(err).Error("metrics: push statsd metrics error")
+		}
+	}
+}
+
+// push sends the current metrics to the StatsD server. Counters are sent as
+// the delta since the previous push, gauges are sent as their current value.
+func (e *statsdExporter) push() error {
+	lines, err := e.lines()
+	if err != nil {
+		return errors.Wrap(err, "get statsd lines error")
+	}
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if buf.Len() != 0 && buf.Len()+len(line)+1 > statsdMaxPacketSize {
+			if _, err := e.conn.Write(buf.Bytes()); err != nil {
+				return errors.Wrap(err, "write statsd packet error")
+			}
+			buf.Reset()
+		}
+
+		if buf.Len() != 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+
+	if buf.Len() != 0 {
+		if _, err := e.conn.Write(buf.Bytes()); err != nil {
+			return errors.Wrap(err, "write statsd packet error")
+		}
+	}
+
+	return nil
+}
+
+func (e *statsdExporter) lines() ([]string, error) {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		return nil, errors.Wrap(err, "gather metrics error")
+	}
+
+	var out []string
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			name := e.metricName(mf.GetName(), m.GetLabel())
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				v := m.GetCounter().GetValue()
+				delta := v - e.counters[name]
+				e.counters[name] = v
+
+				if delta != 0 {
+					out = append(out, fmt.Sprintf("%s:%g|c", name, delta))
+				}
+			case dto.MetricType_GAUGE:
+				out = append(out, fmt.Sprintf("%s:%g|g", name, m.GetGauge().GetValue()))
+			case dto.MetricType_UNTYPED:
+				out = append(out, fmt.Sprintf("%s:%g|g", name, m.GetUntyped().GetValue()))
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// metricName returns the StatsD metric name, e.g.
+// prefix.integration_mqtt_event_count.event.up.
+func (e *statsdExporter) metricName(name string, labels []*dto.LabelPair) string {
+	parts := []string{name}
+	if e.prefix != "" {
+		parts = append([]string{e.prefix}, parts...)
+	}
+
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].GetName() < labels[j].GetName()
+	})
+
+	for _, l := range labels {
+		parts = append(parts, l.GetName(), l.GetValue())
+	}
+
+	return statsdReplacer.Replace(strings.Join(parts, "."))
+}
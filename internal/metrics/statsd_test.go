@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsDExporter(t *testing.T) {
+	assert := require.New(t)
+
+	// mock statsd server
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer conn.Close()
+
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "test_event_count",
+		Help: "Test counter.",
+	}, []string{"event"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "test_gateway_count",
+		Help: "Test gauge.",
+	})
+	reg.MustRegister(counter, gauge)
+
+	exporter, err := newStatsDExporter(conn.LocalAddr().String(), "cgb", reg)
+	assert.NoError(err)
+
+	receive := func() []string {
+		assert.NoError(conn.SetReadDeadline(time.Now().Add(time.Second)))
+		buf := make([]byte, statsdMaxPacketSize)
+		n, _, err := conn.ReadFrom(buf)
+		assert.NoError(err)
+
+		lines := strings.Split(string(buf[:n]), "\n")
+		sort.Strings(lines)
+		return lines
+	}
+
+	t.Run("Initial push", func(t *testing.T) {
+		assert := require.New(t)
+
+		counter.WithLabelValues("up").Add(2)
+		counter.WithLabelValues("stats").Inc()
+		gauge.Set(5)
+
+		assert.NoError(exporter.push())
+		assert.Equal([]string{
+			"cgb.test_event_count.event.stats:1|c",
+			"cgb.test_event_count.event.up:2|c",
+			"cgb.test_gateway_count:5|g",
+		}, receive())
+	})
+
+	t.Run("Counter delta", func(t *testing.T) {
+		assert := require.New(t)
+
+		counter.WithLabelValues("up").Add(3)
+		gauge.Set(4)
+
+		assert.NoError(exporter.push())
+		assert.Equal([]string{
+			"cgb.test_event_count.event.up:3|c",
+			"cgb.test_gateway_count:4|g",
+		}, receive())
+	})
+}
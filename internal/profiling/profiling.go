@@ -0,0 +1,48 @@
+// Package profiling implements the (optional) pprof HTTP server, which can be
+// used to diagnose CPU and memory issues.
+package profiling
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+)
+
+// Setup configures the profiling package. When a pprof bind is configured,
+// the pprof HTTP server is started.
+func Setup(conf config.Config) error {
+	if conf.General.PProfBind == "" {
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"bind": conf.General.PProfBind,
+	}).Info("profiling: starting pprof server")
+
+	server := http.Server{
+		Handler: Handler(),
+		Addr:    conf.General.PProfBind,
+	}
+
+	go func() {
+		err := server.ListenAndServe()
+		log.WithError(err).Error("profiling: pprof server error")
+	}()
+
+	return nil
+}
+
+// Handler returns the HTTP handler serving the pprof endpoints under
+// /debug/pprof/.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
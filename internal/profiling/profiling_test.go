@@ -0,0 +1,49 @@
+package profiling
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+)
+
+func TestProfiling(t *testing.T) {
+	// get a free port
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	bind := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	t.Run("Disabled", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(Setup(config.Config{}))
+		time.Sleep(100 * time.Millisecond)
+
+		_, err := http.Get("http://" + bind + "/debug/pprof/")
+		assert.Error(err)
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		assert := require.New(t)
+
+		var conf config.Config
+		conf.General.PProfBind = bind
+		assert.NoError(Setup(conf))
+		time.Sleep(100 * time.Millisecond)
+
+		resp, err := http.Get("http://" + bind + "/debug/pprof/")
+		assert.NoError(err)
+		defer resp.Body.Close()
+		assert.Equal(http.StatusOK, resp.StatusCode)
+
+		resp, err = http.Get("http://" + bind + "/debug/pprof/goroutine?debug=1")
+		assert.NoError(err)
+		defer resp.Body.Close()
+		assert.Equal(http.StatusOK, resp.StatusCode)
+	})
+}
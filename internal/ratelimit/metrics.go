@@ -0,0 +1,17 @@
+package ratelimit
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	udc = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ratelimit_uplink_dropped_count",
+		Help: "The number of uplinks dropped because of the rate limit (per limit).",
+	}, []string{"limit"})
+)
+
+func uplinkDroppedCounter(limit string) prometheus.Counter {
+	return udc.With(prometheus.Labels{"limit": limit})
+}
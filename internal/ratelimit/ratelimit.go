@@ -0,0 +1,137 @@
+// Package ratelimit implements the uplink rate limiting, using a token
+// bucket per gateway and a global token bucket for all gateways. This
+// protects the integration (e.g. the MQTT broker) against a single gateway
+// flooding uplinks (e.g. a misbehaving device or jamming).
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+// Limits by which an uplink can be dropped.
+const (
+	LimitGateway = "gateway"
+	LimitGlobal  = "global"
+)
+
+// timeNow is used to get the current time (overridden by the tests).
+var timeNow = time.Now
+
+var (
+	mux sync.Mutex
+
+	// per gateway rate and burst, rate 0 = disabled
+	gatewayRate  float64
+	gatewayBurst float64
+	gateways     map[lorawan.EUI64]*bucket
+
+	// global bucket, nil = disabled
+	global *bucket
+)
+
+// Setup configures the ratelimit package.
+func Setup(conf config.Config) error {
+	mux.Lock()
+	defer mux.Unlock()
+
+	gatewayRate = conf.RateLimit.GatewayUplinkRate
+	gatewayBurst = burst(gatewayRate, conf.RateLimit.GatewayUplinkBurst)
+	gateways = make(map[lorawan.EUI64]*bucket)
+
+	global = nil
+	if rate := conf.RateLimit.UplinkRate; rate > 0 {
+		global = newBucket(rate, burst(rate, conf.RateLimit.UplinkBurst))
+	}
+
+	if gatewayRate > 0 || global != nil {
+		log.WithFields(log.Fields{
+			"gateway_uplink_rate":  conf.RateLimit.GatewayUplinkRate,
+			"gateway_uplink_burst": conf.RateLimit.GatewayUplinkBurst,
+			"uplink_rate":          conf.RateLimit.UplinkRate,
+			"uplink_burst":         conf.RateLimit.UplinkBurst,
+		}).Info("ratelimit: uplink rate limiting configured")
+	}
+
+	return nil
+}
+
+// Uplink returns true when an uplink of the given gateway is allowed by the
+// per gateway and global rate limits. When false is returned, the uplink
+// must be dropped.
+func Uplink(gatewayID lorawan.EUI64) bool {
+	mux.Lock()
+	defer mux.Unlock()
+
+	now := timeNow()
+
+	if gatewayRate > 0 {
+		b, ok := gateways[gatewayID]
+		if !ok {
+			b = newBucket(gatewayRate, gatewayBurst)
+			gateways[gatewayID] = b
+		}
+
+		if !b.take(now) {
+			uplinkDroppedCounter(LimitGateway).Inc()
+			log.WithField("gateway_id", gatewayID).Debug("ratelimit: uplink dropped, gateway rate limit exceeded")
+			return false
+		}
+	}
+
+	if global != nil && !global.take(now) {
+		uplinkDroppedCounter(LimitGlobal).Inc()
+		log.WithField("gateway_id", gatewayID).Debug("ratelimit: uplink dropped, global rate limit exceeded")
+		return false
+	}
+
+	return true
+}
+
+// burst returns the configured burst, or the rate (rounded up) when the
+// burst is not configured.
+func burst(rate float64, burst int) float64 {
+	if burst > 0 {
+		return float64(burst)
+	}
+	return math.Max(1, math.Ceil(rate))
+}
+
+// bucket implements a token bucket. Tokens are added at the given rate
+// (per second), up to the given burst.
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate, burst float64) *bucket {
+	return &bucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   timeNow(),
+	}
+}
+
+// take takes a token from the bucket, it returns false when the bucket is
+// empty.
+func (b *bucket) take(now time.Time) bool {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+func TestUplink(t *testing.T) {
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	gatewayA := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	gatewayB := lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}
+
+	t.Run("Disabled", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(Setup(config.Config{}))
+		for i := 0; i < 100; i++ {
+			assert.True(Uplink(gatewayA))
+		}
+	})
+
+	t.Run("Gateway limit", func(t *testing.T) {
+		assert := require.New(t)
+
+		var conf config.Config
+		conf.RateLimit.GatewayUplinkRate = 2
+		conf.RateLimit.GatewayUplinkBurst = 3
+		assert.NoError(Setup(conf))
+
+		// the burst is allowed
+		for i := 0; i < 3; i++ {
+			assert.True(Uplink(gatewayA))
+		}
+		assert.False(Uplink(gatewayA))
+
+		// other gateways are not affected
+		assert.True(Uplink(gatewayB))
+
+		// tokens are added at the configured rate
+		now = now.Add(500 * time.Millisecond)
+		assert.True(Uplink(gatewayA))
+		assert.False(Uplink(gatewayA))
+
+		// up to the burst
+		now = now.Add(time.Minute)
+		for i := 0; i < 3; i++ {
+			assert.True(Uplink(gatewayA))
+		}
+		assert.False(Uplink(gatewayA))
+	})
+
+	t.Run("Global limit", func(t *testing.T) {
+		assert := require.New(t)
+
+		var conf config.Config
+		conf.RateLimit.GatewayUplinkRate = 10
+		conf.RateLimit.UplinkRate = 1.5
+		assert.NoError(Setup(conf))
+
+		// the burst defaults to the rate (rounded up)
+		assert.True(Uplink(gatewayA))
+		assert.True(Uplink(gatewayB))
+		assert.False(Uplink(gatewayA))
+		assert.False(Uplink(gatewayB))
+
+		now = now.Add(time.Second)
+		assert.True(Uplink(gatewayB))
+		assert.False(Uplink(gatewayA))
+	})
+}
@@ -0,0 +1,116 @@
+// Package status keeps track of the last error per subsystem.
+package status
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+)
+
+// Subsystems for which the last error is stored.
+const (
+	MQTT   = "mqtt"
+	UDP    = "udp"
+	Config = "config"
+)
+
+// maxErrorLabelLength defines the max. length of the error label of the
+// last error metric, to keep its cardinality bounded.
+const maxErrorLabelLength = 64
+
+var (
+	leg = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "status_last_error_timestamp_seconds",
+		Help: "The timestamp of the last error (per subsystem). The error is exposed as label.",
+	}, []string{"subsystem", "error"})
+)
+
+// LastError contains the last error of a subsystem.
+type LastError struct {
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+var (
+	mux           sync.RWMutex
+	lastErrors    = make(map[string]LastError)
+	errorLabels   = make(map[string]string)
+	metricEnabled bool
+)
+
+// Setup configures the status package.
+func Setup(conf config.Config) error {
+	mux.Lock()
+	defer mux.Unlock()
+
+	metricEnabled = conf.Metrics.LastErrorMetric
+
+	return nil
+}
+
+// SetLastError stores the given error as the last error of the given
+// subsystem.
+func SetLastError(subsystem string, err error) {
+	if err == nil {
+		return
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	le := LastError{
+		Error: err.Error(),
+		Time:  time.Now(),
+	}
+	lastErrors[subsystem] = le
+
+	if !metricEnabled {
+		return
+	}
+
+	// only expose the last error per subsystem
+	if label, ok := errorLabels[subsystem]; ok {
+		leg.Delete(prometheus.Labels{"subsystem": subsystem, "error": label})
+	}
+
+	label := le.Error
+	if len(label) > maxErrorLabelLength {
+		label = label[:maxErrorLabelLength]
+	}
+	errorLabels[subsystem] = label
+	leg.With(prometheus.Labels{"subsystem": subsystem, "error": label}).Set(float64(le.Time.Unix()))
+}
+
+// GetLastErrors returns the last error per subsystem.
+func GetLastErrors() map[string]LastError {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	out := make(map[string]LastError, len(lastErrors))
+	for k, v := range lastErrors {
+		out[k] = v
+	}
+	return out
+}
+
+// Handler returns the HTTP handler for the /status endpoint.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(struct {
+			LastErrors map[string]LastError `json:"last_errors"`
+		}{
+			LastErrors: GetLastErrors(),
+		}); err != nil {
+			log.WithError(err).Error("status: encode status error")
+		}
+	})
+}
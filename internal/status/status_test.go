@@ -0,0 +1,67 @@
+package status
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+)
+
+func TestLastError(t *testing.T) {
+	assert := require.New(t)
+
+	var conf config.Config
+	conf.Metrics.LastErrorMetric = true
+	assert.NoError(Setup(conf))
+
+	t.Run("Set per subsystem", func(t *testing.T) {
+		assert := require.New(t)
+
+		SetLastError(MQTT, errors.New("mqtt error"))
+		SetLastError(UDP, errors.New("udp error"))
+		SetLastError(Config, errors.New("config error"))
+		SetLastError(Config, nil)
+
+		lastErrors := GetLastErrors()
+		assert.Len(lastErrors, 3)
+		assert.Equal("mqtt error", lastErrors[MQTT].Error)
+		assert.Equal("udp error", lastErrors[UDP].Error)
+		assert.Equal("config error", lastErrors[Config].Error)
+		assert.False(lastErrors[MQTT].Time.IsZero())
+	})
+
+	t.Run("Metric is bounded", func(t *testing.T) {
+		assert := require.New(t)
+
+		SetLastError(UDP, errors.New("other udp error"))
+		SetLastError(UDP, errors.New(strings.Repeat("x", 100)))
+
+		assert.Equal(3, testutil.CollectAndCount(leg))
+		assert.Equal(float64(GetLastErrors()[UDP].Time.Unix()), testutil.ToFloat64(leg.With(prometheus.Labels{
+			"subsystem": UDP,
+			"error":     strings.Repeat("x", maxErrorLabelLength),
+		})))
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		assert := require.New(t)
+
+		w := httptest.NewRecorder()
+		Handler().ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+		assert.Equal("application/json", w.Header().Get("Content-Type"))
+
+		var resp struct {
+			LastErrors map[string]LastError `json:"last_errors"`
+		}
+		assert.NoError(json.NewDecoder(w.Body).Decode(&resp))
+		assert.Equal("mqtt error", resp.LastErrors[MQTT].Error)
+		assert.Equal("config error", resp.LastErrors[Config].Error)
+	})
+}
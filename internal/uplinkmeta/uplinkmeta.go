@@ -0,0 +1,47 @@
+// Package uplinkmeta keeps track of additional (backend specific) meta-data
+// of received uplinks, for which the gw.UplinkRXInfo message does not provide
+// a field. The meta-data is stored by uplink ID and expires automatically.
+package uplinkmeta
+
+import (
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// Meta-data keys.
+const (
+	SourceAddr = "source_addr"
+)
+
+// expiration defines the duration after which the meta-data of an uplink
+// expires. This must be greater than the max. time it takes for an uplink to
+// be published by the integration.
+const expiration = time.Minute
+
+var metaCache = cache.New(expiration, expiration)
+
+// Set sets the given meta-data key and value for the given uplink ID.
+func Set(uplinkID []byte, key, value string) {
+	meta := Get(uplinkID)
+	if meta == nil {
+		meta = make(map[string]string)
+	}
+	meta[key] = value
+	metaCache.SetDefault(string(uplinkID), meta)
+}
+
+// Get returns a copy of the meta-data of the given uplink ID. It returns nil
+// when no meta-data is set.
+func Get(uplinkID []byte) map[string]string {
+	v, ok := metaCache.Get(string(uplinkID))
+	if !ok {
+		return nil
+	}
+
+	out := make(map[string]string)
+	for k, v := range v.(map[string]string) {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,31 @@
+package uplinkmeta
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUplinkMeta(t *testing.T) {
+	t.Run("Not set", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Nil(Get([]byte{1, 2, 3}))
+	})
+
+	t.Run("Set", func(t *testing.T) {
+		assert := require.New(t)
+
+		Set([]byte{1, 2, 3}, SourceAddr, "192.168.1.10:1700")
+		Set([]byte{1, 2, 3}, "foo", "bar")
+
+		meta := Get([]byte{1, 2, 3})
+		assert.Equal(map[string]string{
+			SourceAddr: "192.168.1.10:1700",
+			"foo":      "bar",
+		}, meta)
+
+		// returned meta-data is a copy
+		meta["foo"] = "baz"
+		assert.Equal("bar", Get([]byte{1, 2, 3})["foo"])
+	})
+}
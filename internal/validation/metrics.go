@@ -0,0 +1,44 @@
+package validation
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	udc = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "validation_uplink_dropped_count",
+		Help: "The number of uplinks dropped because of a missing required field (per field).",
+	}, []string{"field"})
+
+	ufc = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "validation_uplink_fixed_count",
+		Help: "The number of uplinks for which a missing field has been set (per field).",
+	}, []string{"field"})
+
+	usc = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "validation_uplink_stale_count",
+		Help: "The number of uplinks dropped because the max. uplink age was exceeded.",
+	})
+
+	dtc = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "validation_downlink_token_anomaly_count",
+		Help: "The number of downlinks with a duplicate or regressed token (per anomaly).",
+	}, []string{"anomaly"})
+)
+
+func uplinkDroppedCounter(field string) prometheus.Counter {
+	return udc.With(prometheus.Labels{"field": field})
+}
+
+func uplinkFixedCounter(field string) prometheus.Counter {
+	return ufc.With(prometheus.Labels{"field": field})
+}
+
+func uplinkStaleCounter() prometheus.Counter {
+	return usc
+}
+
+func downlinkTokenAnomalyCounter(anomaly string) prometheus.Counter {
+	return dtc.With(prometheus.Labels{"anomaly": anomaly})
+}
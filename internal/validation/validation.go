@@ -0,0 +1,277 @@
+package validation
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-api/go/v3/common"
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+// Actions that can be taken for incomplete uplinks.
+const (
+	ActionDisabled = "disabled"
+	ActionDrop     = "drop"
+	ActionFix      = "fix"
+	ActionLog      = "log"
+)
+
+// Downlink token anomalies.
+const (
+	TokenDuplicate  = "duplicate"
+	TokenRegression = "regression"
+)
+
+// defaultCodeRate is used when fixing LoRa uplinks without code-rate.
+const defaultCodeRate = "4/5"
+
+var (
+	uplinkAction string
+	maxUplinkAge time.Duration
+
+	downlinkTokenAction string
+	downlinkTokensMux   sync.Mutex
+	downlinkTokens      map[lorawan.EUI64]uint16
+)
+
+// Setup configures the validation package.
+func Setup(conf config.Config) error {
+	switch conf.Validation.UplinkAction {
+	case "", ActionDisabled:
+		uplinkAction = ActionDisabled
+	case ActionDrop, ActionFix:
+		uplinkAction = conf.Validation.UplinkAction
+	default:
+		return fmt.Errorf("unknown uplink action: %s", conf.Validation.UplinkAction)
+	}
+
+	maxUplinkAge = conf.Validation.MaxUplinkAge
+
+	switch conf.Validation.DownlinkTokenAction {
+	case "", ActionDisabled:
+		downlinkTokenAction = ActionDisabled
+	case ActionLog, ActionDrop:
+		downlinkTokenAction = conf.Validation.DownlinkTokenAction
+	default:
+		return fmt.Errorf("unknown downlink token action: %s", conf.Validation.DownlinkTokenAction)
+	}
+
+	downlinkTokensMux.Lock()
+	downlinkTokens = make(map[lorawan.EUI64]uint16)
+	downlinkTokensMux.Unlock()
+
+	log.WithFields(log.Fields{
+		"uplink_action":         uplinkAction,
+		"max_uplink_age":        maxUplinkAge,
+		"downlink_token_action": downlinkTokenAction,
+	}).Info("validation: uplink validation configured")
+
+	return nil
+}
+
+// UplinkFrame validates that the required fields of the given uplink frame
+// are set. When the action is set to fix, missing fields that can be derived
+// or defaulted are set. This function returns false when the uplink frame
+// must be dropped, which is also the case when the uplink frame is older than
+// the configured max. uplink age.
+func UplinkFrame(pl *gw.UplinkFrame) bool {
+	if isStale(pl) {
+		uplinkStaleCounter().Inc()
+		logUplinkFrame(pl, "time").Warning("validation: uplink dropped, max. uplink age exceeded")
+		return false
+	}
+
+	if uplinkAction == ActionDisabled {
+		return true
+	}
+
+	if uplinkAction == ActionFix {
+		for _, field := range fixUplinkFrame(pl) {
+			uplinkFixedCounter(field).Inc()
+			logUplinkFrame(pl, field).Warning("validation: missing uplink field has been set")
+		}
+	}
+
+	if field := missingUplinkField(pl); field != "" {
+		uplinkDroppedCounter(field).Inc()
+		logUplinkFrame(pl, field).Warning("validation: uplink dropped, missing required field")
+		return false
+	}
+
+	return true
+}
+
+// DownlinkFrame validates the token of the given downlink frame against the
+// last token received for the same gateway. A token equal to the last token
+// is a duplicate (e.g. a re-delivered downlink), a token before the last
+// token is a regression (e.g. after a network server restart). As the 16bit
+// token wraps around, a token is before the last token when it is less than
+// half of the token space behind it. Downlinks without token are not
+// validated, as the token is assigned by the backend. This function returns
+// false when the downlink frame must be dropped.
+func DownlinkFrame(pl *gw.DownlinkFrame) bool {
+	if downlinkTokenAction == ActionDisabled || pl.GetToken() == 0 {
+		return true
+	}
+
+	var gatewayID lorawan.EUI64
+	copy(gatewayID[:], pl.GetGatewayId())
+	token := uint16(pl.GetToken())
+
+	downlinkTokensMux.Lock()
+	last, ok := downlinkTokens[gatewayID]
+	downlinkTokens[gatewayID] = token
+	downlinkTokensMux.Unlock()
+
+	if !ok {
+		return true
+	}
+
+	anomaly := getTokenAnomaly(token, last)
+	if anomaly == "" {
+		return true
+	}
+
+	downlinkTokenAnomalyCounter(anomaly).Inc()
+
+	var downID uuid.UUID
+	copy(downID[:], pl.GetDownlinkId())
+	entry := log.WithFields(log.Fields{
+		"gateway_id":  gatewayID,
+		"downlink_id": downID,
+		"token":       token,
+		"last_token":  last,
+		"anomaly":     anomaly,
+	})
+
+	desc := "duplicate"
+	if anomaly == TokenRegression {
+		desc = "regressed"
+	}
+
+	if downlinkTokenAction == ActionDrop {
+		entry.Warningf("validation: downlink dropped, %s token", desc)
+		return false
+	}
+
+	entry.Warningf("validation: downlink with %s token", desc)
+	return true
+}
+
+// getTokenAnomaly returns the anomaly of the given token compared to the last
+// token, or an empty string when the token follows the last token.
+func getTokenAnomaly(token, last uint16) string {
+	switch diff := int16(token - last); {
+	case diff == 0:
+		return TokenDuplicate
+	case diff < 0:
+		return TokenRegression
+	default:
+		return ""
+	}
+}
+
+// isStale returns true when the receive time of the uplink frame exceeds the
+// max. uplink age. Uplink frames without receive time are never stale.
+func isStale(pl *gw.UplinkFrame) bool {
+	if maxUplinkAge == 0 || pl.GetRxInfo().GetTime() == nil {
+		return false
+	}
+
+	ts, err := ptypes.Timestamp(pl.RxInfo.Time)
+	if err != nil {
+		return false
+	}
+
+	return time.Since(ts) > maxUplinkAge
+}
+
+// missingUplinkField returns the name of the first missing required field,
+// or an empty string when all required fields are set.
+func missingUplinkField(pl *gw.UplinkFrame) string {
+	if len(pl.PhyPayload) == 0 {
+		return "phy_payload"
+	}
+
+	if pl.RxInfo == nil || len(pl.RxInfo.GatewayId) != 8 {
+		return "gateway_id"
+	}
+
+	if pl.TxInfo == nil || pl.TxInfo.Frequency == 0 {
+		return "frequency"
+	}
+
+	switch pl.TxInfo.Modulation {
+	case common.Modulation_LORA:
+		modInfo := pl.TxInfo.GetLoraModulationInfo()
+		if modInfo == nil || modInfo.SpreadingFactor == 0 || modInfo.Bandwidth == 0 {
+			return "data_rate"
+		}
+		if modInfo.CodeRate == "" {
+			return "code_rate"
+		}
+	case common.Modulation_FSK:
+		modInfo := pl.TxInfo.GetFskModulationInfo()
+		if modInfo == nil || modInfo.Datarate == 0 {
+			return "data_rate"
+		}
+	case common.Modulation_LR_FHSS:
+		if pl.TxInfo.GetLrFhssModulationInfo() == nil {
+			return "data_rate"
+		}
+	}
+
+	return ""
+}
+
+// fixUplinkFrame sets the missing fields that can be derived or defaulted
+// and returns the names of the fields that have been set.
+func fixUplinkFrame(pl *gw.UplinkFrame) []string {
+	var out []string
+
+	if pl.TxInfo == nil {
+		return out
+	}
+
+	// the modulation defaults to LoRa, make sure it matches the
+	// modulation-info
+	switch pl.TxInfo.ModulationInfo.(type) {
+	case *gw.UplinkTXInfo_FskModulationInfo:
+		if pl.TxInfo.Modulation != common.Modulation_FSK {
+			pl.TxInfo.Modulation = common.Modulation_FSK
+			out = append(out, "modulation")
+		}
+	case *gw.UplinkTXInfo_LrFhssModulationInfo:
+		if pl.TxInfo.Modulation != common.Modulation_LR_FHSS {
+			pl.TxInfo.Modulation = common.Modulation_LR_FHSS
+			out = append(out, "modulation")
+		}
+	}
+
+	if modInfo := pl.TxInfo.GetLoraModulationInfo(); modInfo != nil && modInfo.CodeRate == "" {
+		modInfo.CodeRate = defaultCodeRate
+		out = append(out, "code_rate")
+	}
+
+	return out
+}
+
+func logUplinkFrame(pl *gw.UplinkFrame, field string) *log.Entry {
+	var gatewayID lorawan.EUI64
+	var uplinkID uuid.UUID
+	copy(gatewayID[:], pl.GetRxInfo().GetGatewayId())
+	copy(uplinkID[:], pl.GetRxInfo().GetUplinkId())
+
+	return log.WithFields(log.Fields{
+		"gateway_id": gatewayID,
+		"uplink_id":  uplinkID,
+		"field":      field,
+	})
+}
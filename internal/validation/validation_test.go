@@ -0,0 +1,356 @@
+package validation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-api/go/v3/common"
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+)
+
+func TestSetup(t *testing.T) {
+	assert := require.New(t)
+
+	var conf config.Config
+	assert.NoError(Setup(conf))
+	assert.Equal(ActionDisabled, uplinkAction)
+
+	conf.Validation.UplinkAction = "foo"
+	assert.EqualError(Setup(conf), "unknown uplink action: foo")
+}
+
+func TestUplinkFrame(t *testing.T) {
+	complete := func() gw.UplinkFrame {
+		return gw.UplinkFrame{
+			PhyPayload: []byte{1, 2, 3},
+			TxInfo: &gw.UplinkTXInfo{
+				Frequency:  868100000,
+				Modulation: common.Modulation_LORA,
+				ModulationInfo: &gw.UplinkTXInfo_LoraModulationInfo{
+					LoraModulationInfo: &gw.LoRaModulationInfo{
+						Bandwidth:       125,
+						SpreadingFactor: 7,
+						CodeRate:        "4/5",
+					},
+				},
+			},
+			RxInfo: &gw.UplinkRXInfo{
+				GatewayId: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			},
+		}
+	}
+
+	noFrequency := complete()
+	noFrequency.TxInfo.Frequency = 0
+
+	noDataRate := complete()
+	noDataRate.TxInfo.ModulationInfo = nil
+
+	noSpreadingFactor := complete()
+	noSpreadingFactor.TxInfo.GetLoraModulationInfo().SpreadingFactor = 0
+
+	noCodeRate := complete()
+	noCodeRate.TxInfo.GetLoraModulationInfo().CodeRate = ""
+	noCodeRateFixed := complete()
+
+	noGatewayID := complete()
+	noGatewayID.RxInfo = nil
+
+	noPHYPayload := complete()
+	noPHYPayload.PhyPayload = nil
+
+	fskModulation := complete()
+	fskModulation.TxInfo.ModulationInfo = &gw.UplinkTXInfo_FskModulationInfo{
+		FskModulationInfo: &gw.FSKModulationInfo{
+			Datarate: 50000,
+		},
+	}
+	fskModulationFixed := complete()
+	fskModulationFixed.TxInfo.Modulation = common.Modulation_FSK
+	fskModulationFixed.TxInfo.ModulationInfo = fskModulation.TxInfo.ModulationInfo
+
+	tests := []struct {
+		Name                string
+		Action              string
+		UplinkFrame         gw.UplinkFrame
+		Expected            bool
+		ExpectedUplinkFrame *gw.UplinkFrame
+	}{
+		{
+			Name:        "complete uplink",
+			Action:      ActionDrop,
+			UplinkFrame: complete(),
+			Expected:    true,
+		},
+		{
+			Name:        "no frequency",
+			Action:      ActionDrop,
+			UplinkFrame: noFrequency,
+			Expected:    false,
+		},
+		{
+			Name:        "no data-rate",
+			Action:      ActionDrop,
+			UplinkFrame: noDataRate,
+			Expected:    false,
+		},
+		{
+			Name:        "no spreading-factor",
+			Action:      ActionDrop,
+			UplinkFrame: noSpreadingFactor,
+			Expected:    false,
+		},
+		{
+			Name:        "no gateway id",
+			Action:      ActionDrop,
+			UplinkFrame: noGatewayID,
+			Expected:    false,
+		},
+		{
+			Name:        "no phypayload",
+			Action:      ActionDrop,
+			UplinkFrame: noPHYPayload,
+			Expected:    false,
+		},
+		{
+			Name:        "no code-rate, drop",
+			Action:      ActionDrop,
+			UplinkFrame: noCodeRate,
+			Expected:    false,
+		},
+		{
+			Name:                "no code-rate, fix",
+			Action:              ActionFix,
+			UplinkFrame:         noCodeRate,
+			Expected:            true,
+			ExpectedUplinkFrame: &noCodeRateFixed,
+		},
+		{
+			Name:                "modulation does not match modulation-info, fix",
+			Action:              ActionFix,
+			UplinkFrame:         fskModulation,
+			Expected:            true,
+			ExpectedUplinkFrame: &fskModulationFixed,
+		},
+		{
+			Name:        "no frequency, fix",
+			Action:      ActionFix,
+			UplinkFrame: noFrequency,
+			Expected:    false,
+		},
+		{
+			Name:        "no frequency, disabled",
+			Action:      ActionDisabled,
+			UplinkFrame: noFrequency,
+			Expected:    true,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			var conf config.Config
+			conf.Validation.UplinkAction = tst.Action
+			assert.NoError(Setup(conf))
+
+			pl := proto.Clone(&tst.UplinkFrame).(*gw.UplinkFrame)
+			assert.Equal(tst.Expected, UplinkFrame(pl))
+
+			if tst.ExpectedUplinkFrame != nil {
+				assert.True(proto.Equal(tst.ExpectedUplinkFrame, pl))
+			}
+		})
+	}
+}
+
+func TestMaxUplinkAge(t *testing.T) {
+	var conf config.Config
+	conf.Validation.MaxUplinkAge = time.Minute
+	require.NoError(t, Setup(conf))
+
+	uplink := func(ts time.Time) *gw.UplinkFrame {
+		pl := gw.UplinkFrame{
+			RxInfo: &gw.UplinkRXInfo{},
+		}
+		if !ts.IsZero() {
+			pl.RxInfo.Time, _ = ptypes.TimestampProto(ts)
+		}
+		return &pl
+	}
+
+	tests := []struct {
+		Name     string
+		Time     time.Time
+		Expected bool
+	}{
+		{
+			Name:     "fresh uplink",
+			Time:     time.Now().Add(-time.Second),
+			Expected: true,
+		},
+		{
+			Name:     "aged uplink",
+			Time:     time.Now().Add(-2 * time.Minute),
+			Expected: false,
+		},
+		{
+			Name:     "uplink without time",
+			Expected: true,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Expected, UplinkFrame(uplink(tst.Time)))
+		})
+	}
+}
+
+func TestDownlinkFrame(t *testing.T) {
+	gatewayID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	tests := []struct {
+		Name     string
+		Action   string
+		Tokens   []uint32
+		Expected []bool
+	}{
+		{
+			Name:     "disabled",
+			Action:   ActionDisabled,
+			Tokens:   []uint32{10, 10, 5},
+			Expected: []bool{true, true, true},
+		},
+		{
+			Name:     "log duplicate",
+			Action:   ActionLog,
+			Tokens:   []uint32{10, 10, 5, 6},
+			Expected: []bool{true, true, true, true},
+		},
+		{
+			Name:     "drop duplicate",
+			Action:   ActionDrop,
+			Tokens:   []uint32{10, 11, 11, 12},
+			Expected: []bool{true, true, false, true},
+		},
+		{
+			Name:     "log regression",
+			Action:   ActionLog,
+			Tokens:   []uint32{10, 20, 15, 16},
+			Expected: []bool{true, true, true, true},
+		},
+		{
+			Name:     "drop regression",
+			Action:   ActionDrop,
+			Tokens:   []uint32{10, 20, 15, 16},
+			Expected: []bool{true, true, false, true},
+		},
+		{
+			Name:     "drop regression after wrap around",
+			Action:   ActionDrop,
+			Tokens:   []uint32{65535, 1, 65534, 2},
+			Expected: []bool{true, true, false, true},
+		},
+		{
+			Name:     "max. gap is accepted",
+			Action:   ActionDrop,
+			Tokens:   []uint32{10, 10 + 32767, 10 + 32767 + 32768},
+			Expected: []bool{true, true, false},
+		},
+		{
+			Name:     "gaps are accepted",
+			Action:   ActionDrop,
+			Tokens:   []uint32{10, 100, 1000},
+			Expected: []bool{true, true, true},
+		},
+		{
+			Name:     "wrap around",
+			Action:   ActionDrop,
+			Tokens:   []uint32{65534, 65535, 0, 1},
+			Expected: []bool{true, true, true, true},
+		},
+		{
+			Name:     "no token",
+			Action:   ActionDrop,
+			Tokens:   []uint32{0, 0, 0},
+			Expected: []bool{true, true, true},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			var conf config.Config
+			conf.Validation.DownlinkTokenAction = tst.Action
+			assert.NoError(Setup(conf))
+
+			var out []bool
+			for _, token := range tst.Tokens {
+				out = append(out, DownlinkFrame(&gw.DownlinkFrame{
+					GatewayId: gatewayID,
+					Token:     token,
+				}))
+			}
+			assert.Equal(tst.Expected, out)
+		})
+	}
+
+	t.Run("Anomaly is logged and counted", func(t *testing.T) {
+		assert := require.New(t)
+
+		level := log.GetLevel()
+		log.SetLevel(log.WarnLevel)
+		hook := logtest.NewGlobal()
+		defer func() {
+			log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+			log.SetLevel(level)
+		}()
+
+		var conf config.Config
+		conf.Validation.DownlinkTokenAction = ActionLog
+		assert.NoError(Setup(conf))
+
+		regressions := testutil.ToFloat64(downlinkTokenAnomalyCounter(TokenRegression))
+
+		assert.True(DownlinkFrame(&gw.DownlinkFrame{GatewayId: gatewayID, Token: 20}))
+		assert.True(DownlinkFrame(&gw.DownlinkFrame{GatewayId: gatewayID, Token: 15}))
+
+		entry := hook.LastEntry()
+		assert.NotNil(entry)
+		assert.Equal("validation: downlink with regressed token", entry.Message)
+		assert.Equal(TokenRegression, entry.Data["anomaly"])
+		assert.Equal(uint16(15), entry.Data["token"])
+		assert.Equal(uint16(20), entry.Data["last_token"])
+		assert.Equal(regressions+1, testutil.ToFloat64(downlinkTokenAnomalyCounter(TokenRegression)))
+	})
+
+	t.Run("Per gateway", func(t *testing.T) {
+		assert := require.New(t)
+
+		var conf config.Config
+		conf.Validation.DownlinkTokenAction = ActionDrop
+		assert.NoError(Setup(conf))
+
+		assert.True(DownlinkFrame(&gw.DownlinkFrame{GatewayId: gatewayID, Token: 10}))
+		assert.True(DownlinkFrame(&gw.DownlinkFrame{GatewayId: []byte{8, 7, 6, 5, 4, 3, 2, 1}, Token: 10}))
+	})
+
+	t.Run("Invalid action", func(t *testing.T) {
+		assert := require.New(t)
+
+		var conf config.Config
+		conf.Validation.DownlinkTokenAction = "foo"
+		assert.EqualError(Setup(conf), "unknown downlink token action: foo")
+	})
+}
@@ -0,0 +1,334 @@
+package virtualgateway
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/backend/events"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+// pendingDownlink keeps track of a downlink that has been distributed to
+// multiple physical gateways.
+type pendingDownlink struct {
+	token   uint32
+	pending int
+	acked   bool
+}
+
+var (
+	mux sync.Mutex
+
+	// physical gateway ID to virtual gateway ID
+	virtualIDs map[lorawan.EUI64]lorawan.EUI64
+
+	// virtual gateway ID to physical gateway IDs
+	physicalIDs map[lorawan.EUI64][]lorawan.EUI64
+
+	// virtual gateway ID to the connected physical gateway IDs
+	connected map[lorawan.EUI64]map[lorawan.EUI64]struct{}
+
+	// uplink de-duplication window and cache
+	dedupWindow time.Duration
+	dedupCache  *cache.Cache
+
+	// downlink id to pending downlink
+	downlinkCache *cache.Cache
+
+	// the (16bit) tokens of the downlinks in-flight
+	tokenCache *cache.Cache
+)
+
+// Setup configures the virtual gateways.
+func Setup(conf config.Config) error {
+	mux.Lock()
+	defer mux.Unlock()
+
+	virtualIDs = make(map[lorawan.EUI64]lorawan.EUI64)
+	physicalIDs = make(map[lorawan.EUI64][]lorawan.EUI64)
+	connected = make(map[lorawan.EUI64]map[lorawan.EUI64]struct{})
+
+	for _, vgw := range conf.VirtualGateways.Gateways {
+		var virtualID lorawan.EUI64
+		if err := virtualID.UnmarshalText([]byte(vgw.GatewayID)); err != nil {
+			return errors.Wrap(err, "unmarshal virtual gateway id error")
+		}
+
+		for _, s := range vgw.PhysicalGatewayIDs {
+			var physicalID lorawan.EUI64
+			if err := physicalID.UnmarshalText([]byte(s)); err != nil {
+				return errors.Wrap(err, "unmarshal physical gateway id error")
+			}
+
+			if id, ok := virtualIDs[physicalID]; ok {
+				return fmt.Errorf("physical gateway %s is already mapped to virtual gateway %s", physicalID, id)
+			}
+
+			virtualIDs[physicalID] = virtualID
+			physicalIDs[virtualID] = append(physicalIDs[virtualID], physicalID)
+		}
+
+		log.WithFields(log.Fields{
+			"gateway_id":           virtualID,
+			"physical_gateway_ids": physicalIDs[virtualID],
+		}).Info("virtualgateway: virtual gateway configured")
+	}
+
+	dedupWindow = conf.VirtualGateways.DeduplicationWindow
+	dedupCache = cache.New(dedupWindow, time.Minute)
+	downlinkCache = cache.New(time.Minute, time.Minute)
+	tokenCache = cache.New(time.Minute, time.Minute)
+
+	return nil
+}
+
+// Subscribe maps the subscribe event of a physical gateway to its virtual
+// gateway. The virtual gateway is subscribed when the first physical gateway
+// connects and unsubscribed when the last physical gateway disconnects.
+// It returns false when the event must not be forwarded.
+func Subscribe(pl events.Subscribe) (events.Subscribe, bool) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	virtualID, ok := virtualIDs[pl.GatewayID]
+	if !ok {
+		return pl, true
+	}
+
+	if connected[virtualID] == nil {
+		connected[virtualID] = make(map[lorawan.EUI64]struct{})
+	}
+
+	count := len(connected[virtualID])
+	if pl.Subscribe {
+		connected[virtualID][pl.GatewayID] = struct{}{}
+	} else {
+		delete(connected[virtualID], pl.GatewayID)
+	}
+
+	// only forward the first subscribe and the last unsubscribe
+	if (pl.Subscribe && count != 0) || (!pl.Subscribe && len(connected[virtualID]) != 0) {
+		return pl, false
+	}
+
+	return events.Subscribe{
+		GatewayID: virtualID,
+		Subscribe: pl.Subscribe,
+	}, true
+}
+
+// UplinkFrame maps the gateway ID of the given uplink frame to its virtual
+// gateway ID. It returns false when the same frame has already been received
+// by an other physical gateway of the same virtual gateway within the
+// de-duplication window.
+func UplinkFrame(pl *gw.UplinkFrame) bool {
+	if pl.RxInfo == nil {
+		return true
+	}
+
+	virtualID, ok := getVirtualID(pl.RxInfo.GatewayId)
+	if !ok {
+		return true
+	}
+
+	pl.RxInfo.GatewayId = virtualID[:]
+
+	if dedupWindow == 0 {
+		return true
+	}
+
+	key := fmt.Sprintf("%s:%s", virtualID, hex.EncodeToString(pl.PhyPayload))
+	if err := dedupCache.Add(key, struct{}{}, cache.DefaultExpiration); err != nil {
+		return false
+	}
+
+	return true
+}
+
+// GatewayStats maps the gateway ID of the given stats to its virtual gateway ID.
+func GatewayStats(pl *gw.GatewayStats) {
+	if virtualID, ok := getVirtualID(pl.GatewayId); ok {
+		pl.GatewayId = virtualID[:]
+	}
+}
+
+// RawPacketForwarderEvent maps the gateway ID of the given event to its
+// virtual gateway ID.
+func RawPacketForwarderEvent(pl *gw.RawPacketForwarderEvent) {
+	if virtualID, ok := getVirtualID(pl.GatewayId); ok {
+		pl.GatewayId = virtualID[:]
+	}
+}
+
+// DownlinkFrames returns the downlink frames that must be sent for the given
+// downlink frame. In case the downlink is for a virtual gateway, a downlink
+// frame is returned for each (connected) physical gateway. Each of these
+// frames has an unique token, the original token is restored in the
+// DownlinkTXAck. The tokens of the additional frames do not collide with the
+// tokens of the other downlinks in-flight.
+func DownlinkFrames(pl gw.DownlinkFrame) ([]gw.DownlinkFrame, error) {
+	tokenCache.SetDefault(getTokenKey(pl.Token), struct{}{})
+
+	var virtualID lorawan.EUI64
+	copy(virtualID[:], pl.GetGatewayId())
+
+	mux.Lock()
+	ids := make([]lorawan.EUI64, 0, len(physicalIDs[virtualID]))
+	for _, id := range physicalIDs[virtualID] {
+		if _, ok := connected[virtualID][id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		ids = append(ids, physicalIDs[virtualID]...)
+	}
+	mux.Unlock()
+
+	if len(ids) == 0 {
+		return []gw.DownlinkFrame{pl}, nil
+	}
+
+	// make sure the downlink can be correlated with its acknowledgements
+	if len(pl.DownlinkId) == 0 {
+		downID, err := uuid.NewV4()
+		if err != nil {
+			return nil, errors.Wrap(err, "new uuid error")
+		}
+		pl.DownlinkId = downID[:]
+	}
+
+	downlinkCache.SetDefault(string(pl.DownlinkId), &pendingDownlink{
+		token:   pl.Token,
+		pending: len(ids),
+	})
+
+	var out []gw.DownlinkFrame
+	for i := range ids {
+		id := ids[i]
+		df := pl
+		df.GatewayId = id[:]
+		df.Items = make([]*gw.DownlinkFrameItem, len(pl.Items))
+
+		for j := range pl.Items {
+			item := *pl.Items[j]
+			if item.TxInfo != nil {
+				txInfo := *item.TxInfo
+				txInfo.GatewayId = id[:]
+				item.TxInfo = &txInfo
+			}
+			df.Items[j] = &item
+		}
+
+		if i != 0 {
+			token, err := getUniqueToken()
+			if err != nil {
+				return nil, errors.Wrap(err, "get unique token error")
+			}
+			df.Token = token
+		}
+
+		out = append(out, df)
+	}
+
+	return out, nil
+}
+
+// DownlinkTXAck maps the given acknowledgement of a physical gateway to its
+// virtual gateway. As a downlink for a virtual gateway results in an
+// acknowledgement for each physical gateway, only the first successful
+// acknowledgement is forwarded. In case all physical gateways returned an
+// error, the last acknowledgement is forwarded. It returns false when the
+// acknowledgement must not be forwarded.
+func DownlinkTXAck(pl *gw.DownlinkTXAck) bool {
+	tokenCache.Delete(getTokenKey(pl.Token))
+
+	virtualID, ok := getVirtualID(pl.GatewayId)
+	if !ok {
+		return true
+	}
+
+	pl.GatewayId = virtualID[:]
+
+	v, ok := downlinkCache.Get(string(pl.DownlinkId))
+	if !ok {
+		return true
+	}
+	pd := v.(*pendingDownlink)
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	pl.Token = pd.token
+
+	if pd.acked {
+		return false
+	}
+
+	pd.pending--
+
+	ok = false
+	for _, item := range pl.Items {
+		if item.Status == gw.TxAckStatus_OK {
+			ok = true
+		}
+	}
+
+	if ok || pd.pending <= 0 {
+		pd.acked = true
+		return true
+	}
+
+	return false
+}
+
+func getVirtualID(b []byte) (lorawan.EUI64, bool) {
+	var gatewayID lorawan.EUI64
+	copy(gatewayID[:], b)
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	virtualID, ok := virtualIDs[gatewayID]
+	return virtualID, ok
+}
+
+// getTokenKey returns the token cache key of the given token. Only the lower
+// 16 bits are used, as the UDP packet-forwarder uses a 16bit token.
+func getTokenKey(token uint32) string {
+	return fmt.Sprintf("%d", uint16(token))
+}
+
+// getUniqueToken returns a (non-zero) 16bit token that is not used by any of
+// the downlinks in-flight and reserves it. Starting at a random token, the
+// first token that is not in use is returned.
+func getUniqueToken() (uint32, error) {
+	b := make([]byte, 2)
+	if _, err := rand.Read(b); err != nil {
+		return 0, errors.Wrap(err, "read random bytes error")
+	}
+	start := binary.BigEndian.Uint16(b)
+
+	for i := 0; i < 1<<16; i++ {
+		token := uint32(start + uint16(i))
+		if token == 0 {
+			continue
+		}
+
+		if err := tokenCache.Add(getTokenKey(token), struct{}{}, cache.DefaultExpiration); err == nil {
+			return token, nil
+		}
+	}
+
+	return 0, errors.New("all tokens are in use")
+}
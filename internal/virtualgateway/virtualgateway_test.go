@@ -0,0 +1,326 @@
+package virtualgateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/backend/events"
+	"github.com/brocaar/chirpstack-gateway-bridge/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+var (
+	virtualID   = lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1}
+	physicalID1 = lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 2}
+	physicalID2 = lorawan.EUI64{3, 3, 3, 3, 3, 3, 3, 3}
+	otherID     = lorawan.EUI64{4, 4, 4, 4, 4, 4, 4, 4}
+)
+
+func setup(t *testing.T) {
+	var conf config.Config
+	conf.VirtualGateways.DeduplicationWindow = 100 * time.Millisecond
+	conf.VirtualGateways.Gateways = []config.VirtualGateway{
+		{
+			GatewayID:          virtualID.String(),
+			PhysicalGatewayIDs: []string{physicalID1.String(), physicalID2.String()},
+		},
+	}
+
+	require.NoError(t, Setup(conf))
+}
+
+func TestSetup(t *testing.T) {
+	assert := require.New(t)
+
+	var conf config.Config
+	conf.VirtualGateways.Gateways = []config.VirtualGateway{
+		{
+			GatewayID:          virtualID.String(),
+			PhysicalGatewayIDs: []string{physicalID1.String()},
+		},
+		{
+			GatewayID:          otherID.String(),
+			PhysicalGatewayIDs: []string{physicalID1.String()},
+		},
+	}
+	assert.EqualError(Setup(conf), "physical gateway 0202020202020202 is already mapped to virtual gateway 0101010101010101")
+
+	conf.VirtualGateways.Gateways = []config.VirtualGateway{
+		{
+			GatewayID: "foo",
+		},
+	}
+	assert.Error(Setup(conf))
+}
+
+func TestSubscribe(t *testing.T) {
+	setup(t)
+
+	tests := []struct {
+		Name        string
+		In          events.Subscribe
+		Expected    events.Subscribe
+		ExpectedFwd bool
+	}{
+		{
+			Name:        "other gateway",
+			In:          events.Subscribe{GatewayID: otherID, Subscribe: true},
+			Expected:    events.Subscribe{GatewayID: otherID, Subscribe: true},
+			ExpectedFwd: true,
+		},
+		{
+			Name:        "first physical gateway connects",
+			In:          events.Subscribe{GatewayID: physicalID1, Subscribe: true},
+			Expected:    events.Subscribe{GatewayID: virtualID, Subscribe: true},
+			ExpectedFwd: true,
+		},
+		{
+			Name:        "second physical gateway connects",
+			In:          events.Subscribe{GatewayID: physicalID2, Subscribe: true},
+			Expected:    events.Subscribe{GatewayID: physicalID2, Subscribe: true},
+			ExpectedFwd: false,
+		},
+		{
+			Name:        "first physical gateway disconnects",
+			In:          events.Subscribe{GatewayID: physicalID1, Subscribe: false},
+			Expected:    events.Subscribe{GatewayID: physicalID1, Subscribe: false},
+			ExpectedFwd: false,
+		},
+		{
+			Name:        "last physical gateway disconnects",
+			In:          events.Subscribe{GatewayID: physicalID2, Subscribe: false},
+			Expected:    events.Subscribe{GatewayID: virtualID, Subscribe: false},
+			ExpectedFwd: true,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			out, fwd := Subscribe(tst.In)
+			assert.Equal(tst.ExpectedFwd, fwd)
+			assert.Equal(tst.Expected, out)
+		})
+	}
+}
+
+func TestUplinkFrame(t *testing.T) {
+	setup(t)
+
+	uplink := func(gatewayID lorawan.EUI64, phy []byte) gw.UplinkFrame {
+		return gw.UplinkFrame{
+			PhyPayload: phy,
+			RxInfo: &gw.UplinkRXInfo{
+				GatewayId: gatewayID[:],
+			},
+		}
+	}
+
+	t.Run("Collapse with de-duplication", func(t *testing.T) {
+		assert := require.New(t)
+
+		up1 := uplink(physicalID1, []byte{1, 2, 3})
+		up2 := uplink(physicalID2, []byte{1, 2, 3})
+
+		assert.True(UplinkFrame(&up1))
+		assert.Equal(virtualID[:], up1.RxInfo.GatewayId)
+
+		assert.False(UplinkFrame(&up2))
+		assert.Equal(virtualID[:], up2.RxInfo.GatewayId)
+	})
+
+	t.Run("Different payload", func(t *testing.T) {
+		assert := require.New(t)
+
+		up := uplink(physicalID2, []byte{3, 2, 1})
+		assert.True(UplinkFrame(&up))
+		assert.Equal(virtualID[:], up.RxInfo.GatewayId)
+	})
+
+	t.Run("After de-duplication window", func(t *testing.T) {
+		assert := require.New(t)
+
+		time.Sleep(150 * time.Millisecond)
+
+		up := uplink(physicalID2, []byte{1, 2, 3})
+		assert.True(UplinkFrame(&up))
+	})
+
+	t.Run("Other gateway", func(t *testing.T) {
+		assert := require.New(t)
+
+		up1 := uplink(otherID, []byte{4, 5, 6})
+		up2 := uplink(otherID, []byte{4, 5, 6})
+
+		assert.True(UplinkFrame(&up1))
+		assert.True(UplinkFrame(&up2))
+		assert.Equal(otherID[:], up2.RxInfo.GatewayId)
+	})
+}
+
+func TestDownlinkFrames(t *testing.T) {
+	assert := require.New(t)
+	setup(t)
+
+	downID, err := uuid.NewV4()
+	assert.NoError(err)
+
+	df := gw.DownlinkFrame{
+		GatewayId:  virtualID[:],
+		Token:      1234,
+		DownlinkId: downID[:],
+		Items: []*gw.DownlinkFrameItem{
+			{
+				PhyPayload: []byte{1, 2, 3},
+				TxInfo: &gw.DownlinkTXInfo{
+					GatewayId: virtualID[:],
+					Frequency: 868100000,
+				},
+			},
+		},
+	}
+
+	t.Run("Fan-out to each physical gateway", func(t *testing.T) {
+		assert := require.New(t)
+
+		frames, err := DownlinkFrames(df)
+		assert.NoError(err)
+		assert.Len(frames, 2)
+
+		for i, id := range []lorawan.EUI64{physicalID1, physicalID2} {
+			assert.Equal(id[:], frames[i].GatewayId)
+			assert.Equal(id[:], frames[i].Items[0].TxInfo.GatewayId)
+			assert.Equal(downID[:], frames[i].DownlinkId)
+			assert.Equal(uint32(868100000), frames[i].Items[0].TxInfo.Frequency)
+		}
+		assert.Equal(uint32(1234), frames[0].Token)
+
+		// the original frame must not have been modified
+		assert.Equal(virtualID[:], df.Items[0].TxInfo.GatewayId)
+
+		t.Run("First successful ack is forwarded", func(t *testing.T) {
+			assert := require.New(t)
+
+			ack1 := gw.DownlinkTXAck{
+				GatewayId:  physicalID1[:],
+				Token:      frames[0].Token,
+				DownlinkId: downID[:],
+				Items:      []*gw.DownlinkTXAckItem{{Status: gw.TxAckStatus_TOO_LATE}},
+			}
+			assert.False(DownlinkTXAck(&ack1))
+
+			ack2 := gw.DownlinkTXAck{
+				GatewayId:  physicalID2[:],
+				Token:      frames[1].Token,
+				DownlinkId: downID[:],
+				Items:      []*gw.DownlinkTXAckItem{{Status: gw.TxAckStatus_OK}},
+			}
+			assert.True(DownlinkTXAck(&ack2))
+			assert.Equal(virtualID[:], ack2.GatewayId)
+			assert.Equal(uint32(1234), ack2.Token)
+		})
+	})
+
+	t.Run("Fan-out to connected physical gateways", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, _ = Subscribe(events.Subscribe{GatewayID: physicalID2, Subscribe: true})
+
+		frames, err := DownlinkFrames(df)
+		assert.NoError(err)
+		assert.Len(frames, 1)
+		assert.Equal(physicalID2[:], frames[0].GatewayId)
+
+		t.Run("Error ack is forwarded when all gateways failed", func(t *testing.T) {
+			assert := require.New(t)
+
+			ack := gw.DownlinkTXAck{
+				GatewayId:  physicalID2[:],
+				Token:      frames[0].Token,
+				DownlinkId: downID[:],
+				Items:      []*gw.DownlinkTXAckItem{{Status: gw.TxAckStatus_TOO_LATE}},
+			}
+			assert.True(DownlinkTXAck(&ack))
+			assert.Equal(virtualID[:], ack.GatewayId)
+		})
+	})
+
+	t.Run("Other gateway", func(t *testing.T) {
+		assert := require.New(t)
+
+		df := gw.DownlinkFrame{
+			GatewayId: otherID[:],
+		}
+
+		frames, err := DownlinkFrames(df)
+		assert.NoError(err)
+		assert.Equal([]gw.DownlinkFrame{df}, frames)
+	})
+}
+
+func TestDownlinkFramesUniqueToken(t *testing.T) {
+	assert := require.New(t)
+	setup(t)
+
+	downID, err := uuid.NewV4()
+	assert.NoError(err)
+
+	df := gw.DownlinkFrame{
+		GatewayId:  virtualID[:],
+		Token:      1234,
+		DownlinkId: downID[:],
+		Items: []*gw.DownlinkFrameItem{
+			{
+				PhyPayload: []byte{1, 2, 3},
+				TxInfo: &gw.DownlinkTXInfo{
+					GatewayId: virtualID[:],
+				},
+			},
+		},
+	}
+
+	// all tokens but 4321 are in-flight
+	for i := 1; i < 1<<16; i++ {
+		if i != 4321 {
+			tokenCache.SetDefault(getTokenKey(uint32(i)), struct{}{})
+		}
+	}
+
+	t.Run("Free token is used", func(t *testing.T) {
+		assert := require.New(t)
+
+		frames, err := DownlinkFrames(df)
+		assert.NoError(err)
+		assert.Len(frames, 2)
+		assert.Equal(uint32(1234), frames[0].Token)
+		assert.Equal(uint32(4321), frames[1].Token)
+	})
+
+	t.Run("No free token", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := DownlinkFrames(df)
+		assert.EqualError(err, "get unique token error: all tokens are in use")
+	})
+
+	t.Run("Token is released on ack", func(t *testing.T) {
+		assert := require.New(t)
+
+		ack := gw.DownlinkTXAck{
+			GatewayId:  physicalID2[:],
+			Token:      4321,
+			DownlinkId: downID[:],
+			Items:      []*gw.DownlinkTXAckItem{{Status: gw.TxAckStatus_OK}},
+		}
+		assert.True(DownlinkTXAck(&ack))
+
+		frames, err := DownlinkFrames(df)
+		assert.NoError(err)
+		assert.Equal(uint32(4321), frames[1].Token)
+	})
+}